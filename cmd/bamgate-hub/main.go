@@ -2,9 +2,12 @@
 // testing. It relays WebRTC signaling messages (SDP offers/answers, ICE
 // candidates) between connected bamgate peers.
 //
+// The hub also stores the network policy (see "bamgate policy"). Pass
+// -policy to keep it across restarts.
+//
 // Usage:
 //
-//	bamgate-hub -addr :8080
+//	bamgate-hub -addr :8080 -policy /var/lib/bamgate-hub/policy.json
 package main
 
 import (
@@ -21,6 +24,7 @@ import (
 
 func main() {
 	addr := flag.String("addr", ":8080", "listen address")
+	policyPath := flag.String("policy", "", "file the network policy is loaded from and saved to (default: memory only)")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
@@ -28,6 +32,12 @@ func main() {
 	}))
 
 	hub := signaling.NewHub(logger)
+	if *policyPath != "" {
+		if err := hub.LoadPolicy(*policyPath); err != nil {
+			logger.Error("loading policy", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	srv := &http.Server{
 		Addr:    *addr,
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Show the access control rules enforced on peers",
+	Long: `Show which destinations each peer may reach through the tunnel and how
+many packets the rules have dropped.
+
+Rules live in the config file. Each [peers.<name>] section may restrict the
+peer to a list of destinations:
+
+  [peers.phone]
+  allow = ["192.168.1.10:22,443/tcp", "10.0.0.1/icmp"]
+
+Entries are "dest[:ports][/protocol]", where dest is an address or CIDR on
+this device's tunnel address or advertised routes. Peers without rules may
+reach everything unless device.acl_default is "deny". Replies to connections
+this device opens are always allowed.
+
+The network policy from the signaling server (see bamgate policy) may add
+its own rules and default; a packet must be allowed by both.
+
+After editing the config, apply the rules with: bamgate acl reload`,
+	Args: cobra.NoArgs,
+	RunE: runACL,
+}
+
+var aclReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Re-read access control rules from the config file",
+	Args:  cobra.NoArgs,
+	RunE:  runACLReload,
+}
+
+func init() {
+	aclCmd.AddCommand(aclReloadCmd)
+}
+
+func runACL(cmd *cobra.Command, args []string) error {
+	status, err := control.FetchStatus(socketPath())
+	if err != nil {
+		return fmt.Errorf("is bamgate running? %w", err)
+	}
+
+	if status.ACL == nil {
+		fmt.Println("No access control rules: every peer may reach this device and its routes.")
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "%s  %s\n", styleKey.Render("Default:"), status.ACL.Default)
+	if status.ACL.NetworkDefault != "" {
+		fmt.Fprintf(os.Stdout, "%s  %s\n", styleKey.Render("Network:"), status.ACL.NetworkDefault+" (network policy default)")
+	}
+	if status.ACL.DroppedUnknown > 0 {
+		fmt.Fprintf(os.Stdout, "%s  %d packets from unknown sources\n", styleKey.Render("Dropped:"), status.ACL.DroppedUnknown)
+	}
+	fmt.Println()
+
+	if len(status.ACL.Peers) == 0 {
+		fmt.Println("No per-peer rules.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	network := status.ACL.NetworkDefault != ""
+	if network {
+		fmt.Fprintln(w, "PEER\tALLOW\tNETWORK ALLOW\tDROPPED")
+	} else {
+		fmt.Fprintln(w, "PEER\tALLOW\tDROPPED")
+	}
+	for _, p := range status.ACL.Peers {
+		allow := status.ACL.Default + " (default)"
+		if len(p.Allow) > 0 {
+			allow = strings.Join(p.Allow, " ")
+		}
+		if !network {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", p.Peer, allow, p.Dropped)
+			continue
+		}
+		netAllow := status.ACL.NetworkDefault + " (default)"
+		if len(p.NetworkAllow) > 0 {
+			netAllow = strings.Join(p.NetworkAllow, " ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", p.Peer, allow, netAllow, p.Dropped)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func runACLReload(cmd *cobra.Command, args []string) error {
+	if err := control.ReloadACL(socketPath()); err != nil {
+		return err
+	}
+	fmt.Println("Access control rules reloaded.")
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+var (
+	capturePeer      string
+	captureWrite     string
+	captureCount     int
+	captureSnapLen   int
+	captureMaxMB     int
+	captureDuration  time.Duration
+	captureEncrypted bool
+)
+
+var captureCmd = &cobra.Command{
+	Use:   "capture [filter]",
+	Short: "Capture tunnel traffic to a pcapng file",
+	Long: `Record the packets the running agent exchanges with peers, as seen inside
+the tunnel, and write them as pcapng for Wireshark or tcpdump -r.
+
+Packets are captured where WireGuard meets the TUN device, so this works
+in userspace mode and shows traffic from peers before access control rules
+drop it. Each peer gets its own interface in the file; packets no peer
+could be attributed to appear on "unknown". With --encrypted, the
+WireGuard frames on each peer's data channel are added on "<peer>/wg",
+wrapped in UDP headers so Wireshark decodes them.
+
+An optional filter selects packets, using a subset of tcpdump's syntax:
+primitives (tcp, udp, icmp, icmp6, ip, ip6, [src|dst] host ADDR,
+[src|dst] net CIDR, [src|dst] port N) joined by "and" and negated by "not".
+
+  bamgate capture --peer phone -w phone.pcapng
+  bamgate capture -w nas.pcapng host 192.168.1.10 and tcp and port 445
+  bamgate capture -c 100 -w - icmp | tcpdump -n -r -
+
+The capture runs until interrupted or a limit (--count, --max-size,
+--duration) is reached.`,
+	RunE: runCapture,
+}
+
+func init() {
+	captureCmd.Flags().StringVar(&capturePeer, "peer", "", "only capture traffic with this peer")
+	captureCmd.Flags().StringVarP(&captureWrite, "write", "w", "", `write to this file ("-" for stdout)`)
+	captureCmd.Flags().IntVarP(&captureCount, "count", "c", 0, "stop after this many packets")
+	captureCmd.Flags().IntVarP(&captureSnapLen, "snaplen", "s", 0, "keep at most this many bytes of each packet (default: whole packets)")
+	captureCmd.Flags().IntVar(&captureMaxMB, "max-size", 0, "stop when the file reaches this many megabytes")
+	captureCmd.Flags().DurationVar(&captureDuration, "duration", 0, "stop after this long (e.g. 30s, 5m)")
+	captureCmd.Flags().BoolVar(&captureEncrypted, "encrypted", false, "also capture the encrypted WireGuard frames")
+	_ = captureCmd.MarkFlagRequired("write")
+}
+
+func runCapture(cmd *cobra.Command, args []string) error {
+	if captureCount < 0 || captureSnapLen < 0 || captureMaxMB < 0 || captureDuration < 0 {
+		return fmt.Errorf("--count, --snaplen, --max-size and --duration must not be negative")
+	}
+
+	var out io.Writer = os.Stdout
+	if captureWrite == "-" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to write a capture to a terminal; redirect stdout or use -w <file>")
+		}
+	} else {
+		f, err := os.Create(captureWrite)
+		if err != nil {
+			return fmt.Errorf("creating capture file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	req := control.CaptureRequest{
+		Peer:       capturePeer,
+		Filter:     strings.Join(args, " "),
+		Encrypted:  captureEncrypted,
+		SnapLen:    captureSnapLen,
+		MaxPackets: captureCount,
+		MaxBytes:   int64(captureMaxMB) << 20,
+		Duration:   captureDuration,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	what := "all peers"
+	if capturePeer != "" {
+		what = "peer " + capturePeer
+	}
+	fmt.Fprintf(os.Stderr, "Capturing traffic with %s, press Ctrl-C to stop.\n", what)
+
+	n, err := control.StreamCapture(ctx, socketPath(), req, out)
+	if err != nil {
+		if n == 0 && captureWrite != "-" {
+			_ = os.Remove(captureWrite)
+		}
+		if opErr := (*net.OpError)(nil); errors.As(err, &opErr) {
+			return fmt.Errorf("is bamgate running? %w", err)
+		}
+		return err
+	}
+
+	if captureWrite != "-" {
+		fmt.Fprintf(os.Stderr, "Wrote %d bytes to %s.\n", n, captureWrite)
+	}
+	return nil
+}
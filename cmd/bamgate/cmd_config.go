@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
 )
 
 var configCmd = &cobra.Command{
@@ -19,6 +23,7 @@ var configCmd = &cobra.Command{
   bamgate config                Print config file paths
   bamgate config edit           Open config.toml in $EDITOR
   bamgate config edit --secrets Open secrets.toml in $EDITOR
+  bamgate config reload         Apply config changes to the running agent
   bamgate config path           Print the config directory path`,
 	RunE: runConfig,
 }
@@ -29,10 +34,29 @@ var configEditCmd = &cobra.Command{
 	Use:   "edit",
 	Short: "Open config.toml in $EDITOR",
 	Long: `Open the bamgate config.toml in your editor. Use --secrets to
-open secrets.toml instead (contains private key, tokens, etc.).`,
+open secrets.toml instead (contains private key, tokens, etc.).
+
+If the agent is running, the changes are applied when the editor exits
+(see bamgate config reload).`,
 	RunE: runConfigEdit,
 }
 
+var configReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Apply config changes to the running agent",
+	Long: `Ask the running agent to re-read its config files and apply what
+changed without reconnecting peers: advertised routes and DNS, per-peer
+selections, access control rules, blocked peers, the route conflict
+policy, and STUN servers and force_relay for new connections.
+
+Changes to settings the tunnel is built on, such as the private key, the
+address or the signaling server, are listed and take effect after
+'bamgate restart'. The agent also reloads on SIGHUP and when config.toml
+or secrets.toml changes on disk.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigReload,
+}
+
 var configPathCmd = &cobra.Command{
 	Use:   "path",
 	Short: "Print the config directory path",
@@ -42,6 +66,7 @@ var configPathCmd = &cobra.Command{
 func init() {
 	configEditCmd.Flags().BoolVar(&editSecrets, "secrets", false, "edit secrets.toml instead of config.toml")
 	configCmd.AddCommand(configEditCmd)
+	configCmd.AddCommand(configReloadCmd)
 	configCmd.AddCommand(configPathCmd)
 }
 
@@ -96,9 +121,44 @@ func runConfigEdit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("editor exited: %w", err)
 	}
 
+	// Apply the changes now rather than when the agent notices them.
+	result, err := control.ReloadConfig(socketPath())
+	if err != nil {
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) {
+			fmt.Fprintf(os.Stderr, "Warning: the running agent did not apply the changes: %v\n", err)
+		}
+		return nil
+	}
+	printReloadResult(result)
+	return nil
+}
+
+func runConfigReload(cmd *cobra.Command, args []string) error {
+	result, err := control.ReloadConfig(socketPath())
+	if err != nil {
+		return err
+	}
+	printReloadResult(result)
 	return nil
 }
 
+// printReloadResult prints what a config reload applied and what needs a
+// restart.
+func printReloadResult(result *control.ReloadResult) {
+	if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+		fmt.Println("No changes to apply.")
+		return
+	}
+	if len(result.Applied) > 0 {
+		fmt.Fprintf(os.Stdout, "%s %s\n", styleKey.Render("Applied:"), strings.Join(result.Applied, ", "))
+	}
+	if len(result.RestartRequired) > 0 {
+		fmt.Fprintf(os.Stdout, "%s %s\n", styleKey.Render("Restart required:"), strings.Join(result.RestartRequired, ", "))
+		fmt.Println("Run 'sudo bamgate restart' to apply these.")
+	}
+}
+
 func runConfigPath(cmd *cobra.Command, args []string) error {
 	cfgPath := resolvedConfigPath()
 	fmt.Println(filepath.Dir(cfgPath))
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"github.com/kuuji/bamgate/internal/auth"
 	"github.com/kuuji/bamgate/internal/config"
 	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/netmap"
 )
 
 var devicesCmd = &cobra.Command{
@@ -47,7 +50,14 @@ var devicesConfigureCmd = &cobra.Command{
 	Short: "Interactively configure what to accept from devices",
 	Long: `Open an interactive TUI to select which routes, DNS servers, and
 search domains to accept from each online device. Selections are saved
-to the config file and applied immediately.`,
+to the config file and applied immediately.
+
+Each accepted route can optionally be reached through an alias prefix of
+the same size (e.g. 10.201.1.0/24 for a remote 192.168.1.0/24), so several
+sites using the same subnet can be reached at once. The remote device
+translates the alias back to the real subnet, and DNS answers from its
+resolvers are rewritten into the alias. Aliases apply on the next
+connection to the device.`,
 	RunE: runDevicesConfigure,
 }
 
@@ -57,6 +67,19 @@ func init() {
 	devicesCmd.AddCommand(devicesConfigureCmd)
 }
 
+// formatServices renders published services as "name=port/proto", or
+// "port/proto" for unnamed ones, separated by spaces.
+func formatServices(services []control.Service) string {
+	parts := make([]string, len(services))
+	for i, svc := range services {
+		parts[i] = fmt.Sprintf("%d/%s", svc.Port, svc.Protocol)
+		if svc.Name != "" {
+			parts[i] = svc.Name + "=" + parts[i]
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
 // httpBaseURL converts the WSS signaling URL from config to an HTTPS base URL
 // suitable for REST API calls.
 func httpBaseURL(serverURL string) string {
@@ -91,7 +114,7 @@ func getJWT(cfgPath string) (jwt, baseURL string, cfg *config.Config, err error)
 	baseURL = httpBaseURL(cfg.Network.ServerURL)
 
 	// Try to borrow the JWT from the running daemon first.
-	socketPath := control.ResolveSocketPath()
+	socketPath := socketPath()
 	token, err := control.FetchToken(socketPath)
 	if err == nil && token != "" {
 		return token, baseURL, cfg, nil
@@ -133,7 +156,7 @@ type liveState struct {
 // fetchLivePeers queries the running daemon for connected peer status and
 // offerings. Returns daemonRunning=false (not an error) if the daemon is offline.
 func fetchLivePeers() liveState {
-	socketPath := control.ResolveSocketPath()
+	socketPath := socketPath()
 
 	peers := make(map[string]peerInfoByName)
 
@@ -231,6 +254,12 @@ func runDevicesList(cmd *cobra.Command, args []string) error {
 						caps = append(caps, fmt.Sprintf("routes: %d/%d", accepted, total))
 						hasConfigurableDevices = true
 					}
+					if n := len(info.offering.Conflicts); n > 0 {
+						caps = append(caps, styleWarning.Render(fmt.Sprintf("conflicts: %d", n)))
+					}
+					if n := len(info.offering.InstalledRoutes) - len(info.offering.PrimaryRoutes); n > 0 {
+						caps = append(caps, fmt.Sprintf("standby: %d", n))
+					}
 					if len(info.offering.Advertised.DNS) > 0 {
 						accepted := len(info.offering.Accepted.DNS)
 						total := len(info.offering.Advertised.DNS)
@@ -243,6 +272,9 @@ func runDevicesList(cmd *cobra.Command, args []string) error {
 						caps = append(caps, fmt.Sprintf("search: %d/%d", accepted, total))
 						hasConfigurableDevices = true
 					}
+					if len(info.offering.Advertised.Services) > 0 {
+						caps = append(caps, "services: "+formatServices(info.offering.Advertised.Services))
+					}
 				}
 			}
 		}
@@ -373,7 +405,7 @@ func runDevicesRevoke(cmd *cobra.Command, args []string) error {
 }
 
 func runDevicesConfigure(cmd *cobra.Command, args []string) error {
-	socketPath := control.ResolveSocketPath()
+	socketPath := socketPath()
 
 	offerings, err := control.FetchOfferings(socketPath)
 	if err != nil {
@@ -409,13 +441,17 @@ func runDevicesConfigure(cmd *cobra.Command, args []string) error {
 		if len(o.Advertised.Routes) > 0 {
 			routeOptions := make([]huh.Option[string], len(o.Advertised.Routes))
 			for i, r := range o.Advertised.Routes {
-				routeOptions[i] = huh.NewOption(r, r)
+				routeOptions[i] = huh.NewOption(routeOptionLabel(r, o.Conflicts), r)
+			}
+			routeDesc := "Select subnet routes to accept from this device"
+			if len(o.Conflicts) > 0 {
+				routeDesc += "\nSome routes overlap local subnets: " + conflictPolicyHint(o.ConflictPolicy)
 			}
 			selectedRoutes = append([]string{}, o.Accepted.Routes...)
 			formFields = append(formFields,
 				huh.NewMultiSelect[string]().
 					Title("Routes").
-					Description("Select subnet routes to accept from this device").
+					Description(routeDesc).
 					Options(routeOptions...).
 					Value(&selectedRoutes),
 			)
@@ -465,13 +501,27 @@ func runDevicesConfigure(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("form cancelled: %w", err)
 		}
 
+		aliases, err := runAliasForm(selectedRoutes, o.Accepted.RouteAliases)
+		if err != nil {
+			return err
+		}
+
+		priority := o.Accepted.RoutePriority
+		if shared := sharedRoutes(o.PeerID, selectedRoutes, offerings); len(shared) > 0 {
+			if priority, err = runPriorityForm(shared, priority); err != nil {
+				return err
+			}
+		}
+
 		// Send the selections to the running agent.
 		req := control.ConfigureRequest{
 			PeerID: o.PeerID,
 			Selections: control.PeerCapabilities{
-				Routes:    selectedRoutes,
-				DNS:       selectedDNS,
-				DNSSearch: selectedSearch,
+				Routes:        selectedRoutes,
+				DNS:           selectedDNS,
+				DNSSearch:     selectedSearch,
+				RouteAliases:  aliases,
+				RoutePriority: priority,
 			},
 		}
 
@@ -480,16 +530,139 @@ func runDevicesConfigure(cmd *cobra.Command, args []string) error {
 		}
 
 		fmt.Fprintf(os.Stderr, "Saved selections for %s.\n", o.PeerID)
-		printSelectionSummary(selectedRoutes, selectedDNS, selectedSearch)
+		printSelectionSummary(selectedRoutes, aliases, selectedDNS, selectedSearch)
 	}
 
 	return nil
 }
 
-func printSelectionSummary(routes, dns, search []string) {
+// sharedRoutes returns the routes in selected that other connected devices
+// advertise too, i.e. routes this device may be primary or standby for.
+func sharedRoutes(peerID string, selected []string, offerings []control.PeerOfferings) []string {
+	var shared []string
+	for _, route := range selected {
+		for _, other := range offerings {
+			if other.PeerID != peerID && slices.Contains(other.Advertised.Routes, route) {
+				shared = append(shared, route)
+				break
+			}
+		}
+	}
+	return shared
+}
+
+// runPriorityForm asks for the device's route priority when other devices
+// advertise the same routes.
+func runPriorityForm(shared []string, current int) (int, error) {
+	value := strconv.Itoa(current)
+	form := huh.NewForm(huh.NewGroup(
+		huh.NewInput().
+			Title("Route priority").
+			Description(fmt.Sprintf("Other devices also advertise %s. The highest-priority healthy device "+
+				"carries these routes; the others take over if it fails.", strings.Join(shared, ", "))).
+			Validate(func(s string) error {
+				if _, err := strconv.Atoi(strings.TrimSpace(s)); err != nil {
+					return fmt.Errorf("priority must be an integer")
+				}
+				return nil
+			}).
+			Value(&value),
+	)).WithTheme(customHuhTheme())
+	if err := form.Run(); err != nil {
+		return 0, fmt.Errorf("form cancelled: %w", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(value))
+}
+
+// runAliasForm asks for an optional alias prefix for each selected route,
+// prefilled with the current aliases. Routes left blank are used as-is.
+func runAliasForm(routes []string, current map[string]string) (map[string]string, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, len(routes))
+	fields := make([]huh.Field, len(routes))
+	for i, route := range routes {
+		values[i] = current[route]
+		fields[i] = huh.NewInput().
+			Title("Alias for " + route).
+			Description("Optional same-size prefix to reach this route through, e.g. when another site uses the same subnet. Leave blank for none.").
+			Placeholder("10.201.1.0/24").
+			Validate(func(s string) error {
+				if s = strings.TrimSpace(s); s == "" {
+					return nil
+				}
+				_, err := netmap.Parse(route, s)
+				return err
+			}).
+			Value(&values[i])
+	}
+
+	form := huh.NewForm(huh.NewGroup(fields...)).WithTheme(customHuhTheme())
+	if err := form.Run(); err != nil {
+		return nil, fmt.Errorf("form cancelled: %w", err)
+	}
+
+	var aliases map[string]string
+	for i, route := range routes {
+		alias := strings.TrimSpace(values[i])
+		if alias == "" {
+			continue
+		}
+		if aliases == nil {
+			aliases = make(map[string]string)
+		}
+		aliases[route] = alias
+	}
+	return aliases, nil
+}
+
+// routeOptionLabel annotates an advertised route with the local subnets it
+// overlaps, so conflicts are visible before the route is accepted.
+func routeOptionLabel(route string, conflicts []control.RouteConflict) string {
+	var locals []string
+	for _, c := range conflicts {
+		if c.Route != route {
+			continue
+		}
+		l := c.LocalSubnet
+		if c.Interface != "" {
+			l += " on " + c.Interface
+		}
+		locals = append(locals, l)
+	}
+	if len(locals) == 0 {
+		return route
+	}
+	return fmt.Sprintf("%s (conflicts with local %s)", route, strings.Join(locals, ", "))
+}
+
+// conflictPolicyHint explains what the agent does with a conflicting route
+// under the given device.route_conflict_policy.
+func conflictPolicyHint(policy string) string {
+	switch policy {
+	case "refuse":
+		return "they will not be installed."
+	case "more-specific":
+		return "only their non-conflicting parts will be installed."
+	default:
+		return "they will be installed anyway and may break local access " +
+			"(see device.route_conflict_policy)."
+	}
+}
+
+func printSelectionSummary(routes []string, aliases map[string]string, dns, search []string) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	if len(routes) > 0 {
-		fmt.Fprintf(w, "  Routes:\t%s\n", strings.Join(routes, ", "))
+		labels := make([]string, len(routes))
+		for i, r := range routes {
+			labels[i] = r
+			if alias, ok := aliases[r]; ok {
+				labels[i] = fmt.Sprintf("%s as %s", r, alias)
+			}
+		}
+		fmt.Fprintf(w, "  Routes:\t%s\n", strings.Join(labels, ", "))
 	}
 	if len(dns) > 0 {
 		fmt.Fprintf(w, "  DNS:\t%s\n", strings.Join(dns, ", "))
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 
 	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/agent"
 )
 
 var downCmd = &cobra.Command{
@@ -15,7 +18,11 @@ var downCmd = &cobra.Command{
 	Long: `Stop the bamgate system service and disable it from starting on boot.
 
 This is the counterpart to 'sudo bamgate up -d'.
-If bamgate is running in the foreground, press Ctrl+C to stop it instead.`,
+If bamgate is running in the foreground, press Ctrl+C to stop it instead.
+
+Once the service has stopped, forwarding, NAT rules, routes and DNS
+settings left behind by an agent that was killed or crashed are undone,
+as recorded in its state journal under /run/bamgate.`,
 	RunE: runDown,
 }
 
@@ -24,14 +31,36 @@ func runDown(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("'bamgate down' requires root (try: sudo bamgate down)")
 	}
 
+	var err error
 	switch runtime.GOOS {
 	case "linux":
-		return runDownLinux()
+		err = runDownLinux()
 	case "darwin":
-		return runDownDarwin()
+		err = runDownDarwin()
 	default:
 		return fmt.Errorf("'bamgate down' is not supported on %s", runtime.GOOS)
 	}
+	if err != nil {
+		return err
+	}
+	return recoverLeftoverState()
+}
+
+// recoverLeftoverState undoes the kernel changes the stopped agents of
+// every network did not clean up, e.g. because they were killed.
+func recoverLeftoverState() error {
+	networks, err := upNetworks()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, n := range networks {
+		if err := agent.RecoverState(agent.StatePath(n.name), globalLogger); err != nil {
+			errs = append(errs, fmt.Errorf("network %s: cleaning up after previous run: %w",
+				networkDisplayName(n.name), err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func runDownLinux() error {
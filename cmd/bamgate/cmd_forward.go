@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+var (
+	forwardReverse bool
+	forwardUDP     bool
+	forwardPersist bool
+)
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward <listen> <target>",
+	Short: "Forward a TCP or UDP port over the network",
+	Long: `Forward a port over the running bamgate tunnel without touching kernel
+routes.
+
+A local forward listens on this machine and connects to a mesh destination.
+The target host may be a device name or any address reachable through the
+mesh (including accepted subnet routes):
+  bamgate forward 127.0.0.1:5432 home-server:5432
+  bamgate forward 8080 192.168.1.10:80
+
+A reverse forward (--reverse) listens on this device's tunnel address and
+connects to a local address, exposing a local service to other devices:
+  bamgate forward --reverse 3000 127.0.0.1:3000
+
+A bare port listens on 127.0.0.1 (or the tunnel address with --reverse).
+Forwards run inside the agent, including in userspace mode, until removed
+or the agent stops. Use --persist to save a forward to the config file so
+it is restarted with the agent.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runForward,
+}
+
+var forwardListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List active port forwards",
+	Args:  cobra.NoArgs,
+	RunE:  runForwardList,
+}
+
+var forwardRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Stop a port forward (and remove it from the config if persistent)",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runForwardRemove,
+}
+
+func init() {
+	forwardCmd.Flags().BoolVar(&forwardReverse, "reverse", false, "listen on the tunnel address and forward to a local address")
+	forwardCmd.Flags().BoolVar(&forwardUDP, "udp", false, "forward UDP instead of TCP")
+	forwardCmd.Flags().BoolVar(&forwardPersist, "persist", false, "save the forward to the config file")
+	forwardCmd.AddCommand(forwardListCmd)
+	forwardCmd.AddCommand(forwardRemoveCmd)
+}
+
+func runForward(cmd *cobra.Command, args []string) error {
+	req := control.Forward{
+		Protocol:   "tcp",
+		Reverse:    forwardReverse,
+		Listen:     args[0],
+		Target:     args[1],
+		Persistent: forwardPersist,
+	}
+	if forwardUDP {
+		req.Protocol = "udp"
+	}
+
+	fwd, err := control.AddForward(socketPath(), req)
+	if err != nil {
+		return fmt.Errorf("is bamgate running? %w", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "Forward %s started: %s %s -> %s\n", fwd.ID, fwd.Protocol, fwd.Listen, fwd.Target)
+	if fwd.Persistent {
+		fmt.Fprintln(os.Stdout, "Saved to config; it will be restarted with the agent.")
+	}
+	fmt.Fprintf(os.Stdout, "Stop it with: bamgate forward remove %s\n", fwd.ID)
+	return nil
+}
+
+func runForwardList(cmd *cobra.Command, args []string) error {
+	forwards, err := control.FetchForwards(socketPath())
+	if err != nil {
+		return fmt.Errorf("is bamgate running? %w", err)
+	}
+
+	if len(forwards) == 0 {
+		fmt.Println("No port forwards.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPROTO\tDIRECTION\tLISTEN\tTARGET\tACTIVE\tPERSISTENT")
+	for _, f := range forwards {
+		direction := "local"
+		if f.Reverse {
+			direction = "reverse"
+		}
+		persistent := "no"
+		if f.Persistent {
+			persistent = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			f.ID, f.Protocol, direction, f.Listen, f.Target, f.Active, persistent)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func runForwardRemove(cmd *cobra.Command, args []string) error {
+	if err := control.RemoveForward(socketPath(), args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Forward %s removed.\n", args[0])
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+var networksCmd = &cobra.Command{
+	Use:   "networks",
+	Short: "List configured networks",
+	Long: `List the networks configured on this machine and whether their agent is
+running.
+
+The default network is configured in /etc/bamgate/config.toml. Each
+additional network has its own directory, /etc/bamgate/networks/<name>,
+with its own config.toml and secrets.toml, and gets its own TUN interface
+(bg-<name> on Linux), WireGuard device, signaling connection and control
+socket. Create one with:
+  sudo bamgate --network client setup
+
+'bamgate up' runs all configured networks side by side, refusing to start
+when their tunnel subnets overlap. Every other command acts on the default
+network unless given --network <name>, e.g.:
+  bamgate --network client status
+
+Conflicts between the networks' configs are listed after the table.`,
+	Args: cobra.NoArgs,
+	RunE: runNetworks,
+}
+
+func runNetworks(cmd *cobra.Command, args []string) error {
+	names, err := config.ListNetworks(config.DefaultConfigDir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(config.NetworkConfigPath(config.DefaultConfigDir, "")); err == nil {
+		names = append([]string{""}, names...)
+	}
+	if len(names) == 0 {
+		fmt.Println("No networks configured. Run 'sudo bamgate setup' to configure one.")
+		return nil
+	}
+
+	cfgs := make(map[string]*config.Config, len(names))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NETWORK\tSTATE\tDEVICE\tADDRESS\tPEERS\tCONFIG")
+	for _, name := range names {
+		cfgPath := config.NetworkConfigPath(config.DefaultConfigDir, name)
+		device, address := "-", "-"
+		if cfg, err := config.LoadPublicConfig(cfgPath); err == nil {
+			cfgs[name] = cfg
+			device, address = cfg.Device.Name, cfg.Device.Address
+		}
+
+		state, peers := "stopped", "-"
+		if status, err := control.FetchStatus(control.NetworkSocketPath(name)); err == nil {
+			state, peers = "running", strconv.Itoa(len(status.Peers))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			networkDisplayName(name), state, device, address, peers, cfgPath)
+	}
+	w.Flush()
+
+	conflicts := config.CheckNetworkConflicts(cfgs)
+	if len(conflicts) == 0 {
+		return nil
+	}
+	fmt.Println()
+	for _, c := range conflicts {
+		severity := "warning"
+		if c.Fatal {
+			severity = "error"
+		}
+		fmt.Fprintf(os.Stdout, "%s: %v\n", severity, c)
+	}
+	return nil
+}
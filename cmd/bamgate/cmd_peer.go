@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+var peerCmd = &cobra.Command{
+	Use:   "peer",
+	Short: "Restart, reconnect or block individual peers",
+	Long: `Act on one peer's connection without restarting bamgate, which would drop
+every peer.
+
+  bamgate peer restart-ice home    # renegotiate the network path
+  bamgate peer reconnect home      # tear the connection down and start over
+  bamgate peer block old-phone     # disconnect and refuse to reconnect
+  bamgate peer unblock old-phone
+
+Try restart-ice first when a peer stops passing traffic: it finds a new path
+and keeps the session. reconnect builds a new session on both sides. Blocked
+peers are saved in device.blocked_peers and shown by bamgate status.`,
+}
+
+// peerActionCmd returns a "bamgate peer" subcommand performing action.
+func peerActionCmd(action, short, done string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " <peer>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := control.PeerAction(socketPath(), args[0], action); err != nil {
+				return err
+			}
+			fmt.Printf(done+"\n", args[0])
+			return nil
+		},
+	}
+}
+
+func init() {
+	peerCmd.AddCommand(
+		peerActionCmd(control.PeerActionRestartICE, "Restart ICE with a peer", "ICE restart started with %s."),
+		peerActionCmd(control.PeerActionReconnect, "Tear down and re-establish the connection with a peer", "Reconnecting to %s."),
+		peerActionCmd(control.PeerActionBlock, "Disconnect a peer and refuse to reconnect to it", "Blocked %s."),
+		peerActionCmd(control.PeerActionUnblock, "Allow a blocked peer to connect again", "Unblocked %s."),
+	)
+}
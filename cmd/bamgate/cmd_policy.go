@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/policy"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Manage the network-wide policy stored on the signaling server",
+	Long: `Manage the policy the signaling server pushes to every device on the
+network: access control rules, routes to accept automatically per device
+or group, DNS servers to use, and which devices are hubs and spokes.
+
+  acl_default = "deny"
+
+  [groups]
+  laptops = ["work-laptop", "home-laptop"]
+
+  [[acl]]
+  from = ["group:laptops"]
+  to = ["home-gw"]
+  allow = ["192.168.1.0/24:22,443/tcp"]
+
+  [[routes]]
+  devices = ["group:laptops"]
+  from = "home-gw"
+  accept = ["192.168.1.0/24"]
+
+  [[dns]]
+  from = "home-gw"
+  servers = ["192.168.1.1"]
+  search = ["home.lan"]
+
+  [topology]
+  hubs = ["home-gw"]
+  spokes = ["group:laptops"]
+
+Entries select devices by name, "group:<name>" or "*"; an empty to or
+devices list means every device. Devices enforce the network ACL together
+with their own [peers.*] allow rules, so local rules can only narrow it. A
+local route or DNS selection for a peer replaces the policy's for that peer.
+Spokes connect only to hubs and reach each other through them; a device's
+own device.role overrides the topology section. ACL and topology changes
+apply immediately; route and DNS changes apply as peers reconnect.`,
+	Args: cobra.NoArgs,
+	RunE: runPolicyGet,
+}
+
+var policyGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Print the current network policy",
+	Args:  cobra.NoArgs,
+	RunE:  runPolicyGet,
+}
+
+var policySetCmd = &cobra.Command{
+	Use:   "set <file>",
+	Short: "Validate a policy file and upload it to the signaling server",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPolicySet,
+}
+
+var policyValidateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Check a policy file without uploading it",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPolicyValidate,
+}
+
+func init() {
+	policyCmd.AddCommand(policyGetCmd)
+	policyCmd.AddCommand(policySetCmd)
+	policyCmd.AddCommand(policyValidateCmd)
+}
+
+// policyServer returns the signaling server's HTTP base URL and a JWT for
+// it. Devices without registration credentials talk to a self-hosted hub,
+// which does not authenticate, so they get an empty token.
+func policyServer() (jwt, baseURL string, err error) {
+	cfgPath := resolvedConfigPath()
+	cfg, err := config.LoadPublicConfig(cfgPath)
+	if err != nil {
+		return "", "", fmt.Errorf("loading config: %w", err)
+	}
+	if cfg.Network.ServerURL == "" {
+		return "", "", fmt.Errorf("server_url not configured — run 'bamgate setup' first")
+	}
+	if cfg.Network.DeviceID == "" {
+		return "", httpBaseURL(cfg.Network.ServerURL), nil
+	}
+	jwt, baseURL, _, err = getJWT(cfgPath)
+	return jwt, baseURL, err
+}
+
+func runPolicyGet(cmd *cobra.Command, args []string) error {
+	jwt, baseURL, err := policyServer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	doc, version, err := policy.Fetch(ctx, baseURL, jwt)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		fmt.Fprintln(os.Stderr, "No network policy set. Upload one with: bamgate policy set <file>")
+		return nil
+	}
+
+	out, err := doc.TOML()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# version %d\n", version)
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func runPolicySet(cmd *cobra.Command, args []string) error {
+	doc, err := loadPolicyFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	jwt, baseURL, err := policyServer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	version, err := policy.Upload(ctx, baseURL, jwt, doc)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Network policy updated to version %d and pushed to connected devices.\n", version)
+	return nil
+}
+
+func runPolicyValidate(cmd *cobra.Command, args []string) error {
+	doc, err := loadPolicyFile(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is valid: %d group(s), %d acl, %d routes and %d dns entries.\n",
+		args[0], len(doc.Groups), len(doc.ACL), len(doc.Routes), len(doc.DNS))
+	return nil
+}
+
+// loadPolicyFile reads and validates a TOML or JSON policy file.
+func loadPolicyFile(path string) (*policy.Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy file: %w", err)
+	}
+	doc, err := policy.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, nil
+}
@@ -57,8 +57,11 @@ func runSetup(cmd *cobra.Command, args []string) error {
 
 	// Root-only housekeeping: migrations and permission fixes.
 	if isRoot {
-		if err := migrateConfig(cfgPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: config migration check failed: %v\n", err)
+		// The legacy user-level config can only be the default network's.
+		if selectedNetwork() == "" {
+			if err := migrateConfig(cfgPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: config migration check failed: %v\n", err)
+			}
 		}
 		if err := config.MigrateConfigSplit(cfgPath); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: config split migration failed: %v\n", err)
@@ -257,6 +260,7 @@ func reauthenticateDevice(ctx context.Context, cfgPath string, cfg *config.Confi
 	cfg.Network.RefreshToken = resp.RefreshToken
 	cfg.Network.TURNSecret = resp.TURNSecret
 	cfg.Device.Address = resp.Address
+	cfg.Device.Address6 = resp.Address6
 
 	if err := config.SaveConfig(cfgPath, cfg); err != nil {
 		return fmt.Errorf("saving config: %w", err)
@@ -264,7 +268,11 @@ func reauthenticateDevice(ctx context.Context, cfgPath string, cfg *config.Confi
 
 	fmt.Fprintf(os.Stderr, "  Device re-registered\n")
 	fmt.Fprintf(os.Stderr, "  Device ID: %s\n", resp.DeviceID)
-	fmt.Fprintf(os.Stderr, "  Tunnel address: %s\n\n", resp.Address)
+	fmt.Fprintf(os.Stderr, "  Tunnel address: %s\n", resp.Address)
+	if resp.Address6 != "" {
+		fmt.Fprintf(os.Stderr, "  Tunnel IPv6 address: %s\n", resp.Address6)
+	}
+	fmt.Fprintln(os.Stderr)
 
 	return nil
 }
@@ -330,7 +338,7 @@ func runSetupFull(cfgPath string) error {
 	fmt.Fprintf(os.Stderr, "  WireGuard key pair generated\n")
 
 	// --- Step 3b: Route advertisement ---
-	cfg.Device.Routes = promptRouteAdvertisement(scanner, cfg.Device.Address)
+	cfg.Device.Routes = promptRouteAdvertisement(scanner, cfg.Device.Address, cfg.Device.Address6)
 
 	// --- Step 4: Save config ---
 	if err := config.SaveConfig(cfgPath, cfg); err != nil {
@@ -376,6 +384,9 @@ func runSetupFull(cfgPath string) error {
 	fmt.Fprintf(os.Stderr, "\nSetup complete!\n")
 	fmt.Fprintf(os.Stderr, "  Public key: %s\n", pubKey.String())
 	fmt.Fprintf(os.Stderr, "  Tunnel address: %s\n", cfg.Device.Address)
+	if cfg.Device.Address6 != "" {
+		fmt.Fprintf(os.Stderr, "  Tunnel IPv6 address: %s\n", cfg.Device.Address6)
+	}
 	fmt.Fprintf(os.Stderr, "  Device ID: %s\n", cfg.Network.DeviceID)
 
 	if serviceStarted {
@@ -556,6 +567,9 @@ func registerDevice(ctx context.Context, scanner *bufio.Scanner, serverURL, gith
 
 	fmt.Fprintf(os.Stderr, "  Device registered\n")
 	fmt.Fprintf(os.Stderr, "  Tunnel address: %s (auto-assigned)\n", resp.Address)
+	if resp.Address6 != "" {
+		fmt.Fprintf(os.Stderr, "  Tunnel IPv6 address: %s (auto-assigned)\n", resp.Address6)
+	}
 
 	// Build the signaling WebSocket URL.
 	wsURL, err := normalizeServerURL(serverURL + "/connect")
@@ -569,6 +583,7 @@ func registerDevice(ctx context.Context, scanner *bufio.Scanner, serverURL, gith
 	cfg.Network.DeviceID = resp.DeviceID
 	cfg.Network.RefreshToken = resp.RefreshToken
 	cfg.Device.Address = resp.Address
+	cfg.Device.Address6 = resp.Address6
 
 	// Save Cloudflare credentials if this was a new network deploy.
 	if apiToken != "" {
@@ -608,9 +623,10 @@ func enableAndStartService() error {
 }
 
 // promptRouteAdvertisement discovers local subnets and asks the user which
-// ones to advertise to peers. Returns nil if no routes are selected.
-func promptRouteAdvertisement(scanner *bufio.Scanner, tunnelAddress string) []string {
-	subnets, err := tunnel.DiscoverLocalSubnets(tunnelAddress)
+// ones to advertise to peers. The tunnel's own subnets are never offered.
+// Returns nil if no routes are selected.
+func promptRouteAdvertisement(scanner *bufio.Scanner, tunnelAddresses ...string) []string {
+	subnets, err := tunnel.DiscoverLocalSubnets(tunnelAddresses...)
 	if err != nil || len(subnets) == 0 {
 		return nil
 	}
@@ -668,6 +684,7 @@ Wants=network-online.target
 [Service]
 Type=simple
 ExecStart=/usr/local/bin/bamgate up
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=on-failure
 RestartSec=10
 
@@ -676,9 +693,13 @@ RestartSec=10
 StartLimitBurst=5
 StartLimitIntervalSec=300
 
-# Runtime directory for the control socket.
+# Runtime directory for the control socket and, in its private state/
+# subdirectory, the state journal. Kept when the service stops or crashes,
+# so the journal can be used to undo leftover forwarding, NAT, routes and
+# DNS.
 RuntimeDirectory=bamgate
 RuntimeDirectoryMode=0755
+RuntimeDirectoryPreserve=yes
 
 # State directory for persistent data.
 StateDirectory=bamgate
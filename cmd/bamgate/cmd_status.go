@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -11,22 +18,56 @@ import (
 	"github.com/kuuji/bamgate/internal/control"
 )
 
+var statusWatch bool
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show connection status",
-	Long:  `Query the running bamgate agent and display connected peers, connection type (direct/relayed), and tunnel addresses.`,
-	RunE:  runStatus,
+	Long: `Query the running bamgate agent and display connected peers, connection type (direct/relayed), and tunnel addresses.
+
+With --watch, keep running after the status and print the agent's events
+as they happen: peers discovered and removed, ICE state changes and
+restarts, data channels opening, routes, DNS, token refreshes, signaling
+reconnects and forwarding repairs.`,
+	RunE: runStatus,
+}
+
+// watchRetryInterval is how long "status --watch" waits before
+// resubscribing after the event stream ends, e.g. because the agent
+// restarted.
+const watchRetryInterval = 2 * time.Second
+
+func init() {
+	statusCmd.Flags().BoolVarP(&statusWatch, "watch", "w", false, "stream events after printing the status")
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
-	status, err := control.FetchStatus(control.ResolveSocketPath())
+	status, err := control.FetchStatus(socketPath())
 	if err != nil {
 		return fmt.Errorf("is bamgate running? %w", err)
 	}
+	printStatus(status)
+
+	if statusWatch {
+		return watchEvents()
+	}
+	return nil
+}
 
+// printStatus prints the agent header and peer table.
+func printStatus(status *control.Status) {
 	// Print header.
+	if status.Network != "" {
+		fmt.Fprintf(os.Stdout, "%s   %s\n", styleKey.Render("Network:"), status.Network)
+	}
 	fmt.Fprintf(os.Stdout, "%s    %s\n", styleKey.Render("Device:"), status.Device)
 	fmt.Fprintf(os.Stdout, "%s   %s\n", styleKey.Render("Address:"), status.Address)
+	if status.Address6 != "" {
+		fmt.Fprintf(os.Stdout, "%s  %s\n", styleKey.Render("Address6:"), status.Address6)
+	}
+	if status.Role != "" {
+		fmt.Fprintf(os.Stdout, "%s      %s\n", styleKey.Render("Role:"), status.Role)
+	}
 	routes := "none"
 	if len(status.Routes) > 0 {
 		routes = fmt.Sprintf("%v", status.Routes)
@@ -34,18 +75,34 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Fprintf(os.Stdout, "%s    %s\n", styleKey.Render("Routes:"), routes)
 	fmt.Fprintf(os.Stdout, "%s    %s\n", styleKey.Render("Server:"), status.ServerURL)
 	fmt.Fprintf(os.Stdout, "%s    %s\n", styleKey.Render("Uptime:"), formatDuration(time.Duration(status.UptimeSeconds*float64(time.Second))))
+	if status.ACL != nil {
+		fmt.Fprintf(os.Stdout, "%s       default %s, %d peer(s) with rules (see bamgate acl)\n",
+			styleKey.Render("ACL:"), status.ACL.Default, countRestricted(status.ACL.Peers))
+	}
+	if status.PolicyVersion > 0 {
+		fmt.Fprintf(os.Stdout, "%s    network policy version %d (see bamgate policy)\n",
+			styleKey.Render("Policy:"), status.PolicyVersion)
+	}
+	if len(status.Blocked) > 0 {
+		fmt.Fprintf(os.Stdout, "%s   %s (see bamgate peer unblock)\n",
+			styleKey.Render("Blocked:"), strings.Join(status.Blocked, ", "))
+	}
 	fmt.Fprintf(os.Stdout, "%s     %d\n", styleKey.Render("Peers:"), len(status.Peers))
 	fmt.Println()
 
 	if len(status.Peers) == 0 {
 		fmt.Println("No peers connected.")
-		return nil
+		return
 	}
 
 	// Print peer table.
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "PEER\tADDRESS\tSTATE\tICE TYPE\tROUTES\tCONNECTED")
+	fmt.Fprintln(w, "PEER\tADDRESS\tSTATE\tICE TYPE\tMTU\tROUTES\tCONNECTED")
 	for _, p := range status.Peers {
+		mtu := "-"
+		if p.MTU > 0 {
+			mtu = strconv.Itoa(p.MTU)
+		}
 		routes := "-"
 		if len(p.Routes) > 0 {
 			routes = fmt.Sprintf("%v", p.Routes)
@@ -54,12 +111,77 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		if !p.ConnectedSince.IsZero() {
 			connected = formatDuration(time.Since(p.ConnectedSince)) + " ago"
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			p.ID, p.Address, p.State, p.ICEType, routes, connected)
+		peer := p.ID
+		if p.Role != "" {
+			peer += " (" + p.Role + ")"
+		}
+		state := p.State
+		if p.Relay != "" {
+			state += " via " + p.Relay
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			peer, p.Address, state, p.ICEType, mtu, routes, connected)
 	}
 	w.Flush()
+}
 
-	return nil
+// watchEvents prints the agent's events until interrupted. When the stream
+// ends it resubscribes, so watching survives agent restarts; events that
+// happen in between are missed.
+func watchEvents() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println()
+	fmt.Println("Watching events, press Ctrl-C to stop.")
+	for {
+		err := control.WatchEvents(ctx, socketPath(), printEvent)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if opErr := (*net.OpError)(nil); !errors.As(err, &opErr) {
+			fmt.Fprintf(os.Stderr, "%s: %v, reconnecting\n", time.Now().Format(time.TimeOnly), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// printEvent prints ev as one line: its time, type and details.
+func printEvent(ev control.Event) {
+	var details []string
+	add := func(key, value string) {
+		if value != "" {
+			details = append(details, key+"="+value)
+		}
+	}
+	add("peer", ev.Peer)
+	add("state", ev.State)
+	add("route", ev.Route)
+	add("dns", strings.Join(ev.DNS, ","))
+	add("search", strings.Join(ev.DNSSearch, ","))
+	add("interface", ev.Interface)
+	add("relay", ev.Relay)
+	if ev.Attempt > 0 {
+		add("attempt", strconv.Itoa(ev.Attempt))
+	}
+	fmt.Fprintf(os.Stdout, "%s  %-22s %s\n",
+		ev.Time.Local().Format(time.TimeOnly), ev.Type, strings.Join(details, " "))
+}
+
+// countRestricted returns how many peers have local or network allow rules.
+func countRestricted(peers []control.ACLPeerStatus) int {
+	n := 0
+	for _, p := range peers {
+		if len(p.Allow) > 0 || len(p.NetworkAllow) > 0 {
+			n++
+		}
+	}
+	return n
 }
 
 // formatDuration formats a duration into a human-readable string like "2h15m" or "45s".
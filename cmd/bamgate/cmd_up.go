@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -16,11 +17,15 @@ import (
 	"github.com/kuuji/bamgate/internal/agent"
 	"github.com/kuuji/bamgate/internal/auth"
 	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/netstack"
+	"github.com/kuuji/bamgate/internal/tunnel"
 )
 
 var (
 	upDaemon       bool
 	upAcceptRoutes bool
+	upUserspace    bool
 )
 
 var upCmd = &cobra.Command{
@@ -32,6 +37,21 @@ signaling server, and bridge traffic over WebRTC data channels.
 Requires root privileges for TUN device creation:
   sudo bamgate up
 
+Use --userspace (or device.userspace = true) to run without root on an
+in-process network stack instead of a TUN device. The mesh is then reached
+through a local SOCKS5 / HTTP proxy (device.proxy_listen, default
+127.0.0.1:1080), e.g.:
+  bamgate up --userspace --config ~/.config/bamgate/config.toml
+  curl --proxy socks5h://127.0.0.1:1080 http://10.0.0.1:8080
+Routes advertised by this device are not forwarded in userspace mode.
+
+Without --network or --config, up runs the default network and every
+network configured under /etc/bamgate/networks side by side (see
+'bamgate networks'); with --network it runs only that one.
+
+The agent applies most config changes without reconnecting peers when
+config.toml changes, on SIGHUP, or with 'bamgate config reload'.
+
 Use -d/--daemon to start bamgate as a system service (systemd on Linux,
 launchd on macOS). The service is enabled on boot and started immediately.
 Requires 'sudo bamgate setup' first.`,
@@ -43,6 +63,7 @@ const systemdServicePath = "/etc/systemd/system/bamgate.service"
 func init() {
 	upCmd.Flags().BoolVarP(&upDaemon, "daemon", "d", false, "start as a system service (enable + start)")
 	upCmd.Flags().BoolVar(&upAcceptRoutes, "accept-routes", false, "accept subnet routes advertised by peers")
+	upCmd.Flags().BoolVar(&upUserspace, "userspace", false, "run without root on a userspace network stack with a local proxy")
 }
 
 func runUp(cmd *cobra.Command, args []string) error {
@@ -50,54 +71,218 @@ func runUp(cmd *cobra.Command, args []string) error {
 		return runUpDaemon()
 	}
 
-	// Migrate from monolithic config.toml to split config.toml + secrets.toml,
-	// then fix directory/file permissions for non-root CLI access.
-	if err := config.MigrateConfigSplit(resolvedConfigPath()); err != nil {
-		globalLogger.Warn("config split migration failed", "error", err)
+	networks, err := upNetworks()
+	if err != nil {
+		return err
 	}
-	if err := config.FixPermissions(resolvedConfigPath()); err != nil {
-		globalLogger.Warn("fixing config permissions failed", "error", err)
+	for _, n := range networks {
+		if err := n.load(); err != nil {
+			return n.wrap(err)
+		}
+	}
+	if err := checkNetworkConflicts(networks); err != nil {
+		return err
 	}
 
-	cfg, err := loadConfig()
+	agents := make([]*agent.Agent, len(networks))
+	for i, n := range networks {
+		a, err := n.newAgent()
+		if err != nil {
+			return n.wrap(err)
+		}
+		agents[i] = a
+	}
+
+	// Set up context with signal handling.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go reloadOnHangup(ctx, agents...)
+
+	if len(networks) == 1 {
+		return networks[0].run(ctx, agents[0])
+	}
+
+	// A network that fails stops the others too, so the service manager
+	// restarts them all. A revoked device only stops its own network.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(networks))
+	var wg sync.WaitGroup
+	for i, n := range networks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = n.run(ctx, agents[i]); errs[i] != nil {
+				errs[i] = n.wrap(errs[i])
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// upNetwork is a network started by "bamgate up".
+type upNetwork struct {
+	name    string // network name, "" for the default network
+	cfgPath string
+	cfg     *config.Config
+}
+
+// upNetworks returns the networks "bamgate up" runs: the one selected with
+// --network or --config, or else the default network and every network
+// configured under /etc/bamgate/networks.
+func upNetworks() ([]*upNetwork, error) {
+	if globalNetwork != "" || globalConfigPath != "" {
+		return []*upNetwork{{name: selectedNetwork(), cfgPath: resolvedConfigPath()}}, nil
+	}
+
+	names, err := config.ListNetworks(config.DefaultConfigDir)
 	if err != nil {
+		return nil, err
+	}
+	var networks []*upNetwork
+	defaultPath := resolvedConfigPath()
+	if _, err := os.Stat(defaultPath); err == nil || len(names) == 0 {
+		networks = append(networks, &upNetwork{cfgPath: defaultPath})
+	}
+	for _, name := range names {
+		networks = append(networks, &upNetwork{
+			name:    name,
+			cfgPath: config.NetworkConfigPath(config.DefaultConfigDir, name),
+		})
+	}
+	return networks, nil
+}
+
+// checkNetworkConflicts refuses to run networks whose configs clash, e.g.
+// with overlapping tunnel subnets, and logs the conflicts they can live
+// with, like overlapping routes.
+func checkNetworkConflicts(networks []*upNetwork) error {
+	if len(networks) < 2 {
+		return nil
+	}
+	cfgs := make(map[string]*config.Config, len(networks))
+	for _, n := range networks {
+		cfgs[n.name] = n.cfg
+	}
+
+	var fatal []error
+	for _, c := range config.CheckNetworkConflicts(cfgs) {
+		if c.Fatal {
+			fatal = append(fatal, c)
+			continue
+		}
+		globalLogger.Warn("network conflict, traffic goes to one of the networks per the route conflict policy",
+			"networks", networkDisplayName(c.Networks[0])+","+networkDisplayName(c.Networks[1]),
+			"conflict", c.Reason)
+	}
+	if len(fatal) > 0 {
+		return fmt.Errorf("conflicting networks: %w", errors.Join(fatal...))
+	}
+	return nil
+}
+
+// wrap prefixes err with the network's name, unless it is the default
+// network.
+func (n *upNetwork) wrap(err error) error {
+	if n.name == "" {
 		return err
 	}
+	return fmt.Errorf("network %s: %w", n.name, err)
+}
 
-	// CLI flag overrides config file.
-	if upAcceptRoutes {
-		cfg.Device.AcceptRoutes = true //nolint:staticcheck // legacy flag, deprecated in favor of per-peer selections
+// load migrates, loads and validates the network's config, applying the
+// command line overrides.
+func (n *upNetwork) load() error {
+	// Migrate from monolithic config.toml to split config.toml + secrets.toml,
+	// then fix directory/file permissions for non-root CLI access.
+	if err := config.MigrateConfigSplit(n.cfgPath); err != nil {
+		globalLogger.Warn("config split migration failed", "network", n.name, "error", err)
+	}
+	if err := config.FixPermissions(n.cfgPath); err != nil {
+		globalLogger.Warn("fixing config permissions failed", "network", n.name, "error", err)
 	}
 
+	cfg, err := config.LoadConfig(n.cfgPath)
+	if err != nil {
+		return fmt.Errorf("loading config from %s: %w", n.cfgPath, err)
+	}
+	upOverrides(cfg)
+
 	if err := validateConfig(cfg); err != nil {
 		return fmt.Errorf("invalid config: %w", err)
 	}
+	n.cfg = cfg
+	return nil
+}
 
-	// Set up context with signal handling.
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+// upOverrides applies the command line flags, which override the config
+// file, also when the agent reloads it.
+func upOverrides(cfg *config.Config) {
+	if upAcceptRoutes {
+		cfg.Device.AcceptRoutes = true //nolint:staticcheck // legacy flag, deprecated in favor of per-peer selections
+	}
+	if upUserspace {
+		cfg.Device.Userspace = true
+	}
+}
 
-	a := agent.New(cfg, globalLogger, agent.WithConfigPath(resolvedConfigPath()))
+// newAgent creates the network's agent from its loaded config.
+func (n *upNetwork) newAgent() (*agent.Agent, error) {
+	cfg := n.cfg
+	opts := []agent.Option{
+		agent.WithConfigPath(n.cfgPath),
+		agent.WithConfigOverrides(upOverrides),
+		agent.WithNetwork(n.name),
+	}
+	if !cfg.Device.Userspace {
+		// Userspace mode makes no kernel changes, so only kernel mode
+		// keeps a state journal.
+		opts = append(opts, agent.WithStateJournal(agent.StatePath(n.name)))
+	} else {
+		addresses := []string{cfg.Device.Address}
+		if cfg.Device.Address6 != "" {
+			addresses = append(addresses, cfg.Device.Address6)
+		}
+		stack, err := netstack.New(addresses, globalLogger)
+		if err != nil {
+			return nil, fmt.Errorf("creating userspace network stack: %w", err)
+		}
+		opts = append(opts, agent.WithUserspace(stack, cfg.Device.ProxyListen))
+	}
+	return agent.New(cfg, globalLogger, opts...), nil
+}
 
-	globalLogger.Info("starting bamgate", "config", resolvedConfigPath())
+// run runs the network's agent until ctx is cancelled or it fails.
+func (n *upNetwork) run(ctx context.Context, a *agent.Agent) error {
+	log := globalLogger
+	if n.name != "" {
+		log = log.With("network", n.name)
+	}
+	log.Info("starting bamgate", "config", n.cfgPath)
 
 	if err := a.Run(ctx); err != nil {
 		if ctx.Err() != nil {
 			// Context was cancelled (signal received) — clean shutdown.
-			globalLogger.Info("bamgate stopped")
+			log.Info("bamgate stopped")
 			return nil
 		}
 		// Device revoked or refresh token expired — this is permanent.
 		// Exit cleanly (return nil) so systemd's Restart=on-failure does
 		// NOT restart us. Retrying will never succeed.
 		if errors.Is(err, auth.ErrDeviceRevoked) {
-			globalLogger.Error("device has been revoked or refresh token expired — agent will not restart")
-			fmt.Fprintln(os.Stderr, "\nThis device has been revoked. Run 'bamgate setup' to re-register.")
+			log.Error("device has been revoked or refresh token expired — agent will not restart")
+			setup := "bamgate setup"
+			if n.name != "" {
+				setup = "bamgate --network " + n.name + " setup"
+			}
+			fmt.Fprintf(os.Stderr, "\nThis device has been revoked. Run '%s' to re-register.\n", setup)
 			return nil
 		}
 		// Provide actionable guidance for TUN permission errors.
-		if strings.Contains(err.Error(), "operation not permitted") || strings.Contains(err.Error(), "not permitted") {
-			return fmt.Errorf("agent error: %w\n\nTUN device creation requires root privileges.\nRun: sudo bamgate up", err)
+		if !n.cfg.Device.Userspace && (strings.Contains(err.Error(), "operation not permitted") || strings.Contains(err.Error(), "not permitted")) {
+			return fmt.Errorf("agent error: %w\n\nTUN device creation requires root privileges.\nRun: sudo bamgate up\nOr run without root: bamgate up --userspace", err)
 		}
 		return fmt.Errorf("agent error: %w", err)
 	}
@@ -105,6 +290,27 @@ func runUp(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// reloadOnHangup reloads the config of every agent each time the process
+// receives SIGHUP, until ctx is cancelled.
+func reloadOnHangup(ctx context.Context, agents ...*agent.Agent) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			for _, a := range agents {
+				if _, err := a.Reload(); err != nil {
+					globalLogger.Warn("reloading config on SIGHUP", "error", err)
+				}
+			}
+		}
+	}
+}
+
 // runUpDaemon starts bamgate as a system service (enable + start).
 func runUpDaemon() error {
 	if os.Getuid() != 0 {
@@ -178,6 +384,12 @@ func validateConfig(cfg *config.Config) error {
 	if cfg.Device.Address == "" {
 		return fmt.Errorf("device.address is required")
 	}
+	if _, err := tunnel.ParseDNSBackend(cfg.Device.DNSBackend); err != nil {
+		return fmt.Errorf("device.dns_backend: %w", err)
+	}
+	if _, err := tunnel.ParseRouteConflictPolicy(cfg.Device.RouteConflictPolicy); err != nil {
+		return fmt.Errorf("device.route_conflict_policy: %w", err)
+	}
 	return nil
 }
 
@@ -191,16 +403,34 @@ func loadConfig() (*config.Config, error) {
 	return cfg, nil
 }
 
+// selectedNetwork returns the network selected with --network, "" for the
+// default network.
+func selectedNetwork() string {
+	if globalNetwork == config.DefaultNetworkName {
+		return ""
+	}
+	return globalNetwork
+}
+
+// networkDisplayName returns the name network is shown as.
+func networkDisplayName(network string) string {
+	if network == "" {
+		return config.DefaultNetworkName
+	}
+	return network
+}
+
 // resolvedConfigPath returns the config file path, using the global flag
-// if set, otherwise the default system path (/etc/bamgate/config.toml).
+// if set, otherwise the selected network's config under /etc/bamgate
+// (/etc/bamgate/config.toml for the default network).
 func resolvedConfigPath() string {
 	if globalConfigPath != "" {
 		return globalConfigPath
 	}
-	p, err := config.DefaultConfigPath()
-	if err != nil {
-		// Fallback — this shouldn't happen in practice.
-		return "config.toml"
-	}
-	return p
+	return config.NetworkConfigPath(config.DefaultConfigDir, selectedNetwork())
+}
+
+// socketPath returns the control socket path of the selected network's agent.
+func socketPath() string {
+	return control.NetworkSocketPath(selectedNetwork())
 }
@@ -9,6 +9,8 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/kuuji/bamgate/internal/config"
 )
 
 // version is set at build time via -ldflags "-X main.version=...".
@@ -18,6 +20,7 @@ var version = "dev"
 // Global flags shared across subcommands.
 var (
 	globalConfigPath string
+	globalNetwork    string
 	globalVerbose    bool
 	globalLogger     *slog.Logger
 )
@@ -29,7 +32,13 @@ var rootCmd = &cobra.Command{
 	Long: `bamgate lets you access your home network from anywhere without
 exposing the home network's public IP. It tunnels WireGuard traffic
 over WebRTC data channels, using Cloudflare Workers for signaling.`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if n := selectedNetwork(); n != "" {
+			if err := config.ValidateNetworkName(n); err != nil {
+				return fmt.Errorf("--network: %w", err)
+			}
+		}
+
 		level := slog.LevelInfo
 		if globalVerbose {
 			level = slog.LevelDebug
@@ -37,11 +46,13 @@ over WebRTC data channels, using Cloudflare Workers for signaling.`,
 		globalLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 			Level: level,
 		}))
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&globalConfigPath, "config", "", "path to config file (default: /etc/bamgate/config.toml)")
+	rootCmd.PersistentFlags().StringVar(&globalNetwork, "network", "", "network to use (default: the default network; see 'bamgate networks')")
 	rootCmd.PersistentFlags().BoolVarP(&globalVerbose, "verbose", "v", false, "enable debug logging")
 
 	rootCmd.AddCommand(setupCmd)
@@ -49,8 +60,14 @@ func init() {
 	rootCmd.AddCommand(downCmd)
 	rootCmd.AddCommand(restartCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(networksCmd)
 	rootCmd.AddCommand(logsCmd)
 	rootCmd.AddCommand(devicesCmd)
+	rootCmd.AddCommand(peerCmd)
+	rootCmd.AddCommand(forwardCmd)
+	rootCmd.AddCommand(aclCmd)
+	rootCmd.AddCommand(captureCmd)
+	rootCmd.AddCommand(policyCmd)
 	rootCmd.AddCommand(workerCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(qrCmd)
@@ -32,6 +32,7 @@ var (
 	styleKey     = lipgloss.NewStyle().Foreground(lipgloss.Color(colorBlue)) // Blue for keys (Device:, etc.)
 	styleActive  = lipgloss.NewStyle().Foreground(lipgloss.Color(colorGreen))
 	styleRevoked = lipgloss.NewStyle().Foreground(lipgloss.Color(colorRed))
+	styleWarning = lipgloss.NewStyle().Foreground(lipgloss.Color(colorOrange))
 )
 
 // customHuhTheme returns a huh theme using our palette.
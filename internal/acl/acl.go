@@ -0,0 +1,332 @@
+// Package acl enforces per-peer access control on traffic arriving from the
+// tunnel. A Policy lists, for each peer, the destinations it may open
+// connections to; a Filter wraps the tun.Device handed to wireguard-go and
+// drops packets from peers that the policy does not allow. Replies to
+// connections this device opened are always let through.
+package acl
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kuuji/bamgate/internal/netmap"
+)
+
+// Action is what happens to traffic from a peer without allow rules.
+type Action string
+
+const (
+	// Allow lets peers without rules reach everything (the default, and
+	// bamgate's behavior before ACLs).
+	Allow Action = "allow"
+
+	// Deny blocks new connections from peers without rules.
+	Deny Action = "deny"
+)
+
+// ParseAction parses device.acl_default. An empty string means Allow.
+func ParseAction(s string) (Action, error) {
+	switch Action(s) {
+	case "", Allow:
+		return Allow, nil
+	case Deny:
+		return Deny, nil
+	default:
+		return "", fmt.Errorf("invalid ACL default %q (want allow or deny)", s)
+	}
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	First, Last uint16
+}
+
+// Rule allows traffic to Prefix on the given ports and protocol.
+type Rule struct {
+	Prefix netip.Prefix
+
+	// Ports restricts TCP and UDP traffic. Empty means every port.
+	Ports []PortRange
+
+	// Protocol is "tcp", "udp", "icmp" or "" for any.
+	Protocol string
+}
+
+// ParseRule parses a rule of the form "dest[:ports][/protocol]":
+//
+//	192.168.1.10:22,443/tcp
+//	192.168.1.0/24
+//	10.0.0.1:8000-8100/udp
+//	[fd00::10]:22/tcp
+//	10.0.0.1/icmp
+//
+// dest is an address or CIDR; ports is a comma-separated list of ports and
+// ranges, or "*". A rule with ports and no protocol matches TCP and UDP.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	spec := strings.TrimSpace(s)
+
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		switch p := spec[i+1:]; p {
+		case "tcp", "udp", "icmp":
+			r.Protocol = p
+			spec = spec[:i]
+		}
+	}
+
+	dest, ports := spec, ""
+	switch {
+	case strings.HasPrefix(spec, "["):
+		end := strings.Index(spec, "]")
+		if end < 0 {
+			return Rule{}, fmt.Errorf("invalid rule %q: missing ]", s)
+		}
+		dest = spec[1:end]
+		if rest := spec[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return Rule{}, fmt.Errorf("invalid rule %q: want [address]:ports", s)
+			}
+			ports = rest[1:]
+		}
+	case strings.Count(spec, ":") == 1:
+		dest, ports, _ = strings.Cut(spec, ":")
+	}
+
+	prefix, err := parseDest(dest)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+	}
+	r.Prefix = prefix
+
+	if ports != "" && ports != "*" {
+		if r.Protocol == "icmp" {
+			return Rule{}, fmt.Errorf("invalid rule %q: icmp has no ports", s)
+		}
+		r.Ports, err = parsePorts(ports)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+		}
+	}
+	return r, nil
+}
+
+// ParseRules parses a list of rules.
+func ParseRules(specs []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, s := range specs {
+		r, err := ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseDest(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parsePorts(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if isRange {
+			if hi, err = parsePort(last); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		ranges = append(ranges, PortRange{First: lo, Last: hi})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(n), nil
+}
+
+// String formats the rule in the syntax accepted by ParseRule.
+func (r Rule) String() string {
+	var b strings.Builder
+	dest := r.Prefix.String()
+	if r.Prefix.IsSingleIP() {
+		dest = r.Prefix.Addr().String()
+	}
+	if len(r.Ports) > 0 {
+		if r.Prefix.Addr().Is6() {
+			dest = "[" + dest + "]"
+		}
+		parts := make([]string, len(r.Ports))
+		for i, pr := range r.Ports {
+			parts[i] = strconv.Itoa(int(pr.First))
+			if pr.Last != pr.First {
+				parts[i] += "-" + strconv.Itoa(int(pr.Last))
+			}
+		}
+		dest += ":" + strings.Join(parts, ",")
+	}
+	b.WriteString(dest)
+	if r.Protocol != "" {
+		b.WriteString("/" + r.Protocol)
+	}
+	return b.String()
+}
+
+// matches reports whether the rule allows a packet to dst.
+func (r Rule) matches(proto uint8, dst netip.Addr, dport uint16, hasPort bool) bool {
+	if !r.Prefix.Contains(dst) {
+		return false
+	}
+	switch r.Protocol {
+	case "tcp":
+		if proto != protoTCP {
+			return false
+		}
+	case "udp":
+		if proto != protoUDP {
+			return false
+		}
+	case "icmp":
+		return proto == protoICMP || proto == protoICMPv6
+	}
+	if len(r.Ports) == 0 {
+		return true
+	}
+	if proto != protoTCP && proto != protoUDP {
+		return false
+	}
+	if !hasPort {
+		// A non-first fragment: the first one carried the ports and was
+		// checked, and the rest cannot be reassembled without it.
+		return true
+	}
+	return slices.ContainsFunc(r.Ports, func(pr PortRange) bool {
+		return dport >= pr.First && dport <= pr.Last
+	})
+}
+
+// Peer is the access granted to one peer.
+type Peer struct {
+	Name string
+
+	// Sources are the prefixes the peer sends from: its tunnel addresses
+	// and the routes it carries, as in its WireGuard AllowedIPs. Packets
+	// are attributed to the peer whose source prefix contains them.
+	Sources []netip.Prefix
+
+	// Aliases are the alias prefixes the peer uses for our advertised
+	// routes. Destinations inside an alias are checked at the real address
+	// the kernel translates them to.
+	Aliases []netmap.Mapping
+
+	// Rules restrict the peer to the listed destinations. Nil means the
+	// policy default applies.
+	Rules []Rule
+
+	// NetworkRules are the peer's rules from the network-wide policy. A
+	// packet must be allowed by them as well as by Rules, so local rules
+	// can only narrow the network policy. Nil means NetworkDefault applies.
+	NetworkRules []Rule
+}
+
+// Policy is the set of rules the Filter enforces.
+type Policy struct {
+	// Default applies to peers without rules and to packets from unknown
+	// sources.
+	Default Action
+
+	// NetworkDefault is the network-wide policy's default, applied like
+	// Default to peers without network rules. Empty means Allow.
+	NetworkDefault Action
+
+	Peers []Peer
+}
+
+// Restricts reports whether the policy can drop anything. A policy that
+// allows everything lets the Filter skip packet inspection.
+func (p *Policy) Restricts() bool {
+	if p == nil {
+		return false
+	}
+	if p.Default == Deny || p.NetworkDefault == Deny {
+		return true
+	}
+	return slices.ContainsFunc(p.Peers, func(peer Peer) bool {
+		return peer.Rules != nil || peer.NetworkRules != nil
+	})
+}
+
+// peerFor returns the peer a packet from src belongs to, preferring the
+// most specific source prefix, or nil if none matches.
+func (p *Policy) peerFor(src netip.Addr) *Peer {
+	var best *Peer
+	bits := -1
+	for i := range p.Peers {
+		for _, prefix := range p.Peers[i].Sources {
+			if prefix.Contains(src) && prefix.Bits() > bits {
+				best, bits = &p.Peers[i], prefix.Bits()
+			}
+		}
+	}
+	return best
+}
+
+// allowed decides whether a new packet from a peer may pass. It returns
+// the name of the peer the packet was attributed to ("" if unknown).
+func (p *Policy) allowed(pkt packetInfo) (string, bool) {
+	peer := p.peerFor(pkt.src)
+	if peer == nil {
+		return "", p.Default != Deny && p.NetworkDefault != Deny
+	}
+
+	dst := pkt.dst
+	for _, m := range peer.Aliases {
+		if addr, ok := m.ToRoute(dst); ok {
+			dst = addr
+			break
+		}
+	}
+	ok := permits(peer.NetworkRules, p.NetworkDefault, pkt, dst) &&
+		permits(peer.Rules, p.Default, pkt, dst)
+	return peer.Name, ok
+}
+
+// permits checks a packet to dst against one set of rules, falling back to
+// def when rules is nil.
+func permits(rules []Rule, def Action, pkt packetInfo, dst netip.Addr) bool {
+	if rules == nil {
+		return def != Deny
+	}
+	for _, r := range rules {
+		if r.matches(pkt.proto, dst, pkt.dport, pkt.hasPorts) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package acl
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/kuuji/bamgate/internal/netmap"
+)
+
+func TestParseRule(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    Rule
+		str     string
+		wantErr bool
+	}{
+		{
+			in:   "192.168.1.10:22,443/tcp",
+			want: Rule{Prefix: netip.MustParsePrefix("192.168.1.10/32"), Ports: []PortRange{{22, 22}, {443, 443}}, Protocol: "tcp"},
+		},
+		{
+			in:   "192.168.1.0/24",
+			want: Rule{Prefix: netip.MustParsePrefix("192.168.1.0/24")},
+		},
+		{
+			in:   "192.168.1.0/24:8000-8100/udp",
+			want: Rule{Prefix: netip.MustParsePrefix("192.168.1.0/24"), Ports: []PortRange{{8000, 8100}}, Protocol: "udp"},
+		},
+		{
+			in:   "10.0.0.1:*",
+			want: Rule{Prefix: netip.MustParsePrefix("10.0.0.1/32")},
+			str:  "10.0.0.1",
+		},
+		{
+			in:   "[fd00::10]:22/tcp",
+			want: Rule{Prefix: netip.MustParsePrefix("fd00::10/128"), Ports: []PortRange{{22, 22}}, Protocol: "tcp"},
+		},
+		{
+			in:   "fd00::/64",
+			want: Rule{Prefix: netip.MustParsePrefix("fd00::/64")},
+		},
+		{
+			in:   "10.0.0.1/icmp",
+			want: Rule{Prefix: netip.MustParsePrefix("10.0.0.1/32"), Protocol: "icmp"},
+		},
+		{
+			in:   "192.168.1.77/24",
+			want: Rule{Prefix: netip.MustParsePrefix("192.168.1.0/24")},
+			str:  "192.168.1.0/24",
+		},
+		{in: "10.0.0.1:0/tcp", wantErr: true},
+		{in: "10.0.0.1:22-10/tcp", wantErr: true},
+		{in: "10.0.0.1:22/icmp", wantErr: true},
+		{in: "phone:22", wantErr: true},
+		{in: "[fd00::1:22", wantErr: true},
+		{in: "10.0.0.1/sctp", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseRule(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Prefix != tt.want.Prefix || got.Protocol != tt.want.Protocol || !slices.Equal(got.Ports, tt.want.Ports) {
+				t.Errorf("ParseRule(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			str := tt.str
+			if str == "" {
+				str = tt.in
+			}
+			if got.String() != str {
+				t.Errorf("String() = %q, want %q", got.String(), str)
+			}
+		})
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]Action{"": Allow, "allow": Allow, "deny": Deny} {
+		got, err := ParseAction(in)
+		if err != nil || got != want {
+			t.Errorf("ParseAction(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseAction("drop"); err == nil {
+		t.Error("ParseAction(\"drop\") succeeded, want error")
+	}
+}
+
+func TestPolicy_allowed(t *testing.T) {
+	t.Parallel()
+
+	rules, err := ParseRules([]string{"192.168.1.10:22,443/tcp", "10.0.0.1/icmp"})
+	if err != nil {
+		t.Fatalf("ParseRules() error: %v", err)
+	}
+	alias, err := netmap.Parse("192.168.1.0/24", "10.201.1.0/24")
+	if err != nil {
+		t.Fatalf("netmap.Parse() error: %v", err)
+	}
+	policy := &Policy{
+		Default: Allow,
+		Peers: []Peer{
+			{
+				Name:    "phone",
+				Sources: []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32")},
+				Aliases: []netmap.Mapping{alias},
+				Rules:   rules,
+			},
+			{
+				Name:    "laptop",
+				Sources: []netip.Prefix{netip.MustParsePrefix("10.0.0.3/32"), netip.MustParsePrefix("172.16.0.0/16")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pkt      packetInfo
+		wantPeer string
+		want     bool
+	}{
+		{
+			name:     "allowed port",
+			pkt:      tcpInfo("10.0.0.2", "192.168.1.10", 443),
+			wantPeer: "phone",
+			want:     true,
+		},
+		{
+			name:     "other port",
+			pkt:      tcpInfo("10.0.0.2", "192.168.1.10", 80),
+			wantPeer: "phone",
+		},
+		{
+			name:     "other host",
+			pkt:      tcpInfo("10.0.0.2", "192.168.1.11", 22),
+			wantPeer: "phone",
+		},
+		{
+			name:     "udp to tcp rule",
+			pkt:      packetInfo{proto: protoUDP, src: addr("10.0.0.2"), dst: addr("192.168.1.10"), dport: 22, hasPorts: true},
+			wantPeer: "phone",
+		},
+		{
+			name:     "via alias",
+			pkt:      tcpInfo("10.0.0.2", "10.201.1.10", 22),
+			wantPeer: "phone",
+			want:     true,
+		},
+		{
+			name:     "icmp to tunnel address",
+			pkt:      packetInfo{proto: protoICMP, src: addr("10.0.0.2"), dst: addr("10.0.0.1")},
+			wantPeer: "phone",
+			want:     true,
+		},
+		{
+			name:     "tcp to tunnel address",
+			pkt:      tcpInfo("10.0.0.2", "10.0.0.1", 22),
+			wantPeer: "phone",
+		},
+		{
+			name:     "fragment to allowed host",
+			pkt:      packetInfo{proto: protoTCP, src: addr("10.0.0.2"), dst: addr("192.168.1.10")},
+			wantPeer: "phone",
+			want:     true,
+		},
+		{
+			name:     "unrestricted peer",
+			pkt:      tcpInfo("10.0.0.3", "192.168.1.11", 80),
+			wantPeer: "laptop",
+			want:     true,
+		},
+		{
+			name:     "unrestricted peer's route",
+			pkt:      tcpInfo("172.16.4.4", "192.168.1.11", 80),
+			wantPeer: "laptop",
+			want:     true,
+		},
+		{
+			name: "unknown source",
+			pkt:  tcpInfo("10.0.0.9", "192.168.1.11", 80),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			peer, ok := policy.allowed(tt.pkt)
+			if peer != tt.wantPeer || ok != tt.want {
+				t.Errorf("allowed() = %q, %v; want %q, %v", peer, ok, tt.wantPeer, tt.want)
+			}
+		})
+	}
+
+	deny := &Policy{Default: Deny, Peers: policy.Peers}
+	if _, ok := deny.allowed(tcpInfo("10.0.0.3", "192.168.1.11", 80)); ok {
+		t.Error("default deny allowed a peer without rules")
+	}
+	if _, ok := deny.allowed(tcpInfo("10.0.0.9", "192.168.1.11", 80)); ok {
+		t.Error("default deny allowed an unknown source")
+	}
+
+	// Network rules and local rules must both allow a packet.
+	ssh, err := ParseRules([]string{"192.168.1.0/24:22/tcp"})
+	if err != nil {
+		t.Fatalf("ParseRules() error: %v", err)
+	}
+	layered := &Policy{Default: Allow, NetworkDefault: Deny, Peers: []Peer{
+		{Name: "phone", Sources: policy.Peers[0].Sources, Rules: rules, NetworkRules: ssh},
+		policy.Peers[1],
+	}}
+	if _, ok := layered.allowed(tcpInfo("10.0.0.2", "192.168.1.10", 22)); !ok {
+		t.Error("packet allowed by both layers dropped")
+	}
+	if _, ok := layered.allowed(tcpInfo("10.0.0.2", "192.168.1.10", 443)); ok {
+		t.Error("local rule widened the network policy")
+	}
+	if _, ok := layered.allowed(tcpInfo("10.0.0.3", "192.168.1.11", 80)); ok {
+		t.Error("network default deny allowed a peer without network rules")
+	}
+}
+
+func TestPolicy_Restricts(t *testing.T) {
+	t.Parallel()
+
+	var nilPolicy *Policy
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   bool
+	}{
+		{"nil", nilPolicy, false},
+		{"allow without rules", &Policy{Default: Allow, Peers: []Peer{{Name: "a"}}}, false},
+		{"deny", &Policy{Default: Deny}, true},
+		{"peer with rules", &Policy{Default: Allow, Peers: []Peer{{Name: "a", Rules: []Rule{}}}}, true},
+		{"network deny", &Policy{Default: Allow, NetworkDefault: Deny}, true},
+		{"peer with network rules", &Policy{Peers: []Peer{{Name: "a", NetworkRules: []Rule{}}}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Restricts(); got != tt.want {
+			t.Errorf("%s: Restricts() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func addr(s string) netip.Addr { return netip.MustParseAddr(s) }
+
+func tcpInfo(src, dst string, dport uint16) packetInfo {
+	return packetInfo{proto: protoTCP, src: addr(src), dst: addr(dst), sport: 40000, dport: dport, hasPorts: true}
+}
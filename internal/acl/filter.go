@@ -0,0 +1,235 @@
+package acl
+
+import (
+	"log/slog"
+	"maps"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const (
+	// flowIdleTimeout is how long a tracked connection may go without
+	// outbound traffic before replies to it are no longer recognized.
+	flowIdleTimeout = 10 * time.Minute
+
+	// flowSweepInterval is how often expired flows are removed.
+	flowSweepInterval = time.Minute
+
+	// maxFlows bounds the connection tracking table.
+	maxFlows = 65536
+)
+
+// Filter is a tun.Device that enforces a Policy on packets wireguard-go
+// writes to the TUN device, i.e. traffic arriving from peers. Packets
+// leaving through the tunnel are never dropped; while the policy restricts
+// anything, their flows are tracked so replies from restricted peers pass.
+// Flows opened before a restricting policy was set are not known, so their
+// replies may be dropped until they are reopened.
+type Filter struct {
+	tun.Device
+
+	log    *slog.Logger
+	policy atomic.Pointer[Policy]
+	flows  flowTable
+
+	mu      sync.Mutex
+	dropped map[string]uint64 // by peer name, "" for unknown sources
+}
+
+// NewFilter wraps dev. Until SetPolicy is called every packet passes.
+func NewFilter(dev tun.Device, logger *slog.Logger) *Filter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Filter{
+		Device:  dev,
+		log:     logger.With("component", "acl"),
+		flows:   flowTable{flows: make(map[flowKey]time.Time), pairs: make(map[pairKey]int)},
+		dropped: make(map[string]uint64),
+	}
+}
+
+// SetPolicy replaces the policy. It is safe to call while packets flow.
+func (f *Filter) SetPolicy(p *Policy) {
+	f.policy.Store(p)
+	if !p.Restricts() {
+		f.flows.reset()
+	}
+}
+
+// Policy returns the policy in effect, or nil.
+func (f *Filter) Policy() *Policy {
+	return f.policy.Load()
+}
+
+// Dropped returns the number of packets dropped per peer name. Packets
+// from sources no peer owns are counted under "".
+func (f *Filter) Dropped() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.dropped)
+}
+
+// Read reads packets headed into the tunnel and tracks their flows.
+func (f *Filter) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := f.Device.Read(bufs, sizes, offset)
+	if n > 0 && f.policy.Load().Restricts() {
+		now := time.Now()
+		for i := 0; i < n; i++ {
+			pkt, ok := parsePacket(bufs[i][offset : offset+sizes[i]])
+			if ok {
+				f.flows.track(pkt, now)
+			}
+		}
+	}
+	return n, err
+}
+
+// Write delivers packets from peers to the TUN device, dropping those the
+// policy does not allow. Dropped packets count as written.
+func (f *Filter) Write(bufs [][]byte, offset int) (int, error) {
+	p := f.policy.Load()
+	if !p.Restricts() {
+		return f.Device.Write(bufs, offset)
+	}
+
+	now := time.Now()
+	var kept [][]byte
+	for i, buf := range bufs {
+		if f.allow(p, buf[offset:], now) {
+			if kept != nil {
+				kept = append(kept, buf)
+			}
+			continue
+		}
+		if kept == nil {
+			kept = make([][]byte, i, len(bufs))
+			copy(kept, bufs[:i])
+		}
+	}
+	if kept == nil {
+		return f.Device.Write(bufs, offset)
+	}
+	if len(kept) > 0 {
+		if _, err := f.Device.Write(kept, offset); err != nil {
+			return 0, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// allow decides whether one inbound packet may pass.
+func (f *Filter) allow(p *Policy, b []byte, now time.Time) bool {
+	pkt, ok := parsePacket(b)
+	if !ok {
+		return false
+	}
+	if f.flows.isReply(pkt, now) {
+		return true
+	}
+	peer, ok := p.allowed(pkt)
+	if !ok {
+		f.mu.Lock()
+		f.dropped[peer]++
+		f.mu.Unlock()
+		f.log.Debug("dropped packet", "peer_id", peer, "protocol", pkt.proto,
+			"src", pkt.src, "dst", pkt.dst, "dport", pkt.dport)
+	}
+	return ok
+}
+
+// flowKey identifies a connection from this device's side.
+type flowKey struct {
+	proto         uint8
+	local, remote netip.Addr
+	lport, rport  uint16
+}
+
+// pairKey identifies the hosts of a connection, used to match fragments
+// that carry no ports.
+type pairKey struct {
+	proto         uint8
+	local, remote netip.Addr
+}
+
+// flowTable tracks connections this device opened through the tunnel.
+type flowTable struct {
+	mu        sync.Mutex
+	flows     map[flowKey]time.Time // last outbound packet
+	pairs     map[pairKey]int       // number of flows per host pair
+	lastSweep time.Time
+}
+
+// track records the flow of an outbound packet.
+func (t *flowTable) track(pkt packetInfo, now time.Time) {
+	if !pkt.hasPorts || pkt.icmpKind == icmpEchoReply {
+		return
+	}
+	key := flowKey{pkt.proto, pkt.src, pkt.dst, pkt.sport, pkt.dport}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.lastSweep) >= flowSweepInterval {
+		t.sweep(now)
+	}
+	if _, ok := t.flows[key]; !ok {
+		if len(t.flows) >= maxFlows {
+			return
+		}
+		t.pairs[pairKey{key.proto, key.local, key.remote}]++
+	}
+	t.flows[key] = now
+}
+
+// isReply reports whether an inbound packet belongs to a tracked flow.
+func (t *flowTable) isReply(pkt packetInfo, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pkt.icmpKind == icmpError {
+		// The error quotes the header of a packet we sent.
+		inner, ok := parsePacket(pkt.inner)
+		if !ok || !inner.hasPorts {
+			return false
+		}
+		return t.live(flowKey{inner.proto, inner.src, inner.dst, inner.sport, inner.dport}, now)
+	}
+	if !pkt.hasPorts {
+		return t.pairs[pairKey{pkt.proto, pkt.dst, pkt.src}] > 0
+	}
+	if pkt.icmpKind == icmpEchoRequest {
+		return false
+	}
+	return t.live(flowKey{pkt.proto, pkt.dst, pkt.src, pkt.dport, pkt.sport}, now)
+}
+
+func (t *flowTable) live(key flowKey, now time.Time) bool {
+	last, ok := t.flows[key]
+	return ok && now.Sub(last) < flowIdleTimeout
+}
+
+// sweep removes expired flows. t.mu must be held.
+func (t *flowTable) sweep(now time.Time) {
+	t.lastSweep = now
+	for key, last := range t.flows {
+		if now.Sub(last) < flowIdleTimeout {
+			continue
+		}
+		delete(t.flows, key)
+		pk := pairKey{key.proto, key.local, key.remote}
+		if t.pairs[pk]--; t.pairs[pk] <= 0 {
+			delete(t.pairs, pk)
+		}
+	}
+}
+
+func (t *flowTable) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.flows)
+	clear(t.pairs)
+}
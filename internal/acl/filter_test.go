@@ -0,0 +1,205 @@
+package acl
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// fakeTUN returns queued packets from Read and records writes.
+type fakeTUN struct {
+	mu      sync.Mutex
+	inbound [][]byte // returned by Read
+	written [][]byte
+}
+
+func (d *fakeTUN) File() *os.File { return nil }
+
+func (d *fakeTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for n < len(bufs) && len(d.inbound) > 0 {
+		sizes[n] = copy(bufs[n][offset:], d.inbound[0])
+		d.inbound = d.inbound[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (d *fakeTUN) Write(bufs [][]byte, offset int) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, b := range bufs {
+		d.written = append(d.written, append([]byte(nil), b[offset:]...))
+	}
+	return len(bufs), nil
+}
+
+func (d *fakeTUN) MTU() (int, error)        { return 1420, nil }
+func (d *fakeTUN) Name() (string, error)    { return "fake0", nil }
+func (d *fakeTUN) Events() <-chan tun.Event { return nil }
+func (d *fakeTUN) Close() error             { return nil }
+func (d *fakeTUN) BatchSize() int           { return 1 }
+
+// ipv4 builds an IPv4 packet with the given protocol and L4 payload.
+func ipv4(proto uint8, src, dst string, l4 []byte) []byte {
+	b := make([]byte, 20+len(l4))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = proto
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(b[12:16], s[:])
+	copy(b[16:20], d[:])
+	copy(b[20:], l4)
+	return b
+}
+
+func tcpPacket(src string, sport uint16, dst string, dport uint16) []byte {
+	l4 := make([]byte, 20)
+	binary.BigEndian.PutUint16(l4[0:2], sport)
+	binary.BigEndian.PutUint16(l4[2:4], dport)
+	return ipv4(protoTCP, src, dst, l4)
+}
+
+func icmpPacket(typ uint8, src, dst string, body []byte) []byte {
+	l4 := make([]byte, 8, 8+len(body))
+	l4[0] = typ
+	binary.BigEndian.PutUint16(l4[4:6], 7) // echo identifier
+	return ipv4(protoICMP, src, dst, append(l4, body...))
+}
+
+// write passes one packet through f and reports whether it reached dev.
+func write(t *testing.T, f *Filter, dev *fakeTUN, pkt []byte) bool {
+	t.Helper()
+	const offset = 16
+	buf := append(make([]byte, offset), pkt...)
+	before := len(dev.written)
+	n, err := f.Write([][]byte{buf}, offset)
+	if err != nil || n != 1 {
+		t.Fatalf("Write() = %d, %v; want 1, nil", n, err)
+	}
+	return len(dev.written) > before
+}
+
+// read sends one packet from the host into the tunnel through f.
+func read(t *testing.T, f *Filter, dev *fakeTUN, pkt []byte) {
+	t.Helper()
+	dev.inbound = append(dev.inbound, pkt)
+	bufs := [][]byte{make([]byte, 1500)}
+	sizes := []int{0}
+	if n, err := f.Read(bufs, sizes, 0); err != nil || n != 1 {
+		t.Fatalf("Read() = %d, %v; want 1, nil", n, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	dev := &fakeTUN{}
+	f := NewFilter(dev, nil)
+
+	phone := tcpPacket("10.0.0.2", 40000, "192.168.1.10", 80)
+	if !write(t, f, dev, phone) {
+		t.Fatal("packet dropped without a policy")
+	}
+
+	rules, err := ParseRules([]string{"192.168.1.10:22/tcp"})
+	if err != nil {
+		t.Fatalf("ParseRules() error: %v", err)
+	}
+	f.SetPolicy(&Policy{
+		Default: Allow,
+		Peers: []Peer{{
+			Name:    "phone",
+			Sources: []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32")},
+			Rules:   rules,
+		}},
+	})
+
+	if write(t, f, dev, phone) {
+		t.Error("disallowed port passed")
+	}
+	if !write(t, f, dev, tcpPacket("10.0.0.2", 40000, "192.168.1.10", 22)) {
+		t.Error("allowed port dropped")
+	}
+	if !write(t, f, dev, tcpPacket("10.0.0.3", 40000, "192.168.1.10", 80)) {
+		t.Error("unrestricted peer dropped")
+	}
+
+	// Replies to a connection this device opened pass.
+	reply := tcpPacket("10.0.0.2", 8080, "10.0.0.1", 51000)
+	if write(t, f, dev, reply) {
+		t.Error("reply passed before the connection was opened")
+	}
+	read(t, f, dev, tcpPacket("10.0.0.1", 51000, "10.0.0.2", 8080))
+	if !write(t, f, dev, reply) {
+		t.Error("reply to tracked connection dropped")
+	}
+	if write(t, f, dev, tcpPacket("10.0.0.2", 8080, "10.0.0.1", 51001)) {
+		t.Error("packet to another local port passed")
+	}
+
+	// So do echo replies and ICMP errors about our packets.
+	read(t, f, dev, icmpPacket(8, "10.0.0.1", "10.0.0.2", nil))
+	if !write(t, f, dev, icmpPacket(0, "10.0.0.2", "10.0.0.1", nil)) {
+		t.Error("echo reply dropped")
+	}
+	if write(t, f, dev, icmpPacket(8, "10.0.0.2", "10.0.0.1", nil)) {
+		t.Error("echo request from restricted peer passed")
+	}
+	quoted := tcpPacket("10.0.0.1", 51000, "10.0.0.2", 8080)[:28]
+	if !write(t, f, dev, icmpPacket(3, "10.0.0.2", "10.0.0.1", quoted)) {
+		t.Error("ICMP error about tracked connection dropped")
+	}
+	other := tcpPacket("10.0.0.1", 52000, "10.0.0.2", 8080)[:28]
+	if write(t, f, dev, icmpPacket(3, "10.0.0.2", "10.0.0.1", other)) {
+		t.Error("ICMP error about unknown connection passed")
+	}
+
+	if got := f.Dropped()["phone"]; got != 5 {
+		t.Errorf("Dropped()[phone] = %d, want 5", got)
+	}
+
+	// Lifting the restriction lets everything through again.
+	f.SetPolicy(&Policy{Default: Allow})
+	if !write(t, f, dev, phone) {
+		t.Error("packet dropped after restriction lifted")
+	}
+}
+
+func TestFilter_WriteBatch(t *testing.T) {
+	t.Parallel()
+
+	dev := &fakeTUN{}
+	f := NewFilter(dev, nil)
+	f.SetPolicy(&Policy{Default: Deny, Peers: []Peer{{
+		Name:    "laptop",
+		Sources: []netip.Prefix{netip.MustParsePrefix("10.0.0.3/32")},
+		Rules:   []Rule{{Prefix: netip.MustParsePrefix("0.0.0.0/0")}},
+	}}})
+
+	pkts := [][]byte{
+		tcpPacket("10.0.0.2", 1000, "10.0.0.1", 22),
+		tcpPacket("10.0.0.3", 1000, "10.0.0.1", 22),
+		tcpPacket("10.0.0.2", 1000, "10.0.0.1", 23),
+		tcpPacket("10.0.0.3", 1000, "10.0.0.1", 23),
+	}
+	n, err := f.Write(pkts, 0)
+	if err != nil || n != len(pkts) {
+		t.Fatalf("Write() = %d, %v; want %d, nil", n, err, len(pkts))
+	}
+	if len(dev.written) != 2 {
+		t.Fatalf("wrote %d packets, want 2", len(dev.written))
+	}
+	for _, w := range dev.written {
+		if pkt, _ := parsePacket(w); pkt.src != netip.MustParseAddr("10.0.0.3") {
+			t.Errorf("wrote packet from %s, want only 10.0.0.3", pkt.src)
+		}
+	}
+}
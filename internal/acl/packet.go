@@ -0,0 +1,121 @@
+package acl
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// IP protocol numbers.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// ICMP message kinds relevant to connection tracking.
+const (
+	icmpOther = iota
+	icmpEchoRequest
+	icmpEchoReply
+	icmpError // carries the header of the packet that caused it
+)
+
+// packetInfo is what the filter needs from an IP packet.
+type packetInfo struct {
+	proto    uint8
+	src, dst netip.Addr
+
+	// sport and dport are the TCP/UDP ports, or the echo identifier (in
+	// both) for ICMP echo requests and replies. hasPorts is false for
+	// non-first fragments and truncated packets.
+	sport, dport uint16
+	hasPorts     bool
+
+	icmpKind int
+	inner    []byte // ICMP error payload: the offending packet's header
+}
+
+// parsePacket extracts addresses, protocol and ports from an IPv4 or IPv6
+// packet. IPv6 extension headers are not followed; such packets are seen
+// with their first next-header value and no ports.
+func parsePacket(b []byte) (packetInfo, bool) {
+	if len(b) < 1 {
+		return packetInfo{}, false
+	}
+	var p packetInfo
+	var l4 []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return packetInfo{}, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return packetInfo{}, false
+		}
+		p.proto = b[9]
+		p.src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.dst = netip.AddrFrom4([4]byte(b[16:20]))
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+			return p, true // non-first fragment
+		}
+		l4 = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return packetInfo{}, false
+		}
+		p.proto = b[6]
+		p.src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.dst = netip.AddrFrom16([16]byte(b[24:40]))
+		l4 = b[40:]
+	default:
+		return packetInfo{}, false
+	}
+
+	switch p.proto {
+	case protoTCP, protoUDP:
+		if len(l4) >= 4 {
+			p.sport = binary.BigEndian.Uint16(l4[0:2])
+			p.dport = binary.BigEndian.Uint16(l4[2:4])
+			p.hasPorts = true
+		}
+	case protoICMP, protoICMPv6:
+		if len(l4) < 8 {
+			break
+		}
+		p.icmpKind = icmpKind(p.proto, l4[0])
+		switch p.icmpKind {
+		case icmpEchoRequest, icmpEchoReply:
+			id := binary.BigEndian.Uint16(l4[4:6])
+			p.sport, p.dport, p.hasPorts = id, id, true
+		case icmpError:
+			p.inner = l4[8:]
+		}
+	}
+	return p, true
+}
+
+// icmpKind classifies an ICMP or ICMPv6 message type.
+func icmpKind(proto, typ uint8) int {
+	if proto == protoICMP {
+		switch typ {
+		case 8:
+			return icmpEchoRequest
+		case 0:
+			return icmpEchoReply
+		case 3, 11, 12: // unreachable, time exceeded, parameter problem
+			return icmpError
+		}
+		return icmpOther
+	}
+	switch typ {
+	case 128:
+		return icmpEchoRequest
+	case 129:
+		return icmpEchoReply
+	case 1, 2, 3, 4: // unreachable, packet too big, time exceeded, parameter problem
+		return icmpError
+	}
+	return icmpOther
+}
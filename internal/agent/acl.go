@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+
+	"github.com/kuuji/bamgate/internal/acl"
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+// parseACL reads device.acl_default and the per-peer allow rules from cfg.
+// Peers with an empty allow list are left out, so the default applies to
+// them.
+func parseACL(cfg *config.Config) (acl.Action, map[string][]acl.Rule, error) {
+	def, err := acl.ParseAction(cfg.Device.ACLDefault)
+	if err != nil {
+		return "", nil, fmt.Errorf("device.acl_default: %w", err)
+	}
+	rules := make(map[string][]acl.Rule)
+	for name, sel := range cfg.Peers {
+		if len(sel.Allow) == 0 {
+			continue
+		}
+		r, err := acl.ParseRules(sel.Allow)
+		if err != nil {
+			return "", nil, fmt.Errorf("peers.%s.allow: %w", name, err)
+		}
+		rules[name] = r
+	}
+	return def, rules, nil
+}
+
+// updateACL rebuilds the access control policy from the connected peers,
+// the local rules and the network policy, and installs it on the TUN
+// filter. Packets are attributed to a peer by
+// the same prefixes as its WireGuard AllowedIPs, or by its tunnel
+// addresses while it is relayed, so it is called whenever those change. The capture tap attributes packets by the same prefixes.
+func (a *Agent) updateACL() {
+	if a.aclFilter == nil {
+		return
+	}
+
+	a.mu.Lock()
+	self := a.cfg.Device.Name
+	policy := &acl.Policy{Default: a.aclDefault, NetworkDefault: a.netPolicy.Default()}
+	// Relayed peers may have no peer state while their traffic comes
+	// through the relay.
+	ids := slices.Collect(maps.Keys(a.peers))
+	for id := range a.relays {
+		if _, ok := a.peers[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		ps, ok := a.peers[id]
+		if !ok {
+			ps = &peerState{}
+		}
+		peer := acl.Peer{
+			Name:         id,
+			Aliases:      ps.routeAliases,
+			Rules:        a.aclRules[id],
+			NetworkRules: a.netPolicy.ACLFor(self, id),
+		}
+		for _, cidr := range ps.tunnelAllowedIPs {
+			if p, err := netip.ParsePrefix(cidr); err == nil {
+				peer.Sources = append(peer.Sources, p)
+			}
+		}
+		if _, relayed := a.relays[id]; relayed {
+			info := a.directory[id]
+			if ips, err := peerTunnelIPs(info.Address, info.Metadata); err == nil {
+				for _, cidr := range ips {
+					peer.Sources = append(peer.Sources, netip.MustParsePrefix(cidr))
+				}
+			}
+		}
+		for route, owner := range a.routeOwners {
+			if p, err := netip.ParsePrefix(route); err == nil && owner == id {
+				peer.Sources = append(peer.Sources, p)
+			}
+		}
+		policy.Peers = append(policy.Peers, peer)
+	}
+	a.mu.Unlock()
+
+	a.aclFilter.SetPolicy(policy)
+	if a.captureTap != nil {
+		sources := make(map[string][]netip.Prefix, len(policy.Peers))
+		for _, peer := range policy.Peers {
+			sources[peer.Name] = peer.Sources
+		}
+		a.captureTap.SetPeers(sources)
+	}
+}
+
+// ReloadACL re-reads device.acl_default and the per-peer allow rules from
+// the config file and applies them without reconnecting peers. Other
+// settings in the file are ignored. An invalid file leaves the running
+// policy unchanged.
+func (a *Agent) ReloadACL() error {
+	if a.configPath == "" {
+		return fmt.Errorf("no config file to reload from")
+	}
+	cfg, err := a.deps.Config.LoadPublicConfig(a.configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	def, rules, err := parseACL(cfg)
+	if err != nil {
+		return err
+	}
+	a.setACL(cfg, def, rules)
+	return nil
+}
+
+// setACL replaces the running access control policy with def and rules,
+// parsed from cfg, and records cfg's rules in the running config.
+func (a *Agent) setACL(cfg *config.Config, def acl.Action, rules map[string][]acl.Rule) {
+	a.mu.Lock()
+	a.aclDefault = def
+	a.aclRules = rules
+	a.cfg.Device.ACLDefault = cfg.Device.ACLDefault
+	// Replace the map rather than writing to it, since it is read without
+	// holding mu.
+	peers := make(map[string]config.PeerSelections, len(a.cfg.Peers))
+	for name, sel := range a.cfg.Peers {
+		sel.Allow = nil
+		peers[name] = sel
+	}
+	for name, sel := range cfg.Peers {
+		if len(sel.Allow) == 0 {
+			continue
+		}
+		cur := peers[name]
+		cur.Allow = sel.Allow
+		peers[name] = cur
+	}
+	a.cfg.Peers = peers
+	a.mu.Unlock()
+
+	a.updateACL()
+	a.log.Info("reloaded access control rules", "default", def, "restricted_peers", len(rules))
+}
+
+// aclStatus reports the access control configuration and drop counters,
+// or nil if no ACL is configured. a.mu must be held.
+func (a *Agent) aclStatus() *control.ACLStatus {
+	self := a.cfg.Device.Name
+	netDefault := a.netPolicy.Default()
+	networkRules := make(map[string][]acl.Rule)
+	for id := range a.peers {
+		if rules := a.netPolicy.ACLFor(self, id); rules != nil {
+			networkRules[id] = rules
+		}
+	}
+	if a.aclDefault != acl.Deny && len(a.aclRules) == 0 && netDefault != acl.Deny && len(networkRules) == 0 {
+		return nil
+	}
+
+	st := &control.ACLStatus{Default: string(a.aclDefault)}
+	if a.aclDefault == "" {
+		st.Default = string(acl.Allow)
+	}
+	if a.netPolicy != nil {
+		st.NetworkDefault = string(netDefault)
+	}
+	var dropped map[string]uint64
+	if a.aclFilter != nil {
+		dropped = a.aclFilter.Dropped()
+	}
+	names := slices.Collect(maps.Keys(a.aclRules))
+	for name := range networkRules {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for name := range dropped {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		ps := control.ACLPeerStatus{Peer: name, Dropped: dropped[name]}
+		for _, r := range a.aclRules[name] {
+			ps.Allow = append(ps.Allow, r.String())
+		}
+		for _, r := range networkRules[name] {
+			ps.NetworkAllow = append(ps.NetworkAllow, r.String())
+		}
+		st.Peers = append(st.Peers, ps)
+	}
+	st.DroppedUnknown = dropped[""]
+	return st
+}
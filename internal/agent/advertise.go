@@ -0,0 +1,343 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/kuuji/bamgate/internal/tunnel"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+const (
+	// advertiseCheckInterval is how often advertise_routes = "auto"
+	// re-discovers local subnets when the platform has no change
+	// notifications.
+	advertiseCheckInterval = time.Minute
+
+	// advertiseFallbackInterval is how often it re-discovers when driven
+	// by change notifications, in case one is missed.
+	advertiseFallbackInterval = 10 * time.Minute
+)
+
+// parseAdvertiseRoutes validates device.advertise_routes and
+// device.advertise_exclude, and reports whether routes are discovered
+// automatically.
+func parseAdvertiseRoutes(mode string, exclude []string) (bool, error) {
+	switch mode {
+	case "":
+		if len(exclude) > 0 {
+			return false, fmt.Errorf("device.advertise_exclude requires advertise_routes = \"auto\"")
+		}
+		return false, nil
+	case "auto":
+	default:
+		return false, fmt.Errorf("device.advertise_routes: unknown mode %q (want \"auto\" or empty)", mode)
+	}
+	for _, e := range exclude {
+		if _, err := netip.ParsePrefix(e); err == nil {
+			continue
+		}
+		if _, err := path.Match(e, ""); err != nil {
+			return false, fmt.Errorf("device.advertise_exclude: %q is neither a CIDR nor an interface pattern", e)
+		}
+	}
+	return true, nil
+}
+
+// advertiseExcluded reports whether a discovered subnet matches one of the
+// device.advertise_exclude entries: a CIDR containing it or a pattern
+// matching its interface name.
+func advertiseExcluded(s tunnel.SubnetInfo, exclude []string) bool {
+	subnet, err := netip.ParsePrefix(s.CIDR)
+	if err != nil {
+		return true
+	}
+	for _, e := range exclude {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			if p.Bits() <= subnet.Bits() && p.Contains(subnet.Addr()) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(e, s.Interface); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// advertisedRoutes returns the routes this device currently advertises:
+// device.routes, plus the discovered subnets with advertise_routes = "auto".
+func (a *Agent) advertisedRoutes() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.advertised
+}
+
+// advertisedMetadata builds the signaling metadata for the currently
+// advertised routes.
+func (a *Agent) advertisedMetadata() map[string]string {
+	dev := a.cfg.Device
+	dev.Routes = a.advertisedRoutes()
+	return dev.BuildMetadata()
+}
+
+// discoverRoutes returns device.routes followed by the subnets of this
+// host's interfaces that advertise_exclude does not filter out. The tunnel
+// subnets are never included. It reports false if the interfaces cannot be
+// listed, in which case the current routes should be kept.
+func (a *Agent) discoverRoutes() ([]string, bool) {
+	subnets, err := a.deps.Network.DiscoverLocalSubnets(a.cfg.Device.Address, a.cfg.Device.Address6)
+	if err != nil {
+		a.log.Warn("discovering local subnets to advertise", "error", err)
+		return nil, false
+	}
+
+	routes := slices.Clone(a.cfg.Device.Routes)
+	var discovered []string
+	for _, s := range subnets {
+		if advertiseExcluded(s, a.cfg.Device.AdvertiseExclude) {
+			a.log.Debug("not advertising excluded subnet", "route", s.CIDR, "interface", s.Interface)
+			continue
+		}
+		if !slices.Contains(routes, s.CIDR) && !slices.Contains(discovered, s.CIDR) {
+			discovered = append(discovered, s.CIDR)
+		}
+	}
+	slices.Sort(discovered)
+	return append(routes, discovered...), true
+}
+
+// startRouteAdvertiser launches a background goroutine that re-discovers
+// local subnets for advertise_routes = "auto" when interfaces or addresses
+// change (polling where the platform has no notifications), and
+// re-advertises them to peers when the set changes.
+//
+// The goroutine is owned by Run() and exits when ctx is cancelled.
+func (a *Agent) startRouteAdvertiser(ctx context.Context) {
+	if a.advertiserStarted.Swap(true) {
+		return
+	}
+	interval := advertiseFallbackInterval
+	events, err := a.deps.Network.WatchNetwork(ctx)
+	if err != nil {
+		a.log.Debug("network change notifications unavailable, polling local subnets", "error", err)
+		interval = advertiseCheckInterval
+	}
+	a.log.Debug("route advertiser started", "event_driven", events != nil, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		var settle <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.refreshAdvertisedRoutes(ctx)
+			case ev, ok := <-events:
+				if !ok {
+					a.log.Warn("route advertiser: network change notifications stopped, polling",
+						"interval", advertiseCheckInterval)
+					events = nil
+					ticker.Reset(advertiseCheckInterval)
+					continue
+				}
+				switch ev.Kind {
+				case tunnel.LinkChanged, tunnel.AddressChanged, tunnel.EventsLost:
+					if settle == nil {
+						settle = time.After(networkSettleDelay)
+					}
+				}
+			case <-settle:
+				settle = nil
+				a.refreshAdvertisedRoutes(ctx)
+			}
+		}
+	}()
+}
+
+// refreshAdvertisedRoutes re-runs subnet discovery and, if the advertised
+// routes changed, updates forwarding and masquerade and announces the new
+// routes to peers through the signaling server. It does nothing once a
+// config reload turned advertise_routes = "auto" off.
+func (a *Agent) refreshAdvertisedRoutes(ctx context.Context) {
+	a.mu.Lock()
+	auto := a.cfg.Device.AdvertiseRoutes == "auto"
+	a.mu.Unlock()
+	if !auto {
+		return
+	}
+	routes, ok := a.discoverRoutes()
+	if !ok {
+		return
+	}
+	a.setAdvertisedRoutes(ctx, routes, false)
+}
+
+// setAdvertisedRoutes replaces the advertised routes. If they changed, or
+// announce is set because the metadata did, it updates forwarding and
+// masquerade and announces the routes and metadata to peers.
+func (a *Agent) setAdvertisedRoutes(ctx context.Context, routes []string, announce bool) {
+	a.mu.Lock()
+	old := a.advertised
+	if slices.Equal(old, routes) && !announce {
+		a.mu.Unlock()
+		return
+	}
+	a.advertised = routes
+	a.mu.Unlock()
+
+	var added, removed []string
+	for _, r := range routes {
+		if !slices.Contains(old, r) {
+			added = append(added, r)
+		}
+	}
+	for _, r := range old {
+		if !slices.Contains(routes, r) {
+			removed = append(removed, r)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		a.log.Info("advertised routes changed", "routes", routes, "added", added, "removed", removed)
+	}
+
+	a.updateRouteForwarding()
+
+	if a.sigClient != nil {
+		if err := a.sigClient.Update(ctx, routes, a.advertisedMetadata()); err != nil {
+			a.log.Warn("announcing advertised routes (peers learn them on reconnect)", "error", err)
+		}
+	}
+}
+
+// updateRouteForwarding brings forwarding and masquerade in line with the
+// advertised routes after they changed: interfaces newly carrying a route
+// get forwarding and a masquerade rule, and rules for interfaces no route
+// uses any more are dropped. Neither nftables nor pf rules are tracked
+// individually, so dropping one rebuilds the whole table, including the
+// netmap and port forward rules.
+func (a *Agent) updateRouteForwarding() {
+	if a.opts.userspace != nil || a.opts.tunFD > 0 || a.tunName == "" {
+		return
+	}
+
+	a.natMu.Lock()
+	defer a.natMu.Unlock()
+
+	if a.natManager == nil {
+		if a.deps.NAT != nil {
+			a.natManager = a.deps.NAT
+		} else {
+			a.natManager = tunnel.NewNATManager(a.log, a.opts.network)
+		}
+		a.journalMasquerades()
+	}
+	if err := a.enableForwarding(a.tunName); err != nil {
+		a.log.Warn("enabling forwarding on TUN interface", "interface", a.tunName, "error", err)
+		return
+	}
+
+	var want []masqueradeEntry
+	for _, route := range a.forwardedSubnets() {
+		if entry, ok := a.forwardRoute(route); ok && !slices.Contains(want, entry) {
+			want = append(want, entry)
+		}
+	}
+
+	rebuild := slices.ContainsFunc(a.masqueradeRules, func(e masqueradeEntry) bool {
+		return !slices.Contains(want, e)
+	})
+	if rebuild {
+		if err := a.natManager.Cleanup(); err != nil {
+			a.log.Warn("removing stale masquerade rules", "error", err)
+		}
+		a.masqueradeRules = nil
+	}
+
+	for _, entry := range want {
+		if slices.Contains(a.masqueradeRules, entry) {
+			continue
+		}
+		if err := a.natManager.SetupMasquerade(entry.wgSubnet, entry.outIface); err != nil {
+			a.log.Error("setting up masquerade for advertised routes",
+				"subnet", entry.wgSubnet, "out_iface", entry.outIface, "error", err)
+			continue
+		}
+		a.masqueradeRules = append(a.masqueradeRules, entry)
+	}
+	a.journalMasquerades()
+
+	if rebuild || !a.mssClamp {
+		if err := a.natManager.SetMSSClamp(a.tunName, tunnel.DefaultMTU); err != nil {
+			a.log.Warn("setting up MSS clamping", "error", err)
+		} else {
+			a.mssClamp = true
+		}
+	}
+
+	if rebuild {
+		if len(a.netmapRules) > 0 {
+			if err := a.natManager.SetNetmaps(a.tunName, a.netmapRules); err != nil {
+				a.log.Error("re-applying netmap rules", "error", err)
+			}
+		}
+		if len(a.portForwardRules) > 0 {
+			if err := a.natManager.SetPortForwards(a.tunName, a.portForwardRules); err != nil {
+				a.log.Error("re-applying port forward rules", "error", err)
+			}
+		}
+	}
+}
+
+// handlePeerUpdate applies the routes and metadata a peer announced
+// without reconnecting. The routes accepted from a connected peer are
+// resolved again and installed or withdrawn right away; changes to its
+// DNS offerings apply when it next connects.
+func (a *Agent) handlePeerUpdate(msg *protocol.UpdateMessage) error {
+	a.mu.Lock()
+	if info, ok := a.directory[msg.PeerID]; ok {
+		info.Routes = msg.Routes
+		info.Metadata = msg.Metadata
+		a.directory[msg.PeerID] = info
+	}
+	ps, ok := a.peers[msg.PeerID]
+	if !ok {
+		a.mu.Unlock()
+		return nil
+	}
+	ps.routes = msg.Routes
+	ps.metadata = msg.Metadata
+	connected := ps.tunnelAllowedIPs != nil
+	a.mu.Unlock()
+
+	a.log.Info("peer updated its advertised routes", "peer_id", msg.PeerID, "routes", msg.Routes)
+	if !connected {
+		return nil // resolved when the data channel opens
+	}
+
+	a.reresolveRoutes(msg.PeerID, ps)
+	return nil
+}
+
+// reresolveRoutes resolves the routes accepted from a connected peer again
+// and installs or withdraws the difference.
+func (a *Agent) reresolveRoutes(peerID string, ps *peerState) {
+	accepted := a.applyRouteConflictPolicy(peerID, a.resolveAcceptedRoutes(peerID, ps))
+	a.mu.Lock()
+	installed := slices.Concat(accepted, a.hubRoutes(peerID, ps))
+	changed := !slices.Equal(ps.installedRoutes, installed)
+	ps.installedRoutes = installed
+	a.mu.Unlock()
+
+	if changed {
+		a.rebalanceRoutes(peerID)
+		a.notifyNewRoutes(peerID, accepted)
+	}
+}
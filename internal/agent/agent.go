@@ -15,18 +15,27 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
+	"net/netip"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v4"
 	"golang.zx2c4.com/wireguard/tun"
 
+	"github.com/kuuji/bamgate/internal/acl"
 	"github.com/kuuji/bamgate/internal/auth"
 	"github.com/kuuji/bamgate/internal/bridge"
+	"github.com/kuuji/bamgate/internal/capture"
 	"github.com/kuuji/bamgate/internal/config"
 	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/netmap"
+	"github.com/kuuji/bamgate/internal/netstack"
+	"github.com/kuuji/bamgate/internal/policy"
 	"github.com/kuuji/bamgate/internal/signaling"
 	"github.com/kuuji/bamgate/internal/tunnel"
 	"github.com/kuuji/bamgate/internal/turn"
@@ -77,6 +86,11 @@ type options struct {
 	tokenUpdateCallback TokenUpdateFunc // optional callback when refresh token is rotated
 	configPath          string          // path to config file for persisting rotated refresh tokens
 	deps                *Deps           // if set, overrides the default production dependencies
+	userspace           *netstack.Stack // if set, run on this userspace network stack
+	proxyListen         string          // local proxy address in userspace mode
+	configOverrides     func(*config.Config)
+	network             string // network name, "" for the default network
+	statePath           string // state journal, see WithStateJournal
 }
 
 // WithTunFD configures the agent to use an existing TUN file descriptor
@@ -118,6 +132,30 @@ func WithConfigPath(path string) Option {
 	return func(o *options) { o.configPath = path }
 }
 
+// WithNetwork runs the agent as the named network, so it can run next to
+// agents of other networks: its TUN interface, NAT table and control socket
+// are named after the network, and its log lines carry it. The default
+// network ("") keeps the historical names.
+func WithNetwork(name string) Option {
+	return func(o *options) { o.network = name }
+}
+
+// WithStateJournal records the kernel changes the agent makes (forwarding,
+// NAT rules, routes, DNS) in a state journal at path, usually
+// StatePath(network), and undoes those left in it by a previous run that
+// crashed before the agent sets up the tunnel. Without it, kernel changes
+// left by a crash, DNS included, are not recovered.
+func WithStateJournal(path string) Option {
+	return func(o *options) { o.statePath = path }
+}
+
+// WithConfigOverrides sets a function applied to the config file each
+// time Reload reads it, so settings given on the command line instead of
+// in the file survive a reload.
+func WithConfigOverrides(fn func(*config.Config)) Option {
+	return func(o *options) { o.configOverrides = fn }
+}
+
 // WithDeps overrides the default production dependencies with custom
 // implementations. This is primarily used in tests to inject fakes for
 // components that require root privileges or network access.
@@ -140,31 +178,135 @@ type Agent struct {
 	sigClient SignalingClient
 	ctrlSrv   *control.Server
 
-	// Forwarding and NAT state for cleanup on shutdown.
+	// events publishes what happens to peers, routes and the signaling
+	// connection to GET /events and the mobile event callback.
+	events *control.EventBus
+
+	// Counters exported by WriteMetrics.
+	signalingReconnects atomic.Uint64
+	jwtRefreshFailures  atomic.Uint64
+	forwardingRepairs   atomic.Uint64
+
+	// dnsBackend is the DNS backend (device.dns_backend), with
+	// DNSBackendAuto resolved once in Run, so DNS is reverted and
+	// recovered through the backend that set it.
+	dnsBackend tunnel.DNSBackend
+
+	// routeConflictPolicy decides how accepted routes that overlap local
+	// subnets are handled (device.route_conflict_policy).
+	routeConflictPolicy tunnel.RouteConflictPolicy
+
+	// aclFilter wraps the TUN device and drops traffic from peers that
+	// aclDefault and aclRules (per-peer allow rules from the config) do not
+	// allow. aclDefault and aclRules are guarded by mu.
+	aclFilter  *acl.Filter
+	aclDefault acl.Action
+	aclRules   map[string][]acl.Rule
+
+	// captureTap records tunnel traffic for "bamgate capture": plaintext
+	// packets around aclFilter and encrypted frames in the bridge.
+	captureTap *capture.Tap
+
+	// netPolicy is the network-wide policy pushed by the signaling server
+	// (nil until one arrives) and policyVersion its version. Its ACL is
+	// enforced alongside aclRules; its routes and DNS apply to peers
+	// without a local selection. Both are guarded by mu.
+	netPolicy     *policy.Document
+	policyVersion int
+
+	// advertised is device.routes plus, with advertise_routes = "auto",
+	// the discovered local subnets. Guarded by mu; replaced, never
+	// modified in place.
+	advertised []string
+
+	// advertiserStarted and watchdogStarted record that the route
+	// advertiser and the forwarding watchdog are running, so a config
+	// reload can start them without starting them twice.
+	advertiserStarted atomic.Bool
+	watchdogStarted   atomic.Bool
+
+	// reloadMu serializes config reloads. reloadReady, guarded by it, is
+	// set once Run has connected and a reload can be applied.
+	reloadMu    sync.Mutex
+	reloadReady bool
+
+	// Forwarding and NAT state for cleanup on shutdown and for the
+	// watchdog. Once Run has started its goroutines, natMu guards all of
+	// it, the NAT updates made from it and the NAT entries of the journal;
+	// it is never acquired while holding mu. mssClamp records that MSS
+	// clamping was set up.
+	natMu           sync.Mutex
+	mssClamp        bool
 	natManager      NATSetup
 	forwardingState []forwardingSave  // interfaces whose forwarding state was changed
 	masqueradeRules []masqueradeEntry // masquerade rules for re-application by watchdog
 
+	// netmapRules are the NETMAP translations installed for peers that
+	// reach our advertised routes through aliases.
+	netmapRules []tunnel.NetmapRule
+
+	// portForwardRules are the DNAT rules publishing LAN services from
+	// device.port_forwards.
+	portForwardRules []tunnel.PortForwardRule
+
+	// journal records the kernel changes above, and routes and DNS, for
+	// cleanup after a crash (see journal.go). Nil without a state journal.
+	journal *stateJournal
+
+	// dnsProxy rewrites DNS answers into alias space when the resolver of
+	// a peer (dnsProxyPeer) sits behind a NETMAP alias. It listens on port
+	// 53 of our tunnel address, so there is at most one; dnsProxyPeer is
+	// set while it starts. Guarded by mu.
+	dnsProxy     *netmap.DNSProxy
+	dnsProxyPeer string
+
+	// Port forwards started by `bamgate forward` or [[forwards]] in the
+	// config, by ID.
+	forwardsMu    sync.Mutex
+	forwards      map[string]*activeForward
+	nextForwardID int
+
 	startedAt      time.Time
 	mu             sync.Mutex
 	peers          map[string]*peerState // peerID -> state
 	notifiedRoutes map[string]bool       // routes already sent via RouteUpdateCallback
+	routeOwners    map[string]string     // accepted route -> peer carrying it (see rebalanceRoutes)
+	routeMTUs      map[string]int        // accepted route -> MTU set on it; guarded by routesMu
+	routesMu       sync.Mutex            // serializes rebalanceRoutes; never acquired while holding mu
 	ctx            context.Context       // lifecycle context, set in Run()
 
+	// blocked holds the peers in device.blocked_peers. directory is the
+	// last signaling record of each peer on the hub, kept after the peer
+	// is removed so it can be reconnected or unblocked without waiting
+	// for it to rejoin. Both guarded by mu.
+	blocked   map[string]bool
+	directory map[string]protocol.PeerInfo
+
+	// relays maps each peer reached through a relay peer to that relay
+	// (see relay.go). Guarded by mu.
+	relays map[string]string
+
+	// onDemand and idleTimeout are device.on_demand and the parsed
+	// device.idle_timeout (see ondemand.go). Set once in Run().
+	onDemand    bool
+	idleTimeout time.Duration
+
 	// Network change debounce — prevents rapid-fire ICE restarts when
 	// Android sends multiple connectivity callbacks in quick succession.
 	lastNetworkChange time.Time
 
 	// JWT token management.
-	configPath string // path to config file, for persisting rotated refresh tokens
-	tokenMu    sync.RWMutex
-	jwtToken   string // current access JWT
+	configPath   string     // path to config file, for persisting rotated refresh tokens
+	configFileMu sync.Mutex // serializes saveConfig's load, change and save
+	tokenMu      sync.RWMutex
+	jwtToken     string // current access JWT
 }
 
 // forwardingSave records the previous forwarding state for an interface so it
 // can be restored on shutdown.
 type forwardingSave struct {
 	ifName          string
+	ipv6            bool // global IPv6 forwarding; ifName is unused
 	previousEnabled bool
 }
 
@@ -186,11 +328,21 @@ const (
 	iceDisconnectGrace = 5 * time.Second
 
 	// forwardingCheckInterval is how often the forwarding watchdog verifies
-	// that IP forwarding and NAT masquerade rules are still in place.
-	// NetworkManager can reset per-interface forwarding on DHCP renewal,
-	// wifi reconnect, or suspend/resume.
+	// that IP forwarding and NAT masquerade rules are still in place when
+	// the platform has no change notifications. NetworkManager can reset
+	// per-interface forwarding on DHCP renewal, wifi reconnect, or
+	// suspend/resume.
 	forwardingCheckInterval = 30 * time.Second
 
+	// forwardingFallbackInterval is how often the watchdog polls when it
+	// is driven by change notifications, in case one is missed.
+	forwardingFallbackInterval = 5 * time.Minute
+
+	// forwardingEventDelay lets a burst of network change notifications
+	// (an interface reconnecting produces several) settle before the
+	// watchdog checks once.
+	forwardingEventDelay = 200 * time.Millisecond
+
 	// networkChangeDebounce prevents rapid-fire ICE restarts when Android
 	// sends multiple connectivity callbacks in quick succession (e.g.
 	// onAvailable + onCapabilitiesChanged firing within milliseconds).
@@ -204,6 +356,21 @@ type peerState struct {
 	address   string     // WireGuard tunnel address (e.g. "10.0.0.3/24")
 	routes    []string   // additional subnets reachable through this peer
 
+	// installedRoutes are the routes accepted from this peer after the
+	// route conflict policy was applied. When several peers offer the same
+	// route, only the primary carries it (see rebalanceRoutes).
+	installedRoutes []string
+
+	// tunnelAllowedIPs are the peer's tunnel addresses, the part of its
+	// WireGuard AllowedIPs that never moves to another peer. Nil until the
+	// WireGuard peer has been added.
+	tunnelAllowedIPs []string
+
+	// routeAliases are the aliases this peer uses to reach our advertised
+	// routes, received in its offer or answer. Each one becomes a NETMAP
+	// rule translating the alias back to the real route.
+	routeAliases []netmap.Mapping
+
 	// metadata holds the peer's advertised capabilities (routes, DNS,
 	// search domains) received via signaling. Used by the control plane
 	// to show peer offerings and by the agent to apply user selections.
@@ -211,6 +378,19 @@ type peerState struct {
 
 	connectedAt time.Time // when the data channel opened
 
+	// On-demand tracking (see ondemand.go). lastActivity is when the idle
+	// monitor last saw activityPackets, the peer's bridge packet count,
+	// change. dialedAt is when the last on-demand connection attempt
+	// started, and pendingOffer is set while its answer is outstanding.
+	lastActivity    time.Time
+	activityPackets uint64
+	dialedAt        time.Time
+	pendingOffer    bool
+
+	// pathMTU is the effective tunnel MTU to this peer over its current
+	// ICE path (see refreshPathMTU), 0 until known.
+	pathMTU int
+
 	// pendingCandidates buffers ICE candidates that arrive before the
 	// remote SDP description is set (e.g. trickle candidates that arrive
 	// before the SDP offer/answer). They are flushed after
@@ -219,6 +399,7 @@ type peerState struct {
 
 	// ICE restart tracking.
 	iceRestarts    int         // number of restarts attempted
+	iceRestartsAll uint64      // restarts since the peer was discovered, for metrics
 	disconnectTime time.Time   // when ICE entered disconnected state
 	restartTimer   *time.Timer // grace period timer (nil = not running)
 	pendingRestart bool        // true while we've sent an ICE restart offer and are awaiting an answer
@@ -241,6 +422,17 @@ func New(cfg *config.Config, logger *slog.Logger, opts ...Option) *Agent {
 	if o.deps != nil {
 		deps = *o.deps
 	}
+	if o.userspace != nil {
+		deps = userspaceDeps(deps, o.userspace)
+	}
+	if o.network != "" {
+		logger = logger.With("network", o.network)
+	}
+
+	blocked := make(map[string]bool, len(cfg.Device.BlockedPeers))
+	for _, id := range cfg.Device.BlockedPeers {
+		blocked[id] = true
+	}
 
 	return &Agent{
 		cfg:            cfg,
@@ -249,7 +441,15 @@ func New(cfg *config.Config, logger *slog.Logger, opts ...Option) *Agent {
 		log:            logger.With("component", "agent"),
 		peers:          make(map[string]*peerState),
 		notifiedRoutes: make(map[string]bool),
+		routeOwners:    make(map[string]string),
+		routeMTUs:      make(map[string]int),
+		forwards:       make(map[string]*activeForward),
 		configPath:     o.configPath,
+		advertised:     cfg.Device.Routes,
+		events:         control.NewEventBus(),
+		blocked:        blocked,
+		directory:      make(map[string]protocol.PeerInfo),
+		relays:         make(map[string]string),
 	}
 }
 
@@ -259,21 +459,87 @@ func New(cfg *config.Config, logger *slog.Logger, opts ...Option) *Agent {
 func (a *Agent) Run(ctx context.Context) error {
 	a.ctx = ctx
 
+	dnsBackend, err := tunnel.ParseDNSBackend(a.cfg.Device.DNSBackend)
+	if err != nil {
+		return fmt.Errorf("device.dns_backend: %w", err)
+	}
+	a.dnsBackend = tunnel.ResolveDNSBackend(dnsBackend)
+
+	routeConflictPolicy, err := tunnel.ParseRouteConflictPolicy(a.cfg.Device.RouteConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("device.route_conflict_policy: %w", err)
+	}
+	a.routeConflictPolicy = routeConflictPolicy
+
+	a.idleTimeout, err = parseIdleTimeout(a.cfg.Device.IdleTimeout)
+	if err != nil {
+		return err
+	}
+	a.onDemand = a.cfg.Device.OnDemand
+
+	if err := parseRole(a.cfg.Device.Role); err != nil {
+		return err
+	}
+
+	a.aclDefault, a.aclRules, err = parseACL(a.cfg)
+	if err != nil {
+		return err
+	}
+
+	autoAdvertise, err := parseAdvertiseRoutes(a.cfg.Device.AdvertiseRoutes, a.cfg.Device.AdvertiseExclude)
+	if err != nil {
+		return err
+	}
+	autoAdvertise = autoAdvertise && a.opts.tunFD <= 0
+	if autoAdvertise {
+		if routes, ok := a.discoverRoutes(); ok {
+			a.advertised = routes
+			a.log.Info("advertising discovered routes", "routes", routes)
+		}
+	}
+
 	// 1. Create the bridge Bind.
 	a.bind = bridge.NewBind(a.log)
 
+	// Undo what a previous run that crashed left behind, before the
+	// forwarding state it changed is mistaken for the system's.
+	if a.opts.statePath != "" {
+		if err := recoverState(a.opts.statePath, a.deps, a.log); err != nil {
+			a.log.Warn("undoing kernel changes left by a previous run", "error", err)
+		}
+	}
+
 	// 2. Create or adopt TUN device.
-	var err error
 	tunDev, err := a.createTUNDevice()
 	if err != nil {
 		return err
 	}
+	if a.opts.statePath != "" {
+		a.journal = &stateJournal{
+			path:  a.opts.statePath,
+			log:   a.log,
+			state: journalState{Network: a.opts.network, Interface: a.tunName},
+		}
+	}
+
+	// Enforce per-peer access control on packets WireGuard delivers, and
+	// let "bamgate capture" see them, including those the ACL drops.
+	a.aclFilter = acl.NewFilter(tunDev, a.log)
+	a.captureTap = capture.NewTap(a.log)
+	a.bind.SetPacketHook(a.captureTap.Frame)
+	a.updateACL()
+
+	// In on-demand mode, WireGuard's first packet to a peer without a
+	// data channel connects to it.
+	if a.onDemand {
+		a.bind.SetMissHook(a.connectOnDemand)
+	}
 
 	// 3. Create WireGuard device with our custom Bind.
 	wgCfg := tunnel.DeviceConfig{
 		PrivateKey: a.cfg.Device.PrivateKey,
 	}
-	a.wgDevice, err = a.deps.WireGuard.NewDevice(wgCfg, tunDev, a.bind, a.log)
+	a.wgDevice, err = a.deps.WireGuard.NewDevice(wgCfg, a.captureTap.Wrap(a.aclFilter), a.bind, a.log)
 	if err != nil {
 		_ = tunDev.Close()
 		return fmt.Errorf("creating WireGuard device: %w", err)
@@ -281,27 +547,68 @@ func (a *Agent) Run(ctx context.Context) error {
 	defer a.wgDevice.Close()
 	defer a.cleanupForwardingAndNAT()
 
+	// In userspace mode, applications reach the mesh through a local proxy.
+	proxy, err := a.startProxy()
+	if err != nil {
+		return err
+	}
+	if proxy != nil {
+		defer func() { _ = proxy.Close() }()
+	}
+
 	// 4. Configure the TUN interface IP address and bring it up.
 	// Skip when using an injected TUN FD (Android VpnService already configured it).
 	if a.opts.tunFD <= 0 {
 		if err := a.configureTUN(a.tunName); err != nil {
 			return fmt.Errorf("configuring TUN interface: %w", err)
 		}
+		a.setupPeerForwarding()
 
-		// Start the forwarding watchdog if we set up forwarding/NAT.
-		// NetworkManager can reset per-interface forwarding on DHCP renewal
-		// or wifi reconnect; the watchdog detects and re-enables it.
-		if len(a.forwardingState) > 0 {
+		// Start the forwarding watchdog if we set up forwarding/NAT, or may
+		// once discovered routes appear. NetworkManager can reset
+		// per-interface forwarding on DHCP renewal or wifi reconnect; the
+		// watchdog detects and re-enables it.
+		if len(a.forwardingState) > 0 || autoAdvertise {
 			a.startForwardingWatchdog(ctx)
 		}
 	}
 
-	// 5. Start control server for "bamgate status" and "bamgate devices".
+	// Watch the health of peers sharing routes so a standby gateway takes
+	// over when the primary stops handshaking.
+	a.startRouteHealthCheck(ctx)
+
+	// Start port forwards declared in the config file.
+	a.startConfiguredForwards()
+	defer a.closeForwards()
+
 	a.startedAt = time.Now()
-	a.ctrlSrv = control.NewServer(control.ResolveSocketPath(), a.Status, a.log)
+
+	// Serve metrics over TCP as well when metrics_listen is set.
+	stopMetrics, err := a.startMetricsListener()
+	if err != nil {
+		return err
+	}
+	if stopMetrics != nil {
+		defer stopMetrics()
+	}
+
+	// 5. Start control server for "bamgate status" and "bamgate devices".
+	a.ctrlSrv = control.NewServer(control.NetworkSocketPath(a.opts.network), a.Status, a.log)
+	a.ctrlSrv.SetGroup(a.cfg.Device.ControlGroup)
 	a.ctrlSrv.SetOfferingsProvider(a.PeerOfferings)
 	a.ctrlSrv.SetConfigureFunc(a.ConfigurePeer)
 	a.ctrlSrv.SetTokenProvider(a.tokenProvider)
+	a.ctrlSrv.SetForwardFuncs(control.ForwardFuncs{
+		List:   a.Forwards,
+		Add:    a.AddForward,
+		Remove: a.RemoveForward,
+	})
+	a.ctrlSrv.SetACLReloadFunc(a.ReloadACL)
+	a.ctrlSrv.SetReloadFunc(a.Reload)
+	a.ctrlSrv.SetCaptureFunc(a.Capture)
+	a.ctrlSrv.SetEventSubscriber(a.SubscribeEvents)
+	a.ctrlSrv.SetMetricsFunc(a.WriteMetrics)
+	a.ctrlSrv.SetPeerActions(a.peerActions())
 	if err := a.ctrlSrv.Start(); err != nil {
 		a.log.Warn("control server failed to start (status command will be unavailable)", "error", err)
 		// Non-fatal — agent can run without the control server.
@@ -330,10 +637,14 @@ func (a *Agent) Run(ctx context.Context) error {
 		PeerID:        a.cfg.Device.Name,
 		PublicKey:     pubKey.String(),
 		Address:       a.cfg.Device.Address,
-		Routes:        a.cfg.Device.Routes,
-		Metadata:      a.cfg.Device.BuildMetadata(),
+		Routes:        a.advertisedRoutes(),
+		Metadata:      a.advertisedMetadata(),
 		TokenProvider: a.tokenProvider,
-		Logger:        a.log,
+		OnReconnect: func(attempts int) {
+			a.signalingReconnects.Add(1)
+			a.events.Publish(control.Event{Type: control.EventSignalingReconnected, Attempt: attempts})
+		},
+		Logger: a.log,
 		Reconnect: signaling.ReconnectConfig{
 			Enabled:     true,
 			MaxAttempts: 20,
@@ -350,6 +661,27 @@ func (a *Agent) Run(ctx context.Context) error {
 		return fmt.Errorf("connecting to signaling server: %w", err)
 	}
 
+	// Reconnect automatically when the machine changes networks or wakes
+	// from suspend. The mobile apps report these through
+	// NotifyNetworkChange instead.
+	if a.opts.tunFD <= 0 {
+		a.startNetworkChangeMonitor(ctx)
+	}
+	if autoAdvertise {
+		a.startRouteAdvertiser(ctx)
+	}
+	if a.onDemand {
+		a.startIdleMonitor(ctx)
+	} else {
+		a.startRelayMonitor(ctx)
+	}
+
+	// Apply config changes from now on, whether signalled or noticed.
+	a.reloadMu.Lock()
+	a.reloadReady = true
+	a.reloadMu.Unlock()
+	a.startConfigWatcher(ctx)
+
 	a.log.Info("agent started",
 		"device", a.cfg.Device.Name,
 		"address", a.cfg.Device.Address,
@@ -392,6 +724,10 @@ func (a *Agent) handleMessage(ctx context.Context, msg protocol.Message) error {
 		return a.handleICECandidate(m)
 	case *protocol.PeerLeftMessage:
 		return a.handlePeerLeft(m)
+	case *protocol.PolicyMessage:
+		return a.handlePolicy(m)
+	case *protocol.UpdateMessage:
+		return a.handlePeerUpdate(m)
 	default:
 		a.log.Debug("ignoring unknown message type", "type", msg.MessageType())
 		return nil
@@ -429,6 +765,12 @@ func (a *Agent) handlePeers(ctx context.Context, msg *protocol.PeersMessage) err
 	a.mu.Unlock()
 
 	for _, id := range stale {
+		// On-demand peers stay in WireGuard and reconnect with the next
+		// packet.
+		if a.onDemand {
+			a.idlePeer(id, "stale connection")
+			continue
+		}
 		a.log.Info("removing stale peer", "peer_id", id)
 		a.removePeer(id)
 	}
@@ -446,30 +788,56 @@ func (a *Agent) handlePeers(ctx context.Context, msg *protocol.PeersMessage) err
 		}
 		seen[p.PeerID] = struct{}{}
 
-		a.log.Info("discovered peer",
-			"peer_id", p.PeerID, "public_key", p.PublicKey,
-			"address", p.Address, "routes", p.Routes, "metadata", p.Metadata)
+		a.discoverPeer(ctx, p)
+	}
+	return nil
+}
 
-		// Determine who offers: the peer with the smaller ID.
-		if a.cfg.Device.Name < p.PeerID {
-			if err := a.initiateConnection(ctx, p.PeerID, p.PublicKey, p.Address, p.Routes, p.Metadata); err != nil {
-				a.log.Error("initiating connection", "peer_id", p.PeerID, "error", err)
-			}
+// discoverPeer connects to a peer listed by the signaling server, unless
+// it is blocked.
+func (a *Agent) discoverPeer(ctx context.Context, p protocol.PeerInfo) {
+	a.mu.Lock()
+	a.directory[p.PeerID] = p
+	blocked := a.blocked[p.PeerID]
+	wanted := a.wantsPeer(p.PeerID, p.Metadata)
+	a.mu.Unlock()
+	if blocked {
+		a.log.Info("ignoring blocked peer", "peer_id", p.PeerID)
+		return
+	}
+	if !wanted {
+		a.log.Info("ignoring peer outside the hub-and-spoke topology", "peer_id", p.PeerID)
+		return
+	}
+
+	a.log.Info("discovered peer",
+		"peer_id", p.PeerID, "public_key", p.PublicKey,
+		"address", p.Address, "routes", p.Routes, "metadata", p.Metadata)
+	a.events.Publish(control.Event{Type: control.EventPeerDiscovered, Peer: p.PeerID})
+
+	if a.onDemand {
+		a.registerIdlePeer(p)
+		return
+	}
+
+	// Determine who offers: the peer with the smaller ID.
+	if a.cfg.Device.Name < p.PeerID {
+		if err := a.initiateConnection(ctx, p.PeerID, p.PublicKey, p.Address, p.Routes, p.Metadata, false); err != nil {
+			a.log.Error("initiating connection", "peer_id", p.PeerID, "error", err)
+		}
+	} else {
+		// We'll receive an offer from this peer. Pre-store their address,
+		// routes, and metadata so they're available when the data channel opens.
+		a.mu.Lock()
+		if ps, ok := a.peers[p.PeerID]; ok {
+			ps.address = p.Address
+			ps.routes = p.Routes
+			ps.metadata = p.Metadata
 		} else {
-			// We'll receive an offer from this peer. Pre-store their address,
-			// routes, and metadata so they're available when the data channel opens.
-			a.mu.Lock()
-			if ps, ok := a.peers[p.PeerID]; ok {
-				ps.address = p.Address
-				ps.routes = p.Routes
-				ps.metadata = p.Metadata
-			} else {
-				a.peers[p.PeerID] = &peerState{address: p.Address, routes: p.Routes, metadata: p.Metadata}
-			}
-			a.mu.Unlock()
+			a.peers[p.PeerID] = &peerState{address: p.Address, routes: p.Routes, metadata: p.Metadata}
 		}
+		a.mu.Unlock()
 	}
-	return nil
 }
 
 // handleOffer processes an incoming SDP offer from a remote peer.
@@ -490,7 +858,35 @@ func (a *Agent) handleOffer(ctx context.Context, msg *protocol.OfferMessage) err
 	a.log.Info("received offer", "from", msg.From)
 
 	a.mu.Lock()
+	if a.blocked[msg.From] {
+		a.mu.Unlock()
+		a.log.Info("ignoring offer from blocked peer", "from", msg.From)
+		return nil
+	}
+	if info, _ := a.peerInfo(msg.From); !a.wantsPeer(msg.From, info.Metadata) {
+		a.mu.Unlock()
+		a.log.Info("ignoring offer from peer outside the hub-and-spoke topology", "from", msg.From)
+		return nil
+	}
 	ps, exists := a.peers[msg.From]
+
+	// Both sides connecting on demand at once: like an initial
+	// connection, the offer from the smaller ID wins.
+	if msg.Reset && exists && ps.pendingOffer && a.cfg.Device.Name < msg.From {
+		a.mu.Unlock()
+		a.log.Info("ignoring offer: we are connecting on demand and are the preferred offerer", "from", msg.From)
+		return nil
+	}
+
+	// The peer tore down its side to reconnect: whatever connection we
+	// have is stale, even if ICE still reports it connected.
+	if msg.Reset && exists && ps.rtcPeer != nil {
+		a.mu.Unlock()
+		a.log.Info("peer is reconnecting, tearing down existing connection", "from", msg.From)
+		a.resetPeer(msg.From)
+		a.mu.Lock()
+		ps, exists = a.peers[msg.From]
+	}
 	hasConnection := exists && ps.rtcPeer != nil
 
 	if hasConnection {
@@ -537,21 +933,6 @@ func (a *Agent) handleOffer(ctx context.Context, msg *protocol.OfferMessage) err
 		a.mu.Unlock()
 	}
 
-	// Store the remote peer's WireGuard public key. The offer carries the
-	// sender's public key so the answering side can configure WireGuard
-	// before the data channel opens.
-	if msg.PublicKey != "" {
-		if wgPubKey, err := config.ParseKey(msg.PublicKey); err != nil {
-			a.log.Warn("invalid public key in offer", "from", msg.From, "error", err)
-		} else {
-			a.mu.Lock()
-			if ps, ok := a.peers[msg.From]; ok {
-				ps.publicKey = wgPubKey
-			}
-			a.mu.Unlock()
-		}
-	}
-
 	// Reuse the existing PeerConnection if we have one (ICE restart /
 	// renegotiation). Only create a new one for brand-new peers.
 	var peer *rtcpkg.Peer
@@ -572,6 +953,35 @@ func (a *Agent) handleOffer(ctx context.Context, msg *protocol.OfferMessage) err
 		if err != nil {
 			return fmt.Errorf("creating peer for offer: %w", err)
 		}
+
+		// The peers list may have been forgotten with an earlier
+		// connection; the directory still knows the address and routes.
+		a.mu.Lock()
+		if ps, ok := a.peers[msg.From]; ok && ps.address == "" {
+			if info, known := a.directory[msg.From]; known {
+				ps.address = info.Address
+				ps.routes = info.Routes
+				ps.metadata = info.Metadata
+			}
+		}
+		a.mu.Unlock()
+	}
+
+	// Store the remote peer's WireGuard public key. The offer carries the
+	// sender's public key so the answering side can configure WireGuard
+	// before the data channel opens. Stored after the peer state exists,
+	// which is not yet the case for a peer removed earlier (e.g. one now
+	// relayed) that offers to connect directly again.
+	if msg.PublicKey != "" {
+		if wgPubKey, err := config.ParseKey(msg.PublicKey); err != nil {
+			a.log.Warn("invalid public key in offer", "from", msg.From, "error", err)
+		} else {
+			a.mu.Lock()
+			if ps, ok := a.peers[msg.From]; ok {
+				ps.publicKey = wgPubKey
+			}
+			a.mu.Unlock()
+		}
 	}
 
 	var answerSDP string
@@ -604,12 +1014,15 @@ func (a *Agent) handleOffer(ctx context.Context, msg *protocol.OfferMessage) err
 	}
 	a.mu.Unlock()
 
+	a.setRouteAliases(msg.From, msg.RouteAliases)
+
 	pubKey := config.PublicKey(a.cfg.Device.PrivateKey)
 	return a.sigClient.Send(ctx, &protocol.AnswerMessage{
-		From:      a.cfg.Device.Name,
-		To:        msg.From,
-		SDP:       answerSDP,
-		PublicKey: pubKey.String(),
+		From:         a.cfg.Device.Name,
+		To:           msg.From,
+		SDP:          answerSDP,
+		PublicKey:    pubKey.String(),
+		RouteAliases: a.routeAliases(msg.From),
 	})
 }
 
@@ -621,6 +1034,7 @@ func (a *Agent) handleAnswer(msg *protocol.AnswerMessage) error {
 	ps, ok := a.peers[msg.From]
 	if ok {
 		ps.pendingRestart = false
+		ps.pendingOffer = false
 		if ps.publicKey.IsZero() && msg.PublicKey != "" {
 			if wgPubKey, err := config.ParseKey(msg.PublicKey); err == nil {
 				ps.publicKey = wgPubKey
@@ -640,6 +1054,7 @@ func (a *Agent) handleAnswer(msg *protocol.AnswerMessage) error {
 	if err := ps.rtcPeer.SetAnswer(msg.SDP); err != nil {
 		return err
 	}
+	a.setRouteAliases(msg.From, msg.RouteAliases)
 
 	// SetAnswer calls SetRemoteDescription — flush any ICE candidates
 	// that were buffered before this point.
@@ -655,6 +1070,10 @@ func (a *Agent) handleAnswer(msg *protocol.AnswerMessage) error {
 // remote description is set (see flushPendingCandidates).
 func (a *Agent) handleICECandidate(msg *protocol.ICECandidateMessage) error {
 	a.mu.Lock()
+	if a.blocked[msg.From] {
+		a.mu.Unlock()
+		return nil
+	}
 	ps, ok := a.peers[msg.From]
 
 	// If we don't know this peer at all yet, create a skeleton peerState
@@ -721,13 +1140,17 @@ func (a *Agent) flushPendingCandidates(peerID string) {
 // peer when a remote peer disconnects.
 func (a *Agent) handlePeerLeft(msg *protocol.PeerLeftMessage) error {
 	a.log.Info("peer left", "peer_id", msg.PeerID)
+	a.mu.Lock()
+	delete(a.directory, msg.PeerID)
+	a.mu.Unlock()
 	a.removePeer(msg.PeerID)
+	a.unrelay(msg.PeerID)
 	return nil
 }
 
 // initiateConnection creates a WebRTC peer and sends an SDP offer to the
 // remote peer via signaling.
-func (a *Agent) initiateConnection(ctx context.Context, peerID, publicKey, address string, routes []string, metadata map[string]string) error {
+func (a *Agent) initiateConnection(ctx context.Context, peerID, publicKey, address string, routes []string, metadata map[string]string, reset bool) error {
 	a.log.Info("initiating connection", "peer_id", peerID)
 
 	// Store the public key so we can configure WireGuard when the data
@@ -787,19 +1210,24 @@ func (a *Agent) initiateConnection(ctx context.Context, peerID, publicKey, addre
 
 	pubKey := config.PublicKey(a.cfg.Device.PrivateKey)
 	return a.sigClient.Send(ctx, &protocol.OfferMessage{
-		From:      a.cfg.Device.Name,
-		To:        peerID,
-		SDP:       offerSDP,
-		PublicKey: pubKey.String(),
+		From:         a.cfg.Device.Name,
+		To:           peerID,
+		SDP:          offerSDP,
+		PublicKey:    pubKey.String(),
+		RouteAliases: a.routeAliases(peerID),
+		Reset:        reset,
 	})
 }
 
 // createRTCPeer creates and registers a new WebRTC peer connection.
 func (a *Agent) createRTCPeer(ctx context.Context, peerID string) (*rtcpkg.Peer, error) {
+	// A config reload may change these for future connections.
+	a.mu.Lock()
 	iceConfig := rtcpkg.ICEConfig{
 		STUNServers: a.cfg.STUN.Servers,
 		ForceRelay:  a.cfg.Device.ForceRelay,
 	}
+	a.mu.Unlock()
 
 	// Build a SettingEngine. We always create one so we can set the socket
 	// protector (Android) and/or the TURN proxy dialer.
@@ -917,6 +1345,7 @@ func (a *Agent) createRTCPeer(ctx context.Context, peerID string) (*rtcpkg.Peer,
 // WireGuard configuration.
 func (a *Agent) onDataChannelOpen(peerID string, dc *webrtc.DataChannel) {
 	a.log.Info("data channel open, bridging WireGuard", "peer_id", peerID)
+	a.events.Publish(control.Event{Type: control.EventDataChannelOpen, Peer: peerID})
 
 	// Register the data channel in our custom Bind.
 	a.bind.SetDataChannel(peerID, dc)
@@ -925,23 +1354,39 @@ func (a *Agent) onDataChannelOpen(peerID string, dc *webrtc.DataChannel) {
 	a.mu.Lock()
 	if ps, ok := a.peers[peerID]; ok {
 		ps.connectedAt = time.Now()
+		ps.lastActivity = ps.connectedAt
+		ps.pendingOffer = false
 	}
 	a.mu.Unlock()
 
+	// A direct path replaces the relay, if there was one.
+	a.unrelay(peerID)
+	a.addWireGuardPeer(peerID)
+}
+
+// addWireGuardPeer adds a peer to WireGuard with its tunnel addresses and
+// accepted routes, and installs the routes and DNS it provides. It is
+// called when the data channel opens, or in on-demand mode when the peer
+// is discovered; calling it again updates the peer.
+func (a *Agent) addWireGuardPeer(peerID string) {
 	// Look up the peer's WireGuard public key.
 	a.mu.Lock()
 	ps, ok := a.peers[peerID]
 	a.mu.Unlock()
 
 	if !ok || ps.publicKey.IsZero() {
-		a.log.Warn("data channel opened but no WireGuard public key for peer",
-			"peer_id", peerID)
+		a.log.Warn("no WireGuard public key for peer", "peer_id", peerID)
 		return
 	}
 
 	// Resolve which routes to accept from this peer based on per-peer
-	// selections (preferred) or the legacy AcceptRoutes flag.
-	acceptedRoutes := a.resolveAcceptedRoutes(peerID, ps)
+	// selections (preferred) or the legacy AcceptRoutes flag, then check
+	// them against subnets this host already reaches locally.
+	acceptedRoutes := a.applyRouteConflictPolicy(peerID, a.resolveAcceptedRoutes(peerID, ps))
+	a.mu.Lock()
+	ps.installedRoutes = slices.Concat(acceptedRoutes, a.hubRoutes(peerID, ps))
+	hasRouteAliases := len(ps.routeAliases) > 0
+	a.mu.Unlock()
 
 	// Determine the peer's WireGuard allowed IPs from their tunnel address
 	// and the accepted routes. Peers without a valid address are rejected
@@ -952,74 +1397,89 @@ func (a *Agent) onDataChannelOpen(peerID string, dc *webrtc.DataChannel) {
 			"peer_id", peerID)
 		return
 	}
-	ip, _, err := net.ParseCIDR(ps.address)
+	tunnelIPs, err := peerTunnelIPs(ps.address, ps.metadata)
 	if err != nil {
 		a.log.Error("invalid peer address, refusing to add WireGuard peer",
 			"peer_id", peerID, "address", ps.address, "error", err)
 		return
 	}
-	allowedIPs := append([]string{ip.String() + "/32"}, acceptedRoutes...)
-	a.log.Info("using peer-specific AllowedIPs",
-		"peer_id", peerID, "allowed_ips", allowedIPs)
+	a.mu.Lock()
+	ps.tunnelAllowedIPs = tunnelIPs
+	a.mu.Unlock()
 
-	peerCfg := tunnel.PeerConfig{
-		PublicKey:           ps.publicKey,
-		Endpoint:            peerID,
-		AllowedIPs:          allowedIPs,
-		PersistentKeepalive: 25,
-	}
+	// Add the WireGuard peer with the routes it is primary for, and add
+	// kernel routes for subnets no other peer offered yet, sized for the
+	// peer's path.
+	a.refreshPathMTU(peerID)
+	a.rebalanceRoutes(peerID)
 
-	if err := a.wgDevice.AddPeer(peerCfg); err != nil {
-		a.log.Error("adding WireGuard peer", "peer_id", peerID, "error", err)
-	}
-
-	// Add kernel routes for accepted subnets so the kernel directs matching
-	// traffic into the TUN interface.
-	for _, route := range acceptedRoutes {
-		if err := a.deps.Network.AddRoute(a.tunName, route); err != nil {
-			a.log.Warn("adding route for peer", "peer_id", peerID, "route", route, "error", err)
-		} else {
-			a.log.Info("added route", "peer_id", peerID, "route", route, "dev", a.tunName)
-		}
+	// Translate the aliases this peer uses for our routes back to the real
+	// subnets.
+	if hasRouteAliases {
+		a.applyNetmaps()
 	}
 
 	// Configure DNS for accepted DNS servers/search domains from this peer.
+	a.applyPeerDNS(peerID)
+
+	a.notifyNewRoutes(peerID, acceptedRoutes)
+}
+
+// applyPeerDNS configures the DNS servers and search domains accepted from
+// a connected peer, if any.
+func (a *Agent) applyPeerDNS(peerID string) {
 	acceptedDNS, acceptedSearch := a.resolveAcceptedDNS(peerID)
-	if len(acceptedDNS) > 0 || len(acceptedSearch) > 0 {
-		if err := a.deps.Network.SetDNS(a.tunName, acceptedDNS, acceptedSearch); err != nil {
-			a.log.Warn("setting DNS for peer", "peer_id", peerID, "error", err)
-		} else {
-			a.log.Info("configured DNS",
-				"peer_id", peerID, "dns", acceptedDNS, "search", acceptedSearch, "dev", a.tunName)
-		}
+	if len(acceptedDNS) > 0 {
+		acceptedDNS = a.startDNSProxy(peerID, acceptedDNS)
 	}
+	if len(acceptedDNS) == 0 && len(acceptedSearch) == 0 {
+		return
+	}
+	a.journalDNS(true)
+	if err := a.deps.Network.SetDNS(a.tunName, a.dnsBackend, acceptedDNS, acceptedSearch); err != nil {
+		a.log.Warn("setting DNS for peer", "peer_id", peerID, "error", err)
+		return
+	}
+	a.log.Info("configured DNS",
+		"peer_id", peerID, "dns", acceptedDNS, "search", acceptedSearch, "dev", a.tunName,
+		"backend", a.dnsBackend)
+	a.events.Publish(control.Event{Type: control.EventDNSApplied, Peer: peerID,
+		DNS: acceptedDNS, DNSSearch: acceptedSearch})
+}
 
-	// Notify the route update callback (Android VPN restart) if the peer
-	// has accepted routes we haven't seen before.
-	if a.opts.routeUpdateCallback != nil && len(acceptedRoutes) > 0 {
-		var newRoutes []string
-		a.mu.Lock()
-		for _, route := range acceptedRoutes {
-			if !a.notifiedRoutes[route] {
-				a.notifiedRoutes[route] = true
-				newRoutes = append(newRoutes, route)
-			}
-		}
-		a.mu.Unlock()
+// notifyNewRoutes calls the route update callback (Android VPN restart)
+// if a peer has accepted routes we haven't seen before.
+func (a *Agent) notifyNewRoutes(peerID string, acceptedRoutes []string) {
+	if a.opts.routeUpdateCallback == nil || len(acceptedRoutes) == 0 {
+		return
+	}
 
-		if len(newRoutes) > 0 {
-			a.log.Info("notifying route update callback with new peer routes",
-				"peer_id", peerID, "routes", newRoutes)
-			a.opts.routeUpdateCallback(newRoutes)
+	var newRoutes []string
+	a.mu.Lock()
+	for _, route := range acceptedRoutes {
+		if !a.notifiedRoutes[route] {
+			a.notifiedRoutes[route] = true
+			newRoutes = append(newRoutes, route)
 		}
 	}
+	a.mu.Unlock()
+
+	if len(newRoutes) > 0 {
+		a.log.Info("notifying route update callback with new peer routes",
+			"peer_id", peerID, "routes", newRoutes)
+		a.opts.routeUpdateCallback(newRoutes)
+	}
 }
 
 // resolveAcceptedRoutes determines which routes to accept from a peer.
-// Per-peer selections take precedence; falls back to legacy AcceptRoutes.
+// Per-peer selections take precedence, then routes the network policy
+// accepts automatically; falls back to legacy AcceptRoutes.
 func (a *Agent) resolveAcceptedRoutes(peerID string, ps *peerState) []string {
 	// Check for per-peer selections first.
-	if sel, ok := a.cfg.PeerSelection(peerID); ok {
+	sel, hasSel := a.cfg.PeerSelection(peerID)
+	hasSel = hasSel && !sel.ACLOnly()
+	if hasSel && len(sel.Routes) > 0 {
+		aliases := a.routeAliases(peerID)
 		var accepted []string
 		for _, route := range sel.Routes {
 			if !isValidRoute(route) {
@@ -1027,6 +1487,16 @@ func (a *Agent) resolveAcceptedRoutes(peerID string, ps *peerState) []string {
 					"peer_id", peerID, "route", route)
 				continue
 			}
+			if !slices.Contains(ps.routes, route) {
+				a.log.Info("peer does not advertise selected route, skipping",
+					"peer_id", peerID, "route", route)
+				continue
+			}
+			if alias, ok := aliases[route]; ok {
+				a.log.Info("reaching peer route through alias",
+					"peer_id", peerID, "route", route, "alias", alias)
+				route = alias
+			}
 			accepted = append(accepted, route)
 		}
 		if len(accepted) > 0 {
@@ -1036,6 +1506,15 @@ func (a *Agent) resolveAcceptedRoutes(peerID string, ps *peerState) []string {
 		return accepted
 	}
 
+	if accepted := a.policyRoutes(peerID, ps.routes); len(accepted) > 0 {
+		a.log.Info("applying routes from network policy",
+			"peer_id", peerID, "routes", accepted)
+		return accepted
+	}
+	if hasSel {
+		return nil
+	}
+
 	// Legacy fallback: accept all routes if AcceptRoutes is enabled.
 	if a.cfg.Device.AcceptRoutes { //nolint:staticcheck // intentional backward compat
 		var accepted []string
@@ -1057,14 +1536,317 @@ func (a *Agent) resolveAcceptedRoutes(peerID string, ps *peerState) []string {
 	return nil
 }
 
+// applyRouteConflictPolicy checks accepted routes against the subnets and
+// kernel routes this host already reaches without the tunnel, and applies
+// device.route_conflict_policy to any that overlap. It returns the routes
+// to install.
+func (a *Agent) applyRouteConflictPolicy(peerID string, routes []string) []string {
+	if len(routes) == 0 {
+		return nil
+	}
+	local := a.localRoutes()
+
+	var install []string
+	for _, route := range routes {
+		conflicts := tunnel.FindRouteConflicts(route, local)
+		if len(conflicts) == 0 {
+			install = append(install, route)
+			continue
+		}
+
+		localCIDRs := make([]string, len(conflicts))
+		for i, c := range conflicts {
+			localCIDRs[i] = c.CIDR
+		}
+
+		switch a.routeConflictPolicy {
+		case tunnel.RouteConflictRefuse:
+			a.log.Warn("refusing peer route that conflicts with a local subnet",
+				"peer_id", peerID, "route", route, "local", localCIDRs)
+		case tunnel.RouteConflictMoreSpecific:
+			remainder, err := tunnel.ExcludeSubnets(route, localCIDRs)
+			if err != nil {
+				a.log.Warn("splitting conflicting peer route", "peer_id", peerID, "route", route, "error", err)
+				continue
+			}
+			a.log.Warn("installing only the parts of peer route that do not conflict with local subnets",
+				"peer_id", peerID, "route", route, "local", localCIDRs, "installed", remainder)
+			install = append(install, remainder...)
+		default:
+			a.log.Warn("peer route conflicts with a local subnet, local traffic may be misrouted "+
+				"(set device.route_conflict_policy to refuse or more-specific)",
+				"peer_id", peerID, "route", route, "local", localCIDRs)
+			install = append(install, route)
+		}
+	}
+	return install
+}
+
+// localRoutes returns the subnets this host reaches without the tunnel.
+// Errors are logged and whatever could be read is returned, so conflict
+// detection degrades to fewer warnings rather than blocking routes.
+func (a *Agent) localRoutes() []tunnel.SubnetInfo {
+	local, err := a.deps.Network.LocalRoutes(a.tunName)
+	if err != nil {
+		a.log.Warn("reading local routes for conflict detection", "error", err)
+	}
+	return local
+}
+
 // resolveAcceptedDNS determines which DNS servers and search domains to accept
-// from a peer based on per-peer selections.
+// from a peer based on per-peer selections, or the network policy for peers
+// without a local DNS selection. Servers inside an aliased route
+// are returned at their alias address.
 func (a *Agent) resolveAcceptedDNS(peerID string) (dns []string, search []string) {
 	sel, ok := a.cfg.PeerSelection(peerID)
+	servers, search := sel.DNS, sel.DNSSearch
+	if !ok || len(servers) == 0 && len(search) == 0 {
+		// Without a local DNS selection, use the network policy's.
+		a.mu.Lock()
+		servers, search = a.netPolicy.DNSFor(a.cfg.Device.Name, peerID)
+		a.mu.Unlock()
+	}
+	maps, _ := netmap.ParseAll(a.routeAliases(peerID)) // validated by routeAliases
+	if len(maps) == 0 {
+		return servers, search
+	}
+	dns = make([]string, len(servers))
+	for i, server := range servers {
+		dns[i] = server
+		if addr, err := netip.ParseAddr(server); err == nil {
+			dns[i] = netmap.AliasAddr(addr, maps).String()
+		}
+	}
+	return dns, search
+}
+
+// routeAliases returns the NETMAP aliases selected for a peer's routes
+// (peers.<name>.route_aliases), keyed by route. Entries for routes that are
+// not accepted, or whose alias is not a valid same-size prefix, are logged
+// and dropped.
+func (a *Agent) routeAliases(peerID string) map[string]string {
+	sel, ok := a.cfg.PeerSelection(peerID)
+	if !ok || len(sel.RouteAliases) == 0 {
+		return nil
+	}
+	aliases := make(map[string]string, len(sel.RouteAliases))
+	for route, alias := range sel.RouteAliases {
+		if !slices.Contains(sel.Routes, route) {
+			a.log.Warn("ignoring alias for a route that is not accepted",
+				"peer_id", peerID, "route", route, "alias", alias)
+			continue
+		}
+		if _, err := netmap.Parse(route, alias); err != nil {
+			a.log.Warn("ignoring invalid route alias", "peer_id", peerID, "error", err)
+			continue
+		}
+		aliases[route] = alias
+	}
+	return aliases
+}
+
+// setRouteAliases records the aliases a peer uses to reach our advertised
+// routes, as sent in its offer or answer. Aliases for routes we do not
+// advertise are ignored. The NETMAP rules are rebuilt when the data channel
+// opens, or immediately if an ICE restart changed the aliases of a
+// connected peer.
+func (a *Agent) setRouteAliases(peerID string, aliases map[string]string) {
+	var maps []netmap.Mapping
+	for route, alias := range aliases {
+		m, err := netmap.Parse(route, alias)
+		if err != nil {
+			a.log.Warn("ignoring invalid route alias from peer", "peer_id", peerID, "error", err)
+			continue
+		}
+		if !a.advertisesRoute(m.Route) {
+			a.log.Warn("ignoring alias for a route we do not advertise",
+				"peer_id", peerID, "route", route, "alias", alias)
+			continue
+		}
+		maps = append(maps, m)
+	}
+	slices.SortFunc(maps, func(x, y netmap.Mapping) int {
+		return strings.Compare(x.Route.String(), y.Route.String())
+	})
+
+	a.mu.Lock()
+	ps, ok := a.peers[peerID]
 	if !ok {
-		return nil, nil
+		a.mu.Unlock()
+		return
+	}
+	changed := !slices.Equal(ps.routeAliases, maps)
+	ps.routeAliases = maps
+	connected := !ps.connectedAt.IsZero()
+	a.mu.Unlock()
+
+	if changed && connected {
+		a.applyNetmaps()
+		a.updateACL()
+	}
+}
+
+// advertisesRoute reports whether prefix is one of the advertised routes.
+func (a *Agent) advertisesRoute(prefix netip.Prefix) bool {
+	for _, route := range a.advertisedRoutes() {
+		if p, err := netip.ParsePrefix(route); err == nil && p.Masked() == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNetmaps rebuilds the NETMAP rules from every peer's route aliases.
+// Each rule only matches traffic from the peer that chose the alias, so
+// two peers can use the same alias for different routes.
+func (a *Agent) applyNetmaps() {
+	if a.natManager == nil {
+		return
+	}
+
+	a.natMu.Lock()
+	defer a.natMu.Unlock()
+
+	a.mu.Lock()
+	var rules []tunnel.NetmapRule
+	for peerID, ps := range a.peers {
+		for _, m := range ps.routeAliases {
+			source := peerTunnelIP(ps, m.Route.Addr().Is6())
+			if source == "" {
+				a.log.Warn("peer has no tunnel address of the route's family, skipping alias",
+					"peer_id", peerID, "route", m.Route, "alias", m.Alias)
+				continue
+			}
+			rules = append(rules, tunnel.NetmapRule{
+				Source: source,
+				Alias:  m.Alias.String(),
+				Route:  m.Route.String(),
+			})
+		}
+	}
+	a.mu.Unlock()
+
+	slices.SortFunc(rules, func(x, y tunnel.NetmapRule) int {
+		return strings.Compare(x.Source+" "+x.Alias, y.Source+" "+y.Alias)
+	})
+	if slices.Equal(rules, a.netmapRules) {
+		return
+	}
+	if err := a.natManager.SetNetmaps(a.tunName, rules); err != nil {
+		a.log.Error("applying netmap rules", "error", err)
+		return
+	}
+	a.netmapRules = rules
+}
+
+// peerTunnelIPs returns the WireGuard AllowedIPs covering a peer's tunnel
+// addresses: its address as a /32 and, on dual-stack networks, its IPv6
+// address as a /128.
+func peerTunnelIPs(address string, metadata map[string]string) ([]string, error) {
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, err
+	}
+	ips := []string{ip.String() + "/32"}
+	if ip6 := peerAddress6(metadata); ip6 != nil {
+		ips = append(ips, ip6.String()+"/128")
+	}
+	return ips, nil
+}
+
+// peerTunnelIP returns a peer's IPv4 or IPv6 tunnel address without the
+// prefix length, or "" if it has none.
+func peerTunnelIP(ps *peerState, ipv6 bool) string {
+	if ipv6 {
+		if ip := peerAddress6(ps.metadata); ip != nil {
+			return ip.String()
+		}
+		return ""
+	}
+	ip, _, err := net.ParseCIDR(ps.address)
+	if err != nil || ip.To4() == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// startDNSProxy starts a rewriting DNS proxy on our tunnel address when a
+// peer's DNS servers are reached through NETMAP aliases, so answers naming
+// hosts in the real subnet come back in alias space. It returns the servers
+// to configure: the proxy, or the given servers if there are no aliases or
+// the proxy cannot start. The proxy serves one peer; while it does, the
+// answers of other peers' aliased resolvers are not rewritten.
+func (a *Agent) startDNSProxy(peerID string, servers []string) []string {
+	maps, _ := netmap.ParseAll(a.routeAliases(peerID)) // validated by routeAliases
+	if len(maps) == 0 {
+		return servers
+	}
+
+	ip, _, err := net.ParseCIDR(a.cfg.Device.Address)
+	if err != nil {
+		return servers
+	}
+
+	a.mu.Lock()
+	owner, prev := a.dnsProxyPeer, a.dnsProxy
+	if owner != "" && owner != peerID {
+		a.mu.Unlock()
+		a.log.Warn("DNS proxy already serves another peer's aliased routes, DNS answers will not be rewritten",
+			"peer_id", peerID, "proxy_peer", owner)
+		return servers
+	}
+	a.dnsProxy = nil
+	a.dnsProxyPeer = peerID
+	a.mu.Unlock()
+	if prev != nil {
+		if err := prev.Close(); err != nil {
+			a.log.Warn("closing DNS proxy", "error", err)
+		}
+	}
+
+	proxy, err := netmap.ListenDNSProxy(net.JoinHostPort(ip.String(), "53"), servers, maps, a.log)
+	if err != nil {
+		a.stopDNSProxy(peerID)
+		a.log.Warn("starting DNS proxy for aliased routes, DNS answers will not be rewritten",
+			"peer_id", peerID, "error", err)
+		return servers
+	}
+
+	a.mu.Lock()
+	stopped := a.dnsProxyPeer != peerID
+	if !stopped {
+		a.dnsProxy = proxy
+	}
+	a.mu.Unlock()
+	if stopped {
+		// The peer went away while the proxy started.
+		_ = proxy.Close()
+		return servers
+	}
+
+	a.log.Info("DNS proxy started for aliased routes",
+		"peer_id", peerID, "listen", proxy.Addr(), "upstreams", servers)
+	return []string{ip.String()}
+}
+
+// stopDNSProxy closes the DNS proxy if it serves peerID.
+func (a *Agent) stopDNSProxy(peerID string) {
+	a.mu.Lock()
+	proxy := a.dnsProxy
+	if a.dnsProxyPeer != peerID {
+		a.mu.Unlock()
+		return
+	}
+	a.dnsProxy = nil
+	a.dnsProxyPeer = ""
+	a.mu.Unlock()
+
+	if proxy == nil {
+		return
+	}
+	if err := proxy.Close(); err != nil {
+		a.log.Warn("closing DNS proxy", "error", err)
 	}
-	return sel.DNS, sel.DNSSearch
 }
 
 // removePeer tears down the WebRTC connection and WireGuard peer state.
@@ -1082,23 +1864,33 @@ func (a *Agent) removePeer(peerID string) {
 	}
 	delete(a.peers, peerID)
 	a.mu.Unlock()
+	a.events.Publish(control.Event{Type: control.EventPeerRemoved, Peer: peerID})
 
-	// Remove kernel routes that were accepted from this peer.
-	acceptedRoutes := a.resolveAcceptedRoutes(peerID, ps)
-	for _, route := range acceptedRoutes {
-		if err := a.deps.Network.RemoveRoute(a.tunName, route); err != nil {
-			a.log.Warn("removing route for peer", "peer_id", peerID, "route", route, "error", err)
-		} else {
-			a.log.Info("removed route", "peer_id", peerID, "route", route, "dev", a.tunName)
-		}
+	// Peers relayed through this one need another relay.
+	defer a.rerelay(peerID)
+
+	// Packets from this peer's addresses no longer belong to it.
+	a.updateACL()
+
+	// Hand this peer's routes to standby peers offering the same subnets,
+	// and remove kernel routes no remaining peer offers.
+	if len(ps.installedRoutes) > 0 {
+		a.rebalanceRoutes("")
+	}
+
+	// Drop NETMAP rules for aliases this peer used.
+	if len(ps.routeAliases) > 0 {
+		a.applyNetmaps()
 	}
 
 	// Remove DNS configuration for this peer.
+	a.stopDNSProxy(peerID)
 	acceptedDNS, acceptedSearch := a.resolveAcceptedDNS(peerID)
 	if len(acceptedDNS) > 0 || len(acceptedSearch) > 0 {
-		if err := a.deps.Network.RevertDNS(a.tunName); err != nil {
+		if err := a.deps.Network.RevertDNS(a.tunName, a.dnsBackend); err != nil {
 			a.log.Warn("reverting DNS for peer", "peer_id", peerID, "error", err)
 		} else {
+			a.journalDNS(false)
 			a.log.Info("reverted DNS", "peer_id", peerID, "dev", a.tunName)
 		}
 	}
@@ -1128,6 +1920,8 @@ func (a *Agent) removePeer(peerID string) {
 // NotifyNetworkChange should be called when the underlying network changes
 // (e.g. Android sleep/wake, wifi ↔ mobile data switch). It forces the
 // signaling WebSocket to reconnect and marks all peers for ICE restart.
+// On desktops and servers the agent calls it itself when it detects a
+// change (see startNetworkChangeMonitor).
 //
 // The actual ICE restart is deferred until signaling reconnects and the hub
 // sends a fresh peers list (handled by handlePeers). This avoids the race
@@ -1183,14 +1977,23 @@ func (a *Agent) Status() control.Status {
 	for id, ps := range a.peers {
 		peerStatus := control.PeerStatus{
 			ID:       id,
+			Role:     a.peerRole(id, ps.metadata),
 			Address:  ps.address,
 			Routes:   ps.routes,
 			Metadata: ps.metadata,
+			Relay:    a.relays[id],
+		}
+		if ip6 := peerAddress6(ps.metadata); ip6 != nil {
+			peerStatus.Address6 = ps.metadata[protocol.MetaKeyAddress6]
 		}
 
 		if ps.rtcPeer != nil {
 			peerStatus.State = ps.rtcPeer.ConnectionState().String()
 			peerStatus.ICEType = ps.rtcPeer.ICECandidateType()
+			peerStatus.MTU = ps.pathMTU
+		} else if ps.tunnelAllowedIPs != nil {
+			// In WireGuard, waiting for traffic (on-demand mode).
+			peerStatus.State = "idle"
 		} else {
 			peerStatus.State = "initializing"
 		}
@@ -1201,32 +2004,62 @@ func (a *Agent) Status() control.Status {
 
 		peers = append(peers, peerStatus)
 	}
+	for id, relay := range a.relays {
+		if _, ok := a.peers[id]; ok {
+			continue
+		}
+		info := a.directory[id]
+		peers = append(peers, control.PeerStatus{
+			ID:       id,
+			Role:     a.peerRole(id, info.Metadata),
+			Address:  info.Address,
+			Address6: info.Metadata[protocol.MetaKeyAddress6],
+			State:    "relayed",
+			Routes:   info.Routes,
+			Metadata: info.Metadata,
+			Relay:    relay,
+		})
+	}
 
 	return control.Status{
+		Network:       a.opts.network,
 		Device:        a.cfg.Device.Name,
 		Address:       a.cfg.Device.Address,
-		Routes:        a.cfg.Device.Routes,
+		Address6:      a.cfg.Device.Address6,
+		Routes:        a.advertised,
 		ServerURL:     a.cfg.Network.ServerURL,
 		UptimeSeconds: time.Since(a.startedAt).Seconds(),
 		Peers:         peers,
+		ACL:           a.aclStatus(),
+		PolicyVersion: a.policyVersion,
+		Blocked:       a.cfg.Device.BlockedPeers,
+		Role:          a.role(),
 	}
 }
 
 // PeerOfferings returns the capabilities advertised by each connected peer
 // along with the user's current selections from config.
 func (a *Agent) PeerOfferings() []control.PeerOfferings {
+	local := a.localRoutes()
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	offerings := make([]control.PeerOfferings, 0, len(a.peers))
 	for id, ps := range a.peers {
 		o := control.PeerOfferings{
-			PeerID:  id,
-			Address: ps.address,
+			PeerID:          id,
+			Address:         ps.address,
+			InstalledRoutes: ps.installedRoutes,
+			PrimaryRoutes:   a.primaryRoutes(id, ps),
+			ConflictPolicy:  string(a.routeConflictPolicy),
 		}
 
 		if ps.rtcPeer != nil {
 			o.State = ps.rtcPeer.ConnectionState().String()
+		} else if ps.tunnelAllowedIPs != nil {
+			// In WireGuard, waiting for traffic (on-demand mode).
+			o.State = "idle"
 		} else {
 			o.State = "initializing"
 		}
@@ -1234,12 +2067,24 @@ func (a *Agent) PeerOfferings() []control.PeerOfferings {
 		// Parse advertised capabilities from metadata.
 		o.Advertised = parseCapabilities(ps.metadata, ps.routes)
 
+		for _, route := range o.Advertised.Routes {
+			for _, c := range tunnel.FindRouteConflicts(route, local) {
+				o.Conflicts = append(o.Conflicts, control.RouteConflict{
+					Route:       route,
+					LocalSubnet: c.CIDR,
+					Interface:   c.Interface,
+				})
+			}
+		}
+
 		// Load current selections from config.
 		if sel, ok := a.cfg.PeerSelection(id); ok {
 			o.Accepted = control.PeerCapabilities{
-				Routes:    sel.Routes,
-				DNS:       sel.DNS,
-				DNSSearch: sel.DNSSearch,
+				Routes:        sel.Routes,
+				DNS:           sel.DNS,
+				DNSSearch:     sel.DNSSearch,
+				RouteAliases:  sel.RouteAliases,
+				RoutePriority: sel.RoutePriority,
 			}
 		}
 
@@ -1249,6 +2094,18 @@ func (a *Agent) PeerOfferings() []control.PeerOfferings {
 	return offerings
 }
 
+// primaryRoutes returns the installed routes a peer currently carries.
+// Callers must hold a.mu.
+func (a *Agent) primaryRoutes(peerID string, ps *peerState) []string {
+	var primary []string
+	for _, route := range ps.installedRoutes {
+		if a.routeOwners[route] == peerID {
+			primary = append(primary, route)
+		}
+	}
+	return primary
+}
+
 // parseCapabilities extracts PeerCapabilities from a peer's metadata and
 // legacy routes field.
 func parseCapabilities(metadata map[string]string, legacyRoutes []string) control.PeerCapabilities {
@@ -1281,39 +2138,71 @@ func parseCapabilities(metadata map[string]string, legacyRoutes []string) contro
 		}
 	}
 
+	// Parse published services from metadata.
+	if servicesJSON, ok := metadata[protocol.MetaKeyServices]; ok {
+		var services []control.Service
+		if err := json.Unmarshal([]byte(servicesJSON), &services); err == nil {
+			for i := range services {
+				if services[i].Protocol == "" {
+					services[i].Protocol = "tcp"
+				}
+			}
+			caps.Services = services
+		}
+	}
+
 	return caps
 }
 
-// ConfigurePeer applies per-peer selections from a control request, persists
-// them to the config file, and updates the in-memory config.
+// ConfigurePeer applies per-peer selections from a control request the way
+// a reload does, so the routes and DNS accepted from a connected peer are
+// resolved again, and persists them to the config file.
 func (a *Agent) ConfigurePeer(req control.ConfigureRequest) error {
 	a.log.Info("configuring peer selections",
 		"peer_id", req.PeerID,
 		"routes", req.Selections.Routes,
 		"dns", req.Selections.DNS,
 		"dns_search", req.Selections.DNSSearch,
+		"route_aliases", req.Selections.RouteAliases,
+		"route_priority", req.Selections.RoutePriority,
 	)
 
-	// Update the in-memory config.
-	a.cfg.SetPeerSelection(req.PeerID, config.PeerSelections{
-		Routes:    req.Selections.Routes,
-		DNS:       req.Selections.DNS,
-		DNSSearch: req.Selections.DNSSearch,
+	// Allow rules are not part of the request and are kept.
+	a.reloadMu.Lock()
+	a.mu.Lock()
+	peers := maps.Clone(a.cfg.Peers)
+	a.mu.Unlock()
+	if peers == nil {
+		peers = make(map[string]config.PeerSelections)
+	}
+	peers[req.PeerID] = config.PeerSelections{
+		Routes:        req.Selections.Routes,
+		DNS:           req.Selections.DNS,
+		DNSSearch:     req.Selections.DNSSearch,
+		RouteAliases:  req.Selections.RouteAliases,
+		RoutePriority: req.Selections.RoutePriority,
+		Allow:         peers[req.PeerID].Allow,
+	}
+	a.reloadSelections(&config.Config{Peers: peers}, func(string, bool) bool { return true }, false)
+	a.reloadMu.Unlock()
+
+	// Persist to disk, keeping the allow rules in the file.
+	return a.saveConfig(func(cfg *config.Config) {
+		prev, _ := cfg.PeerSelection(req.PeerID)
+		cfg.SetPeerSelection(req.PeerID, config.PeerSelections{
+			Routes:        req.Selections.Routes,
+			DNS:           req.Selections.DNS,
+			DNSSearch:     req.Selections.DNSSearch,
+			RouteAliases:  req.Selections.RouteAliases,
+			RoutePriority: req.Selections.RoutePriority,
+			Allow:         prev.Allow,
+		})
 	})
-
-	// Persist to disk.
-	if a.configPath != "" {
-		if err := a.deps.Config.SaveConfig(a.configPath, a.cfg); err != nil {
-			return fmt.Errorf("saving config: %w", err)
-		}
-	}
-
-	return nil
 }
 
 // handleICEStateChange reacts to ICE connection state transitions for a peer.
 // Instead of immediately removing a peer on failure, it attempts ICE restarts
-// with a grace period for transient disconnections.
+// with a grace period for transient disconnections (see connectionLost).
 func (a *Agent) handleICEStateChange(ctx context.Context, peerID string, state webrtc.ICEConnectionState) {
 	a.mu.Lock()
 	ps, ok := a.peers[peerID]
@@ -1321,6 +2210,7 @@ func (a *Agent) handleICEStateChange(ctx context.Context, peerID string, state w
 		a.mu.Unlock()
 		return
 	}
+	a.events.Publish(control.Event{Type: control.EventICEState, Peer: peerID, State: state.String()})
 
 	switch state {
 	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
@@ -1359,7 +2249,7 @@ func (a *Agent) handleICEStateChange(ctx context.Context, peerID string, state w
 		a.log.Warn("ICE disconnected, starting grace period",
 			"peer_id", peerID, "grace", iceDisconnectGrace)
 		ps.restartTimer = time.AfterFunc(iceDisconnectGrace, func() {
-			a.attemptICERestart(ctx, peerID)
+			a.connectionLost(ctx, peerID)
 		})
 		a.mu.Unlock()
 
@@ -1377,8 +2267,8 @@ func (a *Agent) handleICEStateChange(ctx context.Context, peerID string, state w
 			ps.restartTimer = nil
 		}
 		a.mu.Unlock()
-		a.log.Warn("ICE connection failed, attempting restart", "peer_id", peerID)
-		a.attemptICERestart(ctx, peerID)
+		a.log.Warn("ICE connection failed", "peer_id", peerID)
+		a.connectionLost(ctx, peerID)
 
 	default:
 		a.mu.Unlock()
@@ -1396,6 +2286,7 @@ func (a *Agent) attemptICERestart(ctx context.Context, peerID string) {
 	}
 
 	ps.iceRestarts++
+	ps.iceRestartsAll++
 	attempt := ps.iceRestarts
 	ps.restartTimer = nil
 	// Clear any buffered ICE candidates from the previous session —
@@ -1407,6 +2298,7 @@ func (a *Agent) attemptICERestart(ctx context.Context, peerID string) {
 		a.log.Error("ICE restart attempts exhausted, removing peer",
 			"peer_id", peerID, "attempts", attempt-1)
 		a.removePeer(peerID)
+		a.relayPeer(peerID)
 		return
 	}
 
@@ -1418,6 +2310,7 @@ func (a *Agent) attemptICERestart(ctx context.Context, peerID string) {
 	}
 
 	a.log.Info("ICE restart attempt", "peer_id", peerID, "attempt", attempt, "max", maxICERestarts)
+	a.events.Publish(control.Event{Type: control.EventICERestart, Peer: peerID, Attempt: attempt})
 
 	offerSDP, err := rtcPeer.RestartICE()
 	if err != nil {
@@ -1436,10 +2329,11 @@ func (a *Agent) attemptICERestart(ctx context.Context, peerID string) {
 	// Send the restart offer through signaling.
 	pubKey := config.PublicKey(a.cfg.Device.PrivateKey)
 	if err := a.sigClient.Send(ctx, &protocol.OfferMessage{
-		From:      a.cfg.Device.Name,
-		To:        peerID,
-		SDP:       offerSDP,
-		PublicKey: pubKey.String(),
+		From:         a.cfg.Device.Name,
+		To:           peerID,
+		SDP:          offerSDP,
+		PublicKey:    pubKey.String(),
+		RouteAliases: a.routeAliases(peerID),
 	}); err != nil {
 		a.log.Error("sending ICE restart offer", "peer_id", peerID, "error", err)
 		// Don't remove peer — signaling might reconnect and we can retry.
@@ -1524,6 +2418,9 @@ func (a *Agent) refreshJWT(ctx context.Context) error {
 
 	resp, err := a.deps.Auth.Refresh(ctx, serverURL, a.cfg.Network.DeviceID, refreshToken)
 	if err != nil {
+		if ctx.Err() == nil {
+			a.jwtRefreshFailures.Add(1)
+		}
 		return fmt.Errorf("refreshing JWT: %w", err)
 	}
 
@@ -1533,6 +2430,12 @@ func (a *Agent) refreshJWT(ctx context.Context) error {
 	a.cfg.Network.RefreshToken = resp.RefreshToken
 	a.tokenMu.Unlock()
 
+	// Devices registered before the network became dual-stack learn their
+	// IPv6 address on refresh. Persist it with the public config.
+	if resp.Address6 != "" && a.cfg.Device.Address6 == "" {
+		a.adoptAddress6(resp.Address6)
+	}
+
 	// Persist the rotated token immediately so we don't lose it on crash.
 	if a.configPath != "" {
 		if err := a.deps.Config.SaveSecrets(a.configPath, a.cfg); err != nil {
@@ -1554,9 +2457,42 @@ func (a *Agent) refreshJWT(ctx context.Context) error {
 	}
 
 	a.log.Info("JWT refreshed", "expires_in", resp.ExpiresIn)
+	a.events.Publish(control.Event{Type: control.EventJWTRefreshed})
 	return nil
 }
 
+// adoptAddress6 records an IPv6 tunnel address assigned by the server after
+// startup, assigns it to the TUN interface, sets up the forwarding and NAT
+// that IPv6 routes and port forwards were skipped for without it, and saves
+// it to config.toml. Peers see it once the agent next joins signaling.
+func (a *Agent) adoptAddress6(addr6 string) {
+	if err := validateAddress6(addr6); err != nil {
+		a.log.Warn("ignoring IPv6 address from server", "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	a.cfg.Device.Address6 = addr6
+	a.mu.Unlock()
+	a.log.Info("assigned IPv6 tunnel address", "address6", addr6)
+
+	if a.opts.tunFD <= 0 && a.tunName != "" {
+		if err := a.deps.Network.AddAddress(a.tunName, addr6); err != nil {
+			a.log.Warn("adding IPv6 address", "name", a.tunName, "address6", addr6, "error", err)
+		}
+		if a.opts.userspace == nil && slices.ContainsFunc(a.forwardedSubnets(), isIPv6Route) {
+			a.updateRouteForwarding()
+			if err := a.applyPortForwards(a.tunName); err != nil {
+				a.log.Error("setting up port forwards for IPv6 address", "error", err)
+			}
+		}
+	}
+
+	if err := a.saveConfig(func(cfg *config.Config) { cfg.Device.Address6 = addr6 }); err != nil {
+		a.log.Error("persisting IPv6 address", "error", err)
+	}
+}
+
 // jwtRefreshLoop periodically refreshes the JWT before it expires.
 // It runs until the context is cancelled or a permanent auth error occurs.
 func (a *Agent) jwtRefreshLoop(ctx context.Context) {
@@ -1606,7 +2542,7 @@ func (a *Agent) createTUNDevice() (tun.Device, error) {
 		return tunDev, nil
 	}
 
-	tunDev, err := a.deps.TUN.CreateTUN(tunnel.DefaultTUNName, tunnel.DefaultMTU)
+	tunDev, err := a.deps.TUN.CreateTUN(tunnel.NetworkTUNName(a.opts.network), tunnel.DefaultMTU)
 	if err != nil {
 		return nil, fmt.Errorf("creating TUN device: %w", err)
 	}
@@ -1642,15 +2578,33 @@ func (a *Agent) configureTUN(ifName string) error {
 		return fmt.Errorf("adding address to %s: %w", ifName, err)
 	}
 
+	// On dual-stack networks, also assign the IPv6 ULA address. Failure is
+	// non-fatal (IPv6 may be disabled on this host); IPv4 keeps working.
+	if addr6 := a.cfg.Device.Address6; addr6 != "" {
+		if err := validateAddress6(addr6); err != nil {
+			return err
+		}
+		if err := a.deps.Network.AddAddress(ifName, addr6); err != nil {
+			a.log.Warn("adding IPv6 address (continuing IPv4-only)",
+				"name", ifName, "address6", addr6, "error", err)
+		}
+	}
+
 	if err := a.deps.Network.SetLinkUp(ifName); err != nil {
 		return fmt.Errorf("bringing up %s: %w", ifName, err)
 	}
 
-	a.log.Info("TUN interface configured", "name", ifName, "address", addr)
+	a.log.Info("TUN interface configured", "name", ifName, "address", addr, "address6", a.cfg.Device.Address6)
 
-	// If this device advertises routes (e.g., 192.168.1.0/24), set up IP
-	// forwarding and NAT so remote peers can reach devices on those subnets.
-	if len(a.cfg.Device.Routes) > 0 {
+	// If this device advertises routes (e.g., 192.168.1.0/24) or publishes
+	// LAN services, set up IP forwarding and NAT so remote peers can reach
+	// devices on those subnets.
+	routes := a.advertisedRoutes()
+	forwards := len(routes) > 0 || len(a.cfg.Device.PortForwards) > 0
+	if forwards && a.opts.userspace != nil {
+		a.log.Warn("advertised routes and port forwards are not forwarded in userspace mode",
+			"routes", routes, "port_forwards", len(a.cfg.Device.PortForwards))
+	} else if forwards {
 		if err := a.setupForwardingAndNAT(ifName); err != nil {
 			a.log.Warn("failed to set up forwarding/NAT (subnet routing may not work for remote peers)",
 				"error", err)
@@ -1674,49 +2628,90 @@ func (a *Agent) setupForwardingAndNAT(tunIface string) error {
 	if a.deps.NAT != nil {
 		a.natManager = a.deps.NAT
 	} else {
-		a.natManager = tunnel.NewNATManager(a.log)
+		a.natManager = tunnel.NewNATManager(a.log, a.opts.network)
 	}
+	a.journalMasquerades()
 
-	// For each advertised route, find the outgoing interface and set up
-	// forwarding + masquerade.
-	for _, route := range a.cfg.Device.Routes {
-		if !isValidRoute(route) {
-			a.log.Warn("skipping invalid route for forwarding setup", "route", route)
+	// For each advertised route and port forward target, find the outgoing
+	// interface and set up forwarding + masquerade.
+	for _, route := range a.forwardedSubnets() {
+		entry, ok := a.forwardRoute(route)
+		if !ok {
 			continue
 		}
 
-		outIface, err := a.deps.Network.FindInterfaceForSubnet(route)
-		if err != nil {
-			a.log.Warn("cannot find outgoing interface for route (masquerade not set up)",
-				"route", route, "error", err)
-			continue
-		}
-
-		// Enable forwarding on the outgoing interface.
-		if err := a.enableForwarding(outIface); err != nil {
-			a.log.Warn("enabling forwarding on outgoing interface",
-				"interface", outIface, "route", route, "error", err)
+		// Set up masquerade: traffic from the WireGuard subnet going out
+		// through the LAN interface gets source NAT'd. Several routes or
+		// port forwards may share one interface; one rule covers them all.
+		if slices.Contains(a.masqueradeRules, entry) {
 			continue
 		}
-
-		// Set up masquerade: traffic from the WireGuard subnet going out
-		// through the LAN interface gets source NAT'd.
-		if err := a.natManager.SetupMasquerade(a.cfg.Device.Address, outIface); err != nil {
-			return fmt.Errorf("setting up masquerade for %s via %s: %w", route, outIface, err)
+		if err := a.natManager.SetupMasquerade(entry.wgSubnet, entry.outIface); err != nil {
+			return fmt.Errorf("setting up masquerade for %s via %s: %w", route, entry.outIface, err)
 		}
 
 		// Record the masquerade rule so the watchdog can re-apply it if
 		// an external process (e.g. NetworkManager) flushes nftables.
-		a.masqueradeRules = append(a.masqueradeRules, masqueradeEntry{
-			wgSubnet: a.cfg.Device.Address,
-			outIface: outIface,
-		})
+		a.masqueradeRules = append(a.masqueradeRules, entry)
+		a.journalMasquerades()
 
 		a.log.Info("forwarding and NAT configured for route",
-			"route", route, "out_iface", outIface, "tun_iface", tunIface)
+			"route", route, "out_iface", entry.outIface, "tun_iface", tunIface)
 	}
 
-	return nil
+	// Clamp the MSS of TCP connections forwarded into the tunnel, so hosts
+	// on the LAN send segments that fit the path to each peer instead of
+	// relying on path MTU discovery, which firewalls often break.
+	if err := a.natManager.SetMSSClamp(tunIface, tunnel.DefaultMTU); err != nil {
+		a.log.Warn("setting up MSS clamping (large TCP transfers may stall)", "error", err)
+	} else {
+		a.mssClamp = true
+	}
+
+	return a.applyPortForwards(tunIface)
+}
+
+// forwardRoute enables forwarding towards a forwarded subnet: on the
+// interface it is reached through and, for IPv6, globally. It returns the
+// masquerade rule the subnet needs, or false (after logging why) if it
+// cannot be forwarded.
+func (a *Agent) forwardRoute(route string) (masqueradeEntry, bool) {
+	if !isValidRoute(route) {
+		a.log.Warn("skipping invalid route for forwarding setup", "route", route)
+		return masqueradeEntry{}, false
+	}
+
+	// IPv6 routes are masqueraded from the tunnel's IPv6 subnet and
+	// need the global IPv6 forwarding switch.
+	wgSubnet := a.cfg.Device.Address
+	if isIPv6Route(route) {
+		wgSubnet = a.cfg.Device.Address6
+		if wgSubnet == "" {
+			a.log.Warn("cannot forward IPv6 route without an IPv6 tunnel address (device.address6)",
+				"route", route)
+			return masqueradeEntry{}, false
+		}
+		if err := a.enableIPv6Forwarding(); err != nil {
+			a.log.Warn("enabling IPv6 forwarding", "route", route, "error", err)
+			return masqueradeEntry{}, false
+		}
+	}
+
+	outIface, err := a.deps.Network.FindInterfaceForSubnet(route)
+	if err != nil {
+		a.log.Warn("cannot find outgoing interface for route (masquerade not set up)",
+			"route", route, "error", err)
+		return masqueradeEntry{}, false
+	}
+
+	// Enable forwarding on the outgoing interface.
+	if err := a.enableForwarding(outIface); err != nil {
+		a.log.Warn("enabling forwarding on outgoing interface",
+			"interface", outIface, "route", route, "error", err)
+		return masqueradeEntry{}, false
+	}
+
+	return masqueradeEntry{wgSubnet: wgSubnet, outIface: outIface}, true
 }
 
 // enableForwarding enables IPv4 forwarding on an interface, saving the previous
@@ -1724,7 +2719,7 @@ func (a *Agent) setupForwardingAndNAT(tunIface string) error {
 func (a *Agent) enableForwarding(ifName string) error {
 	// Check if we already saved state for this interface (avoid duplicates).
 	for _, s := range a.forwardingState {
-		if s.ifName == ifName {
+		if !s.ipv6 && s.ifName == ifName {
 			return nil // already handled
 		}
 	}
@@ -1735,15 +2730,14 @@ func (a *Agent) enableForwarding(ifName string) error {
 		return fmt.Errorf("reading forwarding state for %s: %w", ifName, err)
 	}
 
-	a.forwardingState = append(a.forwardingState, forwardingSave{
-		ifName:          ifName,
-		previousEnabled: wasEnabled,
-	})
+	save := forwardingSave{ifName: ifName, previousEnabled: wasEnabled}
+	a.forwardingState = append(a.forwardingState, save)
 
 	if wasEnabled {
 		a.log.Debug("forwarding already enabled", "interface", ifName)
 		return nil
 	}
+	a.journalForwardingEnabled(save)
 
 	if err := a.deps.Network.SetForwarding(ifName, true); err != nil {
 		return fmt.Errorf("enabling forwarding on %s: %w", ifName, err)
@@ -1753,85 +2747,191 @@ func (a *Agent) enableForwarding(ifName string) error {
 	return nil
 }
 
+// enableIPv6Forwarding enables global IPv6 forwarding, saving the previous
+// state so it can be restored on shutdown.
+func (a *Agent) enableIPv6Forwarding() error {
+	for _, s := range a.forwardingState {
+		if s.ipv6 {
+			return nil // already handled
+		}
+	}
+
+	wasEnabled, err := a.deps.Network.GetIPv6Forwarding()
+	if err != nil {
+		return fmt.Errorf("reading IPv6 forwarding state: %w", err)
+	}
+
+	save := forwardingSave{ipv6: true, previousEnabled: wasEnabled}
+	a.forwardingState = append(a.forwardingState, save)
+
+	if wasEnabled {
+		a.log.Debug("IPv6 forwarding already enabled")
+		return nil
+	}
+	a.journalForwardingEnabled(save)
+
+	if err := a.deps.Network.SetIPv6Forwarding(true); err != nil {
+		return fmt.Errorf("enabling IPv6 forwarding: %w", err)
+	}
+
+	a.log.Info("enabled IPv6 forwarding")
+	return nil
+}
+
+// getForwarding reads the forwarding state recorded by s.
+func (a *Agent) getForwarding(s forwardingSave) (bool, error) {
+	if s.ipv6 {
+		return a.deps.Network.GetIPv6Forwarding()
+	}
+	return a.deps.Network.GetForwarding(s.ifName)
+}
+
+// setForwarding changes the forwarding state recorded by s.
+func (a *Agent) setForwarding(s forwardingSave, enabled bool) error {
+	if s.ipv6 {
+		return a.deps.Network.SetIPv6Forwarding(enabled)
+	}
+	return a.deps.Network.SetForwarding(s.ifName, enabled)
+}
+
+// label names a forwardingSave entry for logging.
+func (s forwardingSave) label() string {
+	if s.ipv6 {
+		return "ipv6/all"
+	}
+	return s.ifName
+}
+
 // cleanupForwardingAndNAT restores forwarding state and removes nftables rules.
 func (a *Agent) cleanupForwardingAndNAT() {
-	// Restore forwarding state for all modified interfaces.
+	a.natMu.Lock()
+	defer a.natMu.Unlock()
+
+	// Restore forwarding state for all modified interfaces. Forwarding
+	// that cannot be restored stays in the journal.
+	var failed []journalForwarding
 	for _, s := range a.forwardingState {
 		if s.previousEnabled {
 			continue // was already enabled, don't disable
 		}
-		if err := a.deps.Network.SetForwarding(s.ifName, false); err != nil {
+		if err := a.setForwarding(s, false); err != nil {
 			a.log.Warn("restoring forwarding state",
-				"interface", s.ifName, "error", err)
+				"interface", s.label(), "error", err)
+			failed = append(failed, journalForwarding{Interface: s.ifName, IPv6: s.ipv6})
 		} else {
-			a.log.Info("restored IPv4 forwarding state",
-				"interface", s.ifName, "forwarding", false)
+			a.log.Info("restored forwarding state",
+				"interface", s.label(), "forwarding", false)
 		}
 	}
 	a.forwardingState = nil
+	a.journal.update(func(st *journalState) { st.Forwarding = failed })
 
 	// Remove nftables rules.
 	if a.natManager != nil {
 		if err := a.natManager.Cleanup(); err != nil {
 			a.log.Warn("cleaning up nftables rules", "error", err)
+		} else {
+			a.journal.update(func(st *journalState) {
+				st.NAT = false
+				st.Masquerade = nil
+			})
 		}
 		a.natManager = nil
 	}
+	a.mssClamp = false
 }
 
-// startForwardingWatchdog launches a background goroutine that periodically
-// verifies IP forwarding and NAT masquerade rules are still in place.
-// NetworkManager and similar tools can reset per-interface forwarding sysctl
-// on DHCP renewal, wifi reconnect, or suspend/resume. The watchdog detects
-// and re-applies these settings.
+// startForwardingWatchdog launches a background goroutine that verifies IP
+// forwarding and NAT masquerade rules are still in place. NetworkManager
+// and similar tools can reset per-interface forwarding sysctl on DHCP
+// renewal, wifi reconnect, or suspend/resume, and firewall reloads can
+// flush the bamgate nftables table. The watchdog detects and re-applies
+// these settings.
+//
+// Where the platform reports network changes (rtnetlink and nftables on
+// Linux), the check runs as soon as forwarding flips or the table is
+// deleted, and polling is only a slow fallback. Elsewhere it polls every
+// forwardingCheckInterval.
 //
 // The goroutine is owned by Run() and exits when ctx is cancelled.
 func (a *Agent) startForwardingWatchdog(ctx context.Context) {
-	ticker := time.NewTicker(forwardingCheckInterval)
+	if a.watchdogStarted.Swap(true) {
+		return
+	}
+	interval := forwardingFallbackInterval
+	events, err := a.deps.Network.WatchNetwork(ctx)
+	if err != nil {
+		a.log.Debug("network change notifications unavailable, polling", "error", err)
+		interval = forwardingCheckInterval
+	}
+	ticker := time.NewTicker(interval)
 	go func() {
 		defer ticker.Stop()
+		var settle <-chan time.Time
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
 				a.checkAndRepairForwarding()
+			case ev, ok := <-events:
+				if !ok {
+					a.log.Warn("forwarding watchdog: network change notifications stopped, polling",
+						"interval", forwardingCheckInterval)
+					events = nil
+					ticker.Reset(forwardingCheckInterval)
+					continue
+				}
+				if settle == nil {
+					a.log.Debug("forwarding watchdog: network changed, checking", "event", ev.Kind)
+					settle = time.After(forwardingEventDelay)
+				}
+			case <-settle:
+				settle = nil
+				a.checkAndRepairForwarding()
 			}
 		}
 	}()
-	a.log.Debug("forwarding watchdog started", "interval", forwardingCheckInterval)
+	a.log.Debug("forwarding watchdog started", "event_driven", events != nil, "interval", interval)
 }
 
 // checkAndRepairForwarding verifies that IP forwarding is still enabled on
 // all interfaces the agent configured, and that nftables masquerade rules
 // are still present. Re-applies any settings that were reset externally.
 func (a *Agent) checkAndRepairForwarding() {
+	// Routes discovered with advertise_routes = "auto" change the
+	// forwarding state and rules at runtime.
+	a.natMu.Lock()
+	defer a.natMu.Unlock()
+
 	// Check per-interface forwarding.
 	for _, s := range a.forwardingState {
 		if s.previousEnabled {
 			continue // Was already enabled before we started; not our responsibility.
 		}
-		enabled, err := a.deps.Network.GetForwarding(s.ifName)
+		enabled, err := a.getForwarding(s)
 		if err != nil {
 			a.log.Warn("forwarding watchdog: cannot read forwarding state",
-				"interface", s.ifName, "error", err)
+				"interface", s.label(), "error", err)
 			continue
 		}
 		if !enabled {
 			a.log.Warn("forwarding watchdog: forwarding was disabled externally, re-enabling",
-				"interface", s.ifName)
-			if err := a.deps.Network.SetForwarding(s.ifName, true); err != nil {
+				"interface", s.label())
+			if err := a.setForwarding(s, true); err != nil {
 				a.log.Error("forwarding watchdog: failed to re-enable forwarding",
-					"interface", s.ifName, "error", err)
+					"interface", s.label(), "error", err)
 			} else {
-				a.log.Info("forwarding watchdog: re-enabled IPv4 forwarding",
-					"interface", s.ifName)
+				a.log.Info("forwarding watchdog: re-enabled forwarding",
+					"interface", s.label())
+				a.forwardingRepairs.Add(1)
+				a.events.Publish(control.Event{Type: control.EventForwardingRepaired, Interface: s.label()})
 			}
 		}
 	}
 
-	// Check nftables masquerade rules.
-	if a.natManager != nil && len(a.masqueradeRules) > 0 {
+	// Check nftables masquerade, netmap and port forward rules.
+	if a.natManager != nil && (len(a.masqueradeRules) > 0 || len(a.netmapRules) > 0 || len(a.portForwardRules) > 0) {
 		if !a.natManager.TableExists() {
 			a.log.Warn("forwarding watchdog: nftables bamgate table was removed, re-applying masquerade rules")
 			for _, rule := range a.masqueradeRules {
@@ -1843,6 +2943,23 @@ func (a *Agent) checkAndRepairForwarding() {
 						"subnet", rule.wgSubnet, "out_iface", rule.outIface)
 				}
 			}
+			if len(a.netmapRules) > 0 {
+				if err := a.natManager.SetNetmaps(a.tunName, a.netmapRules); err != nil {
+					a.log.Error("forwarding watchdog: failed to re-apply netmap rules", "error", err)
+				}
+			}
+			if len(a.portForwardRules) > 0 {
+				if err := a.natManager.SetPortForwards(a.tunName, a.portForwardRules); err != nil {
+					a.log.Error("forwarding watchdog: failed to re-apply port forward rules", "error", err)
+				}
+			}
+			if a.mssClamp {
+				if err := a.natManager.SetMSSClamp(a.tunName, tunnel.DefaultMTU); err != nil {
+					a.log.Error("forwarding watchdog: failed to re-apply MSS clamping", "error", err)
+				}
+			}
+			a.forwardingRepairs.Add(1)
+			a.events.Publish(control.Event{Type: control.EventForwardingRepaired, Interface: a.tunName})
 		}
 	}
 }
@@ -1855,6 +2972,31 @@ var dangerousRoutes = map[string]bool{
 	"::/0":      true,
 }
 
+// isIPv6Route reports whether route is an IPv6 CIDR.
+func isIPv6Route(route string) bool {
+	ip, _, err := net.ParseCIDR(route)
+	return err == nil && ip.To4() == nil
+}
+
+// validateAddress6 checks that the configured IPv6 tunnel address is an
+// IPv6 CIDR.
+func validateAddress6(cidr string) error {
+	if !isIPv6Route(cidr) {
+		return fmt.Errorf("invalid device IPv6 address %q: must be an IPv6 CIDR", cidr)
+	}
+	return nil
+}
+
+// peerAddress6 returns the IPv6 tunnel address a peer advertises in its
+// metadata, or nil if it has none (IPv4-only or an older client).
+func peerAddress6(metadata map[string]string) net.IP {
+	ip, _, err := net.ParseCIDR(metadata[protocol.MetaKeyAddress6])
+	if err != nil || ip.To4() != nil {
+		return nil
+	}
+	return ip
+}
+
 // isValidRoute checks that a route string is a valid CIDR and not a
 // dangerous catch-all route.
 func isValidRoute(route string) bool {
@@ -1,16 +1,26 @@
 package agent
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/pion/webrtc/v4"
 
+	"github.com/kuuji/bamgate/internal/bridge"
 	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/policy"
 	"github.com/kuuji/bamgate/internal/signaling"
 	"github.com/kuuji/bamgate/internal/tunnel"
 	"github.com/kuuji/bamgate/pkg/protocol"
@@ -186,6 +196,8 @@ func TestAgent_PeerLeft_Cleanup(t *testing.T) {
 
 	agentA := New(cfgA, nil, WithDeps(depsA))
 	agentB := New(cfgB, nil, WithDeps(depsB))
+	events, unsubscribe := agentA.SubscribeEvents()
+	defer unsubscribe()
 
 	ctxA, cancelA := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancelA()
@@ -225,6 +237,19 @@ func TestAgent_PeerLeft_Cleanup(t *testing.T) {
 		t.Errorf("alpha has %d peers after bravo left, want 0", peerCount)
 	}
 
+	// Alpha reported bravo's lifecycle, in order, to event subscribers.
+	want := []control.EventType{control.EventPeerDiscovered, control.EventDataChannelOpen, control.EventPeerRemoved}
+	var got []control.EventType
+	for len(events) > 0 {
+		ev := <-events
+		if ev.Peer == "bravo" && slices.Contains(want, ev.Type) {
+			got = append(got, ev.Type)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("alpha's events for bravo = %v, want %v", got, want)
+	}
+
 	_ = pubKeyA // used for symmetry verification in other tests
 
 	cancelA()
@@ -312,6 +337,223 @@ func TestAgent_ThreePeers(t *testing.T) {
 	}
 }
 
+// TestAgent_HubAndSpoke verifies that spokes connect only to the hub and
+// send the tunnel subnet through it, and that the hub forwards between
+// them.
+func TestAgent_HubAndSpoke(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	configs := []*config.Config{
+		testConfig("alpha", "10.0.0.1/24", wsURL),
+		testConfig("bravo", "10.0.0.2/24", wsURL),
+		testConfig("charlie", "10.0.0.3/24", wsURL),
+	}
+	configs[0].Device.Role = "hub"
+	configs[1].Device.Role = "spoke"
+	configs[2].Device.Role = "spoke"
+
+	agents := make([]*Agent, 3)
+	fakes := make([]*testFakes, 3)
+	errChs := make([]chan error, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	for i, cfg := range configs {
+		deps, f := newTestDeps()
+		deps.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+			return signaling.NewClient(cfg)
+		}
+		agents[i] = New(cfg, nil, WithDeps(deps))
+		fakes[i] = f
+		errChs[i] = make(chan error, 1)
+		ag := agents[i]
+		ch := errChs[i]
+		go func() { ch <- ag.Run(ctx) }()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	pubHub := config.PublicKey(configs[0].Device.PrivateKey).String()
+	for _, i := range []int{1, 2} {
+		pubSpoke := config.PublicKey(configs[i].Device.PrivateKey).String()
+		waitFor(t, 15*time.Second, "hub has spoke "+configs[i].Device.Name, func() bool {
+			dev := fakes[0].WireGuard.getDevice()
+			return dev != nil && dev.hasPeer(pubSpoke)
+		})
+		waitFor(t, 15*time.Second, configs[i].Device.Name+" sends the tunnel subnet to the hub", func() bool {
+			dev := fakes[i].WireGuard.getDevice()
+			if dev == nil {
+				return false
+			}
+			dev.mu.Lock()
+			defer dev.mu.Unlock()
+			return slices.Equal(dev.peers[pubHub].AllowedIPs, []string{"10.0.0.1/32", "10.0.0.0/24"})
+		})
+	}
+
+	for i, ag := range agents {
+		st := ag.Status()
+		if want := configs[i].Device.Role; st.Role != want {
+			t.Errorf("%s: Status().Role = %q, want %q", st.Device, st.Role, want)
+		}
+		if i > 0 && (len(st.Peers) != 1 || st.Peers[0].ID != "alpha" || st.Peers[0].Role != "hub") {
+			t.Errorf("%s: peers = %+v, want only hub alpha", st.Device, st.Peers)
+		}
+	}
+	for _, i := range []int{1, 2} {
+		if n := fakes[i].WireGuard.getDevice().peerCount(); n != 1 {
+			t.Errorf("%s has %d WG peers, want 1", configs[i].Device.Name, n)
+		}
+		fakes[i].Network.mu.Lock()
+		routes := fakes[i].Network.routes[agents[i].tunName]
+		fakes[i].Network.mu.Unlock()
+		if len(routes) != 0 {
+			t.Errorf("%s added kernel routes %v; the tunnel subnet is routed by its address", configs[i].Device.Name, routes)
+		}
+	}
+	if forwarding, _ := fakes[0].Network.GetForwarding(agents[0].tunName); !forwarding {
+		t.Error("hub did not enable forwarding on its TUN interface")
+	}
+
+	cancel()
+	for i, ch := range errChs {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent %d error: %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("agent %d did not shut down", i)
+		}
+	}
+}
+
+// TestAgent_Relay verifies that peers whose direct connection fails reach
+// each other through a peer offering to relay, and go back to the direct
+// path once it connects again.
+func TestAgent_Relay(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	configs := []*config.Config{
+		testConfig("alpha", "10.0.0.1/24", wsURL),
+		testConfig("bravo", "10.0.0.2/24", wsURL),
+		testConfig("charlie", "10.0.0.3/24", wsURL),
+	}
+	configs[2].Device.Relay = true
+
+	agents := make([]*Agent, 3)
+	fakes := make([]*testFakes, 3)
+	errChs := make([]chan error, 3)
+	pubs := make([]string, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for i, cfg := range configs {
+		deps, f := newTestDeps()
+		deps.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+			return signaling.NewClient(cfg)
+		}
+		agents[i] = New(cfg, nil, WithDeps(deps))
+		fakes[i] = f
+		errChs[i] = make(chan error, 1)
+		pubs[i] = config.PublicKey(cfg.Device.PrivateKey).String()
+		ag := agents[i]
+		ch := errChs[i]
+		go func() { ch <- ag.Run(ctx) }()
+		time.Sleep(100 * time.Millisecond)
+	}
+	eventsA, unsubscribeA := agents[0].SubscribeEvents()
+	defer unsubscribeA()
+
+	// allowedIPs returns the AllowedIPs agent i has for agent j.
+	allowedIPs := func(i, j int) []string {
+		dev := fakes[i].WireGuard.getDevice()
+		if dev == nil {
+			return nil
+		}
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if p, ok := dev.peers[pubs[j]]; ok {
+			return slices.Clone(p.AllowedIPs)
+		}
+		return nil
+	}
+	for i := range agents {
+		waitFor(t, 15*time.Second, configs[i].Device.Name+" connected to both peers", func() bool {
+			dev := fakes[i].WireGuard.getDevice()
+			return dev != nil && dev.peerCount() == 2
+		})
+	}
+
+	// Alpha and bravo give up on their direct connection, as they would
+	// after the last ICE restart failed.
+	for _, p := range [][2]int{{0, 1}, {1, 0}} {
+		ag, peer := agents[p[0]], configs[p[1]].Device.Name
+		ag.mu.Lock()
+		ag.peers[peer].iceRestarts = maxICERestarts
+		ag.mu.Unlock()
+		ag.attemptICERestart(ctx, peer)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for relayed := false; !relayed; {
+		select {
+		case ev := <-eventsA:
+			relayed = ev.Type == control.EventPeerRelayed && ev.Peer == "bravo" && ev.Relay == "charlie"
+		case <-timeout:
+			t.Fatal("alpha: no peer_relayed event for bravo via charlie")
+		}
+	}
+	waitFor(t, 5*time.Second, "bravo relays alpha through charlie", func() bool {
+		return slices.Equal(allowedIPs(1, 2), []string{"10.0.0.3/32", "10.0.0.1/32"})
+	})
+	if got := allowedIPs(0, 2); !slices.Equal(got, []string{"10.0.0.3/32", "10.0.0.2/32"}) {
+		t.Errorf("alpha AllowedIPs for charlie = %v, want bravo's address added", got)
+	}
+	if allowedIPs(0, 1) != nil {
+		t.Error("alpha still has bravo as a WireGuard peer")
+	}
+	st := agents[0].Status()
+	if i := slices.IndexFunc(st.Peers, func(p control.PeerStatus) bool { return p.ID == "bravo" }); i < 0 ||
+		st.Peers[i].State != "relayed" || st.Peers[i].Relay != "charlie" || st.Peers[i].Address != "10.0.0.2/24" {
+		t.Errorf("alpha status peers = %+v, want bravo relayed via charlie", st.Peers)
+	}
+	if forwarding, _ := fakes[2].Network.GetForwarding(agents[2].tunName); !forwarding {
+		t.Error("relay did not enable forwarding on its TUN interface")
+	}
+
+	// The retry connects directly and takes bravo off the relay.
+	agents[0].retryRelayedPeers(ctx)
+	for _, p := range [][2]int{{0, 1}, {1, 0}} {
+		waitFor(t, 15*time.Second, configs[p[0]].Device.Name+" connected directly again", func() bool {
+			return slices.Equal(allowedIPs(p[0], p[1]), []string{configs[p[1]].Device.Address[:8] + "/32"}) &&
+				slices.Equal(allowedIPs(p[0], 2), []string{"10.0.0.3/32"})
+		})
+	}
+	for _, p := range agents[0].Status().Peers {
+		if p.Relay != "" {
+			t.Errorf("peer %s still relayed via %s", p.ID, p.Relay)
+		}
+	}
+
+	cancel()
+	for i, ch := range errChs {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent %d error: %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("agent %d did not shut down", i)
+		}
+	}
+}
+
 // TestAgent_TokenRefresh verifies that the agent calls the auth refresher
 // on startup when OAuth credentials are configured.
 func TestAgent_TokenRefresh(t *testing.T) {
@@ -383,7 +625,7 @@ func TestAgent_RoutesAccepted(t *testing.T) {
 	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
 	cfgB.Device.AcceptRoutes = true //nolint:staticcheck // testing legacy backward compat
 
-	depsA, _ := newTestDeps()
+	depsA, fakesA := newTestDeps()
 	depsB, fakesB := newTestDeps()
 
 	// alpha has a LAN subnet, so its setupForwardingAndNAT will be called.
@@ -419,6 +661,28 @@ func TestAgent_RoutesAccepted(t *testing.T) {
 		return false
 	})
 
+	// The route is sized for the path to alpha: a host candidate pair on
+	// loopback, limited by the data channel's SCTP packet size.
+	wantMTU := tunnel.PathMTU(tunnel.DefaultMTU, tunnel.Path{LinkMTU: 65536})
+	waitFor(t, 5*time.Second, "route MTU set for alpha's path", func() bool {
+		fakesB.Network.mu.Lock()
+		defer fakesB.Network.mu.Unlock()
+		return fakesB.Network.routeMTUs["192.168.1.0/24"] == wantMTU
+	})
+	for _, p := range agentB.Status().Peers {
+		if p.ID == "alpha" && p.MTU != wantMTU {
+			t.Errorf("Status() MTU for alpha = %d, want %d", p.MTU, wantMTU)
+		}
+	}
+
+	// alpha forwards its LAN, so it clamps the MSS into the tunnel.
+	fakesA.NAT.mu.Lock()
+	clamped := fakesA.NAT.mssClamp
+	fakesA.NAT.mu.Unlock()
+	if clamped != tunnel.DefaultTUNName {
+		t.Errorf("MSS clamp interface = %q, want %q", clamped, tunnel.DefaultTUNName)
+	}
+
 	cancel()
 	for _, ch := range []chan error{errChA, errChB} {
 		select {
@@ -432,22 +696,23 @@ func TestAgent_RoutesAccepted(t *testing.T) {
 	}
 }
 
-// TestAgent_GlareResolution verifies that when both peers send offers
-// simultaneously (possible during ICE restart), the glare is resolved
-// and exactly one connection survives.
-func TestAgent_GlareResolution(t *testing.T) {
+// TestAgent_RouteAdvertisementUpdate verifies that a peer with
+// advertise_routes = "auto" announces a changed LAN without reconnecting,
+// and that the receiving peer swaps the installed routes.
+func TestAgent_RouteAdvertisementUpdate(t *testing.T) {
 	t.Parallel()
 
 	_, _, wsURL := startTestHub(t)
 
-	// "alpha" < "bravo", so alpha is the preferred offerer.
-	// Both start simultaneously, and the hub sends peers lists to both.
-	// Alpha should offer, bravo should wait for the offer.
 	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Device.AdvertiseRoutes = "auto"
+
 	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+	cfgB.Device.AcceptRoutes = true //nolint:staticcheck // accept everything alpha advertises
 
 	depsA, fakesA := newTestDeps()
 	depsB, fakesB := newTestDeps()
+	fakesA.Network.setDiscovered(tunnel.SubnetInfo{CIDR: "192.168.1.0/24", Interface: "eth0"})
 
 	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
 		return signaling.NewClient(cfg)
@@ -467,32 +732,23 @@ func TestAgent_GlareResolution(t *testing.T) {
 	go func() { errChA <- agentA.Run(ctx) }()
 	go func() { errChB <- agentB.Run(ctx) }()
 
-	pubKeyA := config.PublicKey(cfgA.Device.PrivateKey).String()
-	pubKeyB := config.PublicKey(cfgB.Device.PrivateKey).String()
-
-	// Wait for both to be connected.
-	waitFor(t, 10*time.Second, "alpha has bravo's WG peer", func() bool {
-		return fakesA.WireGuard.getDevice() != nil && fakesA.WireGuard.getDevice().hasPeer(pubKeyB)
-	})
-	waitFor(t, 10*time.Second, "bravo has alpha's WG peer", func() bool {
-		return fakesB.WireGuard.getDevice() != nil && fakesB.WireGuard.getDevice().hasPeer(pubKeyA)
+	bravoRoutes := func() []string {
+		fakesB.Network.mu.Lock()
+		defer fakesB.Network.mu.Unlock()
+		return slices.Clone(fakesB.Network.routes[tunnel.DefaultTUNName])
+	}
+	waitFor(t, 10*time.Second, "bravo has alpha's discovered route", func() bool {
+		return slices.Contains(bravoRoutes(), "192.168.1.0/24")
 	})
 
-	// Each agent should have exactly 1 peer.
-	agentA.mu.Lock()
-	peersA := len(agentA.peers)
-	agentA.mu.Unlock()
-
-	agentB.mu.Lock()
-	peersB := len(agentB.peers)
-	agentB.mu.Unlock()
+	// alpha moves to another LAN.
+	fakesA.Network.setDiscovered(tunnel.SubnetInfo{CIDR: "192.168.2.0/24", Interface: "eth0"})
+	agentA.refreshAdvertisedRoutes(ctx)
 
-	if peersA != 1 {
-		t.Errorf("alpha has %d peers, want 1", peersA)
-	}
-	if peersB != 1 {
-		t.Errorf("bravo has %d peers, want 1", peersB)
-	}
+	waitFor(t, 5*time.Second, "bravo swaps to alpha's new route", func() bool {
+		routes := bravoRoutes()
+		return slices.Contains(routes, "192.168.2.0/24") && !slices.Contains(routes, "192.168.1.0/24")
+	})
 
 	cancel()
 	for _, ch := range []chan error{errChA, errChB} {
@@ -507,78 +763,95 @@ func TestAgent_GlareResolution(t *testing.T) {
 	}
 }
 
-// TestAgent_SelfSkip verifies that the agent ignores itself in the peers list.
-func TestAgent_SelfSkip(t *testing.T) {
+// TestAgent_RouteConflict_MoreSpecific verifies that an accepted route
+// overlapping a local subnet is installed only as its non-conflicting
+// more-specific parts, and that the conflict is reported in offerings.
+func TestAgent_RouteConflict_MoreSpecific(t *testing.T) {
 	t.Parallel()
 
 	_, _, wsURL := startTestHub(t)
 
-	cfg := testConfig("solo", "10.0.0.1/24", wsURL)
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Device.Routes = []string{"192.168.0.0/22"}
 
-	deps, fakes := newTestDeps()
-	deps.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+	cfgB.Device.RouteConflictPolicy = "more-specific"
+	cfgB.SetPeerSelection("alpha", config.PeerSelections{Routes: []string{"192.168.0.0/22"}})
+
+	depsA, _ := newTestDeps()
+	depsB, fakesB := newTestDeps()
+	// bravo sits on a LAN that overlaps alpha's advertised route.
+	fakesB.Network.localRoutes = []tunnel.SubnetInfo{{CIDR: "192.168.1.0/24", Interface: "wlan0"}}
+
+	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+	depsB.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
 		return signaling.NewClient(cfg)
 	}
 
-	ag := New(cfg, nil, WithDeps(deps))
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	errCh := make(chan error, 1)
-	go func() { errCh <- ag.Run(ctx) }()
-
-	// Wait a bit to ensure the agent doesn't crash or create peers to itself.
-	time.Sleep(1 * time.Second)
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctx) }()
 
-	ag.mu.Lock()
-	peerCount := len(ag.peers)
-	ag.mu.Unlock()
+	want := []string{"192.168.0.0/24", "192.168.2.0/23"}
+	waitFor(t, 10*time.Second, "bravo installs the non-conflicting routes", func() bool {
+		fakesB.Network.mu.Lock()
+		defer fakesB.Network.mu.Unlock()
+		return slices.Equal(fakesB.Network.routes[tunnel.DefaultTUNName], want)
+	})
 
-	if peerCount != 0 {
-		t.Errorf("agent has %d peers, want 0 (should not connect to itself)", peerCount)
+	offerings := agentB.PeerOfferings()
+	if len(offerings) != 1 {
+		t.Fatalf("PeerOfferings() returned %d entries, want 1", len(offerings))
 	}
-
-	_ = fakes // verify no WG peers were created
-	if fakes.WireGuard.getDevice() != nil && fakes.WireGuard.getDevice().peerCount() != 0 {
-		t.Errorf("WG device has %d peers, want 0", fakes.WireGuard.getDevice().peerCount())
+	o := offerings[0]
+	if len(o.Conflicts) != 1 || o.Conflicts[0].Route != "192.168.0.0/22" || o.Conflicts[0].LocalSubnet != "192.168.1.0/24" {
+		t.Errorf("Conflicts = %+v, want 192.168.0.0/22 vs 192.168.1.0/24", o.Conflicts)
+	}
+	if !slices.Equal(o.InstalledRoutes, want) {
+		t.Errorf("InstalledRoutes = %v, want %v", o.InstalledRoutes, want)
 	}
 
 	cancel()
-	select {
-	case err := <-errCh:
-		if !isShutdownError(err) {
-			t.Errorf("agent error: %v", err)
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
 		}
-	case <-time.After(5 * time.Second):
-		t.Error("agent did not shut down")
 	}
 }
 
-// --- connectedPair sets up two agents that are fully connected ---
-
-type connectedPair struct {
-	agentA, agentB   *Agent
-	fakesA, fakesB   *testFakes
-	pubKeyA, pubKeyB string
-	cancel           context.CancelFunc
-	errChA, errChB   chan error
-}
-
-// startConnectedPair starts two agents, waits for them to establish a WebRTC
-// connection and WireGuard peer configuration, then returns the pair for
-// further testing. Call pair.shutdown(t) when done.
-func startConnectedPair(t *testing.T) *connectedPair {
-	t.Helper()
+// TestAgent_RouteAlias_Netmap verifies that a route accepted through an
+// alias is installed as the alias on the accepting side, and that the
+// advertising side gets a NETMAP rule translating it back.
+func TestAgent_RouteAlias_Netmap(t *testing.T) {
+	t.Parallel()
 
 	_, _, wsURL := startTestHub(t)
 
 	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Device.Routes = []string{"192.168.1.0/24"}
+
 	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+	cfgB.SetPeerSelection("alpha", config.PeerSelections{
+		Routes:       []string{"192.168.1.0/24"},
+		RouteAliases: map[string]string{"192.168.1.0/24": "10.201.1.0/24"},
+	})
 
 	depsA, fakesA := newTestDeps()
 	depsB, fakesB := newTestDeps()
-
 	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
 		return signaling.NewClient(cfg)
 	}
@@ -589,30 +862,1062 @@ func startConnectedPair(t *testing.T) *connectedPair {
 	agentA := New(cfgA, nil, WithDeps(depsA))
 	agentB := New(cfgB, nil, WithDeps(depsB))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
 	errChA := make(chan error, 1)
 	errChB := make(chan error, 1)
 	go func() { errChA <- agentA.Run(ctx) }()
 	go func() { errChB <- agentB.Run(ctx) }()
 
-	pubKeyA := config.PublicKey(cfgA.Device.PrivateKey).String()
-	pubKeyB := config.PublicKey(cfgB.Device.PrivateKey).String()
-
-	// Wait for full connection.
-	waitFor(t, 10*time.Second, "bravo has alpha's WG peer", func() bool {
-		return fakesB.WireGuard.getDevice() != nil && fakesB.WireGuard.getDevice().hasPeer(pubKeyA)
+	waitFor(t, 10*time.Second, "bravo installs the alias route", func() bool {
+		fakesB.Network.mu.Lock()
+		defer fakesB.Network.mu.Unlock()
+		return slices.Equal(fakesB.Network.routes[tunnel.DefaultTUNName], []string{"10.201.1.0/24"})
 	})
-	waitFor(t, 10*time.Second, "alpha has bravo's WG peer", func() bool {
-		return fakesA.WireGuard.getDevice() != nil && fakesA.WireGuard.getDevice().hasPeer(pubKeyB)
+
+	want := []tunnel.NetmapRule{{Source: "10.0.0.2", Alias: "10.201.1.0/24", Route: "192.168.1.0/24"}}
+	waitFor(t, 10*time.Second, "alpha installs the netmap rule", func() bool {
+		fakesA.NAT.mu.Lock()
+		defer fakesA.NAT.mu.Unlock()
+		return slices.Equal(fakesA.NAT.netmaps, want)
 	})
 
-	return &connectedPair{
-		agentA: agentA, agentB: agentB,
-		fakesA: fakesA, fakesB: fakesB,
-		pubKeyA: pubKeyA, pubKeyB: pubKeyB,
-		cancel: cancel,
-		errChA: errChA, errChB: errChB,
+	cancel()
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_PortForwards verifies that a device publishing a LAN service
+// installs the DNAT rule and masquerade for it, and that peers see the
+// service in its advertised capabilities.
+func TestAgent_PortForwards(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Device.PortForwards = []config.PortForward{
+		{Name: "nas-https", Port: 8443, Target: "192.168.1.50:443"},
+	}
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+
+	depsA, fakesA := newTestDeps()
+	depsB, fakesB := newTestDeps()
+	fakesA.Network.subnets["192.168.1.50/32"] = "eth0"
+	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+	depsB.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctx) }()
+
+	waitFor(t, 10*time.Second, "bravo adds alpha as a WireGuard peer", func() bool {
+		dev := fakesB.WireGuard.getDevice()
+		return dev != nil && dev.peerCount() == 1
+	})
+	waitFor(t, 10*time.Second, "bravo sees alpha's published service", func() bool {
+		for _, o := range agentB.PeerOfferings() {
+			if o.PeerID == "alpha" && len(o.Advertised.Services) == 1 {
+				svc := o.Advertised.Services[0]
+				return svc.Name == "nas-https" && svc.Protocol == "tcp" && svc.Port == 8443
+			}
+		}
+		return false
+	})
+
+	fakesA.NAT.mu.Lock()
+	wantRules := []tunnel.PortForwardRule{{Protocol: "tcp", Address: "10.0.0.1", Port: 8443, Target: "192.168.1.50:443"}}
+	if !slices.Equal(fakesA.NAT.portForwards, wantRules) {
+		t.Errorf("port forward rules = %+v, want %+v", fakesA.NAT.portForwards, wantRules)
+	}
+	wantMasq := []masqueradeEntry{{wgSubnet: "10.0.0.1/24", outIface: "eth0"}}
+	if !slices.Equal(fakesA.NAT.rules, wantMasq) {
+		t.Errorf("masquerade rules = %+v, want %+v", fakesA.NAT.rules, wantMasq)
+	}
+	fakesA.NAT.mu.Unlock()
+
+	cancel()
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_ACL verifies that allow rules for a peer are attributed to its
+// tunnel address once it connects and reported in the status.
+func TestAgent_ACL(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Peers = map[string]config.PeerSelections{
+		"bravo": {Allow: []string{"10.0.0.1:22/tcp"}},
+	}
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+
+	depsA, fakesA := newTestDeps()
+	depsB, _ := newTestDeps()
+	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+	depsB.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctx) }()
+
+	waitFor(t, 10*time.Second, "alpha adds bravo as a WireGuard peer", func() bool {
+		dev := fakesA.WireGuard.getDevice()
+		return dev != nil && dev.peerCount() == 1
+	})
+
+	policy := agentA.aclFilter.Policy()
+	if policy == nil || len(policy.Peers) != 1 {
+		t.Fatalf("policy = %+v, want one peer", policy)
+	}
+	bravo := policy.Peers[0]
+	if bravo.Name != "bravo" || !slices.Contains(bravo.Sources, netip.MustParsePrefix("10.0.0.2/32")) || len(bravo.Rules) != 1 {
+		t.Errorf("bravo = %+v, want source 10.0.0.2/32 and one rule", bravo)
+	}
+
+	st := agentA.Status().ACL
+	if st == nil || st.Default != "allow" || len(st.Peers) != 1 || st.Peers[0].Allow[0] != "10.0.0.1:22/tcp" {
+		t.Errorf("Status().ACL = %+v, want bravo's rule", st)
+	}
+
+	cancel()
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_Capture verifies that a capture on alpha attributes packets to
+// bravo by its tunnel address and records the frames sent on bravo's data
+// channel, and that requests for unknown peers or with bad filters fail
+// before anything is written.
+func TestAgent_Capture(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+
+	depsA, fakesA := newTestDeps()
+	depsB, _ := newTestDeps()
+	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+	depsB.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctx) }()
+
+	waitFor(t, 10*time.Second, "alpha adds bravo as a WireGuard peer", func() bool {
+		dev := fakesA.WireGuard.getDevice()
+		return dev != nil && dev.peerCount() == 1
+	})
+
+	for _, req := range []control.CaptureRequest{{Peer: "charlie"}, {Filter: "port x"}} {
+		var out bytes.Buffer
+		if err := agentA.Capture(ctx, req, &out); err == nil || out.Len() > 0 {
+			t.Errorf("Capture(%+v) = %v with %d bytes, want an error and no output", req, err, out.Len())
+		}
+	}
+
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- agentA.Capture(ctx, control.CaptureRequest{Peer: "bravo", Encrypted: true, MaxPackets: 1}, &out)
+	}()
+	// Frames are only recorded once the session is registered, so keep
+	// sending until the capture ends.
+	waitFor(t, 5*time.Second, "capture records a frame sent to bravo", func() bool {
+		if err := agentA.bind.Send([][]byte{[]byte("wireguard frame")}, bridge.NewEndpoint("bravo")); err != nil {
+			t.Fatalf("sending frame to bravo: %v", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Capture() error: %v", err)
+			}
+			return true
+		default:
+			return false
+		}
+	})
+	if !bytes.Contains(out.Bytes(), []byte("bravo/wg")) || !bytes.Contains(out.Bytes(), []byte("wireguard frame")) {
+		t.Errorf("capture does not hold the frame on bravo's encrypted interface")
+	}
+
+	if got := agentA.captureTap.PeerFor(netip.MustParseAddr("10.0.0.2")); got != "bravo" {
+		t.Errorf("packets from 10.0.0.2 attributed to %q, want bravo", got)
+	}
+
+	cancel()
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_Metrics verifies that the agent exports per-peer ICE state,
+// traffic and handshake age, served on the metrics_listen address.
+func TestAgent_Metrics(t *testing.T) {
+	t.Parallel()
+
+	// Reserve a free port for alpha's metrics listener.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsAddr := ln.Addr().String()
+	ln.Close()
+
+	pair := startConnectedPair(t, pairConfig{
+		configure: func(cfgA, _ *config.Config) { cfgA.Device.MetricsListen = metricsAddr },
+	})
+	defer pair.cancel()
+	pubKeyB := config.PublicKey(pair.cfgB.Device.PrivateKey)
+	dev := pair.fakesA.WireGuard.getDevice()
+	dev.mu.Lock()
+	dev.handshakes = map[config.Key]time.Time{pubKeyB: time.Now().Add(-30 * time.Second)}
+	dev.mu.Unlock()
+
+	scrape := func() string {
+		resp, err := http.Get("http://" + metricsAddr + "/metrics")
+		if err != nil {
+			t.Fatalf("scraping metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("scraping metrics: %d %v", resp.StatusCode, err)
+		}
+		return string(body)
+	}
+	var out string
+	waitFor(t, 5*time.Second, "bravo's ICE state exported as connected", func() bool {
+		out = scrape()
+		return strings.Contains(out, `bamgate_peer_ice_state{peer="bravo",state="connected"} 1`)
+	})
+
+	for _, want := range []string{
+		"\nbamgate_peers 1\n",
+		`bamgate_peer_ice_state{peer="bravo",state="failed"} 0`,
+		`bamgate_peer_candidate_type{peer="bravo",type="host"} 1`,
+		`bamgate_peer_transmit_bytes_total{peer="bravo"} `,
+		`bamgate_peer_receive_packets_total{peer="bravo"} `,
+		`bamgate_peer_handshake_age_seconds{peer="bravo"} 3`,
+		`bamgate_peer_ice_restarts_total{peer="bravo"} 0`,
+		"\nbamgate_signaling_reconnects_total 0\n",
+		"\nbamgate_jwt_refresh_failures_total 0\n",
+		"\nbamgate_forwarding_repairs_total 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+
+	pair.shutdown(t)
+
+	// The listener goes away with the agent.
+	if _, err := http.Get("http://" + metricsAddr + "/metrics"); err == nil {
+		t.Error("metrics listener still serving after shutdown")
+	}
+}
+
+// TestAgent_PeerActions verifies the control-plane peer actions: an ICE
+// restart keeps the connection, a reconnect from the answering side
+// rebuilds it on both agents, and a blocked peer stays disconnected until
+// it is unblocked.
+func TestAgent_PeerActions(t *testing.T) {
+	t.Parallel()
+
+	pair := startConnectedPair(t, pairConfig{})
+	defer pair.shutdown(t)
+	agentA, agentB := pair.agentA, pair.agentB
+	eventsA, eventsB := pair.eventsA, pair.eventsB
+
+	expectEvent(t, eventsA, "alpha", control.EventDataChannelOpen, "bravo")
+	expectEvent(t, eventsB, "bravo", control.EventDataChannelOpen, "alpha")
+
+	if err := agentA.RestartICE("bravo"); err != nil {
+		t.Fatalf("RestartICE(bravo) error: %v", err)
+	}
+	expectEvent(t, eventsA, "alpha", control.EventICERestart, "bravo")
+	if err := agentA.RestartICE("charlie"); err == nil {
+		t.Error("RestartICE(charlie) succeeded for an unknown peer")
+	}
+
+	// Bravo answers alpha's offers, but can still rebuild the connection.
+	if err := agentB.ReconnectPeer("alpha"); err != nil {
+		t.Fatalf("ReconnectPeer(alpha) error: %v", err)
+	}
+	expectEvent(t, eventsA, "alpha", control.EventPeerRemoved, "bravo")
+	expectEvent(t, eventsA, "alpha", control.EventDataChannelOpen, "bravo")
+	expectEvent(t, eventsB, "bravo", control.EventDataChannelOpen, "alpha")
+
+	if err := agentA.BlockPeer("bravo"); err != nil {
+		t.Fatalf("BlockPeer(bravo) error: %v", err)
+	}
+	if pair.fakesA.WireGuard.getDevice().hasPeer(pair.pubKeyB) {
+		t.Error("alpha still has bravo's WG peer after blocking it")
+	}
+	if got := agentA.Status().Blocked; !slices.Equal(got, []string{"bravo"}) {
+		t.Errorf("Status().Blocked = %v, want [bravo]", got)
+	}
+	if err := agentA.ReconnectPeer("bravo"); err == nil {
+		t.Error("ReconnectPeer(bravo) succeeded for a blocked peer")
+	}
+
+	if err := agentA.UnblockPeer("bravo"); err != nil {
+		t.Fatalf("UnblockPeer(bravo) error: %v", err)
+	}
+	expectEvent(t, eventsA, "alpha", control.EventDataChannelOpen, "bravo")
+	waitFor(t, 5*time.Second, "alpha has bravo's WG peer again", func() bool {
+		return pair.fakesA.WireGuard.getDevice().hasPeer(pair.pubKeyB)
+	})
+	if len(pair.cfgA.Device.BlockedPeers) != 0 {
+		t.Errorf("BlockedPeers = %v after unblocking, want none", pair.cfgA.Device.BlockedPeers)
+	}
+	if err := agentA.UnblockPeer("bravo"); err == nil {
+		t.Error("UnblockPeer(bravo) succeeded for a peer that is not blocked")
+	}
+}
+
+// TestAgent_OnDemand verifies that on-demand peers are added to WireGuard
+// without connecting, that a packet for one connects both sides even from
+// the side that would not offer, and that idle connections are closed
+// again while the peer stays in WireGuard.
+func TestAgent_OnDemand(t *testing.T) {
+	t.Parallel()
+
+	pair := startConnectedPair(t, pairConfig{
+		configure: func(cfgA, cfgB *config.Config) {
+			for _, cfg := range []*config.Config{cfgA, cfgB} {
+				cfg.Device.OnDemand = true
+				cfg.Device.IdleTimeout = "1s"
+			}
+		},
+	})
+	defer pair.shutdown(t)
+	agentA, agentB := pair.agentA, pair.agentB
+	eventsA, eventsB := pair.eventsA, pair.eventsB
+
+	peerState := func(a *Agent, peer string) string {
+		for _, p := range a.Status().Peers {
+			if p.ID == peer {
+				return p.State
+			}
+		}
+		return ""
+	}
+
+	expectEvent(t, eventsA, "alpha", control.EventPeerDiscovered, "bravo")
+	expectEvent(t, eventsB, "bravo", control.EventPeerDiscovered, "alpha")
+	waitFor(t, 10*time.Second, "alpha and bravo register each other without connecting", func() bool {
+		return peerState(agentA, "bravo") == "idle" && peerState(agentB, "alpha") == "idle"
+	})
+	if !pair.fakesA.WireGuard.getDevice().hasPeer(pair.pubKeyB) {
+		t.Error("alpha has no WG peer for idle bravo")
+	}
+
+	// Bravo would normally wait for alpha's offer; its traffic connects
+	// anyway.
+	if err := agentB.bind.Send([][]byte{{0x45}}, bridge.NewEndpoint("alpha")); err != nil {
+		t.Fatalf("sending to idle peer: %v", err)
+	}
+	expectEvent(t, eventsA, "alpha", control.EventDataChannelOpen, "bravo")
+	expectEvent(t, eventsB, "bravo", control.EventDataChannelOpen, "alpha")
+
+	// Nothing else is sent, so the connection is closed after a second.
+	expectEvent(t, eventsA, "alpha", control.EventPeerIdle, "bravo")
+	waitFor(t, 10*time.Second, "alpha shows bravo idle again", func() bool {
+		return peerState(agentA, "bravo") == "idle"
+	})
+	if !pair.fakesA.WireGuard.getDevice().hasPeer(pair.pubKeyB) {
+		t.Error("alpha removed bravo's WG peer when closing the idle connection")
+	}
+}
+
+// TestAgent_Reload verifies that a config reload, or selections configured
+// over the control socket, apply route, DNS and selection changes to
+// connected peers without reconnecting them, reports changes that need a
+// restart, and rejects an invalid file as a whole.
+func TestAgent_Reload(t *testing.T) {
+	t.Parallel()
+
+	// The fake persister serves the reloaded configs; nothing is on disk.
+	var nextA, nextB config.Config
+	pair := startConnectedPair(t, pairConfig{
+		configure: func(cfgA, _ *config.Config) {
+			cfgA.Peers = map[string]config.PeerSelections{
+				"bravo": {Routes: []string{"192.168.50.0/24"}, DNS: []string{"192.168.50.1"}},
+			}
+		},
+		optsA: []Option{WithConfigPath(filepath.Join(t.TempDir(), "config.toml"))},
+		optsB: []Option{WithConfigPath(filepath.Join(t.TempDir(), "config.toml"))},
+		beforeRun: func(p *connectedPair) {
+			if _, err := p.agentB.Reload(); err == nil {
+				t.Error("Reload() before Run succeeded")
+			}
+			// Copy the configs before the agents start using them.
+			nextA, nextB = *p.cfgA, *p.cfgB
+		},
+	})
+	defer pair.shutdown(t)
+	agentA, agentB := pair.agentA, pair.agentB
+	fakesA, fakesB := pair.fakesA, pair.fakesB
+	eventsA := pair.eventsA
+
+	alphaNet := func() ([]string, []string) {
+		fakesA.Network.mu.Lock()
+		defer fakesA.Network.mu.Unlock()
+		return slices.Clone(fakesA.Network.routes[tunnel.DefaultTUNName]), slices.Clone(fakesA.Network.dns[tunnel.DefaultTUNName])
+	}
+	if _, dns := alphaNet(); !slices.Equal(dns, []string{"192.168.50.1"}) {
+		t.Fatalf("alpha DNS = %v, want bravo's selected server", dns)
+	}
+	setConfig := func(f *testFakes, cfg *config.Config) {
+		f.Config.mu.Lock()
+		f.Config.loadConfig = cfg
+		f.Config.mu.Unlock()
+	}
+
+	// bravo starts advertising a route; the address change needs a restart.
+	nextB.Device.Routes = []string{"192.168.50.0/24"}
+	nextB.Device.Address = "10.0.0.20/24"
+	nextB.STUN.Servers = []string{"stun:stun.example.net:3478"}
+	setConfig(fakesB, &nextB)
+	result, err := agentB.Reload()
+	if err != nil {
+		t.Fatalf("bravo Reload() error: %v", err)
+	}
+	if want := []string{"stun.servers", "device.routes"}; !slices.Equal(result.Applied, want) {
+		t.Errorf("Applied = %v, want %v", result.Applied, want)
+	}
+	if want := []string{"device.address"}; !slices.Equal(result.RestartRequired, want) {
+		t.Errorf("RestartRequired = %v, want %v", result.RestartRequired, want)
+	}
+	if got := agentB.Status().Address; got != "10.0.0.2/24" {
+		t.Errorf("bravo address = %s after reload, want the running one", got)
+	}
+	waitFor(t, 5*time.Second, "alpha installs bravo's new route", func() bool {
+		routes, _ := alphaNet()
+		return slices.Contains(routes, "192.168.50.0/24")
+	})
+
+	// An invalid file changes nothing.
+	bad := nextA
+	bad.Device.RouteConflictPolicy = "sideways"
+	bad.Peers = nil
+	setConfig(fakesA, &bad)
+	if _, err := agentA.Reload(); err == nil {
+		t.Error("Reload() accepted an invalid route_conflict_policy")
+	}
+	if routes, _ := alphaNet(); !slices.Contains(routes, "192.168.50.0/24") {
+		t.Error("rejected reload withdrew bravo's route")
+	}
+
+	// alpha drops its selections for bravo: the route and DNS go away.
+	nextA.Peers = nil
+	setConfig(fakesA, &nextA)
+	result, err = agentA.Reload()
+	if err != nil {
+		t.Fatalf("alpha Reload() error: %v", err)
+	}
+	if want := []string{"peers.bravo"}; !slices.Equal(result.Applied, want) || len(result.RestartRequired) > 0 {
+		t.Errorf("result = %+v, want applied %v", result, want)
+	}
+	routes, dns := alphaNet()
+	if slices.Contains(routes, "192.168.50.0/24") || len(dns) > 0 {
+		t.Errorf("alpha routes = %v, DNS = %v after dropping bravo's selections", routes, dns)
+	}
+
+	// Reloading the same file again is a no-op.
+	if result, err := agentA.Reload(); err != nil || len(result.Applied) > 0 {
+		t.Errorf("second Reload() = %+v, %v, want no changes", result, err)
+	}
+
+	// Selections configured over the control socket apply the same way.
+	err = agentA.ConfigurePeer(control.ConfigureRequest{
+		PeerID:     "bravo",
+		Selections: control.PeerCapabilities{Routes: []string{"192.168.50.0/24"}, DNS: []string{"192.168.50.1"}},
+	})
+	if err != nil {
+		t.Fatalf("ConfigurePeer() error: %v", err)
+	}
+	routes, dns = alphaNet()
+	if !slices.Contains(routes, "192.168.50.0/24") || !slices.Equal(dns, []string{"192.168.50.1"}) {
+		t.Errorf("alpha routes = %v, DNS = %v after configuring bravo's selections", routes, dns)
+	}
+
+	// None of this reconnected bravo.
+	for len(eventsA) > 0 {
+		if ev := <-eventsA; ev.Type == control.EventPeerRemoved {
+			t.Errorf("reload removed peer %s", ev.Peer)
+		}
+	}
+}
+
+// TestAgent_NetworkPolicy verifies that a policy stored on the hub reaches
+// agents before their peers connect: alpha enforces the network ACL on top
+// of its local rule, and bravo accepts alpha's route without a selection.
+// Later versions are pushed to connected agents and applied to connected
+// peers.
+func TestAgent_NetworkPolicy(t *testing.T) {
+	t.Parallel()
+
+	_, srv, wsURL := startTestHub(t)
+
+	const route = "192.168.1.0/24"
+	doc, err := policy.Parse([]byte(`
+[groups]
+laptops = ["bravo"]
+
+[[acl]]
+from = ["group:laptops"]
+to = ["alpha"]
+allow = ["10.0.0.1:22,80/tcp"]
+
+[[routes]]
+devices = ["group:laptops"]
+from = "alpha"
+accept = ["192.168.1.0/24"]
+`))
+	if err != nil {
+		t.Fatalf("policy.Parse() error: %v", err)
+	}
+	version, err := policy.Upload(context.Background(), srv.URL, "", doc)
+	if err != nil || version != 1 {
+		t.Fatalf("policy.Upload() = %d, %v; want 1, nil", version, err)
+	}
+
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Device.Routes = []string{route}
+	cfgA.Peers = map[string]config.PeerSelections{
+		"bravo": {Allow: []string{"10.0.0.1:22/tcp"}},
+	}
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+
+	depsA, fakesA := newTestDeps()
+	depsB, fakesB := newTestDeps()
+	for _, d := range []*Deps{&depsA, &depsB} {
+		d.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+			return signaling.NewClient(cfg)
+		}
+	}
+
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctx) }()
+
+	pubA := config.PublicKey(cfgA.Device.PrivateKey)
+	waitFor(t, 10*time.Second, "bravo accepts alpha's route from the policy", func() bool {
+		dev := fakesB.WireGuard.getDevice()
+		if dev == nil {
+			return false
+		}
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		return slices.Contains(dev.peers[pubA.String()].AllowedIPs, route)
+	})
+	waitFor(t, 10*time.Second, "alpha adds bravo as a WireGuard peer", func() bool {
+		dev := fakesA.WireGuard.getDevice()
+		return dev != nil && dev.peerCount() == 1
+	})
+
+	acl := agentA.aclFilter.Policy()
+	if acl == nil || len(acl.Peers) != 1 {
+		t.Fatalf("policy = %+v, want one peer", acl)
+	}
+	if bravo := acl.Peers[0]; len(bravo.Rules) != 1 || len(bravo.NetworkRules) != 1 {
+		t.Errorf("bravo = %+v, want one local and one network rule", bravo)
+	}
+
+	st := agentA.Status()
+	if st.PolicyVersion != 1 {
+		t.Errorf("Status().PolicyVersion = %d, want 1", st.PolicyVersion)
+	}
+	if st.ACL == nil || st.ACL.NetworkDefault != "allow" || len(st.ACL.Peers) != 1 ||
+		!slices.Equal(st.ACL.Peers[0].NetworkAllow, []string{"10.0.0.1:22,80/tcp"}) {
+		t.Errorf("Status().ACL = %+v, want bravo's network rule", st.ACL)
+	}
+
+	// Updates are pushed to connected agents.
+	doc.ACLDefault = "deny"
+	if _, err := policy.Upload(context.Background(), srv.URL, "", doc); err != nil {
+		t.Fatalf("policy.Upload() error: %v", err)
+	}
+	waitFor(t, 5*time.Second, "alpha applies the updated policy", func() bool {
+		return agentA.aclFilter.Policy().NetworkDefault == "deny"
+	})
+
+	// Route and DNS changes reach peers that are already connected.
+	doc.Routes = nil
+	doc.DNS = []policy.DNSEntry{{Devices: []string{"bravo"}, From: "alpha", Servers: []string{"10.0.0.1"}}}
+	if _, err := policy.Upload(context.Background(), srv.URL, "", doc); err != nil {
+		t.Fatalf("policy.Upload() error: %v", err)
+	}
+	waitFor(t, 5*time.Second, "bravo withdraws alpha's route and uses its DNS", func() bool {
+		fakesB.Network.mu.Lock()
+		routes := slices.Clone(fakesB.Network.routes[tunnel.DefaultTUNName])
+		dns := slices.Clone(fakesB.Network.dns[tunnel.DefaultTUNName])
+		fakesB.Network.mu.Unlock()
+		return !slices.Contains(routes, route) && slices.Equal(dns, []string{"10.0.0.1"})
+	})
+	dev := fakesB.WireGuard.getDevice()
+	dev.mu.Lock()
+	allowed := slices.Clone(dev.peers[pubA.String()].AllowedIPs)
+	dev.mu.Unlock()
+	if slices.Contains(allowed, route) {
+		t.Errorf("alpha's AllowedIPs = %v on bravo, want the route withdrawn", allowed)
+	}
+
+	cancel()
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_ACL_invalidRule verifies that an invalid allow rule stops the
+// agent from starting rather than running without the intended policy.
+func TestAgent_ACL_invalidRule(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig("alpha", "10.0.0.1/24", "ws://127.0.0.1:1")
+	cfg.Peers = map[string]config.PeerSelections{
+		"bravo": {Allow: []string{"bravo:22"}},
+	}
+	deps, _ := newTestDeps()
+
+	err := New(cfg, nil, WithDeps(deps)).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "peers.bravo.allow") {
+		t.Errorf("Run() error = %v, want peers.bravo.allow error", err)
+	}
+}
+
+// TestAgent_RouteFailover verifies that when two peers advertise the same
+// route, only the preferred one carries it, and that the route moves to the
+// standby peer when the primary stops handshaking or disconnects.
+func TestAgent_RouteFailover(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	const route = "192.168.1.0/24"
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgA.Device.Routes = []string{route}
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+	cfgB.Device.Routes = []string{route}
+
+	cfgC := testConfig("charlie", "10.0.0.3/24", wsURL)
+	cfgC.SetPeerSelection("alpha", config.PeerSelections{Routes: []string{route}})
+	cfgC.SetPeerSelection("bravo", config.PeerSelections{Routes: []string{route}, RoutePriority: 10})
+
+	depsA, _ := newTestDeps()
+	depsB, _ := newTestDeps()
+	depsC, fakesC := newTestDeps()
+	for _, d := range []*Deps{&depsA, &depsB, &depsC} {
+		d.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+			return signaling.NewClient(cfg)
+		}
+	}
+
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
+	agentC := New(cfgC, nil, WithDeps(depsC))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	ctxB, cancelB := context.WithCancel(ctx)
+	defer cancelB()
+
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	errChC := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctxB) }()
+	go func() { errChC <- agentC.Run(ctx) }()
+
+	pubA := config.PublicKey(cfgA.Device.PrivateKey)
+	pubB := config.PublicKey(cfgB.Device.PrivateKey)
+
+	// carrier returns which of alpha and bravo has the route in its
+	// AllowedIPs on charlie, or "" if neither or both do.
+	carrier := func() string {
+		dev := fakesC.WireGuard.getDevice()
+		if dev == nil {
+			return ""
+		}
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		a := slices.Contains(dev.peers[pubA.String()].AllowedIPs, route)
+		b := slices.Contains(dev.peers[pubB.String()].AllowedIPs, route)
+		_, hasA := dev.peers[pubA.String()]
+		_, hasB := dev.peers[pubB.String()]
+		switch {
+		case !hasA || !hasB:
+			return ""
+		case a && !b:
+			return "alpha"
+		case b && !a:
+			return "bravo"
+		}
+		return ""
+	}
+	waitCarrier := func(desc, want string) {
+		t.Helper()
+		waitFor(t, 10*time.Second, desc, func() bool { return carrier() == want })
+	}
+
+	waitCarrier("bravo carries the route (higher priority)", "bravo")
+
+	// Bravo stops completing handshakes: alpha takes over.
+	dev := fakesC.WireGuard.getDevice()
+	dev.mu.Lock()
+	dev.handshakes = map[config.Key]time.Time{
+		pubA: time.Now(),
+		pubB: time.Now().Add(-10 * time.Minute),
+	}
+	dev.mu.Unlock()
+	agentC.rebalanceRoutes("")
+	waitCarrier("alpha takes over from a stale bravo", "alpha")
+
+	// Bravo recovers: as the preferred peer it takes the route back.
+	dev.mu.Lock()
+	dev.handshakes[pubB] = time.Now()
+	dev.mu.Unlock()
+	agentC.rebalanceRoutes("")
+	waitCarrier("bravo takes the route back", "bravo")
+
+	// Bravo disconnects: alpha takes over and the kernel route stays.
+	cancelB()
+	waitFor(t, 10*time.Second, "alpha carries the route after bravo leaves", func() bool {
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		_, hasB := dev.peers[pubB.String()]
+		return !hasB && slices.Contains(dev.peers[pubA.String()].AllowedIPs, route)
+	})
+	fakesC.Network.mu.Lock()
+	routes := slices.Clone(fakesC.Network.routes[tunnel.DefaultTUNName])
+	fakesC.Network.mu.Unlock()
+	if !slices.Equal(routes, []string{route}) {
+		t.Errorf("charlie's kernel routes = %v, want [%s]", routes, route)
+	}
+
+	cancel()
+	for _, ch := range []chan error{errChA, errChB, errChC} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_GlareResolution verifies that when both peers send offers
+// simultaneously (possible during ICE restart), the glare is resolved
+// and exactly one connection survives.
+func TestAgent_GlareResolution(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	// "alpha" < "bravo", so alpha is the preferred offerer.
+	// Both start simultaneously, and the hub sends peers lists to both.
+	// Alpha should offer, bravo should wait for the offer.
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+
+	depsA, fakesA := newTestDeps()
+	depsB, fakesB := newTestDeps()
+
+	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+	depsB.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+
+	agentA := New(cfgA, nil, WithDeps(depsA))
+	agentB := New(cfgB, nil, WithDeps(depsB))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	errChA := make(chan error, 1)
+	errChB := make(chan error, 1)
+	go func() { errChA <- agentA.Run(ctx) }()
+	go func() { errChB <- agentB.Run(ctx) }()
+
+	pubKeyA := config.PublicKey(cfgA.Device.PrivateKey).String()
+	pubKeyB := config.PublicKey(cfgB.Device.PrivateKey).String()
+
+	// Wait for both to be connected.
+	waitFor(t, 10*time.Second, "alpha has bravo's WG peer", func() bool {
+		return fakesA.WireGuard.getDevice() != nil && fakesA.WireGuard.getDevice().hasPeer(pubKeyB)
+	})
+	waitFor(t, 10*time.Second, "bravo has alpha's WG peer", func() bool {
+		return fakesB.WireGuard.getDevice() != nil && fakesB.WireGuard.getDevice().hasPeer(pubKeyA)
+	})
+
+	// Each agent should have exactly 1 peer.
+	agentA.mu.Lock()
+	peersA := len(agentA.peers)
+	agentA.mu.Unlock()
+
+	agentB.mu.Lock()
+	peersB := len(agentB.peers)
+	agentB.mu.Unlock()
+
+	if peersA != 1 {
+		t.Errorf("alpha has %d peers, want 1", peersA)
+	}
+	if peersB != 1 {
+		t.Errorf("bravo has %d peers, want 1", peersB)
+	}
+
+	cancel()
+	for _, ch := range []chan error{errChA, errChB} {
+		select {
+		case err := <-ch:
+			if !isShutdownError(err) {
+				t.Errorf("agent error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("agent did not shut down")
+		}
+	}
+}
+
+// TestAgent_SelfSkip verifies that the agent ignores itself in the peers list.
+func TestAgent_SelfSkip(t *testing.T) {
+	t.Parallel()
+
+	_, _, wsURL := startTestHub(t)
+
+	cfg := testConfig("solo", "10.0.0.1/24", wsURL)
+
+	deps, fakes := newTestDeps()
+	deps.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+
+	ag := New(cfg, nil, WithDeps(deps))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- ag.Run(ctx) }()
+
+	// Wait a bit to ensure the agent doesn't crash or create peers to itself.
+	time.Sleep(1 * time.Second)
+
+	ag.mu.Lock()
+	peerCount := len(ag.peers)
+	ag.mu.Unlock()
+
+	if peerCount != 0 {
+		t.Errorf("agent has %d peers, want 0 (should not connect to itself)", peerCount)
+	}
+
+	_ = fakes // verify no WG peers were created
+	if fakes.WireGuard.getDevice() != nil && fakes.WireGuard.getDevice().peerCount() != 0 {
+		t.Errorf("WG device has %d peers, want 0", fakes.WireGuard.getDevice().peerCount())
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if !isShutdownError(err) {
+			t.Errorf("agent error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("agent did not shut down")
+	}
+}
+
+// --- connectedPair sets up two agents that are fully connected ---
+
+type connectedPair struct {
+	agentA, agentB   *Agent
+	cfgA, cfgB       *config.Config
+	fakesA, fakesB   *testFakes
+	pubKeyA, pubKeyB string
+	eventsA, eventsB <-chan control.Event // subscribed before Run
+	cancel           context.CancelFunc
+	errChA, errChB   chan error
+}
+
+// pairConfig customizes startConnectedPair. The zero value starts two
+// agents with testConfig.
+type pairConfig struct {
+	// configure edits the configs before the agents are created.
+	configure func(cfgA, cfgB *config.Config)
+	// optsA and optsB are added to the agents' options.
+	optsA, optsB []Option
+	// beforeRun is called once the agents exist, before they run.
+	beforeRun func(p *connectedPair)
+}
+
+// startConnectedPair starts two agents, alpha and bravo, waits until each
+// has the other as a WireGuard peer (connected, or idle in on-demand mode),
+// then returns the pair for further testing. Call pair.shutdown(t) when
+// done.
+func startConnectedPair(t *testing.T, pc pairConfig) *connectedPair {
+	t.Helper()
+
+	_, _, wsURL := startTestHub(t)
+
+	cfgA := testConfig("alpha", "10.0.0.1/24", wsURL)
+	cfgB := testConfig("bravo", "10.0.0.2/24", wsURL)
+	if pc.configure != nil {
+		pc.configure(cfgA, cfgB)
+	}
+
+	depsA, fakesA := newTestDeps()
+	depsB, fakesB := newTestDeps()
+
+	depsA.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+	depsB.Signaling = func(cfg signaling.ClientConfig) SignalingClient {
+		return signaling.NewClient(cfg)
+	}
+
+	p := &connectedPair{
+		agentA:  New(cfgA, nil, append([]Option{WithDeps(depsA)}, pc.optsA...)...),
+		agentB:  New(cfgB, nil, append([]Option{WithDeps(depsB)}, pc.optsB...)...),
+		cfgA:    cfgA,
+		cfgB:    cfgB,
+		fakesA:  fakesA,
+		fakesB:  fakesB,
+		pubKeyA: config.PublicKey(cfgA.Device.PrivateKey).String(),
+		pubKeyB: config.PublicKey(cfgB.Device.PrivateKey).String(),
+		errChA:  make(chan error, 1),
+		errChB:  make(chan error, 1),
+	}
+	var unsubscribeA, unsubscribeB func()
+	p.eventsA, unsubscribeA = p.agentA.SubscribeEvents()
+	t.Cleanup(unsubscribeA)
+	p.eventsB, unsubscribeB = p.agentB.SubscribeEvents()
+	t.Cleanup(unsubscribeB)
+	if pc.beforeRun != nil {
+		pc.beforeRun(p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	p.cancel = cancel
+	go func() { p.errChA <- p.agentA.Run(ctx) }()
+	go func() { p.errChB <- p.agentB.Run(ctx) }()
+
+	// Wait for full connection.
+	waitFor(t, 10*time.Second, "bravo has alpha's WG peer", func() bool {
+		return fakesB.WireGuard.getDevice() != nil && fakesB.WireGuard.getDevice().hasPeer(p.pubKeyA)
+	})
+	waitFor(t, 10*time.Second, "alpha has bravo's WG peer", func() bool {
+		return fakesA.WireGuard.getDevice() != nil && fakesA.WireGuard.getDevice().hasPeer(p.pubKeyB)
+	})
+
+	return p
+}
+
+// expectEvent waits for an event of type typ about peer on events, from
+// the agent named who, skipping other events.
+func expectEvent(t *testing.T, events <-chan control.Event, who string, typ control.EventType, peer string) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("%s: event subscription closed waiting for %s event for %s", who, typ, peer)
+			}
+			if ev.Type == typ && ev.Peer == peer {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s: no %s event for %s", who, typ, peer)
+		}
 	}
 }
 
@@ -640,7 +1945,7 @@ func (p *connectedPair) shutdown(t *testing.T) {
 func TestAgent_ICEDisconnect_GracePeriod(t *testing.T) {
 	t.Parallel()
 
-	pair := startConnectedPair(t)
+	pair := startConnectedPair(t, pairConfig{})
 	defer pair.shutdown(t)
 
 	// Verify both agents have 1 peer.
@@ -705,7 +2010,7 @@ func TestAgent_ICEDisconnect_GracePeriod(t *testing.T) {
 func TestAgent_ICEFailed_RestartsICE(t *testing.T) {
 	t.Parallel()
 
-	pair := startConnectedPair(t)
+	pair := startConnectedPair(t, pairConfig{})
 	defer pair.shutdown(t)
 
 	// Simulate ICE failure on alpha's side.
@@ -741,7 +2046,7 @@ func TestAgent_ICEFailed_RestartsICE(t *testing.T) {
 func TestAgent_ICERestart_MaxAttempts(t *testing.T) {
 	t.Parallel()
 
-	pair := startConnectedPair(t)
+	pair := startConnectedPair(t, pairConfig{})
 	defer pair.shutdown(t)
 
 	// Pre-set the restart counter to the maximum.
@@ -779,7 +2084,7 @@ func TestAgent_ICERestart_MaxAttempts(t *testing.T) {
 func TestAgent_ICEDisconnect_GraceExpires(t *testing.T) {
 	t.Parallel()
 
-	pair := startConnectedPair(t)
+	pair := startConnectedPair(t, pairConfig{})
 	defer pair.shutdown(t)
 
 	// Simulate ICE disconnection — this starts the grace timer.
@@ -984,7 +2289,7 @@ func TestAgent_ICECandidate_BufferedBeforeRemoteDescription(t *testing.T) {
 func TestAgent_OrphanedPeerConnection_Closed(t *testing.T) {
 	t.Parallel()
 
-	pair := startConnectedPair(t)
+	pair := startConnectedPair(t, pairConfig{})
 	defer pair.shutdown(t)
 
 	// Grab a reference to bravo's current PeerConnection on alpha's side.
@@ -1120,7 +2425,7 @@ func TestAgent_PeerWithoutAddress_NoWireGuardPeer(t *testing.T) {
 func TestAgent_NotifyNetworkChange(t *testing.T) {
 	t.Parallel()
 
-	pair := startConnectedPair(t)
+	pair := startConnectedPair(t, pairConfig{})
 	defer pair.shutdown(t)
 
 	// Set up a stale state: simulate a prior disconnect with a grace timer
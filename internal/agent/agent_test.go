@@ -1,6 +1,23 @@
 package agent
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kuuji/bamgate/internal/acl"
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/netstack"
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
 
 func TestIsValidRoute(t *testing.T) {
 	t.Parallel()
@@ -34,3 +51,812 @@ func TestIsValidRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestPeerAddress6(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     string
+	}{
+		{"ipv6 cidr", map[string]string{"address6": "fd12:3456:789a::2/64"}, "fd12:3456:789a::2"},
+		{"missing", map[string]string{"address": "10.0.0.2/24"}, ""},
+		{"ipv4 rejected", map[string]string{"address6": "10.0.0.2/24"}, ""},
+		{"not a cidr", map[string]string{"address6": "fd12::2"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := peerAddress6(tt.metadata)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("peerAddress6() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.String() != tt.want {
+				t.Errorf("peerAddress6() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickRouteOwner(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		candidates []routeCandidate
+		current    string
+		want       string
+	}{
+		{
+			name:       "higher priority wins",
+			candidates: []routeCandidate{{"alpha", 0, true}, {"bravo", 10, true}},
+			want:       "bravo",
+		},
+		{
+			name:       "tie keeps current owner",
+			candidates: []routeCandidate{{"alpha", 0, true}, {"bravo", 0, true}},
+			current:    "bravo",
+			want:       "bravo",
+		},
+		{
+			name:       "tie without owner picks lowest ID",
+			candidates: []routeCandidate{{"bravo", 0, true}, {"alpha", 0, true}},
+			want:       "alpha",
+		},
+		{
+			name:       "unhealthy primary fails over",
+			candidates: []routeCandidate{{"alpha", 0, true}, {"bravo", 10, false}},
+			current:    "bravo",
+			want:       "alpha",
+		},
+		{
+			name:       "recovered higher priority takes over",
+			candidates: []routeCandidate{{"alpha", 0, true}, {"bravo", 10, true}},
+			current:    "alpha",
+			want:       "bravo",
+		},
+		{
+			name:       "none healthy keeps current owner",
+			candidates: []routeCandidate{{"alpha", 10, false}, {"bravo", 0, false}},
+			current:    "bravo",
+			want:       "bravo",
+		},
+		{
+			name:       "none healthy without owner uses priority",
+			candidates: []routeCandidate{{"alpha", 0, false}, {"bravo", 10, false}},
+			want:       "bravo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := pickRouteOwner(tt.candidates, tt.current); got != tt.want {
+				t.Errorf("pickRouteOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserspaceNetworkManager(t *testing.T) {
+	t.Parallel()
+
+	stack, err := netstack.New([]string{"10.0.0.2/24"}, nil)
+	if err != nil {
+		t.Fatalf("netstack.New() error: %v", err)
+	}
+	a := New(&config.Config{}, nil, WithUserspace(stack, ""))
+	nm := a.deps.Network
+
+	route := netip.MustParseAddr("192.168.1.20")
+	if err := nm.AddRoute("go", "192.168.1.0/24"); err != nil {
+		t.Fatalf("AddRoute() error: %v", err)
+	}
+	if !stack.InMesh(route) {
+		t.Error("route added through the network manager is not in the stack")
+	}
+	if err := nm.RemoveRoute("go", "192.168.1.0/24"); err != nil {
+		t.Fatalf("RemoveRoute() error: %v", err)
+	}
+	if stack.InMesh(route) {
+		t.Error("route removed through the network manager is still in the stack")
+	}
+
+	if err := nm.SetForwarding("go", true); !errors.Is(err, errUserspace) {
+		t.Errorf("SetForwarding() error = %v, want %v", err, errUserspace)
+	}
+	if _, err := a.deps.TUN.CreateTUNFromFD(3); !errors.Is(err, errUserspace) {
+		t.Errorf("CreateTUNFromFD() error = %v, want %v", err, errUserspace)
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		listen  string
+		want    string
+		wantErr bool
+	}{
+		{"5432", "127.0.0.1:5432", false},
+		{":5432", "127.0.0.1:5432", false},
+		{"0.0.0.0:8080", "0.0.0.0:8080", false},
+		{"[::1]:53", "[::1]:53", false},
+		{"http", "", true},
+		{"70000", "", true},
+	}
+	for _, tt := range tests {
+		got, err := listenAddress(tt.listen, "127.0.0.1")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("listenAddress(%q) error = %v, wantErr %v", tt.listen, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("listenAddress(%q) = %q, want %q", tt.listen, got, tt.want)
+		}
+	}
+}
+
+func TestParseIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultIdleTimeout, false},
+		{"90s", 90 * time.Second, false},
+		{"1h", time.Hour, false},
+		{"1s", time.Second, false},
+		{"0s", 0, true},
+		{"3ns", 0, true},
+		{"999ms", 0, true},
+		{"-1m", 0, true},
+		{"5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseIdleTimeout(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIdleTimeout(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseIdleTimeout(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestConnects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		x, y string
+		want bool
+	}{
+		{"", "", true},
+		{"", "hub", true},
+		{"hub", "hub", true},
+		{"hub", "spoke", true},
+		{"spoke", "hub", true},
+		{"spoke", "spoke", false},
+		{"spoke", "", false},
+		{"", "spoke", false},
+	}
+	for _, tt := range tests {
+		if got := connects(tt.x, tt.y); got != tt.want {
+			t.Errorf("connects(%q, %q) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestAgent_Forwards(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, "hello")
+			conn.Close()
+		}
+	}()
+
+	deps, fakes := newTestDeps()
+	// The running config carries a command line override the file lacks.
+	cfg := &config.Config{Device: config.DeviceConfig{Address: "10.0.0.2/24", Userspace: true}}
+	fakes.Config.loadConfig = &config.Config{Device: config.DeviceConfig{Address: "10.0.0.2/24"}}
+	a := New(cfg, nil, WithDeps(deps), WithConfigPath("config.toml"))
+	t.Cleanup(a.closeForwards)
+
+	fwd, err := a.AddForward(control.Forward{
+		Listen:     "127.0.0.1:0",
+		Target:     ln.Addr().String(),
+		Persistent: true,
+	})
+	if err != nil {
+		t.Fatalf("AddForward() error: %v", err)
+	}
+	if fwd.ID != "1" || fwd.Protocol != "tcp" {
+		t.Errorf("AddForward() = %+v, want ID 1 and protocol tcp", fwd)
+	}
+
+	conn, err := net.Dial("tcp", fwd.Listen)
+	if err != nil {
+		t.Fatalf("dialing forward: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	got, _ := io.ReadAll(conn)
+	conn.Close()
+	if string(got) != "hello" {
+		t.Errorf("read %q through forward, want %q", got, "hello")
+	}
+
+	if list := a.Forwards(); len(list) != 1 || list[0].ID != "1" {
+		t.Errorf("Forwards() = %+v, want the added forward", list)
+	}
+	saved := fakes.Config.lastSavedConfig
+	if len(cfg.Forwards) != 1 || fakes.Config.savedConfigs != 1 || len(saved.Forwards) != 1 {
+		t.Fatalf("persistent forward not saved: forwards = %+v, saves = %d", cfg.Forwards, fakes.Config.savedConfigs)
+	}
+	if saved.Device.Userspace {
+		t.Error("command line override saved to the config file")
+	}
+
+	if err := a.RemoveForward("1"); err != nil {
+		t.Fatalf("RemoveForward() error: %v", err)
+	}
+	if len(cfg.Forwards) != 0 || len(a.Forwards()) != 0 || len(fakes.Config.lastSavedConfig.Forwards) != 0 {
+		t.Errorf("forward still present after RemoveForward: config %+v", cfg.Forwards)
+	}
+	if err := a.RemoveForward("1"); err == nil {
+		t.Error("RemoveForward() of a removed forward succeeded")
+	}
+	if _, err := a.AddForward(control.Forward{Protocol: "sctp", Listen: "0", Target: "x:1"}); err == nil {
+		t.Error("AddForward() accepted an unsupported protocol")
+	}
+}
+
+func TestPortForwardRule(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		pf      config.PortForward
+		want    tunnel.PortForwardRule
+		wantErr bool
+	}{
+		{
+			name: "tcp default",
+			pf:   config.PortForward{Port: 8443, Target: "192.168.1.50:443"},
+			want: tunnel.PortForwardRule{Protocol: "tcp", Address: "10.0.0.1", Port: 8443, Target: "192.168.1.50:443"},
+		},
+		{
+			name: "ipv6 target uses address6",
+			pf:   config.PortForward{Protocol: "udp", Port: 53, Target: "[fd00::53]:53"},
+			want: tunnel.PortForwardRule{Protocol: "udp", Address: "fd12::1", Port: 53, Target: "[fd00::53]:53"},
+		},
+		{name: "loopback target", pf: config.PortForward{Port: 80, Target: "127.0.0.1:80"}, wantErr: true},
+		{name: "port out of range", pf: config.PortForward{Port: 70000, Target: "192.168.1.50:80"}, wantErr: true},
+		{name: "missing target port", pf: config.PortForward{Port: 80, Target: "192.168.1.50"}, wantErr: true},
+		{name: "bad protocol", pf: config.PortForward{Protocol: "icmp", Port: 80, Target: "192.168.1.50:80"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := portForwardRule(tt.pf, "10.0.0.1/24", "fd12::1/64")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("portForwardRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("portForwardRule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgent_ReloadACL(t *testing.T) {
+	t.Parallel()
+
+	deps, fakes := newTestDeps()
+	cfg := &config.Config{
+		Device: config.DeviceConfig{Address: "10.0.0.1/24"},
+		Peers: map[string]config.PeerSelections{
+			"home": {Routes: []string{"192.168.1.0/24"}},
+		},
+	}
+	a := New(cfg, nil, WithDeps(deps), WithConfigPath("config.toml"))
+	a.aclFilter = acl.NewFilter(newFakeTUNDevice("bamgate0"), nil)
+	a.peers["phone"] = &peerState{tunnelAllowedIPs: []string{"10.0.0.2/32"}}
+
+	if err := a.ReloadACL(); err == nil {
+		t.Fatal("ReloadACL() with unreadable config succeeded, want error")
+	}
+
+	fakes.Config.loadConfig = &config.Config{
+		Device: config.DeviceConfig{ACLDefault: "deny"},
+		Peers: map[string]config.PeerSelections{
+			"phone": {Allow: []string{"192.168.1.10:22/tcp"}},
+		},
+	}
+	if err := a.ReloadACL(); err != nil {
+		t.Fatalf("ReloadACL() error: %v", err)
+	}
+
+	policy := a.aclFilter.Policy()
+	if policy == nil || policy.Default != acl.Deny || len(policy.Peers) != 1 {
+		t.Fatalf("policy = %+v, want default deny with one peer", policy)
+	}
+	phone := policy.Peers[0]
+	if phone.Name != "phone" || len(phone.Rules) != 1 || phone.Rules[0].String() != "192.168.1.10:22/tcp" {
+		t.Errorf("phone = %+v, want one rule 192.168.1.10:22/tcp", phone)
+	}
+	if !slices.Equal(phone.Sources, []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32")}) {
+		t.Errorf("phone.Sources = %v, want [10.0.0.2/32]", phone.Sources)
+	}
+
+	// Allow rules are merged into the running config without touching
+	// other selections, so they survive the next save.
+	if got := a.cfg.Peers["phone"].Allow; !slices.Equal(got, []string{"192.168.1.10:22/tcp"}) {
+		t.Errorf("cfg.Peers[phone].Allow = %v", got)
+	}
+	if got := a.cfg.Peers["home"].Routes; !slices.Equal(got, []string{"192.168.1.0/24"}) {
+		t.Errorf("cfg.Peers[home].Routes = %v, want them kept", got)
+	}
+
+	st := a.Status().ACL
+	if st == nil || st.Default != "deny" || len(st.Peers) != 1 || st.Peers[0].Peer != "phone" {
+		t.Errorf("Status().ACL = %+v, want default deny with phone", st)
+	}
+
+	// An invalid rule leaves the running policy in place.
+	fakes.Config.loadConfig = &config.Config{
+		Peers: map[string]config.PeerSelections{"phone": {Allow: []string{"phone:22"}}},
+	}
+	if err := a.ReloadACL(); err == nil {
+		t.Error("ReloadACL() with invalid rule succeeded, want error")
+	}
+	if a.aclFilter.Policy() != policy {
+		t.Error("policy replaced by invalid config")
+	}
+}
+
+// TestAgent_ConfigurePeer verifies that configured selections are merged
+// into the config file, keeping the file's allow rules and other settings.
+func TestAgent_ConfigurePeer(t *testing.T) {
+	t.Parallel()
+
+	deps, fakes := newTestDeps()
+	cfg := &config.Config{Device: config.DeviceConfig{Address: "10.0.0.1/24"}}
+	a := New(cfg, nil, WithDeps(deps), WithConfigPath("config.toml"))
+
+	fakes.Config.loadConfig = &config.Config{
+		Device: config.DeviceConfig{Address: "10.0.0.1/24", Name: "laptop"},
+		Peers: map[string]config.PeerSelections{
+			"home": {Routes: []string{"192.168.2.0/24"}, Allow: []string{"192.168.1.10:22/tcp"}},
+		},
+	}
+	err := a.ConfigurePeer(control.ConfigureRequest{
+		PeerID:     "home",
+		Selections: control.PeerCapabilities{Routes: []string{"192.168.1.0/24"}, DNS: []string{"192.168.1.1"}},
+	})
+	if err != nil {
+		t.Fatalf("ConfigurePeer() error: %v", err)
+	}
+
+	saved := fakes.Config.lastSavedConfig
+	if saved == nil {
+		t.Fatal("config not saved")
+	}
+	if saved.Device.Name != "laptop" {
+		t.Errorf("saved device.name = %q, want the file's kept", saved.Device.Name)
+	}
+	home := saved.Peers["home"]
+	if !slices.Equal(home.Routes, []string{"192.168.1.0/24"}) || !slices.Equal(home.DNS, []string{"192.168.1.1"}) {
+		t.Errorf("saved peers.home = %+v, want the configured selections", home)
+	}
+	if !slices.Equal(home.Allow, []string{"192.168.1.10:22/tcp"}) {
+		t.Errorf("saved peers.home.Allow = %v, want the file's kept", home.Allow)
+	}
+	if got := a.cfg.Peers["home"].Routes; !slices.Equal(got, []string{"192.168.1.0/24"}) {
+		t.Errorf("cfg.Peers[home].Routes = %v, want the configured routes", got)
+	}
+}
+
+// TestAgent_ForwardingWatchdog verifies that a network change notification
+// makes the watchdog repair forwarding and NAT right away instead of at the
+// next poll.
+func TestAgent_ForwardingWatchdog(t *testing.T) {
+	t.Parallel()
+
+	deps, fakes := newTestDeps()
+	fakes.Network.events = make(chan tunnel.NetworkEvent, 1)
+	fakes.NAT.tableExists = false
+
+	a := New(&config.Config{}, nil, WithDeps(deps))
+	a.natManager = fakes.NAT
+	a.forwardingState = []forwardingSave{{ifName: "eth0"}}
+	a.masqueradeRules = []masqueradeEntry{{wgSubnet: "10.0.0.1/24", outIface: "eth0"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.startForwardingWatchdog(ctx)
+
+	fakes.Network.events <- tunnel.NetworkEvent{Kind: tunnel.NATTableDeleted}
+	waitFor(t, 2*time.Second, "forwarding re-enabled and masquerade re-applied", func() bool {
+		fakes.NAT.mu.Lock()
+		rules := len(fakes.NAT.rules)
+		fakes.NAT.mu.Unlock()
+		enabled, _ := fakes.Network.GetForwarding("eth0")
+		return enabled && rules == 1
+	})
+}
+
+// TestAgent_StateJournal verifies that kernel changes are journaled, that
+// a clean shutdown leaves no journal behind, and that recovering a journal
+// left by a crash undoes forwarding, NAT, routes and DNS.
+func TestAgent_StateJournal(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state", "default.json")
+	newAgent := func() (*Agent, *testFakes) {
+		deps, fakes := newTestDeps()
+		fakes.Network.subnets["192.168.1.0/24"] = "eth0"
+		cfg := &config.Config{Device: config.DeviceConfig{
+			Address: "10.0.0.1/24",
+			Routes:  []string{"192.168.1.0/24"},
+		}}
+		a := New(cfg, nil, WithDeps(deps), WithStateJournal(path))
+		a.tunName = "bamgate0"
+		a.dnsBackend = tunnel.DNSBackendFile
+		a.journal = &stateJournal{path: path, log: a.log, state: journalState{Interface: a.tunName}}
+		if err := a.setupForwardingAndNAT(a.tunName); err != nil {
+			t.Fatalf("setupForwardingAndNAT: %v", err)
+		}
+		return a, fakes
+	}
+
+	// Clean shutdown.
+	a, _ := newAgent()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("no journal after setting up forwarding: %v", err)
+	}
+	a.cleanupForwardingAndNAT()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal left after clean shutdown: %v", err)
+	}
+
+	// Crash with a route and DNS in place.
+	a, fakes := newAgent()
+	a.journalRoute("192.168.2.0/24", true)
+	_ = fakes.Network.AddRoute(a.tunName, "192.168.2.0/24")
+	a.journalDNS(true)
+
+	want := journalState{
+		Interface:  "bamgate0",
+		Forwarding: []journalForwarding{{Interface: "bamgate0"}, {Interface: "eth0"}},
+		NAT:        true,
+		Masquerade: []journalMasquerade{{Subnet: "10.0.0.1/24", Interface: "eth0"}},
+		Routes:     []string{"192.168.2.0/24"},
+		DNS:        tunnel.DNSBackendFile,
+	}
+	a.journal.mu.Lock()
+	got := a.journal.state
+	a.journal.mu.Unlock()
+	if !slices.Equal(got.Forwarding, want.Forwarding) || !slices.Equal(got.Masquerade, want.Masquerade) ||
+		!slices.Equal(got.Routes, want.Routes) || got.NAT != want.NAT || got.DNS != want.DNS {
+		t.Fatalf("journal = %+v, want %+v", got, want)
+	}
+
+	if err := recoverState(path, a.deps, a.log); err != nil {
+		t.Fatalf("recoverState: %v", err)
+	}
+	for _, ifName := range []string{"bamgate0", "eth0"} {
+		if enabled, _ := fakes.Network.GetForwarding(ifName); enabled {
+			t.Errorf("forwarding on %s still enabled", ifName)
+		}
+	}
+	fakes.NAT.mu.Lock()
+	rules := fakes.NAT.rules
+	fakes.NAT.mu.Unlock()
+	if len(rules) != 0 {
+		t.Errorf("masquerade rules left: %v", rules)
+	}
+	fakes.Network.mu.Lock()
+	routes, recovered := fakes.Network.routes["bamgate0"], fakes.Network.recovered
+	fakes.Network.mu.Unlock()
+	if len(routes) != 0 {
+		t.Errorf("routes left: %v", routes)
+	}
+	if !slices.Equal(recovered, []string{"bamgate0"}) {
+		t.Errorf("DNS recovered for %v, want bamgate0", recovered)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal left after recovery: %v", err)
+	}
+
+	// Nothing to recover.
+	if err := recoverState(path, a.deps, a.log); err != nil {
+		t.Errorf("recoverState without a journal: %v", err)
+	}
+}
+
+// TestAgent_AdoptAddress6Forwarding verifies that an IPv6 address learned
+// after startup gets the IPv6 forwarding, masquerade and journal entries
+// an IPv6 route was skipped for without it.
+func TestAgent_AdoptAddress6Forwarding(t *testing.T) {
+	t.Parallel()
+
+	deps, fakes := newTestDeps()
+	fakes.Network.subnets["192.168.1.0/24"] = "eth0"
+	fakes.Network.subnets["fd00:1::/64"] = "eth0"
+	cfg := &config.Config{Device: config.DeviceConfig{
+		Address: "10.0.0.1/24",
+		Routes:  []string{"192.168.1.0/24", "fd00:1::/64"},
+	}}
+	path := filepath.Join(t.TempDir(), "state", "default.json")
+	a := New(cfg, nil, WithDeps(deps), WithStateJournal(path))
+	a.tunName = "bamgate0"
+	a.journal = &stateJournal{path: path, log: a.log, state: journalState{Interface: a.tunName}}
+	if err := a.setupForwardingAndNAT(a.tunName); err != nil {
+		t.Fatalf("setupForwardingAndNAT: %v", err)
+	}
+
+	a.adoptAddress6("fd00::2/64")
+
+	fakes.Network.mu.Lock()
+	forwarding6 := fakes.Network.forwarding6
+	fakes.Network.mu.Unlock()
+	if !forwarding6 {
+		t.Error("IPv6 forwarding not enabled")
+	}
+	fakes.NAT.mu.Lock()
+	rules := slices.Clone(fakes.NAT.rules)
+	fakes.NAT.mu.Unlock()
+	if want := (masqueradeEntry{wgSubnet: "fd00::2/64", outIface: "eth0"}); !slices.Contains(rules, want) {
+		t.Errorf("masquerade rules = %v, want %v among them", rules, want)
+	}
+	a.journal.mu.Lock()
+	journaled := slices.Contains(a.journal.state.Forwarding, journalForwarding{IPv6: true})
+	a.journal.mu.Unlock()
+	if !journaled {
+		t.Error("IPv6 forwarding not journaled")
+	}
+}
+
+// TestRecoverState_refusesUnsafeJournal verifies that a journal others
+// could have written is left alone instead of being undone.
+func TestRecoverState_refusesUnsafeJournal(t *testing.T) {
+	t.Parallel()
+
+	journal := []byte(`{"interface":"bamgate0","routes":["0.0.0.0/0"]}`)
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string) string
+	}{
+		{"group-writable file", func(t *testing.T, dir string) string {
+			path := filepath.Join(dir, "default.json")
+			if err := os.WriteFile(path, journal, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, 0o620); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}},
+		{"symlink", func(t *testing.T, dir string) string {
+			target := filepath.Join(dir, "target.json")
+			if err := os.WriteFile(target, journal, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "default.json")
+			if err := os.Symlink(target, path); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}},
+		{"shared directory", func(t *testing.T, dir string) string {
+			if err := os.Chmod(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "default.json")
+			if err := os.WriteFile(path, journal, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			deps, fakes := newTestDeps()
+			fakes.Network.routes["bamgate0"] = []string{"0.0.0.0/0"}
+			dir := filepath.Join(t.TempDir(), "state")
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			path := tt.setup(t, dir)
+
+			if err := recoverState(path, deps, New(&config.Config{}, nil).log); err == nil {
+				t.Error("recoverState accepted an unsafe journal")
+			}
+			if _, err := os.Lstat(path); err != nil {
+				t.Errorf("refused journal was removed: %v", err)
+			}
+			fakes.Network.mu.Lock()
+			defer fakes.Network.mu.Unlock()
+			if routes := fakes.Network.routes["bamgate0"]; len(routes) != 1 {
+				t.Errorf("routes after refusing the journal = %v, want 0.0.0.0/0 kept", routes)
+			}
+		})
+	}
+}
+
+func TestIsNetworkChange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		ev   tunnel.NetworkEvent
+		want bool
+	}{
+		{"default route", tunnel.NetworkEvent{Kind: tunnel.DefaultRouteChanged, IfIndex: 2}, true},
+		{"default route on tun", tunnel.NetworkEvent{Kind: tunnel.DefaultRouteChanged, IfIndex: 7}, false},
+		{"address", tunnel.NetworkEvent{Kind: tunnel.AddressChanged, IfIndex: 2, Addr: netip.MustParseAddr("192.168.1.20")}, true},
+		{"address on tun", tunnel.NetworkEvent{Kind: tunnel.AddressChanged, IfIndex: 7, Addr: netip.MustParseAddr("10.0.0.1")}, false},
+		{"link-local address", tunnel.NetworkEvent{Kind: tunnel.AddressChanged, IfIndex: 2, Addr: netip.MustParseAddr("fe80::1")}, false},
+		{"loopback address", tunnel.NetworkEvent{Kind: tunnel.AddressChanged, IfIndex: 1, Addr: netip.MustParseAddr("127.0.0.1")}, false},
+		{"netconf", tunnel.NetworkEvent{Kind: tunnel.NetconfChanged, IfIndex: 2}, false},
+		{"link", tunnel.NetworkEvent{Kind: tunnel.LinkChanged, IfIndex: 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := isNetworkChange(tt.ev, 7); got != tt.want {
+				t.Errorf("isNetworkChange(%+v) = %v, want %v", tt.ev, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgent_NetworkChangeMonitor(t *testing.T) {
+	t.Parallel()
+
+	deps, fakes := newTestDeps()
+	fakes.Network.events = make(chan tunnel.NetworkEvent, 4)
+	sig := &fakeSignalingClient{}
+
+	a := New(&config.Config{}, nil, WithDeps(deps))
+	a.sigClient = sig
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.startNetworkChangeMonitor(ctx)
+
+	// A burst of changes while joining a network reconnects once.
+	fakes.Network.events <- tunnel.NetworkEvent{Kind: tunnel.AddressChanged, IfIndex: 2, Addr: netip.MustParseAddr("fe80::1")}
+	fakes.Network.events <- tunnel.NetworkEvent{Kind: tunnel.DefaultRouteChanged, IfIndex: 2}
+	fakes.Network.events <- tunnel.NetworkEvent{Kind: tunnel.AddressChanged, IfIndex: 3, Addr: netip.MustParseAddr("192.168.5.20")}
+	fakes.Network.events <- tunnel.NetworkEvent{Kind: tunnel.DefaultRouteChanged, IfIndex: 3}
+
+	waitFor(t, 5*time.Second, "signaling reconnect", func() bool {
+		return sig.reconnectCount() > 0
+	})
+	time.Sleep(networkSettleDelay)
+	if got := sig.reconnectCount(); got != 1 {
+		t.Errorf("reconnects = %d, want 1", got)
+	}
+}
+
+func TestAgent_WithNetwork(t *testing.T) {
+	t.Parallel()
+
+	deps, _ := newTestDeps()
+	a := New(&config.Config{}, nil, WithDeps(deps), WithNetwork("client"))
+
+	tunDev, err := a.createTUNDevice()
+	if err != nil {
+		t.Fatalf("createTUNDevice() error: %v", err)
+	}
+	defer func() { _ = tunDev.Close() }()
+	if want := tunnel.NetworkTUNName("client"); a.tunName != want {
+		t.Errorf("TUN name = %q, want %q", a.tunName, want)
+	}
+	if got := a.Status().Network; got != "client" {
+		t.Errorf("Status().Network = %q, want client", got)
+	}
+}
+
+func TestAdvertiseExcluded(t *testing.T) {
+	t.Parallel()
+
+	exclude := []string{"172.17.0.0/16", "vlan*", "fd00::/8"}
+	tests := []struct {
+		name   string
+		subnet tunnel.SubnetInfo
+		want   bool
+	}{
+		{"lan", tunnel.SubnetInfo{CIDR: "192.168.1.0/24", Interface: "eth0"}, false},
+		{"inside excluded CIDR", tunnel.SubnetInfo{CIDR: "172.17.2.0/24", Interface: "docker0"}, true},
+		{"equal to excluded CIDR", tunnel.SubnetInfo{CIDR: "172.17.0.0/16", Interface: "docker0"}, true},
+		{"wider than excluded CIDR", tunnel.SubnetInfo{CIDR: "172.16.0.0/12", Interface: "br0"}, false},
+		{"interface pattern", tunnel.SubnetInfo{CIDR: "10.20.0.0/24", Interface: "vlan20"}, true},
+		{"IPv6 inside excluded CIDR", tunnel.SubnetInfo{CIDR: "fd12:3456::/64", Interface: "eth0"}, true},
+		{"IPv6 global", tunnel.SubnetInfo{CIDR: "2001:db8::/64", Interface: "eth0"}, false},
+		{"invalid CIDR", tunnel.SubnetInfo{CIDR: "bogus", Interface: "eth0"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := advertiseExcluded(tt.subnet, exclude); got != tt.want {
+				t.Errorf("advertiseExcluded(%+v) = %v, want %v", tt.subnet, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgent_RouteAdvertisement(t *testing.T) {
+	t.Parallel()
+
+	deps, fakes := newTestDeps()
+	fakes.Network.subnets["192.168.1.0/24"] = "eth0"
+	fakes.Network.subnets["10.50.0.0/24"] = "eth1"
+	fakes.Network.setDiscovered(
+		tunnel.SubnetInfo{CIDR: "192.168.1.0/24", Interface: "eth0"},
+		tunnel.SubnetInfo{CIDR: "172.17.0.0/16", Interface: "docker0"},
+		tunnel.SubnetInfo{CIDR: "10.0.0.0/24", Interface: "bamgate0"},
+	)
+	sig := &fakeSignalingClient{}
+
+	cfg := &config.Config{Device: config.DeviceConfig{
+		Address:          "10.0.0.1/24",
+		AdvertiseRoutes:  "auto",
+		AdvertiseExclude: []string{"docker*"},
+	}}
+	a := New(cfg, nil, WithDeps(deps))
+	a.sigClient = sig
+	a.tunName = "bamgate0"
+	ctx := context.Background()
+
+	a.refreshAdvertisedRoutes(ctx)
+	if got, want := a.advertisedRoutes(), []string{"192.168.1.0/24"}; !slices.Equal(got, want) {
+		t.Fatalf("advertised routes = %v, want %v", got, want)
+	}
+	if got := sig.updates(); len(got) != 1 {
+		t.Fatalf("signaling updates = %v, want 1", got)
+	}
+	if want := []masqueradeEntry{{wgSubnet: "10.0.0.1/24", outIface: "eth0"}}; !slices.Equal(fakes.NAT.rules, want) {
+		t.Errorf("masquerade rules = %v, want %v", fakes.NAT.rules, want)
+	}
+
+	// Unchanged subnets are not re-announced.
+	a.refreshAdvertisedRoutes(ctx)
+	if got := sig.updates(); len(got) != 1 {
+		t.Errorf("signaling updates after no change = %d, want 1", len(got))
+	}
+
+	// The LAN moved to another interface: the stale rule is dropped.
+	fakes.Network.setDiscovered(tunnel.SubnetInfo{CIDR: "10.50.0.0/24", Interface: "eth1"})
+	a.refreshAdvertisedRoutes(ctx)
+	if got, want := a.advertisedRoutes(), []string{"10.50.0.0/24"}; !slices.Equal(got, want) {
+		t.Fatalf("advertised routes = %v, want %v", got, want)
+	}
+	if got := sig.updates(); len(got) != 2 || !slices.Equal(got[1], []string{"10.50.0.0/24"}) {
+		t.Errorf("signaling updates = %v, want the new routes announced", got)
+	}
+	if want := []masqueradeEntry{{wgSubnet: "10.0.0.1/24", outIface: "eth1"}}; !slices.Equal(fakes.NAT.rules, want) {
+		t.Errorf("masquerade rules = %v, want %v", fakes.NAT.rules, want)
+	}
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/kuuji/bamgate/internal/capture"
+	"github.com/kuuji/bamgate/internal/control"
+)
+
+// Capture records tunnel traffic selected by req to w as pcapng, for
+// "bamgate capture" and the mobile bindings. It returns when ctx is
+// cancelled or a limit in req is reached, which are not errors. An
+// invalid request fails before anything is written.
+func (a *Agent) Capture(ctx context.Context, req control.CaptureRequest, w io.Writer) error {
+	if a.captureTap == nil {
+		return fmt.Errorf("tunnel is not running")
+	}
+
+	filter, err := capture.ParseFilter(req.Filter)
+	if err != nil {
+		return err
+	}
+	if req.Peer != "" {
+		a.mu.Lock()
+		_, known := a.peers[req.Peer]
+		a.mu.Unlock()
+		if !known {
+			return fmt.Errorf("unknown peer %q", req.Peer)
+		}
+	}
+
+	_, err = a.captureTap.Capture(ctx, w, capture.Options{
+		Peer:       req.Peer,
+		Filter:     filter,
+		Encrypted:  req.Encrypted,
+		SnapLen:    req.SnapLen,
+		MaxPackets: req.MaxPackets,
+		MaxBytes:   req.MaxBytes,
+		Duration:   req.Duration,
+	})
+	if errors.Is(err, capture.ErrLimitReached) || ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"log/slog"
+	"time"
 
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/tun"
@@ -20,6 +21,7 @@ type SignalingClient interface {
 	Send(ctx context.Context, msg protocol.Message) error
 	Messages() <-chan protocol.Message
 	ForceReconnect()
+	Update(ctx context.Context, routes []string, metadata map[string]string) error
 	Close() error
 }
 
@@ -27,6 +29,7 @@ type SignalingClient interface {
 type WireGuardDevice interface {
 	AddPeer(peer tunnel.PeerConfig) error
 	RemovePeer(publicKey config.Key) error
+	LastHandshakes() (map[config.Key]time.Time, error)
 	Close()
 }
 
@@ -38,16 +41,26 @@ type NetworkManager interface {
 	SetLinkUp(ifName string) error
 	AddRoute(ifName string, cidr string) error
 	RemoveRoute(ifName string, cidr string) error
+	SetRouteMTU(ifName string, cidr string, mtu int) error
 	GetForwarding(ifName string) (bool, error)
 	SetForwarding(ifName string, enabled bool) error
+	GetIPv6Forwarding() (bool, error)
+	SetIPv6Forwarding(enabled bool) error
 	FindInterfaceForSubnet(cidr string) (string, error)
-	SetDNS(ifName string, servers []string, searchDomains []string) error
-	RevertDNS(ifName string) error
+	LocalRoutes(excludeIface string) ([]tunnel.SubnetInfo, error)
+	DiscoverLocalSubnets(excludeCIDRs ...string) ([]tunnel.SubnetInfo, error)
+	SetDNS(ifName string, backend tunnel.DNSBackend, servers []string, searchDomains []string) error
+	RevertDNS(ifName string, backend tunnel.DNSBackend) error
+	RecoverDNS(ifName string, backend tunnel.DNSBackend) error
+	WatchNetwork(ctx context.Context) (<-chan tunnel.NetworkEvent, error)
 }
 
 // NATSetup abstracts nftables/PF NAT management for testability.
 type NATSetup interface {
 	SetupMasquerade(wgSubnet string, outIface string) error
+	SetNetmaps(tunIface string, rules []tunnel.NetmapRule) error
+	SetPortForwards(tunIface string, rules []tunnel.PortForwardRule) error
+	SetMSSClamp(tunIface string, mtu int) error
 	TableExists() bool
 	Cleanup() error
 }
@@ -61,6 +74,8 @@ type AuthRefresher interface {
 type ConfigPersister interface {
 	SaveSecrets(path string, cfg *config.Config) error
 	SaveConfig(path string, cfg *config.Config) error
+	LoadPublicConfig(path string) (*config.Config, error)
+	LoadConfig(path string) (*config.Config, error)
 	MarshalTOML(cfg *config.Config) (string, error)
 }
 
@@ -124,6 +139,10 @@ func (r *realNetworkManager) RemoveRoute(ifName string, cidr string) error {
 	return tunnel.RemoveRoute(ifName, cidr)
 }
 
+func (r *realNetworkManager) SetRouteMTU(ifName string, cidr string, mtu int) error {
+	return tunnel.SetRouteMTU(ifName, cidr, mtu)
+}
+
 func (r *realNetworkManager) GetForwarding(ifName string) (bool, error) {
 	return tunnel.GetForwarding(ifName)
 }
@@ -132,16 +151,40 @@ func (r *realNetworkManager) SetForwarding(ifName string, enabled bool) error {
 	return tunnel.SetForwarding(ifName, enabled)
 }
 
+func (r *realNetworkManager) GetIPv6Forwarding() (bool, error) {
+	return tunnel.GetIPv6Forwarding()
+}
+
+func (r *realNetworkManager) SetIPv6Forwarding(enabled bool) error {
+	return tunnel.SetIPv6Forwarding(enabled)
+}
+
 func (r *realNetworkManager) FindInterfaceForSubnet(cidr string) (string, error) {
 	return tunnel.FindInterfaceForSubnet(cidr)
 }
 
-func (r *realNetworkManager) SetDNS(ifName string, servers []string, searchDomains []string) error {
-	return tunnel.SetDNS(ifName, servers, searchDomains)
+func (r *realNetworkManager) LocalRoutes(excludeIface string) ([]tunnel.SubnetInfo, error) {
+	return tunnel.LocalRoutes(excludeIface)
+}
+
+func (r *realNetworkManager) DiscoverLocalSubnets(excludeCIDRs ...string) ([]tunnel.SubnetInfo, error) {
+	return tunnel.DiscoverLocalSubnets(excludeCIDRs...)
+}
+
+func (r *realNetworkManager) SetDNS(ifName string, backend tunnel.DNSBackend, servers []string, searchDomains []string) error {
+	return tunnel.SetDNS(ifName, backend, servers, searchDomains)
+}
+
+func (r *realNetworkManager) RevertDNS(ifName string, backend tunnel.DNSBackend) error {
+	return tunnel.RevertDNS(ifName, backend)
+}
+
+func (r *realNetworkManager) WatchNetwork(ctx context.Context) (<-chan tunnel.NetworkEvent, error) {
+	return tunnel.WatchNetwork(ctx)
 }
 
-func (r *realNetworkManager) RevertDNS(ifName string) error {
-	return tunnel.RevertDNS(ifName)
+func (r *realNetworkManager) RecoverDNS(ifName string, backend tunnel.DNSBackend) error {
+	return tunnel.RecoverDNS(ifName, backend)
 }
 
 type realAuthRefresher struct{}
@@ -160,6 +203,14 @@ func (r *realConfigPersister) SaveConfig(path string, cfg *config.Config) error
 	return config.SaveConfig(path, cfg)
 }
 
+func (r *realConfigPersister) LoadPublicConfig(path string) (*config.Config, error) {
+	return config.LoadPublicConfig(path)
+}
+
+func (r *realConfigPersister) LoadConfig(path string) (*config.Config, error) {
+	return config.LoadConfig(path)
+}
+
 func (r *realConfigPersister) MarshalTOML(cfg *config.Config) (string, error) {
 	return config.MarshalTOML(cfg)
 }
@@ -0,0 +1,11 @@
+package agent
+
+import "github.com/kuuji/bamgate/internal/control"
+
+// SubscribeEvents streams the agent's events (peers coming and going, ICE
+// state, routes, DNS, token refreshes, signaling reconnects and forwarding
+// repairs) until cancel is called. It may be called before Run, so callers
+// see the first peers being discovered.
+func (a *Agent) SubscribeEvents() (<-chan control.Event, func()) {
+	return a.events.Subscribe()
+}
@@ -0,0 +1,286 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+const (
+	// routeHealthInterval is how often the agent checks the health of
+	// peers carrying routes that other peers also advertise.
+	routeHealthInterval = 10 * time.Second
+
+	// routeHandshakeTimeout is how long a route's primary may go without a
+	// WireGuard handshake before a standby peer takes over. Persistent
+	// keepalives keep traffic flowing, so a healthy peer rekeys every two
+	// minutes; after three (WireGuard's RejectAfterTime) its session is dead.
+	routeHandshakeTimeout = 3 * time.Minute
+)
+
+// routeCandidate is a connected peer offering an accepted route.
+type routeCandidate struct {
+	peerID   string
+	priority int  // peers.<name>.route_priority
+	healthy  bool // ICE connected and handshaking
+}
+
+// pickRouteOwner chooses which candidate carries a route. The
+// highest-priority healthy candidate wins; on a tie the current owner keeps
+// the route so connections are not moved needlessly, otherwise the lowest
+// peer ID wins so every device decides the same way. If no candidate is
+// healthy the current owner is kept, since moving the route would not help.
+func pickRouteOwner(candidates []routeCandidate, current string) string {
+	best := -1
+	for i, c := range candidates {
+		if !c.healthy {
+			continue
+		}
+		if best < 0 || betterRouteCandidate(c, candidates[best], current) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return candidates[best].peerID
+	}
+
+	for i, c := range candidates {
+		if c.peerID == current {
+			return current
+		}
+		if best < 0 || betterRouteCandidate(c, candidates[best], current) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return candidates[best].peerID
+}
+
+// betterRouteCandidate reports whether c should be preferred over other.
+func betterRouteCandidate(c, other routeCandidate, current string) bool {
+	if c.priority != other.priority {
+		return c.priority > other.priority
+	}
+	if c.peerID == current || other.peerID == current {
+		return c.peerID == current
+	}
+	return c.peerID < other.peerID
+}
+
+// rebalanceRoutes assigns each accepted route to exactly one peer. Several
+// peers may advertise the same route (e.g. two gateways at one site), but
+// WireGuard can only route a prefix to one of them: adding it to a second
+// peer's AllowedIPs silently removes it from the first. The chosen primary
+// carries the route in its AllowedIPs; the others are standby.
+//
+// It updates the AllowedIPs of every peer whose set of carried routes
+// changed, plus updatePeer (a peer just added), and adds or removes kernel
+// routes for routes that gained their first or lost their last candidate.
+// It is called when a peer connects or leaves and periodically by the route
+// health check, which moves routes away from primaries that stop
+// handshaking.
+func (a *Agent) rebalanceRoutes(updatePeer string) {
+	a.routesMu.Lock()
+	defer a.routesMu.Unlock()
+
+	handshakes := a.lastHandshakes()
+	now := time.Now()
+
+	a.mu.Lock()
+	candidates := make(map[string][]routeCandidate)
+	for id, ps := range a.peers {
+		if ps.tunnelAllowedIPs == nil {
+			continue // WireGuard peer not added yet
+		}
+		c := routeCandidate{
+			peerID:   id,
+			priority: a.routePriority(id),
+			healthy:  peerHealthy(ps, handshakes, now),
+		}
+		for _, route := range ps.installedRoutes {
+			candidates[route] = append(candidates[route], c)
+		}
+	}
+
+	old := a.routeOwners
+	owners := make(map[string]string, len(candidates))
+	for route, cs := range candidates {
+		owners[route] = pickRouteOwner(cs, old[route])
+	}
+
+	changed := make(map[string]bool)
+	if updatePeer != "" {
+		changed[updatePeer] = true
+	}
+	for route, owner := range owners {
+		if prev := old[route]; prev != owner {
+			changed[owner] = true
+			changed[prev] = true
+		}
+	}
+	for route, prev := range old {
+		if _, ok := owners[route]; !ok {
+			changed[prev] = true
+		}
+	}
+
+	var updates []tunnel.PeerConfig
+	for id := range changed {
+		ps, ok := a.peers[id]
+		if !ok || ps.tunnelAllowedIPs == nil {
+			continue // removed peers are torn down by removePeer
+		}
+		allowedIPs := slices.Concat(ps.tunnelAllowedIPs, a.relayedIPs(id))
+		for _, route := range ps.installedRoutes {
+			if owners[route] == id {
+				allowedIPs = append(allowedIPs, route)
+			}
+		}
+		updates = append(updates, tunnel.PeerConfig{
+			PublicKey:           ps.publicKey,
+			Endpoint:            id,
+			AllowedIPs:          allowedIPs,
+			PersistentKeepalive: a.persistentKeepalive(),
+		})
+	}
+	a.routeOwners = owners
+	a.mu.Unlock()
+
+	for route, owner := range owners {
+		if prev := old[route]; prev != "" && prev != owner {
+			a.log.Warn("moving route to another peer", "route", route, "from", prev, "to", owner)
+		}
+	}
+
+	// Packets from moved routes are now attributed to their new owner.
+	a.updateACL()
+
+	// Peers gaining a route take it from the previous owner as soon as
+	// their AllowedIPs are set, so the order of updates does not matter.
+	for _, peerCfg := range updates {
+		a.log.Info("using peer-specific AllowedIPs",
+			"peer_id", peerCfg.Endpoint, "allowed_ips", peerCfg.AllowedIPs)
+		if err := a.wgDevice.AddPeer(peerCfg); err != nil {
+			a.log.Error("adding WireGuard peer", "peer_id", peerCfg.Endpoint, "error", err)
+		}
+	}
+
+	// Add kernel routes for newly accepted subnets so the kernel directs
+	// matching traffic into the TUN interface, and remove routes no
+	// connected peer offers any more.
+	for _, route := range slices.Sorted(maps.Keys(owners)) {
+		owner := owners[route]
+		if _, ok := old[route]; ok || a.isTunnelSubnet(route) {
+			continue
+		}
+		a.journalRoute(route, true)
+		if err := a.deps.Network.AddRoute(a.tunName, route); err != nil {
+			a.log.Warn("adding route for peer", "peer_id", owner, "route", route, "error", err)
+			a.journalRoute(route, false)
+		} else {
+			a.log.Info("added route", "peer_id", owner, "route", route, "dev", a.tunName)
+			a.events.Publish(control.Event{Type: control.EventRouteAdded, Peer: owner, Route: route})
+		}
+	}
+	for _, route := range slices.Sorted(maps.Keys(old)) {
+		prev := old[route]
+		if _, ok := owners[route]; ok || a.isTunnelSubnet(route) {
+			continue
+		}
+		if err := a.deps.Network.RemoveRoute(a.tunName, route); err != nil {
+			a.log.Warn("removing route for peer", "peer_id", prev, "route", route, "error", err)
+		} else {
+			a.journalRoute(route, false)
+			a.log.Info("removed route", "peer_id", prev, "route", route, "dev", a.tunName)
+			a.events.Publish(control.Event{Type: control.EventRouteRemoved, Peer: prev, Route: route})
+		}
+	}
+
+	a.syncRouteMTUs(owners)
+}
+
+// lastHandshakes returns WireGuard handshake times when some route has more
+// than one candidate, i.e. when health decides anything. It returns nil
+// otherwise or if the device cannot be queried; peerHealthy then relies on
+// the ICE state alone.
+func (a *Agent) lastHandshakes() map[config.Key]time.Time {
+	a.mu.Lock()
+	seen := make(map[string]bool)
+	shared := false
+	for _, ps := range a.peers {
+		for _, route := range ps.installedRoutes {
+			shared = shared || seen[route]
+			seen[route] = true
+		}
+	}
+	a.mu.Unlock()
+
+	if !shared || a.wgDevice == nil {
+		return nil
+	}
+	handshakes, err := a.wgDevice.LastHandshakes()
+	if err != nil {
+		a.log.Debug("reading WireGuard handshakes for route health", "error", err)
+		return nil
+	}
+	return handshakes
+}
+
+// peerHealthy reports whether a peer can carry routes: its ICE connection
+// is up and, if handshakes are known, it has completed a WireGuard
+// handshake within routeHandshakeTimeout (or connected recently enough that
+// the first one may still be pending).
+func peerHealthy(ps *peerState, handshakes map[config.Key]time.Time, now time.Time) bool {
+	if ps.rtcPeer != nil {
+		switch ps.rtcPeer.ConnectionState() {
+		case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		default:
+			return false
+		}
+	}
+	if handshakes == nil {
+		return true
+	}
+	last := handshakes[ps.publicKey]
+	if last.IsZero() {
+		last = ps.connectedAt
+	}
+	return now.Sub(last) < routeHandshakeTimeout
+}
+
+// routePriority returns the configured route priority for a peer.
+func (a *Agent) routePriority(peerID string) int {
+	sel, _ := a.cfg.PeerSelection(peerID)
+	return sel.RoutePriority
+}
+
+// startRouteHealthCheck periodically rebalances routes so a primary that
+// stops handshaking hands its routes to a standby peer, and a recovered
+// higher-priority peer takes them back. Path MTUs are refreshed first, so
+// route MTUs follow peers moving between direct and relayed paths.
+//
+// The goroutine is owned by Run() and exits when ctx is cancelled.
+func (a *Agent) startRouteHealthCheck(ctx context.Context) {
+	ticker := time.NewTicker(routeHealthInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.refreshPathMTUs()
+				a.rebalanceRoutes("")
+			}
+		}
+	}()
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/netip"
 	"os"
+	"slices"
 	"sync"
+	"time"
 
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/tun"
@@ -13,6 +17,7 @@ import (
 	"github.com/kuuji/bamgate/internal/auth"
 	"github.com/kuuji/bamgate/internal/config"
 	"github.com/kuuji/bamgate/internal/tunnel"
+	"github.com/kuuji/bamgate/pkg/protocol"
 )
 
 // --- Fake TUN device ---
@@ -69,9 +74,10 @@ func (f *fakeTUNDevice) Close() error {
 // fakeWireGuardDevice records AddPeer/RemovePeer calls without actually
 // creating a WireGuard device. Thread-safe.
 type fakeWireGuardDevice struct {
-	mu     sync.Mutex
-	peers  map[string]tunnel.PeerConfig // publicKey.String() -> config
-	closed bool
+	mu         sync.Mutex
+	peers      map[string]tunnel.PeerConfig // publicKey.String() -> config
+	handshakes map[config.Key]time.Time     // returned by LastHandshakes
+	closed     bool
 }
 
 func newFakeWireGuardDevice() *fakeWireGuardDevice {
@@ -94,6 +100,12 @@ func (f *fakeWireGuardDevice) RemovePeer(publicKey config.Key) error {
 	return nil
 }
 
+func (f *fakeWireGuardDevice) LastHandshakes() (map[config.Key]time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.handshakes), nil
+}
+
 func (f *fakeWireGuardDevice) Close() {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -117,14 +129,24 @@ func (f *fakeWireGuardDevice) peerCount() int {
 
 // fakeNetworkManager records all network operations without touching the kernel.
 type fakeNetworkManager struct {
-	mu         sync.Mutex
-	addresses  map[string]string   // ifName -> cidr
-	linksUp    map[string]bool     // ifName -> true
-	routes     map[string][]string // ifName -> list of cidrs
-	forwarding map[string]bool     // ifName -> enabled
-	dns        map[string][]string // ifName -> servers
-	dnsSearch  map[string][]string // ifName -> search domains
-	subnets    map[string]string   // cidr -> ifName (for FindInterfaceForSubnet)
+	mu          sync.Mutex
+	addresses   map[string]string   // ifName -> cidr
+	linksUp     map[string]bool     // ifName -> true
+	routes      map[string][]string // ifName -> list of cidrs
+	routeMTUs   map[string]int      // cidr -> mtu (from SetRouteMTU)
+	forwarding  map[string]bool     // ifName -> enabled
+	forwarding6 bool                // global IPv6 forwarding
+	dns         map[string][]string // ifName -> servers
+	dnsSearch   map[string][]string // ifName -> search domains
+	dnsBackend  tunnel.DNSBackend   // backend passed to the last SetDNS
+	recovered   []string            // ifNames passed to RecoverDNS
+	subnets     map[string]string   // cidr -> ifName (for FindInterfaceForSubnet)
+	localRoutes []tunnel.SubnetInfo // returned by LocalRoutes
+	discovered  []tunnel.SubnetInfo // returned by DiscoverLocalSubnets
+
+	// events is returned by WatchNetwork; nil means notifications are
+	// unsupported.
+	events chan tunnel.NetworkEvent
 }
 
 func newFakeNetworkManager() *fakeNetworkManager {
@@ -132,6 +154,7 @@ func newFakeNetworkManager() *fakeNetworkManager {
 		addresses:  make(map[string]string),
 		linksUp:    make(map[string]bool),
 		routes:     make(map[string][]string),
+		routeMTUs:  make(map[string]int),
 		forwarding: make(map[string]bool),
 		dns:        make(map[string][]string),
 		dnsSearch:  make(map[string][]string),
@@ -173,6 +196,13 @@ func (f *fakeNetworkManager) RemoveRoute(ifName string, cidr string) error {
 	return nil
 }
 
+func (f *fakeNetworkManager) SetRouteMTU(_ string, cidr string, mtu int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routeMTUs[cidr] = mtu
+	return nil
+}
+
 func (f *fakeNetworkManager) GetForwarding(ifName string) (bool, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -186,6 +216,26 @@ func (f *fakeNetworkManager) SetForwarding(ifName string, enabled bool) error {
 	return nil
 }
 
+func (f *fakeNetworkManager) GetIPv6Forwarding() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.forwarding6, nil
+}
+
+func (f *fakeNetworkManager) SetIPv6Forwarding(enabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.forwarding6 = enabled
+	return nil
+}
+
+func (f *fakeNetworkManager) WatchNetwork(_ context.Context) (<-chan tunnel.NetworkEvent, error) {
+	if f.events == nil {
+		return nil, tunnel.ErrWatchUnsupported
+	}
+	return f.events, nil
+}
+
 func (f *fakeNetworkManager) FindInterfaceForSubnet(cidr string) (string, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -195,15 +245,53 @@ func (f *fakeNetworkManager) FindInterfaceForSubnet(cidr string) (string, error)
 	return "", fmt.Errorf("no interface for subnet %s", cidr)
 }
 
-func (f *fakeNetworkManager) SetDNS(ifName string, servers []string, searchDomains []string) error {
+func (f *fakeNetworkManager) DiscoverLocalSubnets(excludeCIDRs ...string) ([]tunnel.SubnetInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Like the real implementation, an excluded address excludes its subnet.
+	var excluded []string
+	for _, c := range excludeCIDRs {
+		if p, err := netip.ParsePrefix(c); err == nil {
+			excluded = append(excluded, p.Masked().String())
+		}
+	}
+	var subnets []tunnel.SubnetInfo
+	for _, s := range f.discovered {
+		if !slices.Contains(excluded, s.CIDR) {
+			subnets = append(subnets, s)
+		}
+	}
+	return subnets, nil
+}
+
+func (f *fakeNetworkManager) setDiscovered(subnets ...tunnel.SubnetInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.discovered = subnets
+}
+
+func (f *fakeNetworkManager) LocalRoutes(excludeIface string) ([]tunnel.SubnetInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var routes []tunnel.SubnetInfo
+	for _, r := range f.localRoutes {
+		if r.Interface != excludeIface {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+func (f *fakeNetworkManager) SetDNS(ifName string, backend tunnel.DNSBackend, servers []string, searchDomains []string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.dnsBackend = backend
 	f.dns[ifName] = servers
 	f.dnsSearch[ifName] = searchDomains
 	return nil
 }
 
-func (f *fakeNetworkManager) RevertDNS(ifName string) error {
+func (f *fakeNetworkManager) RevertDNS(ifName string, _ tunnel.DNSBackend) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	delete(f.dns, ifName)
@@ -211,13 +299,23 @@ func (f *fakeNetworkManager) RevertDNS(ifName string) error {
 	return nil
 }
 
+func (f *fakeNetworkManager) RecoverDNS(ifName string, _ tunnel.DNSBackend) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recovered = append(f.recovered, ifName)
+	return nil
+}
+
 // --- Fake NAT setup ---
 
 // fakeNATSetup records masquerade calls without touching nftables.
 type fakeNATSetup struct {
-	mu          sync.Mutex
-	rules       []masqueradeEntry
-	tableExists bool
+	mu           sync.Mutex
+	rules        []masqueradeEntry
+	netmaps      []tunnel.NetmapRule
+	portForwards []tunnel.PortForwardRule
+	mssClamp     string // TUN interface passed to SetMSSClamp
+	tableExists  bool
 }
 
 func newFakeNATSetup() *fakeNATSetup {
@@ -231,6 +329,27 @@ func (f *fakeNATSetup) SetupMasquerade(wgSubnet string, outIface string) error {
 	return nil
 }
 
+func (f *fakeNATSetup) SetNetmaps(_ string, rules []tunnel.NetmapRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.netmaps = rules
+	return nil
+}
+
+func (f *fakeNATSetup) SetPortForwards(_ string, rules []tunnel.PortForwardRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.portForwards = rules
+	return nil
+}
+
+func (f *fakeNATSetup) SetMSSClamp(tunIface string, _ int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mssClamp = tunIface
+	return nil
+}
+
 func (f *fakeNATSetup) TableExists() bool {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -241,6 +360,7 @@ func (f *fakeNATSetup) Cleanup() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.rules = nil
+	f.mssClamp = ""
 	return nil
 }
 
@@ -288,6 +408,7 @@ type fakeConfigPersister struct {
 	savedSecrets    int
 	savedConfigs    int
 	lastSavedConfig *config.Config
+	loadConfig      *config.Config // returned by LoadPublicConfig and LoadConfig
 }
 
 func (f *fakeConfigPersister) SaveSecrets(_ string, cfg *config.Config) error {
@@ -306,6 +427,19 @@ func (f *fakeConfigPersister) SaveConfig(_ string, cfg *config.Config) error {
 	return nil
 }
 
+func (f *fakeConfigPersister) LoadPublicConfig(path string) (*config.Config, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.loadConfig == nil {
+		return nil, fmt.Errorf("config file not found: %s", path)
+	}
+	return f.loadConfig, nil
+}
+
+func (f *fakeConfigPersister) LoadConfig(path string) (*config.Config, error) {
+	return f.LoadPublicConfig(path)
+}
+
 func (f *fakeConfigPersister) MarshalTOML(cfg *config.Config) (string, error) {
 	return config.MarshalTOML(cfg)
 }
@@ -350,6 +484,49 @@ func (f *fakeWireGuardProvider) getDevice() *fakeWireGuardDevice {
 	return f.device
 }
 
+// --- Fake Signaling Client ---
+
+// fakeSignalingClient implements SignalingClient for unit tests that do not
+// need a hub. It records forced reconnects and route updates.
+type fakeSignalingClient struct {
+	mu         sync.Mutex
+	reconnects int
+	routes     [][]string // routes passed to each Update
+}
+
+func (f *fakeSignalingClient) Connect(_ context.Context) error { return nil }
+
+func (f *fakeSignalingClient) Send(_ context.Context, _ protocol.Message) error { return nil }
+
+func (f *fakeSignalingClient) Messages() <-chan protocol.Message { return nil }
+
+func (f *fakeSignalingClient) ForceReconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reconnects++
+}
+
+func (f *fakeSignalingClient) Update(_ context.Context, routes []string, _ map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes = append(f.routes, routes)
+	return nil
+}
+
+func (f *fakeSignalingClient) Close() error { return nil }
+
+func (f *fakeSignalingClient) updates() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.routes)
+}
+
+func (f *fakeSignalingClient) reconnectCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reconnects
+}
+
 // --- Test helpers ---
 
 // testDeps returns a Deps with all fakes pre-wired. The returned struct
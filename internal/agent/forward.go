@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/forward"
+)
+
+// activeForward is a port forward running in the agent.
+type activeForward struct {
+	spec control.Forward
+	fwd  *forward.Forward
+
+	// saved is the config entry a persistent forward was started from or
+	// saved as, removed from the config with the forward.
+	saved config.ForwardConfig
+}
+
+// AddForward starts a port forward from a control request and, if it is
+// persistent, saves it to the config file.
+func (a *Agent) AddForward(req control.Forward) (control.Forward, error) {
+	spec, err := a.startForward(req)
+	if err != nil {
+		return control.Forward{}, err
+	}
+	if !spec.Persistent {
+		return spec, nil
+	}
+
+	a.forwardsMu.Lock()
+	defer a.forwardsMu.Unlock()
+	saved := config.ForwardConfig{
+		Reverse: spec.Reverse,
+		Listen:  spec.Listen,
+		Target:  spec.Target,
+	}
+	if spec.Protocol != "tcp" {
+		saved.Protocol = spec.Protocol
+	}
+	if af, ok := a.forwards[spec.ID]; ok {
+		af.saved = saved
+	}
+	a.cfg.Forwards = append(a.cfg.Forwards, saved)
+	if err := a.saveConfig(func(cfg *config.Config) { cfg.Forwards = append(cfg.Forwards, saved) }); err != nil {
+		return spec, fmt.Errorf("forward started but not saved: %w", err)
+	}
+	return spec, nil
+}
+
+// RemoveForward stops a port forward and, if it is persistent, removes it
+// from the config file.
+func (a *Agent) RemoveForward(id string) error {
+	a.forwardsMu.Lock()
+	defer a.forwardsMu.Unlock()
+
+	af, ok := a.forwards[id]
+	if !ok {
+		return fmt.Errorf("no forward with ID %q", id)
+	}
+	delete(a.forwards, id)
+	_ = af.fwd.Close()
+	a.log.Info("port forward removed", "id", id, "listen", af.spec.Listen, "target", af.spec.Target)
+
+	if !af.spec.Persistent {
+		return nil
+	}
+	i := slices.Index(a.cfg.Forwards, af.saved)
+	if i < 0 {
+		return nil
+	}
+	a.cfg.Forwards = slices.Delete(a.cfg.Forwards, i, i+1)
+	err := a.saveConfig(func(cfg *config.Config) {
+		if i := slices.Index(cfg.Forwards, af.saved); i >= 0 {
+			cfg.Forwards = slices.Delete(cfg.Forwards, i, i+1)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("forward stopped but config not saved: %w", err)
+	}
+	return nil
+}
+
+// Forwards returns the running port forwards, ordered by ID.
+func (a *Agent) Forwards() []control.Forward {
+	a.forwardsMu.Lock()
+	defer a.forwardsMu.Unlock()
+
+	out := make([]control.Forward, 0, len(a.forwards))
+	for _, af := range a.forwards {
+		spec := af.spec
+		spec.Active = af.fwd.Active()
+		out = append(out, spec)
+	}
+	slices.SortFunc(out, func(x, y control.Forward) int {
+		xi, _ := strconv.Atoi(x.ID)
+		yi, _ := strconv.Atoi(y.ID)
+		return xi - yi
+	})
+	return out
+}
+
+// startConfiguredForwards starts the forwards declared in the config file.
+// Failures are logged and skipped so one bad entry does not stop the agent.
+func (a *Agent) startConfiguredForwards() {
+	for _, fc := range a.cfg.Forwards {
+		spec, err := a.startForward(control.Forward{
+			Protocol:   fc.Protocol,
+			Reverse:    fc.Reverse,
+			Listen:     fc.Listen,
+			Target:     fc.Target,
+			Persistent: true,
+		})
+		if err != nil {
+			a.log.Warn("starting configured port forward", "listen", fc.Listen, "target", fc.Target, "error", err)
+			continue
+		}
+		a.forwardsMu.Lock()
+		if af, ok := a.forwards[spec.ID]; ok {
+			af.saved = fc
+		}
+		a.forwardsMu.Unlock()
+	}
+}
+
+// closeForwards stops every port forward. Config entries are kept.
+func (a *Agent) closeForwards() {
+	a.forwardsMu.Lock()
+	defer a.forwardsMu.Unlock()
+	for id, af := range a.forwards {
+		_ = af.fwd.Close()
+		delete(a.forwards, id)
+	}
+}
+
+// startForward validates req, fills in defaults and starts the forward.
+func (a *Agent) startForward(req control.Forward) (control.Forward, error) {
+	spec, err := a.normalizeForward(req)
+	if err != nil {
+		return control.Forward{}, err
+	}
+
+	// A local forward listens on the host and dials into the mesh; a
+	// reverse forward listens on our tunnel address and dials the host.
+	listenNet, dialNet := forward.Host, a.meshNetwork()
+	if spec.Reverse {
+		listenNet, dialNet = a.meshNetwork(), forward.Host
+	}
+	fwd, err := forward.Start(spec.Protocol, spec.Listen, spec.Target, listenNet, dialNet, a.log)
+	if err != nil {
+		return control.Forward{}, err
+	}
+	// Report the bound address, which differs from the request for port 0.
+	spec.Listen = fwd.Addr().String()
+
+	a.forwardsMu.Lock()
+	a.nextForwardID++
+	spec.ID = strconv.Itoa(a.nextForwardID)
+	a.forwards[spec.ID] = &activeForward{spec: spec, fwd: fwd}
+	a.forwardsMu.Unlock()
+
+	a.log.Info("port forward started", "id", spec.ID, "protocol", spec.Protocol,
+		"reverse", spec.Reverse, "listen", spec.Listen, "target", spec.Target)
+	return spec, nil
+}
+
+// normalizeForward checks a forward request and fills in defaults: TCP,
+// and a listen host of 127.0.0.1 for local forwards or our tunnel address
+// for reverse forwards, so a bare port never listens on every interface.
+func (a *Agent) normalizeForward(req control.Forward) (control.Forward, error) {
+	switch req.Protocol {
+	case "":
+		req.Protocol = "tcp"
+	case "tcp", "udp":
+	default:
+		return req, fmt.Errorf("unsupported protocol %q (want tcp or udp)", req.Protocol)
+	}
+
+	host := "127.0.0.1"
+	if req.Reverse {
+		ip, _, err := net.ParseCIDR(a.cfg.Device.Address)
+		if err != nil {
+			return req, fmt.Errorf("device address %q: %w", a.cfg.Device.Address, err)
+		}
+		host = ip.String()
+	}
+	listen, err := listenAddress(req.Listen, host)
+	if err != nil {
+		return req, err
+	}
+	req.Listen = listen
+
+	if _, port, err := net.SplitHostPort(req.Target); err != nil || port == "" {
+		return req, fmt.Errorf("invalid target %q: want host:port", req.Target)
+	}
+	return req, nil
+}
+
+// listenAddress turns a listen spec ("host:port", ":port" or "port") into
+// "host:port", using defaultHost when the host is omitted.
+func listenAddress(listen, defaultHost string) (string, error) {
+	if !strings.Contains(listen, ":") {
+		listen = ":" + listen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid listen port %q", port)
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// meshNetwork returns the network peers are reached on: the userspace
+// stack, or the host (whose routes lead into the TUN device). Peer names
+// are accepted as hosts and dialed at their tunnel address.
+func (a *Agent) meshNetwork() forward.Network {
+	var base forward.Network = forward.Host
+	if a.opts.userspace != nil {
+		base = a.opts.userspace
+	}
+	return &peerNameNetwork{Network: base, agent: a}
+}
+
+// peerNameNetwork resolves peer names to tunnel addresses when dialing.
+type peerNameNetwork struct {
+	forward.Network
+	agent *Agent
+}
+
+func (n *peerNameNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := n.agent.peerIP(host); ip != "" {
+		address = net.JoinHostPort(ip, port)
+	}
+	return n.Network.DialContext(ctx, network, address)
+}
+
+// peerIP returns the tunnel address of the connected peer named name, or
+// "" if there is none.
+func (a *Agent) peerIP(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ps, ok := a.peers[name]
+	if !ok {
+		return ""
+	}
+	if ip := peerTunnelIP(ps, false); ip != "" {
+		return ip
+	}
+	return peerTunnelIP(ps, true)
+}
@@ -0,0 +1,334 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+// State journal. The agent changes kernel state that outlives it:
+// forwarding sysctls, its nftables table (PF anchor on macOS), routes on
+// the TUN interface and DNS settings. A clean shutdown undoes them; a
+// crash or SIGKILL does not. With WithStateJournal, each change is
+// recorded in a file before it is made and dropped once undone, so the
+// next run of the network, or "bamgate down", can undo whatever a run that
+// died left behind (RecoverState). The journal is the only place DNS
+// changes from a crashed run are recovered from.
+//
+// Journals live in a root-owned directory only the owner can write to
+// (stateDir). A journal names routes, rules and files to remove as root, so
+// one that anybody else could have written is refused rather than undone.
+
+// journalState is the kernel state recorded in a state journal.
+type journalState struct {
+	Network    string              `json:"network,omitempty"`
+	Interface  string              `json:"interface,omitempty"` // TUN interface
+	Forwarding []journalForwarding `json:"forwarding,omitempty"`
+	NAT        bool                `json:"nat,omitempty"` // nftables table or PF anchor in use
+	Masquerade []journalMasquerade `json:"masquerade,omitempty"`
+	Routes     []string            `json:"routes,omitempty"`
+	DNS        tunnel.DNSBackend   `json:"dns,omitempty"` // backend DNS was set with
+}
+
+// journalForwarding is forwarding the agent enabled, from a forwardingSave
+// whose previous state was disabled.
+type journalForwarding struct {
+	Interface string `json:"interface,omitempty"`
+	IPv6      bool   `json:"ipv6,omitempty"` // global IPv6 forwarding
+}
+
+// journalMasquerade is a masquerade rule, from a masqueradeEntry. It is
+// removed with the NAT table.
+type journalMasquerade struct {
+	Subnet    string `json:"subnet"`
+	Interface string `json:"interface"`
+}
+
+// empty reports whether s records nothing to undo.
+func (s *journalState) empty() bool {
+	return len(s.Forwarding) == 0 && !s.NAT && len(s.Masquerade) == 0 &&
+		len(s.Routes) == 0 && s.DNS == ""
+}
+
+// stateJournal keeps a journalState in a file, written on every change
+// and removed when there is nothing left to undo. A nil *stateJournal
+// records nothing. Its lock is never held while acquiring another.
+type stateJournal struct {
+	path string
+	log  *slog.Logger
+
+	mu    sync.Mutex
+	state journalState
+}
+
+// update applies fn to the journal and writes it out. Failing to write is
+// logged, not returned: the change itself still goes ahead.
+func (j *stateJournal) update(fn func(s *journalState)) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.state)
+	if err := j.save(); err != nil {
+		j.log.Warn("writing state journal", "path", j.path, "error", err)
+	}
+}
+
+// save writes the journal atomically, or removes it if it is empty. Must
+// be called with j.mu held.
+func (j *stateJournal) save() error {
+	if j.state.empty() {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(j.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(j.path)
+	if err := makePrivateDir(dir); err != nil {
+		return err
+	}
+	// CreateTemp opens with O_EXCL, so a planted symlink is never followed.
+	tmp, err := os.CreateTemp(dir, ".state-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// journalForwardingEnabled records forwarding about to be enabled.
+func (a *Agent) journalForwardingEnabled(s forwardingSave) {
+	f := journalForwarding{Interface: s.ifName, IPv6: s.ipv6}
+	a.journal.update(func(st *journalState) {
+		if !slices.Contains(st.Forwarding, f) {
+			st.Forwarding = append(st.Forwarding, f)
+		}
+	})
+}
+
+// journalRoute records a kernel route on the TUN interface about to be
+// added (added true) or just removed.
+func (a *Agent) journalRoute(route string, added bool) {
+	a.journal.update(func(st *journalState) {
+		st.Routes = slices.DeleteFunc(st.Routes, func(r string) bool { return r == route })
+		if added {
+			st.Routes = append(st.Routes, route)
+		}
+	})
+}
+
+// journalMasquerades records whether the NAT table is in use and the
+// masquerade rules in it. Must be called with a.natMu held once Run has
+// started its goroutines.
+func (a *Agent) journalMasquerades() {
+	rules := make([]journalMasquerade, 0, len(a.masqueradeRules))
+	for _, e := range a.masqueradeRules {
+		rules = append(rules, journalMasquerade{Subnet: e.wgSubnet, Interface: e.outIface})
+	}
+	a.journal.update(func(st *journalState) {
+		st.NAT = a.natManager != nil
+		st.Masquerade = rules
+	})
+}
+
+// journalDNS records that DNS is about to be set (set true) or was just
+// reverted.
+func (a *Agent) journalDNS(set bool) {
+	a.journal.update(func(st *journalState) {
+		st.DNS = ""
+		if set {
+			st.DNS = a.dnsBackend
+		}
+	})
+}
+
+// stateDir returns the directory state journals are kept in. Unlike the
+// control socket's directory, it never falls back to /tmp.
+func stateDir() string {
+	if runtime.GOOS == "darwin" {
+		return "/var/run/bamgate/state"
+	}
+	return "/run/bamgate/state"
+}
+
+// StatePath returns the state journal path for network, "" being the
+// default network: default.json, or <network>.json for named networks.
+func StatePath(network string) string {
+	name := "default"
+	if network != "" {
+		name = network
+	}
+	return filepath.Join(stateDir(), name+".json")
+}
+
+// makePrivateDir creates dir with mode 0700, its parents with 0755, and
+// checks that an existing dir is private (see checkPrivate).
+func makePrivateDir(dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	return checkPrivate(dir, info, true)
+}
+
+// checkPrivate returns an error unless info, from Lstat, is a directory
+// (dir true) or regular file owned by root or by us that neither group nor
+// others can write to. A directory must not be readable by them either.
+func checkPrivate(path string, info fs.FileInfo, dir bool) error {
+	switch {
+	case dir && !info.IsDir():
+		return fmt.Errorf("%s is not a directory", path)
+	case !dir && !info.Mode().IsRegular():
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int64(st.Uid) != int64(os.Geteuid()) {
+		return fmt.Errorf("%s is owned by uid %d", path, st.Uid)
+	}
+	mask := fs.FileMode(0o022)
+	if dir {
+		mask = 0o077
+	}
+	if perm := info.Mode().Perm(); perm&mask != 0 {
+		return fmt.Errorf("%s has mode %#o", path, perm)
+	}
+	return nil
+}
+
+// RecoverState undoes the kernel changes recorded in the state journal at
+// path by an agent that did not shut down cleanly, then removes the
+// journal. It does nothing if there is no journal. The agent of the
+// network must not be running.
+func RecoverState(path string, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return recoverState(path, DefaultDeps(), logger)
+}
+
+// recoverState is RecoverState with the given dependencies. A journal
+// that cannot be fully undone is kept, so a later attempt can finish. One
+// that is not private, or not in a private directory, is refused.
+func recoverState(path string, deps Deps, log *slog.Logger) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state journal: %w", err)
+	}
+	if err := checkPrivate(path, info, false); err != nil {
+		return fmt.Errorf("refusing state journal: %w", err)
+	}
+	dir := filepath.Dir(path)
+	dirInfo, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("reading state journal: %w", err)
+	}
+	if err := checkPrivate(dir, dirInfo, true); err != nil {
+		return fmt.Errorf("refusing state journal: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("reading state journal: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading state journal: %w", err)
+	}
+	var s journalState
+	if err := json.Unmarshal(data, &s); err != nil {
+		log.Warn("discarding unreadable state journal", "path", path, "error", err)
+		return os.Remove(path)
+	}
+
+	log.Info("undoing kernel changes left by a previous run", "path", path, "interface", s.Interface)
+	if err := undoJournal(s, deps, log); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing state journal: %w", err)
+	}
+	return nil
+}
+
+// undoJournal undoes the kernel changes recorded in s.
+func undoJournal(s journalState, deps Deps, log *slog.Logger) error {
+	var errs []error
+
+	// Routes usually went away with the TUN interface.
+	for _, route := range s.Routes {
+		if err := deps.Network.RemoveRoute(s.Interface, route); err != nil {
+			log.Debug("removing leftover route (may be gone with the interface)",
+				"route", route, "dev", s.Interface, "error", err)
+		} else {
+			log.Info("removed leftover route", "route", route, "dev", s.Interface)
+		}
+	}
+
+	if s.DNS != "" {
+		if err := deps.Network.RecoverDNS(s.Interface, s.DNS); err != nil {
+			errs = append(errs, fmt.Errorf("recovering DNS: %w", err))
+		}
+	}
+
+	// Deleting the table also removes the masquerade rules in it.
+	if s.NAT || len(s.Masquerade) > 0 {
+		nat := deps.NAT
+		if nat == nil {
+			nat = tunnel.NewNATManager(log, s.Network)
+		}
+		if err := nat.Cleanup(); err != nil {
+			errs = append(errs, fmt.Errorf("removing NAT rules: %w", err))
+		}
+	}
+
+	for _, f := range s.Forwarding {
+		save := forwardingSave{ifName: f.Interface, ipv6: f.IPv6}
+		var err error
+		if save.ipv6 {
+			err = deps.Network.SetIPv6Forwarding(false)
+		} else {
+			err = deps.Network.SetForwarding(save.ifName, false)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring forwarding on %s: %w", save.label(), err))
+			continue
+		}
+		log.Info("restored leftover forwarding state", "interface", save.label(), "forwarding", false)
+	}
+
+	return errors.Join(errs...)
+}
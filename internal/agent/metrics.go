@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/kuuji/bamgate/internal/bridge"
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/metrics"
+)
+
+// iceStates are the values of the state label of bamgate_peer_ice_state.
+// Every state is exported for every peer, 1 for the current one, so alerts
+// can match on a state the peer is not in.
+var iceStates = []webrtc.ICEConnectionState{
+	webrtc.ICEConnectionStateNew,
+	webrtc.ICEConnectionStateChecking,
+	webrtc.ICEConnectionStateConnected,
+	webrtc.ICEConnectionStateCompleted,
+	webrtc.ICEConnectionStateDisconnected,
+	webrtc.ICEConnectionStateFailed,
+	webrtc.ICEConnectionStateClosed,
+}
+
+// peerMetrics is a snapshot of one peer taken under a.mu.
+type peerMetrics struct {
+	id            string
+	publicKey     config.Key
+	state         webrtc.ICEConnectionState
+	candidateType string
+	iceRestarts   uint64
+}
+
+// WriteMetrics writes the agent's metrics in the Prometheus text format,
+// for GET /metrics on the control socket and the metrics_listen address.
+// Metric and label names are a stable interface for dashboards and alerts:
+// add new ones rather than renaming.
+func (a *Agent) WriteMetrics(w io.Writer) error {
+	a.mu.Lock()
+	peers := make([]peerMetrics, 0, len(a.peers))
+	for id, ps := range a.peers {
+		p := peerMetrics{
+			id:          id,
+			publicKey:   ps.publicKey,
+			state:       webrtc.ICEConnectionStateNew,
+			iceRestarts: ps.iceRestartsAll,
+		}
+		if ps.rtcPeer != nil {
+			p.state = ps.rtcPeer.ConnectionState()
+			p.candidateType = ps.rtcPeer.ICECandidateType()
+		}
+		peers = append(peers, p)
+	}
+	a.mu.Unlock()
+	slices.SortFunc(peers, func(x, y peerMetrics) int { return cmp.Compare(x.id, y.id) })
+
+	var handshakes map[config.Key]time.Time
+	if a.wgDevice != nil {
+		var err error
+		if handshakes, err = a.wgDevice.LastHandshakes(); err != nil {
+			a.log.Debug("reading handshakes for metrics", "error", err)
+		}
+	}
+	var traffic map[string]bridge.PeerStats
+	if a.bind != nil {
+		traffic = a.bind.Stats()
+	}
+
+	m := metrics.NewWriter(w)
+
+	m.Family("bamgate_uptime_seconds", metrics.Gauge, "Seconds since the agent started.")
+	if !a.startedAt.IsZero() {
+		m.Sample(time.Since(a.startedAt).Seconds())
+	}
+	m.Family("bamgate_peers", metrics.Gauge, "Number of peers known to the agent.")
+	m.Sample(float64(len(peers)))
+
+	m.Family("bamgate_peer_ice_state", metrics.Gauge,
+		"ICE connection state of the peer: 1 for the current state, 0 for the others.")
+	for _, p := range peers {
+		for _, st := range iceStates {
+			m.Sample(boolValue(p.state == st), "peer", p.id, "state", st.String())
+		}
+	}
+
+	m.Family("bamgate_peer_candidate_type", metrics.Gauge,
+		"Local ICE candidate type of the peer's selected path (host, srflx, prflx or relay), always 1.")
+	for _, p := range peers {
+		if p.candidateType != "" && p.candidateType != "unknown" {
+			m.Sample(1, "peer", p.id, "type", p.candidateType)
+		}
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(bridge.PeerStats) uint64
+	}{
+		{"bamgate_peer_transmit_bytes_total", "Encrypted WireGuard bytes sent to the peer.",
+			func(s bridge.PeerStats) uint64 { return s.TxBytes }},
+		{"bamgate_peer_transmit_packets_total", "Encrypted WireGuard packets sent to the peer.",
+			func(s bridge.PeerStats) uint64 { return s.TxPackets }},
+		{"bamgate_peer_receive_bytes_total", "Encrypted WireGuard bytes received from the peer.",
+			func(s bridge.PeerStats) uint64 { return s.RxBytes }},
+		{"bamgate_peer_receive_packets_total", "Encrypted WireGuard packets received from the peer.",
+			func(s bridge.PeerStats) uint64 { return s.RxPackets }},
+	}
+	for _, c := range counters {
+		m.Family(c.name, metrics.Counter, c.help)
+		for _, p := range peers {
+			if s, ok := traffic[p.id]; ok {
+				m.Sample(float64(c.value(s)), "peer", p.id)
+			}
+		}
+	}
+
+	m.Family("bamgate_peer_handshake_age_seconds", metrics.Gauge,
+		"Seconds since the last completed WireGuard handshake with the peer. Absent before the first one.")
+	for _, p := range peers {
+		if t := handshakes[p.publicKey]; !t.IsZero() {
+			m.Sample(time.Since(t).Seconds(), "peer", p.id)
+		}
+	}
+
+	m.Family("bamgate_peer_ice_restarts_total", metrics.Counter,
+		"ICE restarts attempted with the peer since it was discovered.")
+	for _, p := range peers {
+		m.Sample(float64(p.iceRestarts), "peer", p.id)
+	}
+
+	m.Family("bamgate_signaling_reconnects_total", metrics.Counter,
+		"Times the signaling connection was re-established.")
+	m.Sample(float64(a.signalingReconnects.Load()))
+	m.Family("bamgate_jwt_refresh_failures_total", metrics.Counter,
+		"Failed attempts to refresh the signaling access token.")
+	m.Sample(float64(a.jwtRefreshFailures.Load()))
+	m.Family("bamgate_forwarding_repairs_total", metrics.Counter,
+		"Times the forwarding watchdog re-enabled IP forwarding or re-applied NAT rules.")
+	m.Sample(float64(a.forwardingRepairs.Load()))
+
+	return m.Err()
+}
+
+// boolValue converts b to a sample value.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// startMetricsListener serves GET /metrics over TCP on the device's
+// metrics_listen address. It returns a function that stops the listener,
+// or nil if no address is configured.
+func (a *Agent) startMetricsListener() (func(), error) {
+	addr := a.cfg.Device.MetricsListen
+	if addr == "" {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("starting metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", control.MetricsHandler(a.WriteMetrics))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.log.Error("metrics listener failed", "error", err)
+		}
+	}()
+
+	a.log.Info("metrics listener started", "listen", ln.Addr().String())
+	return func() { _ = srv.Close() }, nil
+}
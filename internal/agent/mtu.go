@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+// minIPv6MTU is the smallest MTU an IPv6 route may have.
+const minIPv6MTU = 1280
+
+// refreshPathMTUs recomputes the effective tunnel MTU of every connected
+// peer from its selected ICE candidate pair. The route health check calls
+// it before rebalancing, which applies changed MTUs to the peers' routes.
+func (a *Agent) refreshPathMTUs() {
+	a.mu.Lock()
+	ids := slices.Collect(maps.Keys(a.peers))
+	a.mu.Unlock()
+
+	for _, id := range ids {
+		a.refreshPathMTU(id)
+	}
+}
+
+// refreshPathMTU recomputes the effective tunnel MTU of one peer: the
+// largest packet that reaches it in a single SCTP packet over the current
+// ICE path (see tunnel.PathMTU). The path changes when ICE restarts, e.g.
+// from a direct connection to a TURN relay.
+func (a *Agent) refreshPathMTU(peerID string) {
+	a.mu.Lock()
+	ps, ok := a.peers[peerID]
+	if !ok || ps.rtcPeer == nil {
+		a.mu.Unlock()
+		return
+	}
+	rtcPeer := ps.rtcPeer
+	a.mu.Unlock()
+
+	info, ok := rtcPeer.SelectedPath()
+	if !ok {
+		return
+	}
+	path := tunnel.Path{
+		Relay:         info.CandidateType == "relay",
+		RelayProtocol: info.RelayProtocol,
+		IPv6:          info.IPv6,
+		LinkMTU:       linkMTU(info.BaseAddress),
+	}
+	mtu := tunnel.PathMTU(tunnel.DefaultMTU, path)
+
+	a.mu.Lock()
+	changed := ps.rtcPeer == rtcPeer && ps.pathMTU != mtu
+	if changed {
+		ps.pathMTU = mtu
+	}
+	a.mu.Unlock()
+
+	if changed {
+		a.log.Info("tunnel path MTU", "peer_id", peerID, "mtu", mtu,
+			"ice_type", info.CandidateType, "relay_protocol", info.RelayProtocol,
+			"link_mtu", path.LinkMTU, "overhead", path.Overhead())
+	}
+}
+
+// syncRouteMTUs sets the MTU of each accepted route to the path MTU of the
+// peer carrying it, so local TCP connections and, through the MSS clamp,
+// forwarded ones size their segments for that peer. Only routes whose MTU
+// changed are updated. Called by rebalanceRoutes with routesMu held.
+func (a *Agent) syncRouteMTUs(owners map[string]string) {
+	want := make(map[string]int, len(owners))
+	a.mu.Lock()
+	for route, owner := range owners {
+		if ps, ok := a.peers[owner]; ok && ps.pathMTU > 0 && !a.isTunnelSubnet(route) {
+			want[route] = routeMTU(route, ps.pathMTU)
+		}
+	}
+	a.mu.Unlock()
+
+	for route := range a.routeMTUs {
+		if _, ok := owners[route]; !ok {
+			delete(a.routeMTUs, route) // route removed with its MTU
+		}
+	}
+	for _, route := range slices.Sorted(maps.Keys(want)) {
+		mtu := want[route]
+		if a.routeMTUs[route] == mtu {
+			continue
+		}
+		if err := a.deps.Network.SetRouteMTU(a.tunName, route, mtu); err != nil {
+			a.log.Warn("setting route MTU", "route", route, "mtu", mtu, "error", err)
+			continue
+		}
+		a.routeMTUs[route] = mtu
+		a.log.Debug("set route MTU", "peer_id", owners[route], "route", route, "mtu", mtu)
+	}
+}
+
+// routeMTU returns the MTU to set on route for a peer with the given path
+// MTU. IPv6 routes cannot go below the IPv6 minimum; larger packets are
+// still delivered, split across SCTP packets.
+func routeMTU(route string, pathMTU int) int {
+	if isIPv6Route(route) {
+		return max(pathMTU, minIPv6MTU)
+	}
+	return pathMTU
+}
+
+// linkMTU returns the MTU of the local interface holding addr, or 0 if addr
+// is empty or not found.
+func linkMTU(addr string) int {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return 0
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.MTU
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+const (
+	// networkSettleDelay is how long the network must be quiet after a
+	// default route or address change before the agent reconnects. Joining
+	// a Wi-Fi network removes the old route, brings the link up, adds
+	// addresses and a new route over a second or two; reconnecting midway
+	// would gather ICE candidates for a half-configured network.
+	networkSettleDelay = 2 * time.Second
+
+	// clockCheckInterval is how often the agent compares the wall clock
+	// with the monotonic clock to detect suspend/resume.
+	clockCheckInterval = 5 * time.Second
+
+	// clockJumpThreshold is how far the wall clock must run ahead of the
+	// monotonic clock, which stops while the machine sleeps, before the
+	// agent treats it as a resume from suspend.
+	clockJumpThreshold = 10 * time.Second
+)
+
+// startNetworkChangeMonitor launches a background goroutine that calls
+// NotifyNetworkChange when the machine moves to another network or resumes
+// from suspend, so desktops and servers recover the way the mobile apps do
+// when the OS reports a connectivity change.
+//
+// Network changes come from WatchNetwork (default route and address
+// changes, on Linux); suspend/resume is detected on every platform by the
+// wall clock jumping ahead of the monotonic clock. Both feed one trailing
+// debounce so a burst of changes causes a single reconnect.
+//
+// The goroutine is owned by Run() and exits when ctx is cancelled.
+func (a *Agent) startNetworkChangeMonitor(ctx context.Context) {
+	events, err := a.deps.Network.WatchNetwork(ctx)
+	if err != nil {
+		a.log.Debug("network change notifications unavailable, only detecting suspend/resume", "error", err)
+	}
+
+	// Changes on our own TUN interface are caused by the agent itself.
+	tunIndex := 0
+	if a.tunName != "" {
+		if iface, err := net.InterfaceByName(a.tunName); err == nil {
+			tunIndex = iface.Index
+		}
+	}
+
+	a.log.Debug("network change monitor started", "event_driven", events != nil)
+
+	ticker := time.NewTicker(clockCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		settle := time.NewTimer(networkSettleDelay)
+		settle.Stop()
+		defer settle.Stop()
+
+		last := time.Now()
+		reason := ""
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if jump := clockJump(last, now); jump > clockJumpThreshold {
+					a.log.Info("resume from suspend detected", "suspended", jump.Round(time.Second))
+					reason = "resume"
+					settle.Reset(networkSettleDelay)
+				}
+				last = now
+			case ev, ok := <-events:
+				if !ok {
+					a.log.Warn("network change notifications stopped, only detecting suspend/resume")
+					events = nil
+					continue
+				}
+				if !isNetworkChange(ev, tunIndex) {
+					continue
+				}
+				a.log.Debug("network change event", "event", ev.Kind, "ifindex", ev.IfIndex, "addr", ev.Addr)
+				reason = ev.Kind.String()
+				settle.Reset(networkSettleDelay)
+			case <-settle.C:
+				a.log.Info("network changed, reconnecting peers", "reason", reason)
+				a.NotifyNetworkChange()
+			}
+		}
+	}()
+}
+
+// isNetworkChange reports whether ev means the machine's path to the
+// internet may have changed: a default route or a routable address came or
+// went on an interface other than the bamgate TUN (tunIndex, 0 if unknown).
+func isNetworkChange(ev tunnel.NetworkEvent, tunIndex int) bool {
+	if tunIndex > 0 && ev.IfIndex == tunIndex {
+		return false
+	}
+	switch ev.Kind {
+	case tunnel.DefaultRouteChanged:
+		return true
+	case tunnel.AddressChanged:
+		// Link-local addresses come and go with every link flap and do
+		// not move the machine to another network on their own.
+		return ev.Addr.IsValid() && !ev.Addr.IsLoopback() && !ev.Addr.IsLinkLocalUnicast()
+	default:
+		return false
+	}
+}
+
+// clockJump returns how far the wall clock advanced beyond the monotonic
+// clock between two readings of time.Now. The monotonic clock does not
+// advance while the machine is suspended, so a large value means it slept.
+func clockJump(last, now time.Time) time.Duration {
+	return now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+}
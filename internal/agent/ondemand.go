@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// On-demand mode (device.on_demand) keeps WebRTC sessions only with peers
+// that carry traffic. Discovered peers are added to WireGuard with their
+// AllowedIPs but not connected; the first packet WireGuard sends to one
+// reaches the bridge without a data channel, is queued there and triggers
+// connectOnDemand. Connections without traffic for device.idle_timeout are
+// closed again by the idle monitor, as are connections that fail, and the
+// peer stays in WireGuard for the next packet.
+
+const (
+	// defaultIdleTimeout is used when device.idle_timeout is not set.
+	defaultIdleTimeout = 5 * time.Minute
+
+	// minIdleTimeout is the shortest device.idle_timeout accepted. The
+	// idle monitor checks a quarter of it, which must stay a usable
+	// ticker interval.
+	minIdleTimeout = time.Second
+
+	// maxIdleCheckInterval caps how often the idle monitor looks for idle
+	// connections; it checks four times per idle timeout when shorter.
+	maxIdleCheckInterval = 30 * time.Second
+
+	// onDemandRedialDelay is how long after connecting on demand further
+	// packets for a peer that is still not connected are left to the
+	// attempt in flight, rather than starting another one.
+	onDemandRedialDelay = 5 * time.Second
+)
+
+// parseIdleTimeout parses device.idle_timeout, a Go duration of at least
+// minIdleTimeout. Empty means defaultIdleTimeout.
+func parseIdleTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultIdleTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("device.idle_timeout: %w", err)
+	}
+	if d < minIdleTimeout {
+		return 0, fmt.Errorf("device.idle_timeout: must be at least %s, got %q", minIdleTimeout, s)
+	}
+	return d, nil
+}
+
+// persistentKeepalive returns the WireGuard keepalive interval for peers,
+// in seconds. On-demand peers get none, so idle connections go quiet.
+func (a *Agent) persistentKeepalive() int {
+	if a.onDemand {
+		return 0
+	}
+	return 25
+}
+
+// registerIdlePeer adds a peer discovered in on-demand mode to WireGuard
+// without connecting to it. A peer that is connected keeps its connection
+// and only has its details updated.
+func (a *Agent) registerIdlePeer(p protocol.PeerInfo) {
+	wgPubKey, err := config.ParseKey(p.PublicKey)
+	if err != nil {
+		a.log.Error("parsing peer public key", "peer_id", p.PeerID, "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	ps, ok := a.peers[p.PeerID]
+	if !ok {
+		ps = &peerState{}
+		a.peers[p.PeerID] = ps
+	}
+	ps.publicKey = wgPubKey
+	ps.address = p.Address
+	ps.routes = p.Routes
+	ps.metadata = p.Metadata
+	connected := ps.rtcPeer != nil
+	a.mu.Unlock()
+	if connected {
+		return
+	}
+
+	a.log.Info("peer registered, connecting on demand", "peer_id", p.PeerID)
+	a.addWireGuardPeer(p.PeerID)
+}
+
+// connectOnDemand is the bridge's miss hook: WireGuard sent a packet to a
+// peer without a data channel. It connects to the peer unless a connection
+// is already being set up. It runs on WireGuard's send path, so the
+// connection is set up in the background.
+func (a *Agent) connectOnDemand(peerID string) {
+	a.mu.Lock()
+	ps, ok := a.peers[peerID]
+	if !ok || ps.rtcPeer != nil || a.blocked[peerID] || time.Since(ps.dialedAt) < onDemandRedialDelay {
+		a.mu.Unlock()
+		return
+	}
+	info, known := a.peerInfo(peerID)
+	if !known {
+		a.mu.Unlock()
+		return
+	}
+	ps.dialedAt = time.Now()
+	ps.pendingOffer = true
+	a.mu.Unlock()
+
+	go func() {
+		a.log.Info("traffic for idle peer, connecting", "peer_id", peerID)
+		// The peer may still hold the session we closed as idle; the
+		// reset has it drop it.
+		err := a.initiateConnection(a.ctx, info.PeerID, info.PublicKey, info.Address, info.Routes, info.Metadata, true)
+		if err != nil {
+			a.log.Error("connecting on demand", "peer_id", peerID, "error", err)
+			a.mu.Lock()
+			if ps, ok := a.peers[peerID]; ok {
+				ps.pendingOffer = false
+			}
+			a.mu.Unlock()
+		}
+	}()
+}
+
+// idlePeer closes the connection with a peer but keeps the peer in
+// WireGuard, with its routes and DNS, so the next packet for it connects
+// again. reason is logged.
+func (a *Agent) idlePeer(peerID, reason string) {
+	a.mu.Lock()
+	ps, ok := a.peers[peerID]
+	if !ok || ps.rtcPeer == nil {
+		a.mu.Unlock()
+		return
+	}
+	rtcPeer := ps.rtcPeer
+	ps.rtcPeer = nil
+	ps.connectedAt = time.Time{}
+	ps.lastActivity = time.Time{}
+	ps.pendingCandidates = nil
+	ps.pendingOffer = false
+	ps.pendingRestart = false
+	ps.needsRestart = false
+	ps.iceRestarts = 0
+	if ps.restartTimer != nil {
+		ps.restartTimer.Stop()
+		ps.restartTimer = nil
+	}
+	a.mu.Unlock()
+
+	a.log.Info("closing peer connection, reconnecting on demand", "peer_id", peerID, "reason", reason)
+	a.events.Publish(control.Event{Type: control.EventPeerIdle, Peer: peerID})
+
+	a.bind.RemoveDataChannel(peerID)
+	if err := rtcPeer.Close(); err != nil {
+		a.log.Warn("closing WebRTC peer", "peer_id", peerID, "error", err)
+	}
+}
+
+// connectionLost handles a connection that failed, or stayed disconnected
+// past the grace period: on-demand connections are closed until the next
+// packet, others restart ICE.
+func (a *Agent) connectionLost(ctx context.Context, peerID string) {
+	if a.onDemand {
+		a.idlePeer(peerID, "connection lost")
+		return
+	}
+	a.attemptICERestart(ctx, peerID)
+}
+
+// startIdleMonitor closes on-demand connections that carried no traffic
+// for the idle timeout, until ctx is cancelled.
+func (a *Agent) startIdleMonitor(ctx context.Context) {
+	ticker := time.NewTicker(min(a.idleTimeout/4, maxIdleCheckInterval))
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				a.closeIdleConnections(now)
+			}
+		}
+	}()
+}
+
+// closeIdleConnections closes the connections whose packet counters have
+// not moved for the idle timeout.
+func (a *Agent) closeIdleConnections(now time.Time) {
+	stats := a.bind.Stats()
+
+	var idle []string
+	a.mu.Lock()
+	for id, ps := range a.peers {
+		if ps.rtcPeer == nil || ps.connectedAt.IsZero() {
+			continue
+		}
+		s := stats[id]
+		packets := s.TxPackets + s.RxPackets
+		if ps.lastActivity.IsZero() || packets != ps.activityPackets {
+			ps.activityPackets = packets
+			ps.lastActivity = now
+			continue
+		}
+		if now.Sub(ps.lastActivity) >= a.idleTimeout {
+			idle = append(idle, id)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, id := range idle {
+		a.idlePeer(id, "idle")
+	}
+}
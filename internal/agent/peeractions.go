@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// peerActions returns the functions serving POST /peers/{id}/{action}.
+func (a *Agent) peerActions() control.PeerActions {
+	return control.PeerActions{
+		RestartICE: a.RestartICE,
+		Reconnect:  a.ReconnectPeer,
+		Block:      a.BlockPeer,
+		Unblock:    a.UnblockPeer,
+	}
+}
+
+// RestartICE restarts ICE with a connected peer now, as if its connection
+// had failed, keeping the DTLS session and data channel. The restart
+// starts a new series of attempts rather than counting toward the
+// automatic ones. Used by "bamgate peer restart-ice".
+func (a *Agent) RestartICE(peerID string) error {
+	a.mu.Lock()
+	ps, ok := a.peers[peerID]
+	if !ok || ps.rtcPeer == nil {
+		a.mu.Unlock()
+		return fmt.Errorf("no connection to peer %q", peerID)
+	}
+	if ps.restartTimer != nil {
+		ps.restartTimer.Stop()
+		ps.restartTimer = nil
+	}
+	ps.iceRestarts = 0
+	a.mu.Unlock()
+
+	a.log.Info("ICE restart requested", "peer_id", peerID)
+	a.attemptICERestart(a.ctx, peerID)
+	return nil
+}
+
+// ReconnectPeer tears down the connection with a peer and starts a new
+// one, whichever side offered the old one. The offer asks the peer to
+// discard its side too. Used by "bamgate peer reconnect" when a session
+// is wedged beyond what an ICE restart fixes.
+func (a *Agent) ReconnectPeer(peerID string) error {
+	a.mu.Lock()
+	if a.blocked[peerID] {
+		a.mu.Unlock()
+		return fmt.Errorf("peer %q is blocked", peerID)
+	}
+	info, ok := a.peerInfo(peerID)
+	wanted := a.wantsPeer(peerID, info.Metadata)
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown peer %q", peerID)
+	}
+	if !wanted {
+		return fmt.Errorf("peer %q is outside the hub-and-spoke topology", peerID)
+	}
+
+	a.log.Info("reconnecting peer", "peer_id", peerID)
+	a.resetPeer(peerID)
+	return a.initiateConnection(a.ctx, info.PeerID, info.PublicKey, info.Address, info.Routes, info.Metadata, true)
+}
+
+// BlockPeer disconnects a peer and refuses to connect to it again, until
+// UnblockPeer. The block is saved in device.blocked_peers. Used by
+// "bamgate peer block".
+func (a *Agent) BlockPeer(peerID string) error {
+	if peerID == "" || peerID == a.cfg.Device.Name {
+		return fmt.Errorf("invalid peer %q", peerID)
+	}
+	if !a.blockPeer(peerID) {
+		return nil
+	}
+	return a.saveBlockedPeers()
+}
+
+// UnblockPeer lifts a block set by BlockPeer and connects to the peer if
+// it is on the hub. Used by "bamgate peer unblock".
+func (a *Agent) UnblockPeer(peerID string) error {
+	if !a.unblockPeer(peerID) {
+		return fmt.Errorf("peer %q is not blocked", peerID)
+	}
+	if err := a.saveBlockedPeers(); err != nil {
+		return err
+	}
+	return a.connectUnblocked(peerID)
+}
+
+// blockPeer adds a peer to the blocked peers and disconnects it. It
+// reports false if the peer was already blocked.
+func (a *Agent) blockPeer(peerID string) bool {
+	a.mu.Lock()
+	if a.blocked[peerID] {
+		a.mu.Unlock()
+		return false
+	}
+	// Remember how to reach the peer for when it is unblocked.
+	if info, ok := a.peerInfo(peerID); ok {
+		a.directory[peerID] = info
+	}
+	a.blocked[peerID] = true
+	a.cfg.Device.BlockedPeers = slices.Sorted(maps.Keys(a.blocked))
+	a.mu.Unlock()
+
+	a.log.Info("blocking peer", "peer_id", peerID)
+	a.removePeer(peerID)
+	a.unrelay(peerID)
+	return true
+}
+
+// unblockPeer removes a peer from the blocked peers. It reports false if
+// the peer was not blocked.
+func (a *Agent) unblockPeer(peerID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.blocked[peerID] {
+		return false
+	}
+	delete(a.blocked, peerID)
+	a.cfg.Device.BlockedPeers = slices.DeleteFunc(slices.Clone(a.cfg.Device.BlockedPeers),
+		func(id string) bool { return id == peerID })
+	a.log.Info("unblocking peer", "peer_id", peerID)
+	return true
+}
+
+// connectUnblocked connects to a peer that was just unblocked, if it is
+// on the hub and the topology allows it. Otherwise it is connected when it
+// next joins.
+func (a *Agent) connectUnblocked(peerID string) error {
+	a.mu.Lock()
+	info, known := a.directory[peerID]
+	wanted := known && a.wantsPeer(peerID, info.Metadata)
+	a.mu.Unlock()
+	if !wanted {
+		return nil
+	}
+	if a.onDemand {
+		a.registerIdlePeer(info)
+		return nil
+	}
+
+	// The peer gave up on us while we ignored its offers, so offer from
+	// our side even if it would normally offer, and have it drop whatever
+	// is left of the old connection.
+	return a.initiateConnection(a.ctx, info.PeerID, info.PublicKey, info.Address, info.Routes, info.Metadata, true)
+}
+
+// peerInfo returns what is known about a peer, from its connection or
+// the directory. Must be called with a.mu held.
+func (a *Agent) peerInfo(peerID string) (protocol.PeerInfo, bool) {
+	if ps, ok := a.peers[peerID]; ok && !ps.publicKey.IsZero() {
+		return protocol.PeerInfo{
+			PeerID:    peerID,
+			PublicKey: ps.publicKey.String(),
+			Address:   ps.address,
+			Routes:    ps.routes,
+			Metadata:  ps.metadata,
+		}, true
+	}
+	info, ok := a.directory[peerID]
+	return info, ok
+}
+
+// resetPeer tears down the connection with a peer but keeps its address,
+// routes and metadata for the next one. In on-demand mode the peer also
+// stays in WireGuard, so traffic reconnects it if the next one fails.
+func (a *Agent) resetPeer(peerID string) {
+	if a.onDemand {
+		a.idlePeer(peerID, "reset")
+		return
+	}
+
+	a.mu.Lock()
+	ps, ok := a.peers[peerID]
+	var keep peerState
+	if ok {
+		keep = peerState{
+			publicKey: ps.publicKey,
+			address:   ps.address,
+			routes:    ps.routes,
+			metadata:  ps.metadata,
+		}
+	}
+	a.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	a.removePeer(peerID)
+
+	a.mu.Lock()
+	if _, replaced := a.peers[peerID]; !replaced {
+		a.peers[peerID] = &keep
+	}
+	a.mu.Unlock()
+}
+
+// saveBlockedPeers saves the blocked peers in device.blocked_peers.
+func (a *Agent) saveBlockedPeers() error {
+	a.mu.Lock()
+	blocked := slices.Sorted(maps.Keys(a.blocked))
+	a.mu.Unlock()
+	return a.saveConfig(func(cfg *config.Config) { cfg.Device.BlockedPeers = blocked })
+}
+
+// saveConfig applies fn to the config file as it is on disk and saves it,
+// if the agent has one. a.cfg is not written out: other goroutines use it,
+// and it carries the command line overrides (WithConfigOverrides).
+func (a *Agent) saveConfig(fn func(cfg *config.Config)) error {
+	if a.configPath == "" {
+		return nil
+	}
+	a.configFileMu.Lock()
+	defer a.configFileMu.Unlock()
+
+	cfg, err := a.deps.Config.LoadConfig(a.configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	fn(cfg)
+
+	// Save the current refresh token, holding tokenMu so a rotation in
+	// progress is saved after this rather than overwritten by it.
+	a.tokenMu.RLock()
+	defer a.tokenMu.RUnlock()
+	cfg.Network.RefreshToken = a.cfg.Network.RefreshToken
+	if err := a.deps.Config.SaveConfig(a.configPath, cfg); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
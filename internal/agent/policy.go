@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/kuuji/bamgate/internal/policy"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// handlePolicy applies a network policy pushed by the signaling server.
+// The ACL and topology take effect immediately, and the routes and DNS
+// accepted from connected peers are resolved again. An invalid policy is
+// rejected and the previous one kept.
+func (a *Agent) handlePolicy(msg *protocol.PolicyMessage) error {
+	var doc *policy.Document
+	if len(msg.Policy) > 0 && string(msg.Policy) != "null" {
+		d, err := policy.Parse(msg.Policy)
+		if err == nil {
+			err = d.Validate()
+		}
+		if err != nil {
+			return fmt.Errorf("rejecting network policy version %d: %w", msg.Version, err)
+		}
+		doc = d
+	}
+
+	// The DNS accepted from each connected peer before the change.
+	a.mu.Lock()
+	var connected []string
+	for id, ps := range a.peers {
+		if ps.tunnelAllowedIPs != nil {
+			connected = append(connected, id)
+		}
+	}
+	a.mu.Unlock()
+	dnsBefore := make(map[string]peerDNS, len(connected))
+	for _, id := range connected {
+		servers, search := a.resolveAcceptedDNS(id)
+		dnsBefore[id] = peerDNS{servers, search}
+	}
+
+	a.mu.Lock()
+	unchanged := msg.Version == a.policyVersion && a.netPolicy != nil
+	roles := a.topologyRoles()
+	a.netPolicy = doc
+	a.policyVersion = msg.Version
+	topologyChanged := !maps.Equal(roles, a.topologyRoles())
+	a.mu.Unlock()
+
+	// The server resends the policy on every reconnect.
+	if unchanged {
+		return nil
+	}
+
+	a.updateACL()
+	if topologyChanged {
+		a.applyTopology(a.ctx)
+	}
+	a.reresolvePeers(dnsBefore, nil, true)
+	a.log.Info("applied network policy", "version", msg.Version)
+	return nil
+}
+
+// policyRoutes returns the routes the network policy accepts from a peer,
+// limited to valid routes the peer advertises.
+func (a *Agent) policyRoutes(peerID string, advertised []string) []string {
+	a.mu.Lock()
+	routes := a.netPolicy.RoutesFor(a.cfg.Device.Name, peerID)
+	a.mu.Unlock()
+
+	var accepted []string
+	for _, route := range routes {
+		if !slices.Contains(advertised, route) || !isValidRoute(route) {
+			a.log.Warn("ignoring network policy route that is invalid or not advertised by the peer",
+				"peer_id", peerID, "route", route)
+			continue
+		}
+		accepted = append(accepted, route)
+	}
+	return accepted
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+// forwardedSubnets returns the destinations this device forwards mesh
+// traffic to: its advertised routes plus a host prefix for each port
+// forward target. Each needs forwarding and masquerade on the interface
+// it is reached through.
+func (a *Agent) forwardedSubnets() []string {
+	subnets := slices.Clone(a.advertisedRoutes())
+	for _, pf := range a.cfg.Device.PortForwards {
+		target, err := netip.ParseAddrPort(pf.Target)
+		if err != nil {
+			continue // reported by configuredPortForwards
+		}
+		subnets = append(subnets, netip.PrefixFrom(target.Addr(), target.Addr().BitLen()).String())
+	}
+	return subnets
+}
+
+// configuredPortForwards converts device.port_forwards into DNAT rules on our
+// tunnel address of the target's family. Invalid entries are logged and
+// skipped.
+func (a *Agent) configuredPortForwards() []tunnel.PortForwardRule {
+	var rules []tunnel.PortForwardRule
+	for _, pf := range a.cfg.Device.PortForwards {
+		rule, err := portForwardRule(pf, a.cfg.Device.Address, a.cfg.Device.Address6)
+		if err != nil {
+			a.log.Warn("skipping invalid port forward", "port", pf.Port, "target", pf.Target, "error", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// portForwardRule validates a configured port forward and builds its rule.
+func portForwardRule(pf config.PortForward, address, address6 string) (tunnel.PortForwardRule, error) {
+	proto := pf.Protocol
+	switch proto {
+	case "":
+		proto = "tcp"
+	case "tcp", "udp":
+	default:
+		return tunnel.PortForwardRule{}, fmt.Errorf("unsupported protocol %q (want tcp or udp)", proto)
+	}
+	if pf.Port < 1 || pf.Port > 65535 {
+		return tunnel.PortForwardRule{}, fmt.Errorf("port %d out of range", pf.Port)
+	}
+	target, err := netip.ParseAddrPort(pf.Target)
+	if err != nil {
+		return tunnel.PortForwardRule{}, fmt.Errorf("invalid target %q: want ip:port", pf.Target)
+	}
+	if target.Addr().IsLoopback() || target.Addr().IsUnspecified() {
+		// DNAT from the tunnel cannot reach loopback services; a reverse
+		// `bamgate forward` can.
+		return tunnel.PortForwardRule{}, fmt.Errorf("target %s is not a LAN address", target.Addr())
+	}
+
+	cidr := address
+	if target.Addr().Is6() {
+		cidr = address6
+		if cidr == "" {
+			return tunnel.PortForwardRule{}, fmt.Errorf("IPv6 target %s needs an IPv6 tunnel address (device.address6)", pf.Target)
+		}
+	}
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return tunnel.PortForwardRule{}, fmt.Errorf("tunnel address %q: %w", cidr, err)
+	}
+
+	return tunnel.PortForwardRule{
+		Protocol: proto,
+		Address:  ip.String(),
+		Port:     uint16(pf.Port),
+		Target:   target.String(),
+	}, nil
+}
+
+// applyPortForwards installs the DNAT rules for device.port_forwards and
+// records them for the forwarding watchdog.
+func (a *Agent) applyPortForwards(tunIface string) error {
+	rules := a.configuredPortForwards()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	a.natMu.Lock()
+	defer a.natMu.Unlock()
+	if err := a.natManager.SetPortForwards(tunIface, rules); err != nil {
+		return fmt.Errorf("setting up port forwards: %w", err)
+	}
+	a.portForwardRules = rules
+
+	for _, r := range rules {
+		a.log.Info("port forward published",
+			"protocol", r.Protocol, "listen", net.JoinHostPort(r.Address, fmt.Sprint(r.Port)), "target", r.Target)
+	}
+	return nil
+}
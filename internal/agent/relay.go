@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// Peer relaying. Devices with device.relay advertise it in their metadata
+// and forward traffic between peers on their TUN interface. When ICE to a
+// peer fails for good (see attemptICERestart), the peer's tunnel addresses
+// are added to the AllowedIPs of a connected relay instead, so WireGuard
+// reaches it through the relay's session. The peer does the same for us;
+// both pick the relay with the smallest name among the relays they are
+// connected to, as WireGuard on each side only accepts our addresses from
+// the session it routes them to. A direct connection is retried every
+// relayRetryInterval and replaces the relay as soon as its data channel
+// opens.
+
+// relayRetryInterval is how often a direct connection to a relayed peer is
+// tried again.
+const relayRetryInterval = time.Minute
+
+// relayPeer routes a peer that cannot be reached directly through a relay
+// peer. It reports whether one was found. On-demand mode does not relay;
+// failed connections are closed until the next packet instead.
+func (a *Agent) relayPeer(peerID string) bool {
+	if a.onDemand || a.ctx.Err() != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	relay := ""
+	if _, known := a.directory[peerID]; known && !a.blocked[peerID] {
+		relay = a.pickRelay(peerID)
+	}
+	prev := a.relays[peerID]
+	if relay != "" {
+		a.relays[peerID] = relay
+	}
+	a.mu.Unlock()
+
+	if relay == "" {
+		a.log.Warn("no relay available for unreachable peer", "peer_id", peerID)
+		return false
+	}
+	if relay == prev {
+		return true
+	}
+	a.log.Info("peer unreachable directly, relaying through peer", "peer_id", peerID, "relay", relay)
+	a.events.Publish(control.Event{Type: control.EventPeerRelayed, Peer: peerID, Relay: relay})
+	a.rebalanceRoutes(relay)
+	return true
+}
+
+// pickRelay returns the connected relay with the smallest name, other than
+// peerID, or "" if there is none. Must be called with a.mu held.
+func (a *Agent) pickRelay(peerID string) string {
+	for _, id := range slices.Sorted(maps.Keys(a.peers)) {
+		ps := a.peers[id]
+		if id != peerID && ps.rtcPeer != nil && ps.tunnelAllowedIPs != nil &&
+			ps.metadata[protocol.MetaKeyRelay] == "true" {
+			return id
+		}
+	}
+	return ""
+}
+
+// unrelay stops relaying a peer, because it connected directly, left or
+// was blocked.
+func (a *Agent) unrelay(peerID string) {
+	a.mu.Lock()
+	relay, ok := a.relays[peerID]
+	delete(a.relays, peerID)
+	a.mu.Unlock()
+	if !ok {
+		return
+	}
+	a.log.Info("no longer relaying peer", "peer_id", peerID, "relay", relay)
+	a.rebalanceRoutes(relay)
+}
+
+// rerelay moves the peers relayed through a relay that went away to
+// another relay, if there is one.
+func (a *Agent) rerelay(relay string) {
+	a.mu.Lock()
+	var orphans []string
+	for _, id := range slices.Sorted(maps.Keys(a.relays)) {
+		if a.relays[id] == relay {
+			delete(a.relays, id)
+			orphans = append(orphans, id)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, id := range orphans {
+		a.log.Info("relay went away", "peer_id", id, "relay", relay)
+		a.relayPeer(id)
+	}
+}
+
+// relayedIPs returns the tunnel addresses of the peers relayed through
+// relay, for its AllowedIPs. Must be called with a.mu held.
+func (a *Agent) relayedIPs(relay string) []string {
+	var ips []string
+	for _, id := range slices.Sorted(maps.Keys(a.relays)) {
+		if a.relays[id] != relay {
+			continue
+		}
+		info := a.directory[id]
+		if peerIPs, err := peerTunnelIPs(info.Address, info.Metadata); err == nil {
+			ips = append(ips, peerIPs...)
+		}
+	}
+	return ips
+}
+
+// startRelayMonitor retries direct connections to relayed peers until ctx
+// is cancelled.
+func (a *Agent) startRelayMonitor(ctx context.Context) {
+	ticker := time.NewTicker(relayRetryInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.retryRelayedPeers(ctx)
+			}
+		}
+	}()
+}
+
+// retryRelayedPeers offers a new direct connection to each relayed peer
+// we would normally offer to and that is not connecting already. The
+// others offer to us.
+func (a *Agent) retryRelayedPeers(ctx context.Context) {
+	a.mu.Lock()
+	var retry []protocol.PeerInfo
+	for _, id := range slices.Sorted(maps.Keys(a.relays)) {
+		if _, connecting := a.peers[id]; connecting || a.cfg.Device.Name > id {
+			continue
+		}
+		if info, ok := a.directory[id]; ok {
+			retry = append(retry, info)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, info := range retry {
+		a.log.Info("retrying direct connection to relayed peer", "peer_id", info.PeerID)
+		if err := a.initiateConnection(ctx, info.PeerID, info.PublicKey, info.Address, info.Routes, info.Metadata, true); err != nil {
+			a.log.Warn("retrying direct connection", "peer_id", info.PeerID, "error", err)
+		}
+	}
+}
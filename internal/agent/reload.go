@@ -0,0 +1,362 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+// configWatchInterval is how often the agent checks whether config.toml
+// or secrets.toml changed on disk.
+const configWatchInterval = 2 * time.Second
+
+// Reload re-reads the config file and applies what changed without
+// reconnecting peers: advertised routes and DNS (announced to peers), per-
+// peer selections, access control rules, blocked peers, the route
+// conflict policy, and the STUN servers and force_relay used for future
+// connections. Changes to settings the running tunnel is built on, such as
+// the private key or address, are reported in RestartRequired and keep
+// their old values until "bamgate restart".
+//
+// An invalid file is rejected as a whole. Reload runs on SIGHUP, POST
+// /config/reload and when the file changes.
+func (a *Agent) Reload() (control.ReloadResult, error) {
+	var result control.ReloadResult
+	if a.configPath == "" {
+		return result, fmt.Errorf("no config file to reload from")
+	}
+
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	if !a.reloadReady {
+		return result, fmt.Errorf("agent is not running")
+	}
+
+	next, err := a.deps.Config.LoadConfig(a.configPath)
+	if err != nil {
+		return result, fmt.Errorf("loading config: %w", err)
+	}
+	if a.opts.configOverrides != nil {
+		a.opts.configOverrides(next)
+	}
+
+	// Validate everything before applying anything.
+	aclDefault, aclRules, err := parseACL(next)
+	if err != nil {
+		return result, err
+	}
+	if _, err := parseAdvertiseRoutes(next.Device.AdvertiseRoutes, next.Device.AdvertiseExclude); err != nil {
+		return result, err
+	}
+	conflictPolicy, err := tunnel.ParseRouteConflictPolicy(next.Device.RouteConflictPolicy)
+	if err != nil {
+		return result, fmt.Errorf("device.route_conflict_policy: %w", err)
+	}
+	if _, err := parseIdleTimeout(next.Device.IdleTimeout); err != nil {
+		return result, err
+	}
+	if err := parseRole(next.Device.Role); err != nil {
+		return result, err
+	}
+
+	cur, dev := a.cfg, next.Device
+	restart := func(key string, changed bool) {
+		if changed {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+	restart("network.server_url", next.Network.ServerURL != cur.Network.ServerURL)
+	restart("network.device_id", next.Network.DeviceID != cur.Network.DeviceID)
+	restart("device.name", dev.Name != cur.Device.Name)
+	restart("device.private_key", dev.PrivateKey != cur.Device.PrivateKey)
+	restart("device.address", dev.Address != cur.Device.Address)
+	restart("device.address6", dev.Address6 != cur.Device.Address6)
+	restart("device.dns_backend", dev.DNSBackend != cur.Device.DNSBackend)
+	restart("device.userspace", dev.Userspace != cur.Device.Userspace)
+	restart("device.proxy_listen", dev.ProxyListen != cur.Device.ProxyListen)
+	restart("device.metrics_listen", dev.MetricsListen != cur.Device.MetricsListen)
+	restart("device.control_group", dev.ControlGroup != cur.Device.ControlGroup)
+	restart("device.on_demand", dev.OnDemand != cur.Device.OnDemand)
+	restart("device.idle_timeout", dev.IdleTimeout != cur.Device.IdleTimeout)
+	restart("device.role", dev.Role != cur.Device.Role)
+	restart("device.relay", dev.Relay != cur.Device.Relay)
+	restart("device.port_forwards", !slices.Equal(dev.PortForwards, cur.Device.PortForwards))
+	restart("forwards", !slices.Equal(next.Forwards, cur.Forwards))
+
+	apply := func(key string, changed bool) bool {
+		if changed {
+			result.Applied = append(result.Applied, key)
+		}
+		return changed
+	}
+
+	// Used for connections created from now on.
+	a.mu.Lock()
+	stunChanged := !slices.Equal(next.STUN.Servers, cur.STUN.Servers)
+	relayChanged := dev.ForceRelay != cur.Device.ForceRelay
+	a.cfg.STUN.Servers = next.STUN.Servers
+	a.cfg.Device.ForceRelay = dev.ForceRelay
+	a.mu.Unlock()
+	apply("stun.servers", stunChanged)
+	apply("device.force_relay", relayChanged)
+
+	a.reloadAdvertised(next, apply)
+
+	if apply("acl", !aclEqual(cur, next)) {
+		a.setACL(next, aclDefault, aclRules)
+	}
+
+	//nolint:staticcheck // legacy flag, deprecated in favor of per-peer selections
+	acceptRoutes := dev.AcceptRoutes != cur.Device.AcceptRoutes
+	policyChanged := dev.RouteConflictPolicy != cur.Device.RouteConflictPolicy
+	a.mu.Lock()
+	a.cfg.Device.AcceptRoutes = dev.AcceptRoutes //nolint:staticcheck // see above
+	a.cfg.Device.RouteConflictPolicy = dev.RouteConflictPolicy
+	a.routeConflictPolicy = conflictPolicy
+	a.mu.Unlock()
+	apply("device.accept_routes", acceptRoutes)
+	apply("device.route_conflict_policy", policyChanged)
+
+	a.reloadSelections(next, apply, acceptRoutes || policyChanged)
+
+	if apply("device.blocked_peers", !slices.Equal(slices.Sorted(slices.Values(dev.BlockedPeers)),
+		slices.Sorted(slices.Values(cur.Device.BlockedPeers)))) {
+		a.reloadBlocked(dev.BlockedPeers)
+	}
+
+	switch {
+	case len(result.RestartRequired) > 0:
+		a.log.Warn("reloaded config, some changes need a restart",
+			"applied", result.Applied, "restart_required", result.RestartRequired)
+	case len(result.Applied) > 0:
+		a.log.Info("reloaded config", "applied", result.Applied)
+	default:
+		a.log.Debug("reloaded config, nothing changed")
+	}
+	return result, nil
+}
+
+// reloadAdvertised applies changes to the routes and DNS this device
+// advertises and announces them to peers.
+func (a *Agent) reloadAdvertised(next *config.Config, apply func(string, bool) bool) {
+	cur, dev := a.cfg.Device, next.Device
+	routes := apply("device.routes", !slices.Equal(dev.Routes, cur.Routes))
+	mode := apply("device.advertise_routes", dev.AdvertiseRoutes != cur.AdvertiseRoutes)
+	exclude := apply("device.advertise_exclude", !slices.Equal(dev.AdvertiseExclude, cur.AdvertiseExclude))
+	dns := apply("device.dns", !slices.Equal(dev.DNS, cur.DNS))
+	search := apply("device.dns_search", !slices.Equal(dev.DNSSearch, cur.DNSSearch))
+	if !routes && !mode && !exclude && !dns && !search {
+		return
+	}
+
+	a.mu.Lock()
+	a.cfg.Device.Routes = dev.Routes
+	a.cfg.Device.AdvertiseRoutes = dev.AdvertiseRoutes
+	a.cfg.Device.AdvertiseExclude = dev.AdvertiseExclude
+	a.cfg.Device.DNS = dev.DNS
+	a.cfg.Device.DNSSearch = dev.DNSSearch
+	a.mu.Unlock()
+
+	advertised := slices.Clone(dev.Routes)
+	auto := dev.AdvertiseRoutes == "auto" && a.opts.tunFD <= 0
+	if auto {
+		if discovered, ok := a.discoverRoutes(); ok {
+			advertised = discovered
+		}
+	}
+	a.setAdvertisedRoutes(a.ctx, advertised, dns || search)
+
+	if auto {
+		a.startRouteAdvertiser(a.ctx)
+	}
+	if len(advertised) > 0 && a.opts.tunFD <= 0 && a.opts.userspace == nil {
+		a.startForwardingWatchdog(a.ctx)
+	}
+}
+
+// reloadSelections applies changed per-peer selections to connected peers
+// through reresolvePeers. With all set, every connected peer's routes are
+// resolved again. Allow rules have already been applied by setACL.
+func (a *Agent) reloadSelections(next *config.Config, apply func(string, bool) bool, all bool) {
+	a.mu.Lock()
+	cur := a.cfg.Peers
+	a.mu.Unlock()
+
+	// The DNS accepted from each peer whose selections changed, before
+	// the change.
+	changed := make(map[string]peerDNS)
+	realiased := make(map[string]bool)
+	names := slices.AppendSeq(slices.Collect(maps.Keys(cur)), maps.Keys(next.Peers))
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		if selectionsEqual(cur[name], next.Peers[name]) {
+			continue
+		}
+		apply("peers."+name, true)
+		servers, search := a.resolveAcceptedDNS(name)
+		changed[name] = peerDNS{servers, search}
+		realiased[name] = !maps.Equal(cur[name].RouteAliases, next.Peers[name].RouteAliases)
+	}
+
+	if len(changed) == 0 && !all {
+		return
+	}
+
+	// Replace the map rather than writing to it, since it is read without
+	// holding mu.
+	if len(changed) > 0 {
+		a.mu.Lock()
+		a.cfg.Peers = maps.Clone(next.Peers)
+		a.mu.Unlock()
+	}
+	a.reresolvePeers(changed, realiased, all)
+}
+
+// peerDNS is the DNS servers and search domains accepted from a peer.
+type peerDNS struct{ servers, search []string }
+
+// reresolvePeers resolves the routes and DNS accepted from connected peers
+// again after their selections or the network policy changed. dnsBefore
+// holds the DNS accepted from each affected peer before the change; with
+// all set, the routes of every connected peer are resolved again. A peer
+// in realiased is reconnected instead, since aliases are exchanged in the
+// offer.
+func (a *Agent) reresolvePeers(dnsBefore map[string]peerDNS, realiased map[string]bool, all bool) {
+	a.mu.Lock()
+	connected := make(map[string]*peerState)
+	for id, ps := range a.peers {
+		if ps.tunnelAllowedIPs != nil {
+			connected[id] = ps
+		}
+	}
+	a.mu.Unlock()
+
+	for _, id := range slices.Sorted(maps.Keys(connected)) {
+		before, ok := dnsBefore[id]
+		if !ok && !all {
+			continue
+		}
+		if realiased[id] {
+			if err := a.ReconnectPeer(id); err != nil {
+				a.log.Warn("reconnecting peer for new route aliases", "peer_id", id, "error", err)
+			}
+			continue
+		}
+		a.reresolveRoutes(id, connected[id])
+		if !ok {
+			continue
+		}
+
+		servers, search := a.resolveAcceptedDNS(id)
+		if slices.Equal(servers, before.servers) && slices.Equal(search, before.search) {
+			continue
+		}
+		a.stopDNSProxy(id)
+		if len(servers) == 0 && len(search) == 0 {
+			if err := a.deps.Network.RevertDNS(a.tunName, a.dnsBackend); err != nil {
+				a.log.Warn("reverting DNS for peer", "peer_id", id, "error", err)
+			} else {
+				a.journalDNS(false)
+			}
+			continue
+		}
+		a.applyPeerDNS(id)
+	}
+
+	// Route priorities decide which peer carries a shared route.
+	a.rebalanceRoutes("")
+}
+
+// reloadBlocked blocks and unblocks peers to match device.blocked_peers.
+func (a *Agent) reloadBlocked(blocked []string) {
+	for _, id := range blocked {
+		if id != "" && id != a.cfg.Device.Name {
+			a.blockPeer(id)
+		}
+	}
+
+	a.mu.Lock()
+	current := slices.Sorted(maps.Keys(a.blocked))
+	a.mu.Unlock()
+	for _, id := range current {
+		if slices.Contains(blocked, id) || !a.unblockPeer(id) {
+			continue
+		}
+		if err := a.connectUnblocked(id); err != nil {
+			a.log.Warn("connecting to unblocked peer", "peer_id", id, "error", err)
+		}
+	}
+}
+
+// selectionsEqual reports whether two per-peer selections are the same.
+func selectionsEqual(x, y config.PeerSelections) bool {
+	return slices.Equal(x.Routes, y.Routes) && slices.Equal(x.DNS, y.DNS) &&
+		slices.Equal(x.DNSSearch, y.DNSSearch) && maps.Equal(x.RouteAliases, y.RouteAliases) &&
+		x.RoutePriority == y.RoutePriority && slices.Equal(x.Allow, y.Allow)
+}
+
+// aclEqual reports whether two configs have the same access control rules.
+func aclEqual(x, y *config.Config) bool {
+	if x.Device.ACLDefault != y.Device.ACLDefault {
+		return false
+	}
+	allow := func(cfg *config.Config) map[string][]string {
+		m := make(map[string][]string)
+		for name, sel := range cfg.Peers {
+			if len(sel.Allow) > 0 {
+				m[name] = sel.Allow
+			}
+		}
+		return m
+	}
+	return maps.EqualFunc(allow(x), allow(y), slices.Equal)
+}
+
+// startConfigWatcher reloads the config when config.toml or secrets.toml
+// change on disk, checking their size and modification time every
+// configWatchInterval. A file caught half-written fails to parse and is
+// read again once the write finishes.
+//
+// The goroutine is owned by Run() and exits when ctx is cancelled.
+func (a *Agent) startConfigWatcher(ctx context.Context) {
+	if a.configPath == "" {
+		return
+	}
+	paths := []string{a.configPath, config.SecretsPathFromConfig(a.configPath)}
+	stamp := func() string {
+		var s string
+		for _, p := range paths {
+			if fi, err := os.Stat(p); err == nil {
+				s += fmt.Sprintf("%d/%d;", fi.ModTime().UnixNano(), fi.Size())
+			}
+		}
+		return s
+	}
+
+	last := stamp()
+	ticker := time.NewTicker(configWatchInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if s := stamp(); s != last {
+				last = s
+				a.log.Debug("config file changed, reloading")
+				if _, err := a.Reload(); err != nil {
+					a.log.Warn("reloading changed config", "error", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// Hub-and-spoke topology. Every device has a role: its device.role, else
+// the one the network policy assigns it ([topology] hubs and spokes), else
+// mesh. Peers advertise a configured role in their signaling metadata, so
+// both sides of a pair agree on it. Spokes connect only to hubs and send
+// the whole tunnel subnet through them; hubs forward it back into the
+// tunnel to the spoke it is addressed to. When several hubs are connected
+// the subnet is shared like any other route (see rebalanceRoutes), so a
+// standby hub takes over when the primary goes away.
+
+// parseRole validates device.role.
+func parseRole(s string) error {
+	switch s {
+	case "", protocol.RoleMesh, protocol.RoleHub, protocol.RoleSpoke:
+		return nil
+	}
+	return fmt.Errorf("device.role: unknown role %q (want %s, %s or %s)",
+		s, protocol.RoleMesh, protocol.RoleHub, protocol.RoleSpoke)
+}
+
+// normalizeRole maps a configured or advertised role to protocol.RoleHub,
+// protocol.RoleSpoke or "" for the full mesh.
+func normalizeRole(role string) string {
+	switch role {
+	case protocol.RoleHub, protocol.RoleSpoke:
+		return role
+	}
+	return ""
+}
+
+// role returns this device's topology role (see normalizeRole). Must be
+// called with a.mu held.
+func (a *Agent) role() string {
+	if role := a.cfg.Device.Role; role != "" {
+		return normalizeRole(role)
+	}
+	return a.netPolicy.RoleOf(a.cfg.Device.Name)
+}
+
+// peerRole returns the topology role of a peer from its advertised
+// metadata or, if it advertises none, the network policy. Must be called
+// with a.mu held.
+func (a *Agent) peerRole(peerID string, metadata map[string]string) string {
+	if role, ok := metadata[protocol.MetaKeyRole]; ok {
+		return normalizeRole(role)
+	}
+	return a.netPolicy.RoleOf(peerID)
+}
+
+// connects reports whether devices with roles x and y connect to each
+// other: a spoke only connects to hubs.
+func connects(x, y string) bool {
+	switch {
+	case x == protocol.RoleSpoke:
+		return y == protocol.RoleHub
+	case y == protocol.RoleSpoke:
+		return x == protocol.RoleHub
+	}
+	return true
+}
+
+// wantsPeer reports whether the topology lets this device connect to a
+// peer. Must be called with a.mu held.
+func (a *Agent) wantsPeer(peerID string, metadata map[string]string) bool {
+	return connects(a.role(), a.peerRole(peerID, metadata))
+}
+
+// hubRoutes returns the routes a spoke sends through a hub: the tunnel
+// subnets, which reach the other spokes. It returns nil for other pairs.
+// Must be called with a.mu held.
+func (a *Agent) hubRoutes(peerID string, ps *peerState) []string {
+	if a.role() != protocol.RoleSpoke || a.peerRole(peerID, ps.metadata) != protocol.RoleHub {
+		return nil
+	}
+	return a.tunnelSubnets()
+}
+
+// tunnelSubnets returns the subnets of this device's tunnel addresses.
+func (a *Agent) tunnelSubnets() []string {
+	var subnets []string
+	for _, addr := range []string{a.cfg.Device.Address, a.cfg.Device.Address6} {
+		if p, err := netip.ParsePrefix(addr); err == nil {
+			subnets = append(subnets, p.Masked().String())
+		}
+	}
+	return subnets
+}
+
+// isTunnelSubnet reports whether route is one of the tunnel subnets. They
+// are routed into the TUN by its addresses already, so no kernel route or
+// route MTU is set for them.
+func (a *Agent) isTunnelSubnet(route string) bool {
+	return slices.Contains(a.tunnelSubnets(), route)
+}
+
+// setupPeerForwarding enables forwarding on the TUN interface when this
+// device is a hub or a relay (device.relay), so packets between peers are
+// routed back into the tunnel.
+func (a *Agent) setupPeerForwarding() {
+	a.mu.Lock()
+	forward := a.role() == protocol.RoleHub || a.cfg.Device.Relay
+	a.mu.Unlock()
+	if !forward {
+		return
+	}
+	if a.opts.userspace != nil || a.opts.tunFD > 0 {
+		a.log.Warn("cannot forward traffic between peers in this mode, not acting as hub or relay")
+		return
+	}
+
+	a.natMu.Lock()
+	err := a.enableForwarding(a.tunName)
+	if err == nil && a.cfg.Device.Address6 != "" {
+		err = a.enableIPv6Forwarding()
+	}
+	a.natMu.Unlock()
+	if err != nil {
+		a.log.Warn("enabling forwarding between peers", "error", err)
+		return
+	}
+	a.startForwardingWatchdog(a.ctx)
+}
+
+// topologyRoles returns the role of this device and of each known peer,
+// to tell whether a network policy update changed the topology. Must be
+// called with a.mu held.
+func (a *Agent) topologyRoles() map[string]string {
+	roles := map[string]string{a.cfg.Device.Name: a.role()}
+	for id, info := range a.directory {
+		roles[id] = a.peerRole(id, info.Metadata)
+	}
+	for id, ps := range a.peers {
+		roles[id] = a.peerRole(id, ps.metadata)
+	}
+	return roles
+}
+
+// applyTopology brings connections in line with the topology after it
+// changed: peers it no longer allows are disconnected, peers on the hub it
+// now allows are connected, and connected hubs get the tunnel subnets
+// added or removed.
+func (a *Agent) applyTopology(ctx context.Context) {
+	a.setupPeerForwarding()
+
+	a.mu.Lock()
+	var drop, update []string
+	for id, ps := range a.peers {
+		switch {
+		case !a.wantsPeer(id, ps.metadata):
+			drop = append(drop, id)
+		case ps.tunnelAllowedIPs != nil:
+			update = append(update, id)
+		}
+	}
+	var connect []protocol.PeerInfo
+	for _, id := range slices.Sorted(maps.Keys(a.directory)) {
+		info := a.directory[id]
+		if _, ok := a.peers[id]; ok || a.blocked[id] || !a.wantsPeer(id, info.Metadata) {
+			continue
+		}
+		connect = append(connect, info)
+	}
+	a.mu.Unlock()
+
+	for _, id := range drop {
+		a.log.Info("disconnecting peer outside the topology", "peer_id", id)
+		a.removePeer(id)
+	}
+	for _, id := range update {
+		a.addWireGuardPeer(id)
+	}
+	for _, info := range connect {
+		a.discoverPeer(ctx, info)
+	}
+}
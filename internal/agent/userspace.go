@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/kuuji/bamgate/internal/netstack"
+	"github.com/kuuji/bamgate/internal/tunnel"
+)
+
+// errUserspace is returned by host network operations that have no
+// equivalent on the userspace network stack.
+var errUserspace = errors.New("not supported in userspace mode")
+
+// WithUserspace runs the tunnel on a userspace network stack instead of a
+// kernel TUN device, so the agent needs no root privileges. Routes and DNS
+// accepted from peers are applied to the stack, and applications reach the
+// mesh through a local SOCKS5 / HTTP proxy on proxyListen (default
+// netstack.DefaultProxyListen).
+//
+// Routes advertised by this device are not forwarded in userspace mode:
+// forwarding and masquerading need the kernel.
+func WithUserspace(stack *netstack.Stack, proxyListen string) Option {
+	return func(o *options) {
+		o.userspace = stack
+		o.proxyListen = proxyListen
+	}
+}
+
+// userspaceDeps replaces the TUN provider and network manager in deps with
+// ones backed by stack. Other dependencies are left untouched.
+func userspaceDeps(deps Deps, stack *netstack.Stack) Deps {
+	deps.TUN = &userspaceTUNProvider{stack: stack}
+	deps.Network = &userspaceNetworkManager{stack: stack}
+	return deps
+}
+
+// startProxy starts the local proxy in userspace mode. It returns nil if
+// the agent is not in userspace mode.
+func (a *Agent) startProxy() (*netstack.Proxy, error) {
+	if a.opts.userspace == nil {
+		return nil, nil
+	}
+	listen := a.opts.proxyListen
+	if listen == "" {
+		listen = netstack.DefaultProxyListen
+	}
+	proxy, err := netstack.ListenProxy(listen, a.opts.userspace, a.log)
+	if err != nil {
+		return nil, fmt.Errorf("starting local proxy: %w", err)
+	}
+	a.log.Info("local SOCKS5/HTTP proxy started", "listen", proxy.Addr())
+	return proxy, nil
+}
+
+// userspaceTUNProvider creates the userspace stack's TUN device.
+type userspaceTUNProvider struct {
+	stack *netstack.Stack
+}
+
+func (p *userspaceTUNProvider) CreateTUN(_ string, mtu int) (tun.Device, error) {
+	return p.stack.Open(mtu)
+}
+
+func (p *userspaceTUNProvider) CreateTUNFromFD(int) (tun.Device, error) {
+	return nil, fmt.Errorf("adopting a TUN file descriptor: %w", errUserspace)
+}
+
+// userspaceNetworkManager applies routes and DNS to the userspace stack.
+// Addresses are fixed when the stack is created, and there is no link,
+// forwarding or host routing table to manage.
+type userspaceNetworkManager struct {
+	stack *netstack.Stack
+}
+
+func (m *userspaceNetworkManager) AddAddress(string, string) error { return nil }
+func (m *userspaceNetworkManager) SetLinkUp(string) error          { return nil }
+
+func (m *userspaceNetworkManager) AddRoute(_ string, cidr string) error {
+	return m.stack.AddRoute(cidr)
+}
+
+func (m *userspaceNetworkManager) RemoveRoute(_ string, cidr string) error {
+	return m.stack.RemoveRoute(cidr)
+}
+
+// SetRouteMTU is a no-op: the netstack's TCP sizes segments by the
+// endpoint's MTU, not per route.
+func (m *userspaceNetworkManager) SetRouteMTU(string, string, int) error { return nil }
+
+func (m *userspaceNetworkManager) GetForwarding(string) (bool, error) {
+	return false, errUserspace
+}
+
+func (m *userspaceNetworkManager) SetForwarding(string, bool) error { return errUserspace }
+func (m *userspaceNetworkManager) GetIPv6Forwarding() (bool, error) { return false, errUserspace }
+func (m *userspaceNetworkManager) SetIPv6Forwarding(bool) error     { return errUserspace }
+
+func (m *userspaceNetworkManager) FindInterfaceForSubnet(string) (string, error) {
+	return "", errUserspace
+}
+
+// LocalRoutes returns nothing: routes inside the stack cannot shadow the
+// host's own networks, so there are no conflicts to detect.
+func (m *userspaceNetworkManager) LocalRoutes(string) ([]tunnel.SubnetInfo, error) {
+	return nil, nil
+}
+
+func (m *userspaceNetworkManager) DiscoverLocalSubnets(excludeCIDRs ...string) ([]tunnel.SubnetInfo, error) {
+	return tunnel.DiscoverLocalSubnets(excludeCIDRs...)
+}
+
+func (m *userspaceNetworkManager) SetDNS(_ string, _ tunnel.DNSBackend, servers, searchDomains []string) error {
+	return m.stack.SetDNS(servers, searchDomains)
+}
+
+func (m *userspaceNetworkManager) RevertDNS(string, tunnel.DNSBackend) error {
+	m.stack.RevertDNS()
+	return nil
+}
+
+func (m *userspaceNetworkManager) RecoverDNS(string, tunnel.DNSBackend) error { return nil }
+
+// WatchNetwork reports changes to the host's network, which the userspace
+// stack still runs over.
+func (m *userspaceNetworkManager) WatchNetwork(ctx context.Context) (<-chan tunnel.NetworkEvent, error) {
+	return tunnel.WatchNetwork(ctx)
+}
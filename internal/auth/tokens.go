@@ -26,6 +26,8 @@ type RegisterResponse struct {
 	RefreshToken string `json:"refresh_token"`
 	Address      string `json:"address"`
 	Subnet       string `json:"subnet"`
+	Address6     string `json:"address6,omitempty"`
+	Subnet6      string `json:"subnet6,omitempty"`
 	TURNSecret   string `json:"turn_secret"`
 	ServerURL    string `json:"server_url"`
 }
@@ -191,6 +193,11 @@ type RefreshResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
+
+	// Address6 is the device's IPv6 tunnel address. Servers that predate
+	// dual-stack support omit it; newer servers assign one on first refresh
+	// so devices registered before the upgrade pick it up.
+	Address6 string `json:"address6,omitempty"`
 }
 
 // Refresh exchanges a refresh token for a new JWT access token and a
@@ -18,7 +18,9 @@ import (
 	"log/slog"
 	"net"
 	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pion/webrtc/v4"
 	"golang.zx2c4.com/wireguard/conn"
@@ -31,12 +33,34 @@ type receivedPacket struct {
 	ep   *Endpoint
 }
 
+// PacketHook observes the encrypted packets a Bind exchanges with a peer,
+// for packet capture. outbound is true for packets sent to the peer. It
+// must not block or retain data.
+type PacketHook func(peerID string, outbound bool, data []byte)
+
+// MissHook is called when WireGuard sends to a peer without a data
+// channel, so the caller can connect to it on demand. It is called for
+// every such send and must not block.
+type MissHook func(peerID string)
+
+// maxPendingPackets is how many packets a Bind with a MissHook holds for a
+// peer until its data channel is registered. WireGuard retries its
+// handshake anyway; holding the first packets saves waiting for that.
+const maxPendingPackets = 16
+
 // Bind implements conn.Bind by transporting WireGuard packets over WebRTC
 // data channels. It is safe for concurrent use.
 type Bind struct {
 	mu    sync.RWMutex
 	peers map[string]*peerChannel // peerID -> data channel + endpoint
 	log   *slog.Logger
+	hook  atomic.Pointer[PacketHook]
+
+	// miss and pending, guarded by mu, connect peers on demand: packets
+	// for a peer without a data channel are queued in pending and sent
+	// when its channel is registered.
+	miss    MissHook
+	pending map[string][][]byte
 
 	recvCh    chan receivedPacket
 	closeCh   chan struct{}
@@ -45,8 +69,26 @@ type Bind struct {
 
 // peerChannel associates a WebRTC data channel with its endpoint.
 type peerChannel struct {
-	dc *webrtc.DataChannel
-	ep *Endpoint
+	dc    *webrtc.DataChannel
+	ep    *Endpoint
+	stats *peerCounters
+}
+
+// PeerStats counts the encrypted WireGuard packets exchanged with a peer
+// since its first data channel was registered.
+type PeerStats struct {
+	TxBytes   uint64
+	TxPackets uint64
+	RxBytes   uint64
+	RxPackets uint64
+}
+
+// peerCounters accumulates PeerStats. They survive data channel
+// replacement (e.g. after an ICE restart) and reset when the peer is
+// removed.
+type peerCounters struct {
+	txBytes, txPackets atomic.Uint64
+	rxBytes, rxPackets atomic.Uint64
 }
 
 // NewBind creates a new Bind. Call SetDataChannel to register data channels
@@ -57,6 +99,7 @@ func NewBind(logger *slog.Logger) *Bind {
 	}
 	return &Bind{
 		peers:   make(map[string]*peerChannel),
+		pending: make(map[string][][]byte),
 		log:     logger.With("component", "bridge"),
 		recvCh:  make(chan receivedPacket, 256),
 		closeCh: make(chan struct{}),
@@ -114,15 +157,44 @@ func (b *Bind) Send(bufs [][]byte, ep conn.Endpoint) error {
 	b.mu.RUnlock()
 
 	if !exists {
-		return errors.New("no data channel for peer: " + endpoint.peerID)
+		return b.sendPending(endpoint.peerID, bufs)
 	}
 
+	hook := b.hook.Load()
 	for _, buf := range bufs {
+		if hook != nil {
+			(*hook)(endpoint.peerID, true, buf)
+		}
 		if err := pc.dc.Send(buf); err != nil {
 			return err
 		}
+		pc.stats.txPackets.Add(1)
+		pc.stats.txBytes.Add(uint64(len(buf)))
+	}
+
+	return nil
+}
+
+// sendPending queues packets for a peer without a data channel and calls
+// the MissHook. Without a MissHook the packets are dropped with an error.
+func (b *Bind) sendPending(peerID string, bufs [][]byte) error {
+	b.mu.Lock()
+	miss := b.miss
+	if miss == nil {
+		b.mu.Unlock()
+		return errors.New("no data channel for peer: " + peerID)
+	}
+	queue := b.pending[peerID]
+	for _, buf := range bufs {
+		if len(queue) == maxPendingPackets {
+			queue = queue[1:] // drop the oldest, like a full UDP buffer
+		}
+		queue = append(queue, slices.Clone(buf))
 	}
+	b.pending[peerID] = queue
+	b.mu.Unlock()
 
+	miss(peerID)
 	return nil
 }
 
@@ -142,6 +214,29 @@ func (b *Bind) BatchSize() int {
 	return 1
 }
 
+// SetPacketHook installs a hook called with every packet sent to or
+// received from a peer. A nil hook removes it.
+func (b *Bind) SetPacketHook(h PacketHook) {
+	if h == nil {
+		b.hook.Store(nil)
+		return
+	}
+	b.hook.Store(&h)
+}
+
+// SetMissHook installs a hook called when WireGuard sends to a peer
+// without a data channel. While a hook is installed such packets are
+// queued, up to maxPendingPackets per peer, and sent once the peer's data
+// channel is registered. A nil hook removes it and drops queued packets.
+func (b *Bind) SetMissHook(h MissHook) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.miss = h
+	if h == nil {
+		clear(b.pending)
+	}
+}
+
 // SetDataChannel registers a WebRTC data channel for a peer. Incoming
 // messages on the data channel are queued into the receive channel for
 // wireguard-go to process. This must be called when a data channel opens.
@@ -149,13 +244,24 @@ func (b *Bind) SetDataChannel(peerID string, dc *webrtc.DataChannel) {
 	ep := NewEndpoint(peerID)
 
 	b.mu.Lock()
-	b.peers[peerID] = &peerChannel{dc: dc, ep: ep}
+	stats := &peerCounters{}
+	if old, ok := b.peers[peerID]; ok {
+		stats = old.stats
+	}
+	b.peers[peerID] = &peerChannel{dc: dc, ep: ep, stats: stats}
+	pending := b.pending[peerID]
+	delete(b.pending, peerID)
 	b.mu.Unlock()
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		// Copy the data — the underlying buffer may be reused by pion.
 		data := make([]byte, len(msg.Data))
 		copy(data, msg.Data)
+		stats.rxPackets.Add(1)
+		stats.rxBytes.Add(uint64(len(data)))
+		if hook := b.hook.Load(); hook != nil {
+			(*hook)(peerID, false, data)
+		}
 
 		select {
 		case b.recvCh <- receivedPacket{data: data, ep: ep}:
@@ -168,6 +274,12 @@ func (b *Bind) SetDataChannel(peerID string, dc *webrtc.DataChannel) {
 	})
 
 	b.log.Info("data channel registered", "peer_id", peerID)
+
+	if len(pending) > 0 {
+		if err := b.Send(pending, ep); err != nil {
+			b.log.Debug("sending queued packets", "peer_id", peerID, "error", err)
+		}
+	}
 }
 
 // RemoveDataChannel unregisters the data channel for a peer. Packets from
@@ -175,11 +287,30 @@ func (b *Bind) SetDataChannel(peerID string, dc *webrtc.DataChannel) {
 func (b *Bind) RemoveDataChannel(peerID string) {
 	b.mu.Lock()
 	delete(b.peers, peerID)
+	delete(b.pending, peerID)
 	b.mu.Unlock()
 
 	b.log.Info("data channel removed", "peer_id", peerID)
 }
 
+// Stats returns the packet counters of each peer with a data channel,
+// keyed by peer ID.
+func (b *Bind) Stats() map[string]PeerStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make(map[string]PeerStats, len(b.peers))
+	for id, pc := range b.peers {
+		stats[id] = PeerStats{
+			TxBytes:   pc.stats.txBytes.Load(),
+			TxPackets: pc.stats.txPackets.Load(),
+			RxBytes:   pc.stats.rxBytes.Load(),
+			RxPackets: pc.stats.rxPackets.Load(),
+		}
+	}
+	return stats
+}
+
 // Reset prepares the Bind for reuse after a Close. This is called
 // automatically by Open, but is available for explicit use in tests.
 func (b *Bind) Reset() {
@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"fmt"
 	"net"
 	"sync"
 	"testing"
@@ -148,6 +149,48 @@ func TestBind_Send_UnknownPeer(t *testing.T) {
 	}
 }
 
+func TestBind_MissHook(t *testing.T) {
+	t.Parallel()
+
+	b := NewBind(nil)
+	missed := make(chan string, maxPendingPackets+4)
+	b.SetMissHook(func(peerID string) { missed <- peerID })
+
+	// Packets for a peer without a data channel are queued, keeping the
+	// newest, and the hook asks for a connection.
+	ep := NewEndpoint("peer-b")
+	for i := range maxPendingPackets + 4 {
+		if err := b.Send([][]byte{[]byte(fmt.Sprintf("packet %d", i))}, ep); err != nil {
+			t.Fatalf("Send() #%d error: %v", i, err)
+		}
+	}
+	if got := <-missed; got != "peer-b" {
+		t.Errorf("miss hook called for %q, want peer-b", got)
+	}
+
+	dc1, dc2 := createDataChannelPair(t)
+	received := make(chan string, maxPendingPackets)
+	dc2.OnMessage(func(msg webrtc.DataChannelMessage) { received <- string(msg.Data) })
+	b.SetDataChannel("peer-b", dc1)
+
+	for i := 4; i < maxPendingPackets+4; i++ {
+		want := fmt.Sprintf("packet %d", i)
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("received %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for queued %q", want)
+		}
+	}
+
+	b.SetMissHook(nil)
+	if err := b.Send([][]byte{[]byte("data")}, NewEndpoint("peer-c")); err == nil {
+		t.Error("Send() to a peer without data channel succeeded after removing the miss hook")
+	}
+}
+
 func TestBind_ParseEndpoint(t *testing.T) {
 	t.Parallel()
 
@@ -326,6 +369,100 @@ func TestBind_DataChannelReceive(t *testing.T) {
 	}
 }
 
+func TestBind_PacketHook(t *testing.T) {
+	t.Parallel()
+
+	b := NewBind(nil)
+	if _, _, err := b.Open(0); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	type seen struct {
+		peerID   string
+		outbound bool
+		data     string
+	}
+	hooked := make(chan seen, 2)
+	b.SetPacketHook(func(peerID string, outbound bool, data []byte) {
+		hooked <- seen{peerID, outbound, string(data)}
+	})
+
+	dcA, dcB := createDataChannelPair(t)
+	b.SetDataChannel("peer-alpha", dcA)
+
+	if err := b.Send([][]byte{[]byte("to peer")}, NewEndpoint("peer-alpha")); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if err := dcB.Send([]byte("from peer")); err != nil {
+		t.Fatalf("dcB.Send() error: %v", err)
+	}
+
+	want := []seen{{"peer-alpha", true, "to peer"}, {"peer-alpha", false, "from peer"}}
+	for _, w := range want {
+		select {
+		case got := <-hooked:
+			if got != w {
+				t.Errorf("hook saw %+v, want %+v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for hook to see %+v", w)
+		}
+	}
+
+	b.SetPacketHook(nil)
+	if err := b.Send([][]byte{[]byte("unobserved")}, NewEndpoint("peer-alpha")); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	select {
+	case got := <-hooked:
+		t.Errorf("removed hook saw %+v", got)
+	default:
+	}
+}
+
+func TestBind_Stats(t *testing.T) {
+	t.Parallel()
+
+	b := NewBind(nil)
+	fns, _, err := b.Open(0)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	dcA, dcB := createDataChannelPair(t)
+	b.SetDataChannel("peer-alpha", dcA)
+
+	for _, msg := range []string{"one", "three"} {
+		if err := b.Send([][]byte{[]byte(msg)}, NewEndpoint("peer-alpha")); err != nil {
+			t.Fatalf("Send() error: %v", err)
+		}
+	}
+	if err := dcB.Send([]byte("reply")); err != nil {
+		t.Fatalf("dcB.Send() error: %v", err)
+	}
+	packets, sizes, eps := [][]byte{make([]byte, 1500)}, []int{0}, make([]conn.Endpoint, 1)
+	if _, err := fns[0](packets, sizes, eps); err != nil {
+		t.Fatalf("ReceiveFunc() error: %v", err)
+	}
+
+	want := PeerStats{TxBytes: 8, TxPackets: 2, RxBytes: 5, RxPackets: 1}
+	if got := b.Stats()["peer-alpha"]; got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// Counters carry over to a replacement data channel and go away with
+	// the peer.
+	dcC, _ := createDataChannelPair(t)
+	b.SetDataChannel("peer-alpha", dcC)
+	if got := b.Stats()["peer-alpha"]; got != want {
+		t.Errorf("Stats() after replacing the data channel = %+v, want %+v", got, want)
+	}
+	b.RemoveDataChannel("peer-alpha")
+	if _, ok := b.Stats()["peer-alpha"]; ok {
+		t.Error("Stats() still reports a removed peer")
+	}
+}
+
 func TestEndpoint_Methods(t *testing.T) {
 	t.Parallel()
 
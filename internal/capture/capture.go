@@ -0,0 +1,433 @@
+// Package capture records packets flowing through the tunnel for
+// "bamgate capture".
+//
+// A Tap sits in two places. Wrapped around the TUN device, it sees the
+// plaintext IP packets entering the tunnel and those WireGuard decrypted
+// from peers; hooked into the bridge, it sees the encrypted WireGuard
+// frames each peer's data channel carries. Capture streams what a session
+// selects as pcapng, with one interface per peer and layer so Wireshark
+// shows who sent what. Nothing is copied while no session is running.
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const (
+	// DefaultSnapLen is the number of bytes kept of each packet when
+	// Options.SnapLen is not set: enough for any tunnel packet.
+	DefaultSnapLen = 65535
+
+	// minSnapLen is the smallest snap length accepted, enough for the
+	// IP and transport headers.
+	minSnapLen = 64
+
+	// sessionBuffer is how many packets a session queues before it drops
+	// new ones, so a slow reader never stalls the tunnel.
+	sessionBuffer = 1024
+
+	// wireGuardPort is the UDP port in the headers synthesized around
+	// encrypted frames, which Wireshark decodes as WireGuard.
+	wireGuardPort = 51820
+)
+
+// Options select what a capture session records and when it stops.
+type Options struct {
+	// Peer restricts the capture to one peer. Empty captures all peers
+	// and packets no peer could be attributed to.
+	Peer string
+
+	// Filter selects plaintext packets. Encrypted frames are selected by
+	// Peer only.
+	Filter Filter
+
+	// Encrypted adds the WireGuard frames exchanged with peers.
+	Encrypted bool
+
+	// SnapLen truncates packets to this many bytes. 0 means
+	// DefaultSnapLen.
+	SnapLen int
+
+	// MaxPackets, MaxBytes and Duration stop the capture after that many
+	// packets, bytes of output or time. 0 means no limit.
+	MaxPackets int
+	MaxBytes   int64
+	Duration   time.Duration
+}
+
+// Stats summarize a finished capture.
+type Stats struct {
+	Packets int    // packets written
+	Bytes   int64  // bytes of pcapng output
+	Dropped uint64 // packets lost because the output was too slow
+}
+
+// ErrLimitReached is returned by Capture when MaxPackets, MaxBytes or
+// Duration ended it.
+var ErrLimitReached = errors.New("capture limit reached")
+
+// record is one captured packet queued for a session.
+type record struct {
+	ts        time.Time
+	peer      string
+	encrypted bool
+	outbound  bool
+	data      []byte // at most the session's snap length
+	origLen   int
+}
+
+// session is a running capture.
+type session struct {
+	opts Options
+	ch   chan record
+
+	// drops counts the packets lost per interface, see interfaceFor.
+	// Guarded by Tap.mu.
+	drops map[iface]uint64
+}
+
+// iface identifies a pcapng interface: one per peer and layer.
+type iface struct {
+	peer      string
+	encrypted bool
+}
+
+// peerPrefixes are the addresses a peer's plaintext packets come from or
+// go to: its tunnel addresses and the routes it carries.
+type peerPrefixes struct {
+	name     string
+	prefixes []netip.Prefix
+}
+
+// Tap distributes packets to capture sessions. It is safe for concurrent
+// use; its hooks cost an atomic load while no session is running.
+type Tap struct {
+	log   *slog.Logger
+	peers atomic.Pointer[[]peerPrefixes]
+
+	mu        sync.Mutex
+	sessions  []*session
+	active    atomic.Bool // len(sessions) > 0
+	encrypted atomic.Bool // some session wants encrypted frames
+}
+
+// NewTap creates a Tap with no sessions.
+func NewTap(logger *slog.Logger) *Tap {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Tap{log: logger.With("component", "capture")}
+}
+
+// SetPeers replaces the prefixes plaintext packets are attributed to
+// peers by, keyed by peer name. The most specific prefix wins.
+func (t *Tap) SetPeers(peers map[string][]netip.Prefix) {
+	list := make([]peerPrefixes, 0, len(peers))
+	for name, prefixes := range peers {
+		list = append(list, peerPrefixes{name: name, prefixes: prefixes})
+	}
+	t.peers.Store(&list)
+}
+
+// PeerFor returns the peer plaintext packets to or from addr are
+// attributed to, or "" if none.
+func (t *Tap) PeerFor(addr netip.Addr) string {
+	peers := t.peers.Load()
+	if peers == nil {
+		return ""
+	}
+	best, bits := "", -1
+	for _, p := range *peers {
+		for _, prefix := range p.prefixes {
+			if prefix.Contains(addr) && prefix.Bits() > bits {
+				best, bits = p.name, prefix.Bits()
+			}
+		}
+	}
+	return best
+}
+
+// Wrap returns dev with the plaintext packets it reads and writes passed
+// to the tap. Packets from peers are seen before anything wrapped inside,
+// such as the ACL filter, can drop them.
+func (t *Tap) Wrap(dev tun.Device) tun.Device {
+	return &device{Device: dev, tap: t}
+}
+
+// device is a tun.Device recording packets for a Tap.
+type device struct {
+	tun.Device
+	tap *Tap
+}
+
+// Read reads packets headed into the tunnel.
+func (d *device) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := d.Device.Read(bufs, sizes, offset)
+	if d.tap.active.Load() {
+		for i := 0; i < n; i++ {
+			d.tap.plaintext(bufs[i][offset:offset+sizes[i]], true)
+		}
+	}
+	return n, err
+}
+
+// Write delivers packets from peers.
+func (d *device) Write(bufs [][]byte, offset int) (int, error) {
+	if d.tap.active.Load() {
+		for _, buf := range bufs {
+			d.tap.plaintext(buf[offset:], false)
+		}
+	}
+	return d.Device.Write(bufs, offset)
+}
+
+// plaintext records a packet read from (outbound) or written to the TUN
+// device, attributing it to the peer at the far end.
+func (t *Tap) plaintext(b []byte, outbound bool) {
+	p, ok := parsePacket(b)
+	peer := ""
+	if ok {
+		if outbound {
+			peer = t.PeerFor(p.dst)
+		} else {
+			peer = t.PeerFor(p.src)
+		}
+	}
+	t.deliver(record{ts: time.Now(), peer: peer, outbound: outbound, origLen: len(b)}, b)
+}
+
+// Frame records an encrypted WireGuard frame sent to or received from a
+// peer's data channel. It does not retain b. Its signature matches
+// bridge.PacketHook.
+func (t *Tap) Frame(peerID string, outbound bool, b []byte) {
+	if !t.encrypted.Load() {
+		return
+	}
+	t.deliver(record{ts: time.Now(), peer: peerID, encrypted: true, outbound: outbound, origLen: len(b)}, b)
+}
+
+// deliver queues a copy of b to every session selecting it.
+func (t *Tap) deliver(r record, b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.sessions {
+		if r.encrypted && !s.opts.Encrypted {
+			continue
+		}
+		if s.opts.Peer != "" && s.opts.Peer != r.peer {
+			continue
+		}
+		if !r.encrypted && !s.opts.Filter.Match(b) {
+			continue
+		}
+		r.data = append([]byte(nil), b[:min(len(b), s.opts.SnapLen)]...)
+		select {
+		case s.ch <- r:
+		default:
+			s.drops[iface{r.peer, r.encrypted}]++
+		}
+	}
+}
+
+// add registers a session.
+func (t *Tap) add(s *session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessions = append(t.sessions, s)
+	t.update()
+}
+
+// remove unregisters a session.
+func (t *Tap) remove(s *session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, cur := range t.sessions {
+		if cur == s {
+			t.sessions = append(t.sessions[:i], t.sessions[i+1:]...)
+			break
+		}
+	}
+	t.update()
+}
+
+// update refreshes the fast-path flags. t.mu must be held.
+func (t *Tap) update() {
+	encrypted := false
+	for _, s := range t.sessions {
+		encrypted = encrypted || s.opts.Encrypted
+	}
+	t.active.Store(len(t.sessions) > 0)
+	t.encrypted.Store(encrypted)
+}
+
+// Capture records packets selected by opts to w as pcapng until ctx is
+// cancelled, a limit is reached (ErrLimitReached) or writing fails. The
+// output is valid pcapng after every packet, so it can be cut off at any
+// point. Packets are lost rather than stalling the tunnel when w cannot
+// keep up; their number is recorded in an interface statistics block at
+// the end.
+func (t *Tap) Capture(ctx context.Context, w io.Writer, opts Options) (Stats, error) {
+	switch {
+	case opts.SnapLen == 0:
+		opts.SnapLen = DefaultSnapLen
+	case opts.SnapLen < minSnapLen || opts.SnapLen > DefaultSnapLen:
+		return Stats{}, fmt.Errorf("snap length must be between %d and %d", minSnapLen, DefaultSnapLen)
+	}
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	pw, err := newPcapWriter(w, "bamgate")
+	if err != nil {
+		return Stats{}, fmt.Errorf("writing capture header: %w", err)
+	}
+
+	s := &session{opts: opts, ch: make(chan record, sessionBuffer), drops: make(map[iface]uint64)}
+	t.add(s)
+	t.log.Info("capture started", "peer", opts.Peer, "filter", opts.Filter.String(), "encrypted", opts.Encrypted)
+
+	var stats Stats
+	var writeErr error
+	err = func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				if opts.Duration > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return ErrLimitReached
+				}
+				return ctx.Err()
+			case r := <-s.ch:
+				if writeErr = pw.writeRecord(r, opts.SnapLen); writeErr != nil {
+					return fmt.Errorf("writing capture: %w", writeErr)
+				}
+				stats.Packets++
+				if (opts.MaxPackets > 0 && stats.Packets >= opts.MaxPackets) ||
+					(opts.MaxBytes > 0 && pw.n >= opts.MaxBytes) {
+					return ErrLimitReached
+				}
+			}
+		}
+	}()
+	t.remove(s)
+
+	// remove synchronized with deliver, so the drop counts are final.
+	for _, key := range slices.SortedFunc(maps.Keys(s.drops), compareIface) {
+		stats.Dropped += s.drops[key]
+		if writeErr != nil {
+			continue
+		}
+		name, desc := interfaceFor(key.peer, key.encrypted)
+		id, werr := pw.interfaceID(name, desc, opts.SnapLen)
+		if werr == nil {
+			werr = pw.writeStatistics(id, time.Now(), s.drops[key])
+		}
+		if werr != nil {
+			writeErr = werr
+			t.log.Debug("writing capture drop counts", "error", werr)
+		}
+	}
+	stats.Bytes = pw.n
+	t.log.Info("capture stopped", "packets", stats.Packets, "bytes", stats.Bytes, "dropped", stats.Dropped)
+	return stats, err
+}
+
+// compareIface orders interfaces by peer, plaintext first.
+func compareIface(a, b iface) int {
+	if c := strings.Compare(a.peer, b.peer); c != 0 {
+		return c
+	}
+	switch {
+	case a.encrypted == b.encrypted:
+		return 0
+	case b.encrypted:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// writeRecord writes a captured packet on the interface for its peer and
+// layer. Encrypted frames are truncated further so that, with their
+// synthesized headers, they stay within snapLen.
+func (p *pcapWriter) writeRecord(r record, snapLen int) error {
+	name, desc := interfaceFor(r.peer, r.encrypted)
+	id, err := p.interfaceID(name, desc, snapLen)
+	if err != nil {
+		return err
+	}
+	data, origLen := r.data, r.origLen
+	if r.encrypted {
+		hdr := udpHeader(r.outbound, origLen)
+		data = append(hdr, data[:min(len(data), snapLen-len(hdr))]...)
+		origLen += len(hdr)
+	}
+	return p.writePacket(id, r.ts, r.outbound, data, origLen)
+}
+
+// interfaceFor names the pcapng interface for a peer's plaintext or
+// encrypted packets.
+func interfaceFor(peer string, encrypted bool) (name, desc string) {
+	switch {
+	case peer == "":
+		return "unknown", "Tunnel traffic no peer could be attributed to"
+	case encrypted:
+		return peer + "/wg", "WireGuard frames on the data channel of peer " + peer +
+			" (UDP/IPv4 headers synthesized: 127.0.0.1 is this device)"
+	default:
+		return peer, "Decrypted tunnel traffic with peer " + peer
+	}
+}
+
+// udpHeader synthesizes IPv4 and UDP headers for an encrypted frame of
+// n bytes so packet analyzers decode it as WireGuard. This device is
+// 127.0.0.1 and the peer 127.0.0.2. The UDP checksum is left out, which
+// IPv4 allows.
+func udpHeader(outbound bool, n int) []byte {
+	local, remote := [4]byte{127, 0, 0, 1}, [4]byte{127, 0, 0, 2}
+	src, dst := remote, local
+	if outbound {
+		src, dst = local, remote
+	}
+
+	h := make([]byte, 28)
+	h[0] = 0x45 // version 4, 20-byte header
+	binary.BigEndian.PutUint16(h[2:4], uint16(28+n))
+	h[8] = 64 // TTL
+	h[9] = protoUDP
+	copy(h[12:16], src[:])
+	copy(h[16:20], dst[:])
+	binary.BigEndian.PutUint16(h[10:12], ipChecksum(h[:20]))
+
+	binary.BigEndian.PutUint16(h[20:22], wireGuardPort)
+	binary.BigEndian.PutUint16(h[22:24], wireGuardPort)
+	binary.BigEndian.PutUint16(h[24:26], uint16(8+n))
+	return h
+}
+
+// ipChecksum computes the IPv4 header checksum of h.
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(h[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,303 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// fakeTUN returns queued packets from Read and discards writes.
+type fakeTUN struct {
+	mu      sync.Mutex
+	inbound [][]byte // returned by Read
+}
+
+func (d *fakeTUN) File() *os.File { return nil }
+
+func (d *fakeTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for n < len(bufs) && len(d.inbound) > 0 {
+		sizes[n] = copy(bufs[n][offset:], d.inbound[0])
+		d.inbound = d.inbound[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (d *fakeTUN) Write(bufs [][]byte, offset int) (int, error) { return len(bufs), nil }
+func (d *fakeTUN) MTU() (int, error)                            { return 1420, nil }
+func (d *fakeTUN) Name() (string, error)                        { return "fake0", nil }
+func (d *fakeTUN) Events() <-chan tun.Event                     { return nil }
+func (d *fakeTUN) Close() error                                 { return nil }
+func (d *fakeTUN) BatchSize() int                               { return 1 }
+
+// block is a pcapng block read back from a capture.
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits a pcapng stream into blocks, checking the framing.
+func readBlocks(t *testing.T, b []byte) []block {
+	t.Helper()
+	var blocks []block
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block header: %d bytes left", len(b))
+		}
+		typ := binary.LittleEndian.Uint32(b[0:4])
+		total := int(binary.LittleEndian.Uint32(b[4:8]))
+		if total%4 != 0 || total < 12 || total > len(b) {
+			t.Fatalf("block %#x: bad length %d (%d bytes left)", typ, total, len(b))
+		}
+		if trailer := int(binary.LittleEndian.Uint32(b[total-4 : total])); trailer != total {
+			t.Fatalf("block %#x: trailing length %d, want %d", typ, trailer, total)
+		}
+		blocks = append(blocks, block{typ: typ, body: b[8 : total-4]})
+		b = b[total:]
+	}
+	return blocks
+}
+
+// option returns the value of the first option with code in opts.
+func option(opts []byte, code uint16) ([]byte, bool) {
+	for len(opts) >= 4 {
+		c := binary.LittleEndian.Uint16(opts[0:2])
+		n := int(binary.LittleEndian.Uint16(opts[2:4]))
+		if c == optEndOfOpt || len(opts) < 4+n {
+			break
+		}
+		if c == code {
+			return opts[4 : 4+n], true
+		}
+		opts = opts[4+(n+3)&^3:]
+	}
+	return nil, false
+}
+
+// packetBlock is an enhanced packet block read back from a capture.
+type packetBlock struct {
+	iface    string
+	capLen   int
+	origLen  int
+	outbound bool
+	data     []byte
+}
+
+// readPackets parses a capture into its packets, resolving interface names.
+func readPackets(t *testing.T, b []byte) []packetBlock {
+	t.Helper()
+	blocks := readBlocks(t, b)
+	if len(blocks) == 0 || blocks[0].typ != blockSHB {
+		t.Fatal("capture does not start with a section header")
+	}
+	if magic := binary.LittleEndian.Uint32(blocks[0].body[0:4]); magic != byteOrderMagic {
+		t.Fatalf("byte order magic = %#x", magic)
+	}
+
+	var ifaces []string
+	var packets []packetBlock
+	for _, blk := range blocks[1:] {
+		switch blk.typ {
+		case blockIDB:
+			if lt := binary.LittleEndian.Uint16(blk.body[0:2]); lt != linkTypeRaw {
+				t.Errorf("link type = %d, want %d", lt, linkTypeRaw)
+			}
+			name, _ := option(blk.body[8:], optIfName)
+			ifaces = append(ifaces, string(name))
+		case blockEPB:
+			id := int(binary.LittleEndian.Uint32(blk.body[0:4]))
+			if id >= len(ifaces) {
+				t.Fatalf("packet on undescribed interface %d", id)
+			}
+			capLen := int(binary.LittleEndian.Uint32(blk.body[12:16]))
+			p := packetBlock{
+				iface:   ifaces[id],
+				capLen:  capLen,
+				origLen: int(binary.LittleEndian.Uint32(blk.body[16:20])),
+				data:    blk.body[20 : 20+capLen],
+			}
+			flags, ok := option(blk.body[20+(capLen+3)&^3:], optEpbFlags)
+			if !ok {
+				t.Fatal("packet without direction flags")
+			}
+			p.outbound = binary.LittleEndian.Uint32(flags) == flagOutbound
+			packets = append(packets, p)
+		}
+	}
+	return packets
+}
+
+// waitActive waits until n sessions are registered.
+func waitActive(t *testing.T, tap *Tap, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		tap.mu.Lock()
+		got := len(tap.sessions)
+		tap.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d capture sessions", n)
+}
+
+// startCapture runs a capture in the background and returns a function
+// that waits for it and returns its output.
+func startCapture(t *testing.T, tap *Tap, opts Options) func() ([]byte, Stats, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	type result struct {
+		stats Stats
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stats, err := tap.Capture(context.Background(), &buf, opts)
+		done <- result{stats, err}
+	}()
+	waitActive(t, tap, 1)
+	return func() ([]byte, Stats, error) {
+		select {
+		case r := <-done:
+			return buf.Bytes(), r.stats, r.err
+		case <-time.After(5 * time.Second):
+			t.Fatal("capture did not stop")
+			return nil, Stats{}, nil
+		}
+	}
+}
+
+func TestTap_Capture(t *testing.T) {
+	t.Parallel()
+
+	tap := NewTap(nil)
+	tap.SetPeers(map[string][]netip.Prefix{
+		"nas":   {netip.MustParsePrefix("10.0.0.2/32"), netip.MustParsePrefix("192.168.1.0/24")},
+		"phone": {netip.MustParsePrefix("10.0.0.3/32")},
+	})
+	dev := &fakeTUN{}
+	wrapped := tap.Wrap(dev)
+
+	filter, err := ParseFilter("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait := startCapture(t, tap, Options{Filter: filter, Encrypted: true, SnapLen: 64, MaxPackets: 4})
+
+	// Outbound to the NAS's LAN, then a reply from it.
+	dev.inbound = [][]byte{portPacket(protoTCP, "10.0.0.1", 50000, "192.168.1.10", 22)}
+	bufs, sizes := [][]byte{make([]byte, 1500)}, []int{0}
+	if _, err := wrapped.Read(bufs, sizes, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Filtered out, then from the phone, with a large payload.
+	if _, err := wrapped.Write([][]byte{portPacket(protoUDP, "10.0.0.3", 53, "10.0.0.1", 40000)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	big := append(portPacket(protoTCP, "10.0.0.3", 443, "10.0.0.1", 50001), make([]byte, 1000)...)
+	if _, err := wrapped.Write([][]byte{big}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// From an address no peer owns, then an encrypted frame to the phone.
+	if _, err := wrapped.Write([][]byte{portPacket(protoTCP, "172.16.0.1", 80, "10.0.0.1", 50002)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	tap.Frame("phone", true, make([]byte, 148))
+
+	out, stats, err := wait()
+	if !errors.Is(err, ErrLimitReached) {
+		t.Fatalf("Capture error = %v, want ErrLimitReached", err)
+	}
+	if stats.Packets != 4 || stats.Bytes != int64(len(out)) {
+		t.Errorf("stats = %+v, want 4 packets and %d bytes", stats, len(out))
+	}
+
+	packets := readPackets(t, out)
+	want := []struct {
+		iface    string
+		outbound bool
+		capLen   int
+		origLen  int
+	}{
+		{"nas", true, 40, 40},
+		{"phone", false, 64, 1040},
+		{"unknown", false, 40, 40},
+		{"phone/wg", true, 64, 148 + 28},
+	}
+	if len(packets) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(packets), len(want))
+	}
+	for i, w := range want {
+		p := packets[i]
+		if p.iface != w.iface || p.outbound != w.outbound || p.capLen != w.capLen || p.origLen != w.origLen {
+			t.Errorf("packet %d = {%s outbound=%v %d/%d}, want {%s outbound=%v %d/%d}", i,
+				p.iface, p.outbound, p.capLen, p.origLen, w.iface, w.outbound, w.capLen, w.origLen)
+		}
+	}
+
+	// The encrypted frame is wrapped in UDP/IPv4 to the WireGuard port.
+	hdr, ok := parsePacket(packets[3].data)
+	if !ok || hdr.proto != protoUDP || hdr.dport != wireGuardPort || hdr.dst != netip.MustParseAddr("127.0.0.2") {
+		t.Errorf("encrypted frame header = %+v", hdr)
+	}
+	if ipChecksum(packets[3].data[:20]) != 0 {
+		t.Error("synthesized IPv4 header checksum is wrong")
+	}
+
+	// The session is gone: the hooks are idle again.
+	if tap.active.Load() || tap.encrypted.Load() {
+		t.Error("tap still active after the capture stopped")
+	}
+}
+
+func TestTap_CapturePeer(t *testing.T) {
+	t.Parallel()
+
+	tap := NewTap(nil)
+	tap.SetPeers(map[string][]netip.Prefix{
+		"nas":   {netip.MustParsePrefix("10.0.0.2/32")},
+		"phone": {netip.MustParsePrefix("10.0.0.3/32")},
+	})
+	wrapped := tap.Wrap(&fakeTUN{})
+
+	wait := startCapture(t, tap, Options{Peer: "phone", MaxPackets: 1})
+	tap.Frame("phone", false, make([]byte, 32)) // not requested
+	for _, src := range []string{"10.0.0.2", "10.0.0.3"} {
+		if _, err := wrapped.Write([][]byte{portPacket(protoTCP, src, 22, "10.0.0.1", 50000)}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, _, err := wait()
+	if !errors.Is(err, ErrLimitReached) {
+		t.Fatalf("Capture error = %v, want ErrLimitReached", err)
+	}
+	packets := readPackets(t, out)
+	if len(packets) != 1 || packets[0].iface != "phone" {
+		t.Fatalf("packets = %+v, want one from phone", packets)
+	}
+}
+
+func TestTap_CaptureInvalidSnapLen(t *testing.T) {
+	t.Parallel()
+
+	tap := NewTap(nil)
+	for _, snapLen := range []int{-1, 10, DefaultSnapLen + 1} {
+		if _, err := tap.Capture(context.Background(), &bytes.Buffer{}, Options{SnapLen: snapLen}); err == nil {
+			t.Errorf("Capture with snap length %d succeeded, want error", snapLen)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// IP protocol numbers.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// Filter selects packets by a small subset of the tcpdump expression
+// language: primitives joined by "and", each optionally negated with
+// "not":
+//
+//	tcp | udp | icmp | icmp6 | ip | ip6
+//	[src|dst] host ADDR
+//	[src|dst] net CIDR
+//	[src|dst] port N
+//
+// The zero Filter matches every packet.
+type Filter struct {
+	terms []term
+	expr  string
+}
+
+// term is one, possibly negated, primitive of a Filter.
+type term struct {
+	not   bool
+	match func(packet) bool
+}
+
+// packet is what a Filter needs from an IP packet.
+type packet struct {
+	version  int
+	proto    uint8
+	src, dst netip.Addr
+
+	// sport and dport are the TCP/UDP ports; hasPorts is false for other
+	// protocols, non-first fragments and truncated packets.
+	sport, dport uint16
+	hasPorts     bool
+}
+
+// ParseFilter parses a filter expression. An empty expression matches
+// every packet.
+func ParseFilter(expr string) (Filter, error) {
+	f := Filter{expr: strings.Join(strings.Fields(expr), " ")}
+	tokens := strings.Fields(expr)
+	for len(tokens) > 0 {
+		var t term
+		if tokens[0] == "not" {
+			t.not = true
+			tokens = tokens[1:]
+		}
+		match, rest, err := parsePrimitive(tokens)
+		if err != nil {
+			return Filter{}, fmt.Errorf("parsing filter %q: %w", expr, err)
+		}
+		t.match = match
+		f.terms = append(f.terms, t)
+
+		tokens = rest
+		if len(tokens) == 0 {
+			break
+		}
+		if tokens[0] != "and" {
+			return Filter{}, fmt.Errorf("parsing filter %q: expected \"and\", got %q", expr, tokens[0])
+		}
+		tokens = tokens[1:]
+		if len(tokens) == 0 {
+			return Filter{}, fmt.Errorf("parsing filter %q: expression ends with \"and\"", expr)
+		}
+	}
+	return f, nil
+}
+
+// parsePrimitive parses the primitive at the start of tokens and returns
+// the tokens after it.
+func parsePrimitive(tokens []string) (func(packet) bool, []string, error) {
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("missing primitive after \"not\"")
+	}
+
+	switch tokens[0] {
+	case "tcp":
+		return protoIs(protoTCP), tokens[1:], nil
+	case "udp":
+		return protoIs(protoUDP), tokens[1:], nil
+	case "icmp":
+		return protoIs(protoICMP), tokens[1:], nil
+	case "icmp6":
+		return protoIs(protoICMPv6), tokens[1:], nil
+	case "ip":
+		return func(p packet) bool { return p.version == 4 }, tokens[1:], nil
+	case "ip6":
+		return func(p packet) bool { return p.version == 6 }, tokens[1:], nil
+	}
+
+	src, dst := true, true
+	switch tokens[0] {
+	case "src":
+		dst = false
+		tokens = tokens[1:]
+	case "dst":
+		src = false
+		tokens = tokens[1:]
+	}
+	if len(tokens) < 2 {
+		return nil, nil, fmt.Errorf("expected \"host\", \"net\" or \"port\" with a value")
+	}
+
+	kind, value := tokens[0], tokens[1]
+	switch kind {
+	case "host":
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid host %q", value)
+		}
+		return addrIn(netip.PrefixFrom(addr, addr.BitLen()), src, dst), tokens[2:], nil
+	case "net":
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid net %q", value)
+		}
+		return addrIn(prefix.Masked(), src, dst), tokens[2:], nil
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || port == 0 {
+			return nil, nil, fmt.Errorf("invalid port %q", value)
+		}
+		return portIs(uint16(port), src, dst), tokens[2:], nil
+	}
+	return nil, nil, fmt.Errorf("unknown primitive %q", kind)
+}
+
+func protoIs(proto uint8) func(packet) bool {
+	return func(p packet) bool { return p.proto == proto }
+}
+
+func addrIn(prefix netip.Prefix, src, dst bool) func(packet) bool {
+	return func(p packet) bool {
+		return (src && prefix.Contains(p.src)) || (dst && prefix.Contains(p.dst))
+	}
+}
+
+func portIs(port uint16, src, dst bool) func(packet) bool {
+	return func(p packet) bool {
+		return p.hasPorts && ((src && p.sport == port) || (dst && p.dport == port))
+	}
+}
+
+// String returns the normalized expression.
+func (f Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the IP packet b matches the filter. Packets that
+// cannot be parsed match only the empty filter.
+func (f Filter) Match(b []byte) bool {
+	if len(f.terms) == 0 {
+		return true
+	}
+	p, ok := parsePacket(b)
+	if !ok {
+		return false
+	}
+	for _, t := range f.terms {
+		if t.match(p) == t.not {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePacket extracts addresses, protocol and ports from an IPv4 or IPv6
+// packet. IPv6 extension headers are not followed.
+func parsePacket(b []byte) (packet, bool) {
+	if len(b) < 1 {
+		return packet{}, false
+	}
+	var p packet
+	var l4 []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return packet{}, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return packet{}, false
+		}
+		p.version = 4
+		p.proto = b[9]
+		p.src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.dst = netip.AddrFrom4([4]byte(b[16:20]))
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+			return p, true // non-first fragment
+		}
+		l4 = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return packet{}, false
+		}
+		p.version = 6
+		p.proto = b[6]
+		p.src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.dst = netip.AddrFrom16([16]byte(b[24:40]))
+		l4 = b[40:]
+	default:
+		return packet{}, false
+	}
+
+	if (p.proto == protoTCP || p.proto == protoUDP) && len(l4) >= 4 {
+		p.sport = binary.BigEndian.Uint16(l4[0:2])
+		p.dport = binary.BigEndian.Uint16(l4[2:4])
+		p.hasPorts = true
+	}
+	return p, true
+}
@@ -0,0 +1,104 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// ipv4 builds an IPv4 packet with the given protocol and L4 payload.
+func ipv4(proto uint8, src, dst string, l4 []byte) []byte {
+	b := make([]byte, 20+len(l4))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = proto
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(b[12:16], s[:])
+	copy(b[16:20], d[:])
+	copy(b[20:], l4)
+	return b
+}
+
+// portPacket builds a TCP or UDP packet.
+func portPacket(proto uint8, src string, sport uint16, dst string, dport uint16) []byte {
+	l4 := make([]byte, 20)
+	binary.BigEndian.PutUint16(l4[0:2], sport)
+	binary.BigEndian.PutUint16(l4[2:4], dport)
+	return ipv4(proto, src, dst, l4)
+}
+
+func TestParseFilter(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		"port",
+		"host 10.0.0.300",
+		"net 10.0.0.0/33",
+		"port 0",
+		"port 70000",
+		"tcp udp",
+		"tcp and",
+		"not",
+		"src tcp",
+		"proto 6",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+			if _, err := ParseFilter(expr); err == nil {
+				t.Errorf("ParseFilter(%q) succeeded, want error", expr)
+			}
+		})
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	t.Parallel()
+
+	ssh := portPacket(protoTCP, "10.0.0.2", 50000, "192.168.1.10", 22)
+	dns := portPacket(protoUDP, "10.0.0.2", 40000, "192.168.1.1", 53)
+	ping := ipv4(protoICMP, "10.0.0.3", "192.168.1.10", make([]byte, 8))
+
+	tests := []struct {
+		expr string
+		pkt  []byte
+		want bool
+	}{
+		{"", ssh, true},
+		{"", []byte{0x00}, true},
+		{"tcp", []byte{0x00}, false},
+		{"tcp", ssh, true},
+		{"tcp", dns, false},
+		{"udp and port 53", dns, true},
+		{"icmp", ping, true},
+		{"ip", ping, true},
+		{"ip6", ping, false},
+		{"host 192.168.1.10", ssh, true},
+		{"host 192.168.1.10", dns, false},
+		{"src host 192.168.1.10", ssh, false},
+		{"dst host 192.168.1.10", ssh, true},
+		{"net 192.168.1.0/24", dns, true},
+		{"net 192.168.1.7/24", dns, true},
+		{"port 22", ssh, true},
+		{"src port 22", ssh, false},
+		{"dst port 22", ssh, true},
+		{"port 22", ping, false},
+		{"not port 22", ssh, false},
+		{"not port 22", ping, true},
+		{"tcp and host 192.168.1.10 and not port 443", ssh, true},
+		{"host 10.0.0.3 and icmp", ssh, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			t.Parallel()
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q): %v", tt.expr, err)
+			}
+			if got := f.Match(tt.pkt); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng block types and options (draft-ietf-opsawg-pcapng).
+const (
+	blockSHB = 0x0a0d0d0a // section header
+	blockIDB = 0x00000001 // interface description
+	blockEPB = 0x00000006 // enhanced packet
+	blockISB = 0x00000005 // interface statistics
+
+	byteOrderMagic = 0x1a2b3c4d
+
+	optEndOfOpt  = 0
+	optShbUserAp = 4 // shb_userappl
+	optIfName    = 2 // if_name
+	optIfDesc    = 3 // if_description
+	optEpbFlags  = 2 // epb_flags
+	optIsbIfDrop = 5 // isb_ifdrop
+
+	// epb_flags direction bits.
+	flagInbound  = 1
+	flagOutbound = 2
+
+	// linkTypeRaw is LINKTYPE_RAW: packets start with an IPv4 or IPv6
+	// header.
+	linkTypeRaw = 101
+)
+
+// pcapWriter writes a pcapng stream: a section header followed by
+// interface descriptions, added as interfaces are first used, and
+// enhanced packet blocks. Timestamps use the default microsecond
+// resolution.
+type pcapWriter struct {
+	w     io.Writer
+	ifIDs map[string]uint32 // interface name -> index in the section
+	buf   []byte
+	n     int64 // bytes written
+}
+
+// newPcapWriter writes the section header to w and returns a writer for
+// the section. application names the capturing software.
+func newPcapWriter(w io.Writer, application string) (*pcapWriter, error) {
+	p := &pcapWriter{w: w, ifIDs: make(map[string]uint32)}
+
+	var body []byte
+	body = binary.LittleEndian.AppendUint32(body, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // major version
+	body = binary.LittleEndian.AppendUint16(body, 0) // minor version
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0))
+	body = appendOption(body, optShbUserAp, []byte(application))
+	body = appendOption(body, optEndOfOpt, nil)
+	if err := p.writeBlock(blockSHB, body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// interfaceID returns the index of the named interface, describing it in
+// the stream the first time it is seen.
+func (p *pcapWriter) interfaceID(name, desc string, snapLen int) (uint32, error) {
+	if id, ok := p.ifIDs[name]; ok {
+		return id, nil
+	}
+
+	var body []byte
+	body = binary.LittleEndian.AppendUint16(body, linkTypeRaw)
+	body = binary.LittleEndian.AppendUint16(body, 0) // reserved
+	body = binary.LittleEndian.AppendUint32(body, uint32(snapLen))
+	body = appendOption(body, optIfName, []byte(name))
+	if desc != "" {
+		body = appendOption(body, optIfDesc, []byte(desc))
+	}
+	body = appendOption(body, optEndOfOpt, nil)
+	if err := p.writeBlock(blockIDB, body); err != nil {
+		return 0, err
+	}
+
+	id := uint32(len(p.ifIDs))
+	p.ifIDs[name] = id
+	return id, nil
+}
+
+// writePacket writes one packet captured on interface id. data may be
+// shorter than origLen if it was truncated to the snap length.
+func (p *pcapWriter) writePacket(id uint32, ts time.Time, outbound bool, data []byte, origLen int) error {
+	body := appendTimestamp(binary.LittleEndian.AppendUint32(p.buf[:0], id), ts)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(origLen))
+	body = append(body, data...)
+	body = appendPadding(body)
+
+	flags := uint32(flagInbound)
+	if outbound {
+		flags = flagOutbound
+	}
+	body = appendOption(body, optEpbFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	body = appendOption(body, optEndOfOpt, nil)
+	p.buf = body
+	return p.writeBlock(blockEPB, body)
+}
+
+// writeStatistics records that dropped packets of interface id were lost
+// before they could be written.
+func (p *pcapWriter) writeStatistics(id uint32, ts time.Time, dropped uint64) error {
+	body := appendTimestamp(binary.LittleEndian.AppendUint32(nil, id), ts)
+	body = appendOption(body, optIsbIfDrop, binary.LittleEndian.AppendUint64(nil, dropped))
+	body = appendOption(body, optEndOfOpt, nil)
+	return p.writeBlock(blockISB, body)
+}
+
+// writeBlock frames body as a block of the given type (type, total
+// length, body, total length again) and writes it with a single Write, so
+// a flushing writer never sends a partial block.
+func (p *pcapWriter) writeBlock(typ uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, typ)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+
+	n, err := p.w.Write(block)
+	p.n += int64(n)
+	return err
+}
+
+// appendTimestamp appends ts in microseconds, high word first.
+func appendTimestamp(b []byte, ts time.Time) []byte {
+	us := uint64(ts.UnixMicro())
+	b = binary.LittleEndian.AppendUint32(b, uint32(us>>32))
+	return binary.LittleEndian.AppendUint32(b, uint32(us))
+}
+
+// appendOption appends a pcapng option with its value padded to 32 bits.
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPadding(b)
+}
+
+// appendPadding pads b to a multiple of 4 bytes.
+func appendPadding(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -35,6 +35,7 @@ type Config struct {
 	STUN       STUNConfig                `toml:"stun"`
 	WebRTC     WebRTCConfig              `toml:"webrtc"`
 	Peers      map[string]PeerSelections `toml:"peers,omitempty"`
+	Forwards   []ForwardConfig           `toml:"forwards,omitempty"`
 }
 
 // CloudflareConfig stores Cloudflare account credentials used for deploying
@@ -84,12 +85,29 @@ type DeviceConfig struct {
 	// Address is the WireGuard interface address in CIDR notation (e.g. "10.0.0.1/24").
 	Address string `toml:"address"`
 
+	// Address6 is the IPv6 interface address in CIDR notation on dual-stack
+	// networks (e.g. "fd12:3456:789a::1/64"). It is a unique local address
+	// assigned by the signaling server alongside Address. Empty on IPv4-only
+	// networks.
+	Address6 string `toml:"address6,omitempty"`
+
 	// Routes is a list of additional subnets (CIDR notation) reachable through
 	// this device. These are advertised to peers via signaling and added as
 	// WireGuard AllowedIPs on remote peers. For example, a home server might
 	// advertise ["192.168.1.0/24"] so remote peers can reach the home LAN.
 	Routes []string `toml:"routes,omitempty"`
 
+	// AdvertiseRoutes selects how the advertised routes are chosen: empty
+	// (the default) advertises Routes as configured; "auto" also discovers
+	// the subnets of this host's interfaces and re-advertises them, with
+	// forwarding and masquerade, as they change.
+	AdvertiseRoutes string `toml:"advertise_routes,omitempty"`
+
+	// AdvertiseExclude filters subnets discovered by advertise_routes =
+	// "auto". An entry is a CIDR, which excludes subnets inside it, or an
+	// interface name pattern such as "eth1" or "vlan*".
+	AdvertiseExclude []string `toml:"advertise_exclude,omitempty"`
+
 	// DNS is a list of DNS server IPs available through this device.
 	// These are advertised to peers via signaling metadata so remote peers
 	// can opt in to using them for name resolution. For example, a Kubernetes
@@ -101,6 +119,13 @@ type DeviceConfig struct {
 	// ["svc.cluster.local", "default.svc.cluster.local"].
 	DNSSearch []string `toml:"dns_search,omitempty"`
 
+	// DNSBackend selects how DNS servers and search domains accepted from
+	// peers are applied on Linux: "auto" (default), "resolvectl",
+	// "resolvconf", "networkmanager", or "file" (edit /etc/resolv.conf
+	// directly, restoring the original on shutdown). Ignored on macOS and
+	// Android.
+	DNSBackend string `toml:"dns_backend,omitempty"`
+
 	// AcceptRoutes controls whether this device installs subnet routes
 	// advertised by remote peers. When false (the default), only the peer's
 	// /32 tunnel address is added to WireGuard AllowedIPs — advertised LAN
@@ -112,10 +137,77 @@ type DeviceConfig struct {
 	// future version. When per-peer selections exist, they take precedence.
 	AcceptRoutes bool `toml:"accept_routes,omitempty"`
 
+	// RouteConflictPolicy selects what happens when a route accepted from a
+	// peer overlaps a subnet this host already reaches locally (an interface
+	// subnet or kernel route): "warn" (default) installs it anyway,
+	// "refuse" skips it, and "more-specific" installs only the
+	// non-overlapping parts so local destinations keep working.
+	RouteConflictPolicy string `toml:"route_conflict_policy,omitempty"`
+
+	// ACLDefault decides what peers without [peers.<name>] allow rules may
+	// reach: "allow" (default) lets them reach this device and its
+	// advertised routes as before, "deny" blocks every connection they
+	// open. Replies to connections this device opens always pass.
+	ACLDefault string `toml:"acl_default,omitempty"`
+
+	// PortForwards publish services on this device's LAN to mesh peers at
+	// ports on the tunnel address, e.g. home-gw:8443 -> 192.168.1.50:443,
+	// without advertising the whole subnet. They are advertised to peers
+	// in signaling metadata. Stored as [[device.port_forwards]] tables.
+	PortForwards []PortForward `toml:"port_forwards,omitempty"`
+
 	// ForceRelay forces all WebRTC connections to use the TURN relay,
 	// bypassing direct (host/srflx) connectivity. Useful for testing
 	// the TURN relay path or when direct connectivity is unreliable.
 	ForceRelay bool `toml:"force_relay,omitempty"`
+
+	// OnDemand registers peers in WireGuard as they are discovered but only
+	// connects to one when traffic is sent to it, and tears connections
+	// down after IdleTimeout without traffic. It saves battery and
+	// resources on large meshes.
+	OnDemand bool `toml:"on_demand,omitempty"`
+
+	// IdleTimeout is how long an on-demand connection may go without
+	// traffic before it is torn down, as a Go duration of at least "1s"
+	// (default "5m").
+	IdleTimeout string `toml:"idle_timeout,omitempty"`
+
+	// Role places this device in a hub-and-spoke topology: "hub" forwards
+	// traffic between the spokes connected to it, "spoke" connects only to
+	// hubs and reaches other spokes through them, and "mesh" connects to
+	// every device except spokes. Empty leaves it to the network policy,
+	// and otherwise means "mesh". The role is advertised to peers.
+	Role string `toml:"role,omitempty"`
+
+	// Relay offers this device as a relay: peers that cannot connect to
+	// each other directly send their traffic through its WireGuard
+	// sessions, and it forwards it between them. Advertised to peers.
+	Relay bool `toml:"relay,omitempty"`
+
+	// Userspace runs the tunnel on an in-process network stack instead of a
+	// kernel TUN device, so bamgate needs no root privileges. The mesh is
+	// only reachable through the local proxy at ProxyListen, and routes
+	// advertised by this device are not forwarded.
+	Userspace bool `toml:"userspace,omitempty"`
+
+	// ProxyListen is the address of the local SOCKS5 / HTTP proxy started in
+	// userspace mode (default "127.0.0.1:1080").
+	ProxyListen string `toml:"proxy_listen,omitempty"`
+
+	// MetricsListen is a TCP address (e.g. "127.0.0.1:9469") on which the
+	// agent serves Prometheus metrics at /metrics. Empty (the default)
+	// serves them only on the control socket.
+	MetricsListen string `toml:"metrics_listen,omitempty"`
+
+	// BlockedPeers lists peers this device refuses to connect to, managed
+	// by "bamgate peer block" and "bamgate peer unblock". Their offers are
+	// ignored and they are not connected to when discovered.
+	BlockedPeers []string `toml:"blocked_peers,omitempty"`
+
+	// ControlGroup is the group whose members may use the control socket's
+	// privileged commands (configure, forwards, peer actions, capture,
+	// tokens) without root. Default "bamgate".
+	ControlGroup string `toml:"control_group,omitempty"`
 }
 
 // PeerSelections records what capabilities the user has chosen to accept
@@ -133,6 +225,63 @@ type PeerSelections struct {
 	// DNSSearch is the list of DNS search domains the user chose to accept
 	// from this peer. Must be a subset of what the peer advertises.
 	DNSSearch []string `toml:"dns_search,omitempty"`
+
+	// RouteAliases maps accepted routes to locally unique alias prefixes of
+	// the same size, e.g. {"192.168.1.0/24" = "10.201.1.0/24"}. The alias
+	// is installed locally instead of the route and the peer translates it
+	// back (1:1 NETMAP), so several sites using the same subnet can be
+	// reached at once. DNS answers from the peer's resolvers are rewritten
+	// to match.
+	RouteAliases map[string]string `toml:"route_aliases,omitempty"`
+
+	// RoutePriority ranks this peer among peers advertising the same route
+	// (e.g. two gateways at one site). The highest-priority healthy peer
+	// carries the route; the others are standby and take over if it
+	// disconnects or stops completing handshakes. Default 0.
+	RoutePriority int `toml:"route_priority,omitempty"`
+
+	// Allow restricts what this peer may reach through the tunnel: our
+	// tunnel address and advertised routes. Each entry is
+	// "dest[:ports][/protocol]", e.g. "192.168.1.10:22,443/tcp" or
+	// "10.0.0.1/icmp". When empty, device.acl_default applies.
+	Allow []string `toml:"allow,omitempty"`
+}
+
+// PortForward publishes a LAN service on the tunnel address.
+type PortForward struct {
+	// Name is an optional label shown to peers, e.g. "nas-https".
+	Name string `toml:"name,omitempty" json:"name,omitempty"`
+
+	// Protocol is "tcp" (default) or "udp".
+	Protocol string `toml:"protocol,omitempty" json:"protocol,omitempty"`
+
+	// Port is the port on this device's tunnel address peers connect to.
+	Port int `toml:"port" json:"port"`
+
+	// Target is the LAN "ip:port" the traffic is sent to, e.g.
+	// "192.168.1.50:443". It is not advertised to peers.
+	Target string `toml:"target" json:"-"`
+}
+
+// ForwardConfig is a port forward started with the agent. Written by
+// `bamgate forward --persist`, stored as [[forwards]] tables.
+type ForwardConfig struct {
+	// Protocol is "tcp" (default) or "udp".
+	Protocol string `toml:"protocol,omitempty"`
+
+	// Reverse forwards from the mesh to this host: Listen is a port on this
+	// device's tunnel address and Target is a local address. Otherwise
+	// Listen is a local address and Target is a mesh destination, such as
+	// "home-server:5432" or "192.168.1.10:80".
+	Reverse bool `toml:"reverse,omitempty"`
+
+	// Listen is the address ("host:port" or just a port) to accept
+	// connections on.
+	Listen string `toml:"listen"`
+
+	// Target is the "host:port" connections are forwarded to. The host may
+	// be a peer name.
+	Target string `toml:"target"`
 }
 
 // STUNConfig lists the STUN servers used for ICE NAT traversal.
@@ -160,6 +309,7 @@ type configFile struct {
 	STUN       STUNConfig                `toml:"stun"`
 	WebRTC     WebRTCConfig              `toml:"webrtc"`
 	Peers      map[string]PeerSelections `toml:"peers,omitempty"`
+	Forwards   []ForwardConfig           `toml:"forwards,omitempty"`
 }
 
 type cfConfigFile struct {
@@ -174,13 +324,29 @@ type netConfigFile struct {
 }
 
 type devConfigFile struct {
-	Name         string   `toml:"name"`
-	Address      string   `toml:"address"`
-	Routes       []string `toml:"routes,omitempty"`
-	DNS          []string `toml:"dns,omitempty"`
-	DNSSearch    []string `toml:"dns_search,omitempty"`
-	AcceptRoutes bool     `toml:"accept_routes,omitempty"`
-	ForceRelay   bool     `toml:"force_relay,omitempty"`
+	Name                string        `toml:"name"`
+	Address             string        `toml:"address"`
+	Address6            string        `toml:"address6,omitempty"`
+	Routes              []string      `toml:"routes,omitempty"`
+	AdvertiseRoutes     string        `toml:"advertise_routes,omitempty"`
+	AdvertiseExclude    []string      `toml:"advertise_exclude,omitempty"`
+	DNS                 []string      `toml:"dns,omitempty"`
+	DNSSearch           []string      `toml:"dns_search,omitempty"`
+	DNSBackend          string        `toml:"dns_backend,omitempty"`
+	AcceptRoutes        bool          `toml:"accept_routes,omitempty"`
+	RouteConflictPolicy string        `toml:"route_conflict_policy,omitempty"`
+	ACLDefault          string        `toml:"acl_default,omitempty"`
+	PortForwards        []PortForward `toml:"port_forwards,omitempty"`
+	ForceRelay          bool          `toml:"force_relay,omitempty"`
+	OnDemand            bool          `toml:"on_demand,omitempty"`
+	IdleTimeout         string        `toml:"idle_timeout,omitempty"`
+	Role                string        `toml:"role,omitempty"`
+	Relay               bool          `toml:"relay,omitempty"`
+	Userspace           bool          `toml:"userspace,omitempty"`
+	ProxyListen         string        `toml:"proxy_listen,omitempty"`
+	MetricsListen       string        `toml:"metrics_listen,omitempty"`
+	BlockedPeers        []string      `toml:"blocked_peers,omitempty"`
+	ControlGroup        string        `toml:"control_group,omitempty"`
 }
 
 // secretsFile is the TOML representation for secrets.toml (0640, root + invoking user).
@@ -216,17 +382,34 @@ func toConfigFile(cfg *Config) *configFile {
 			DeviceID:  cfg.Network.DeviceID,
 		},
 		Device: devConfigFile{
-			Name:         cfg.Device.Name,
-			Address:      cfg.Device.Address,
-			Routes:       cfg.Device.Routes,
-			DNS:          cfg.Device.DNS,
-			DNSSearch:    cfg.Device.DNSSearch,
-			AcceptRoutes: cfg.Device.AcceptRoutes,
-			ForceRelay:   cfg.Device.ForceRelay,
+			Name:                cfg.Device.Name,
+			Address:             cfg.Device.Address,
+			Address6:            cfg.Device.Address6,
+			Routes:              cfg.Device.Routes,
+			AdvertiseRoutes:     cfg.Device.AdvertiseRoutes,
+			AdvertiseExclude:    cfg.Device.AdvertiseExclude,
+			DNS:                 cfg.Device.DNS,
+			DNSSearch:           cfg.Device.DNSSearch,
+			DNSBackend:          cfg.Device.DNSBackend,
+			AcceptRoutes:        cfg.Device.AcceptRoutes,
+			RouteConflictPolicy: cfg.Device.RouteConflictPolicy,
+			ACLDefault:          cfg.Device.ACLDefault,
+			PortForwards:        cfg.Device.PortForwards,
+			ForceRelay:          cfg.Device.ForceRelay,
+			OnDemand:            cfg.Device.OnDemand,
+			IdleTimeout:         cfg.Device.IdleTimeout,
+			Role:                cfg.Device.Role,
+			Relay:               cfg.Device.Relay,
+			Userspace:           cfg.Device.Userspace,
+			ProxyListen:         cfg.Device.ProxyListen,
+			MetricsListen:       cfg.Device.MetricsListen,
+			BlockedPeers:        cfg.Device.BlockedPeers,
+			ControlGroup:        cfg.Device.ControlGroup,
 		},
-		STUN:   cfg.STUN,
-		WebRTC: cfg.WebRTC,
-		Peers:  cfg.Peers,
+		STUN:     cfg.STUN,
+		WebRTC:   cfg.WebRTC,
+		Peers:    cfg.Peers,
+		Forwards: cfg.Forwards,
 	}
 }
 
@@ -476,6 +659,14 @@ func (c *Config) SetPeerSelection(peerName string, sel PeerSelections) {
 	c.Peers[peerName] = sel
 }
 
+// ACLOnly reports whether s carries nothing but allow rules. Such entries
+// do not count as route selections, so the legacy accept_routes flag still
+// applies to the peer.
+func (s PeerSelections) ACLOnly() bool {
+	return len(s.Allow) > 0 && len(s.Routes) == 0 && len(s.DNS) == 0 && len(s.DNSSearch) == 0 &&
+		len(s.RouteAliases) == 0 && s.RoutePriority == 0
+}
+
 // HasPeerSelections returns true if any per-peer selections are configured.
 // When true, the legacy AcceptRoutes flag is ignored.
 func (c *Config) HasPeerSelections() bool {
@@ -483,8 +674,8 @@ func (c *Config) HasPeerSelections() bool {
 }
 
 // BuildMetadata constructs a signaling metadata map from this device's
-// advertised capabilities (routes, DNS, search domains). Returns nil if
-// there is nothing to advertise.
+// advertised capabilities (routes, DNS, search domains, published services)
+// and its IPv6 tunnel address, if any. Returns nil if there is nothing to advertise.
 func (d *DeviceConfig) BuildMetadata() map[string]string {
 	meta := make(map[string]string)
 
@@ -500,6 +691,19 @@ func (d *DeviceConfig) BuildMetadata() map[string]string {
 		b, _ := json.Marshal(d.DNSSearch)
 		meta["dns_search"] = string(b)
 	}
+	if d.Address6 != "" {
+		meta["address6"] = d.Address6
+	}
+	if len(d.PortForwards) > 0 {
+		b, _ := json.Marshal(d.PortForwards)
+		meta["services"] = string(b)
+	}
+	if d.Role != "" {
+		meta["role"] = d.Role
+	}
+	if d.Relay {
+		meta["relay"] = "true"
+	}
 
 	if len(meta) == 0 {
 		return nil
@@ -5,6 +5,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -58,9 +59,27 @@ func TestSaveAndLoadConfig_roundTrip(t *testing.T) {
 			RefreshToken: "refresh-token-789",
 		},
 		Device: DeviceConfig{
-			Name:       "home-server",
-			PrivateKey: priv,
-			Address:    "10.0.0.1/24",
+			Name:                "home-server",
+			PrivateKey:          priv,
+			Address:             "10.0.0.1/24",
+			Address6:            "fd12:3456:789a::1/64",
+			DNSBackend:          "file",
+			RouteConflictPolicy: "more-specific",
+			ACLDefault:          "deny",
+			Userspace:           true,
+			ProxyListen:         "127.0.0.1:1081",
+			MetricsListen:       "127.0.0.1:9469",
+			BlockedPeers:        []string{"old-phone"},
+			ControlGroup:        "netadmin",
+			OnDemand:            true,
+			IdleTimeout:         "10m",
+			Role:                "hub",
+			Relay:               true,
+			AdvertiseRoutes:     "auto",
+			AdvertiseExclude:    []string{"10.10.0.0/16", "vlan*"},
+			PortForwards: []PortForward{
+				{Name: "nas-https", Port: 8443, Target: "192.168.1.50:443"},
+			},
 		},
 		STUN: STUNConfig{
 			Servers: []string{
@@ -72,6 +91,14 @@ func TestSaveAndLoadConfig_roundTrip(t *testing.T) {
 			Ordered:        false,
 			MaxRetransmits: 0,
 		},
+		Peers: map[string]PeerSelections{
+			"home": {
+				Routes:        []string{"192.168.1.0/24"},
+				RouteAliases:  map[string]string{"192.168.1.0/24": "10.201.1.0/24"},
+				RoutePriority: 10,
+				Allow:         []string{"192.168.1.10:22,443/tcp"},
+			},
+		},
 	}
 
 	// Save.
@@ -161,6 +188,50 @@ func TestSaveAndLoadConfig_roundTrip(t *testing.T) {
 	if loaded.Device.Address != original.Device.Address {
 		t.Errorf("Device.Address = %q, want %q", loaded.Device.Address, original.Device.Address)
 	}
+	if loaded.Device.Address6 != original.Device.Address6 {
+		t.Errorf("Device.Address6 = %q, want %q", loaded.Device.Address6, original.Device.Address6)
+	}
+	if loaded.Device.DNSBackend != original.Device.DNSBackend {
+		t.Errorf("Device.DNSBackend = %q, want %q", loaded.Device.DNSBackend, original.Device.DNSBackend)
+	}
+	if loaded.Device.RouteConflictPolicy != original.Device.RouteConflictPolicy {
+		t.Errorf("Device.RouteConflictPolicy = %q, want %q", loaded.Device.RouteConflictPolicy, original.Device.RouteConflictPolicy)
+	}
+	if loaded.Device.ACLDefault != original.Device.ACLDefault {
+		t.Errorf("Device.ACLDefault = %q, want %q", loaded.Device.ACLDefault, original.Device.ACLDefault)
+	}
+	if loaded.Device.Userspace != original.Device.Userspace {
+		t.Errorf("Device.Userspace = %v, want %v", loaded.Device.Userspace, original.Device.Userspace)
+	}
+	if len(loaded.Device.PortForwards) != 1 || loaded.Device.PortForwards[0] != original.Device.PortForwards[0] {
+		t.Errorf("Device.PortForwards = %+v, want %+v", loaded.Device.PortForwards, original.Device.PortForwards)
+	}
+	if loaded.Device.ProxyListen != original.Device.ProxyListen {
+		t.Errorf("Device.ProxyListen = %q, want %q", loaded.Device.ProxyListen, original.Device.ProxyListen)
+	}
+	if loaded.Device.MetricsListen != original.Device.MetricsListen {
+		t.Errorf("Device.MetricsListen = %q, want %q", loaded.Device.MetricsListen, original.Device.MetricsListen)
+	}
+	if !slices.Equal(loaded.Device.BlockedPeers, original.Device.BlockedPeers) {
+		t.Errorf("Device.BlockedPeers = %v, want %v", loaded.Device.BlockedPeers, original.Device.BlockedPeers)
+	}
+	if loaded.Device.ControlGroup != original.Device.ControlGroup {
+		t.Errorf("Device.ControlGroup = %q, want %q", loaded.Device.ControlGroup, original.Device.ControlGroup)
+	}
+	if loaded.Device.Role != original.Device.Role {
+		t.Errorf("Device.Role = %q, want %q", loaded.Device.Role, original.Device.Role)
+	}
+	if loaded.Device.Relay != original.Device.Relay {
+		t.Errorf("Device.Relay = %v, want %v", loaded.Device.Relay, original.Device.Relay)
+	}
+	if loaded.Device.OnDemand != original.Device.OnDemand || loaded.Device.IdleTimeout != original.Device.IdleTimeout {
+		t.Errorf("Device.OnDemand, IdleTimeout = %v, %q, want %v, %q", loaded.Device.OnDemand, loaded.Device.IdleTimeout,
+			original.Device.OnDemand, original.Device.IdleTimeout)
+	}
+	if loaded.Device.AdvertiseRoutes != original.Device.AdvertiseRoutes || !slices.Equal(loaded.Device.AdvertiseExclude, original.Device.AdvertiseExclude) {
+		t.Errorf("Device.AdvertiseRoutes = %q %v, want %q %v", loaded.Device.AdvertiseRoutes, loaded.Device.AdvertiseExclude,
+			original.Device.AdvertiseRoutes, original.Device.AdvertiseExclude)
+	}
 	if len(loaded.STUN.Servers) != len(original.STUN.Servers) {
 		t.Fatalf("STUN servers count = %d, want %d", len(loaded.STUN.Servers), len(original.STUN.Servers))
 	}
@@ -175,6 +246,15 @@ func TestSaveAndLoadConfig_roundTrip(t *testing.T) {
 	if loaded.WebRTC.MaxRetransmits != original.WebRTC.MaxRetransmits {
 		t.Errorf("WebRTC.MaxRetransmits = %d, want %d", loaded.WebRTC.MaxRetransmits, original.WebRTC.MaxRetransmits)
 	}
+	if got := loaded.Peers["home"].RouteAliases["192.168.1.0/24"]; got != "10.201.1.0/24" {
+		t.Errorf("Peers[home].RouteAliases = %v, want 192.168.1.0/24 -> 10.201.1.0/24", loaded.Peers["home"].RouteAliases)
+	}
+	if got := loaded.Peers["home"].RoutePriority; got != 10 {
+		t.Errorf("Peers[home].RoutePriority = %d, want 10", got)
+	}
+	if got := loaded.Peers["home"].Allow; !slices.Equal(got, original.Peers["home"].Allow) {
+		t.Errorf("Peers[home].Allow = %v, want %v", got, original.Peers["home"].Allow)
+	}
 }
 
 func TestLoadConfig_fileNotFound(t *testing.T) {
@@ -677,3 +757,56 @@ func TestSecretsPathFromConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildMetadata_address6(t *testing.T) {
+	t.Parallel()
+
+	d := DeviceConfig{Address: "10.0.0.1/24"}
+	if meta := d.BuildMetadata(); meta != nil {
+		t.Errorf("BuildMetadata() = %v, want nil for IPv4-only device", meta)
+	}
+
+	d.Address6 = "fd12:3456:789a::1/64"
+	meta := d.BuildMetadata()
+	if got := meta["address6"]; got != d.Address6 {
+		t.Errorf("metadata[address6] = %q, want %q", got, d.Address6)
+	}
+}
+
+func TestBuildMetadata_services(t *testing.T) {
+	t.Parallel()
+
+	d := DeviceConfig{
+		Address: "10.0.0.1/24",
+		PortForwards: []PortForward{
+			{Name: "nas-https", Port: 8443, Target: "192.168.1.50:443"},
+			{Protocol: "udp", Port: 5353, Target: "192.168.1.2:53"},
+		},
+	}
+	want := `[{"name":"nas-https","port":8443},{"protocol":"udp","port":5353}]`
+	if got := d.BuildMetadata()["services"]; got != want {
+		t.Errorf("metadata[services] = %s, want %s (LAN targets must not be advertised)", got, want)
+	}
+}
+
+func TestBuildMetadata_role(t *testing.T) {
+	t.Parallel()
+
+	d := DeviceConfig{Address: "10.0.0.1/24", Role: "spoke"}
+	if got := d.BuildMetadata()["role"]; got != "spoke" {
+		t.Errorf("metadata[role] = %q, want spoke", got)
+	}
+}
+
+func TestBuildMetadata_relay(t *testing.T) {
+	t.Parallel()
+
+	d := DeviceConfig{Address: "10.0.0.1/24"}
+	if _, ok := d.BuildMetadata()["relay"]; ok {
+		t.Error("metadata[relay] set without device.relay")
+	}
+	d.Relay = true
+	if got := d.BuildMetadata()["relay"]; got != "true" {
+		t.Errorf("metadata[relay] = %q, want true", got)
+	}
+}
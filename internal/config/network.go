@@ -0,0 +1,222 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// networksDirName is the directory, under the config directory, that holds
+// one subdirectory per named network. Each has its own config.toml and
+// secrets.toml:
+//
+//	/etc/bamgate/config.toml                 default network
+//	/etc/bamgate/networks/client/config.toml network "client"
+//	/etc/bamgate/networks/client/secrets.toml
+const networksDirName = "networks"
+
+// DefaultNetworkName is how the default network, the one whose config is
+// directly in the config directory, is shown. It cannot be used as the
+// name of a named network.
+const DefaultNetworkName = "default"
+
+// networkNameRe matches valid network names. They end up in interface,
+// nftables table and socket names, so they are short and plain: the TUN
+// interface of network "client" is "bg-client", which must fit in the 15
+// characters Linux allows.
+var networkNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,11}$`)
+
+// ValidateNetworkName checks that name can be used as a network name.
+func ValidateNetworkName(name string) error {
+	if name == DefaultNetworkName {
+		return fmt.Errorf("network name %q is reserved for the default network", name)
+	}
+	if !networkNameRe.MatchString(name) {
+		return fmt.Errorf("invalid network name %q: use up to 12 lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// NetworkConfigPath returns the config.toml path of network under the
+// config directory dir. The default network ("") uses dir/config.toml.
+func NetworkConfigPath(dir, network string) string {
+	if network == "" {
+		return filepath.Join(dir, "config.toml")
+	}
+	return filepath.Join(dir, networksDirName, network, "config.toml")
+}
+
+// ListNetworks returns the sorted names of the named networks configured
+// under the config directory dir: the subdirectories of dir/networks that
+// contain a config.toml with a valid name. The default network is not
+// included.
+func ListNetworks(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, networksDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading networks directory: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || ValidateNetworkName(e.Name()) != nil {
+			continue
+		}
+		if _, err := os.Stat(NetworkConfigPath(dir, e.Name())); err != nil {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// NetworkConflict is a clash between the configs of two networks run side
+// by side.
+type NetworkConflict struct {
+	// Networks are the names of the two networks, "" for the default one.
+	Networks [2]string
+
+	// Fatal is set when the networks cannot run together, e.g. because
+	// their tunnel subnets overlap. Other conflicts, like overlapping
+	// routes, work but leave traffic to one of the networks, depending on
+	// the route conflict policy.
+	Fatal bool
+
+	// Reason describes the conflict.
+	Reason string
+}
+
+func (c NetworkConflict) Error() string {
+	return fmt.Sprintf("networks %s and %s: %s",
+		displayNetwork(c.Networks[0]), displayNetwork(c.Networks[1]), c.Reason)
+}
+
+// displayNetwork returns the name network is shown as.
+func displayNetwork(network string) string {
+	if network == "" {
+		return DefaultNetworkName
+	}
+	return network
+}
+
+// CheckNetworkConflicts compares the configs of networks, keyed by network
+// name with "" for the default network, and returns the conflicts between
+// each pair, ordered by network name:
+//   - overlapping tunnel subnets (device.address, device.address6) and
+//     equal local listen addresses are fatal;
+//   - a route advertised or accepted by one network that overlaps a route
+//     or the tunnel subnet of another is not.
+//
+// Values that do not parse are skipped; each network validates its own
+// config.
+func CheckNetworkConflicts(networks map[string]*Config) []NetworkConflict {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conflicts []NetworkConflict
+	for i, x := range names {
+		for _, y := range names[i+1:] {
+			conflicts = append(conflicts, networkConflicts(x, networks[x], y, networks[y])...)
+		}
+	}
+	return conflicts
+}
+
+// networkConflicts returns the conflicts between networks x and y.
+func networkConflicts(x string, cx *Config, y string, cy *Config) []NetworkConflict {
+	var conflicts []NetworkConflict
+	add := func(fatal bool, format string, args ...any) {
+		conflicts = append(conflicts, NetworkConflict{
+			Networks: [2]string{x, y},
+			Fatal:    fatal,
+			Reason:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	tunX, tunY := tunnelSubnets(cx), tunnelSubnets(cy)
+	for _, px := range tunX {
+		for _, py := range tunY {
+			if px.Overlaps(py) {
+				add(true, "tunnel subnets %s and %s overlap", px, py)
+			}
+		}
+	}
+
+	if cx.Device.MetricsListen != "" && cx.Device.MetricsListen == cy.Device.MetricsListen {
+		add(true, "both serve metrics on %s", cx.Device.MetricsListen)
+	}
+	// An empty proxy_listen is the same default address for both.
+	if cx.Device.Userspace && cy.Device.Userspace && cx.Device.ProxyListen == cy.Device.ProxyListen {
+		add(true, "both run the userspace proxy on the same address (device.proxy_listen)")
+	}
+
+	routesX, routesY := networkRoutes(cx), networkRoutes(cy)
+	for _, rx := range routesX {
+		for _, ry := range routesY {
+			if rx.Overlaps(ry) {
+				add(false, "routes %s and %s overlap", rx, ry)
+			}
+		}
+		for _, py := range tunY {
+			if rx.Overlaps(py) {
+				add(false, "route %s overlaps tunnel subnet %s of %s", rx, py, displayNetwork(y))
+			}
+		}
+	}
+	for _, ry := range routesY {
+		for _, px := range tunX {
+			if ry.Overlaps(px) {
+				add(false, "route %s overlaps tunnel subnet %s of %s", ry, px, displayNetwork(x))
+			}
+		}
+	}
+	return conflicts
+}
+
+// tunnelSubnets returns the subnets of the device's tunnel addresses.
+func tunnelSubnets(cfg *Config) []netip.Prefix {
+	var subnets []netip.Prefix
+	for _, addr := range []string{cfg.Device.Address, cfg.Device.Address6} {
+		if p, err := netip.ParsePrefix(addr); err == nil {
+			subnets = append(subnets, p.Masked())
+		}
+	}
+	return subnets
+}
+
+// networkRoutes returns the routes the device advertises (device.routes)
+// and the routes it accepts from peers (peers.<name>.routes), without
+// duplicates.
+func networkRoutes(cfg *Config) []netip.Prefix {
+	all := slices.Clone(cfg.Device.Routes)
+	peers := make([]string, 0, len(cfg.Peers))
+	for name := range cfg.Peers {
+		peers = append(peers, name)
+	}
+	sort.Strings(peers)
+	for _, name := range peers {
+		all = append(all, cfg.Peers[name].Routes...)
+	}
+
+	var routes []netip.Prefix
+	for _, r := range all {
+		p, err := netip.ParsePrefix(r)
+		if err != nil {
+			continue
+		}
+		if p = p.Masked(); !slices.Contains(routes, p) {
+			routes = append(routes, p)
+		}
+	}
+	return routes
+}
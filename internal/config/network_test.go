@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestValidateNetworkName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"client", false},
+		{"acme-corp", false},
+		{"home2", false},
+		{"abcdefghijkl", false},
+		{"", true},
+		{"default", true},
+		{"abcdefghijklm", true},
+		{"Client", true},
+		{"-client", true},
+		{"client.com", true},
+		{"../etc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateNetworkName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNetworkName(%q) = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetworkConfigPath(t *testing.T) {
+	t.Parallel()
+
+	if got, want := NetworkConfigPath("/etc/bamgate", ""), "/etc/bamgate/config.toml"; got != want {
+		t.Errorf("default network path = %q, want %q", got, want)
+	}
+	if got, want := NetworkConfigPath("/etc/bamgate", "client"), "/etc/bamgate/networks/client/config.toml"; got != want {
+		t.Errorf("named network path = %q, want %q", got, want)
+	}
+}
+
+func TestListNetworks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if names, err := ListNetworks(dir); err != nil || names != nil {
+		t.Fatalf("ListNetworks() without networks dir = %v, %v; want nil, nil", names, err)
+	}
+
+	for _, name := range []string{"home", "client", "Invalid", "empty"} {
+		if err := os.MkdirAll(filepath.Join(dir, "networks", name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if name == "empty" {
+			continue
+		}
+		if err := os.WriteFile(NetworkConfigPath(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "networks", "stray.toml"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ListNetworks(dir)
+	if err != nil {
+		t.Fatalf("ListNetworks() error: %v", err)
+	}
+	if want := []string{"client", "home"}; !slices.Equal(names, want) {
+		t.Errorf("ListNetworks() = %v, want %v", names, want)
+	}
+}
+
+func TestCheckNetworkConflicts(t *testing.T) {
+	t.Parallel()
+
+	network := func(address string, routes ...string) *Config {
+		cfg := &Config{}
+		cfg.Device.Address = address
+		cfg.Device.Routes = routes
+		return cfg
+	}
+
+	tests := []struct {
+		name      string
+		networks  map[string]*Config
+		wantFatal []string
+		wantWarn  []string
+	}{
+		{
+			name: "disjoint",
+			networks: map[string]*Config{
+				"":       network("10.0.0.2/24", "192.168.1.0/24"),
+				"client": network("10.1.0.2/24", "172.16.0.0/16"),
+			},
+		},
+		{
+			name: "overlapping tunnel subnets",
+			networks: map[string]*Config{
+				"":       network("10.0.0.2/24"),
+				"client": network("10.0.0.7/16"),
+			},
+			wantFatal: []string{"networks default and client: tunnel subnets 10.0.0.0/24 and 10.0.0.0/16 overlap"},
+		},
+		{
+			name: "overlapping routes",
+			networks: map[string]*Config{
+				"client": network("10.1.0.2/24", "192.168.0.0/16"),
+				"home": {
+					Device: DeviceConfig{Address: "10.0.0.2/24"},
+					Peers:  map[string]PeerSelections{"nas": {Routes: []string{"192.168.1.0/24"}}},
+				},
+			},
+			wantWarn: []string{"networks client and home: routes 192.168.0.0/16 and 192.168.1.0/24 overlap"},
+		},
+		{
+			name: "route over tunnel subnet",
+			networks: map[string]*Config{
+				"":       network("10.0.0.2/24", "10.0.0.0/8"),
+				"client": network("10.1.0.2/24"),
+			},
+			wantWarn: []string{"networks default and client: route 10.0.0.0/8 overlaps tunnel subnet 10.1.0.0/24 of client"},
+		},
+		{
+			name: "same metrics address",
+			networks: map[string]*Config{
+				"a": {Device: DeviceConfig{Address: "10.0.0.2/24", MetricsListen: "127.0.0.1:9469"}},
+				"b": {Device: DeviceConfig{Address: "10.1.0.2/24", MetricsListen: "127.0.0.1:9469"}},
+			},
+			wantFatal: []string{"networks a and b: both serve metrics on 127.0.0.1:9469"},
+		},
+		{
+			name: "default userspace proxy",
+			networks: map[string]*Config{
+				"a": {Device: DeviceConfig{Address: "10.0.0.2/24", Userspace: true}},
+				"b": {Device: DeviceConfig{Address: "10.1.0.2/24", Userspace: true}},
+			},
+			wantFatal: []string{"networks a and b: both run the userspace proxy on the same address (device.proxy_listen)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var fatal, warn []string
+			for _, c := range CheckNetworkConflicts(tt.networks) {
+				if c.Fatal {
+					fatal = append(fatal, c.Error())
+				} else {
+					warn = append(warn, c.Error())
+				}
+			}
+			if !slices.Equal(fatal, tt.wantFatal) {
+				t.Errorf("fatal conflicts = %q, want %q", fatal, tt.wantFatal)
+			}
+			if !slices.Equal(warn, tt.wantWarn) {
+				t.Errorf("other conflicts = %q, want %q", warn, tt.wantWarn)
+			}
+		})
+	}
+}
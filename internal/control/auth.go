@@ -0,0 +1,108 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+// DefaultGroup is the group whose members may use the control socket's
+// privileged endpoints when no other group is configured.
+const DefaultGroup = "bamgate"
+
+// PeerCred identifies the process on the other end of a control socket
+// connection, as reported by the kernel.
+type PeerCred struct {
+	PID int // 0 if the OS does not report it
+	UID uint32
+	// Groups holds the primary group and, where the OS reports them, the
+	// supplementary groups.
+	Groups []uint32
+}
+
+type peerCredKey struct{}
+
+// connContext stores the connecting process's credentials in the context
+// of every request on the connection.
+func (s *Server) connContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := peerCred(uc)
+	if err != nil {
+		s.log.Warn("reading control client credentials", "error", err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// resolveGroup looks up the control group's ID. If the group does not
+// exist, only root and the agent's own user are privileged.
+func (s *Server) resolveGroup() {
+	s.groupID = -1
+	g, err := user.LookupGroup(s.group)
+	if err != nil {
+		s.log.Info("control group not found, privileged endpoints limited to root",
+			"group", s.group, "error", err)
+		return
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		s.log.Warn("parsing control group ID", "group", s.group, "gid", g.Gid, "error", err)
+		return
+	}
+	s.groupID = int64(gid)
+}
+
+// privileged wraps the handler of an endpoint that changes the agent's
+// state or returns secrets, refusing clients that are not authorized.
+func (s *Server) privileged(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cred, ok := r.Context().Value(peerCredKey{}).(*PeerCred)
+		if ok && s.authorized(cred) {
+			h(w, r)
+			return
+		}
+
+		attrs := []any{"method", r.Method, "path", r.URL.Path}
+		if ok {
+			attrs = append(attrs, "uid", cred.UID, "pid", cred.PID)
+		}
+		s.log.Warn("control request denied", attrs...)
+		http.Error(w, fmt.Sprintf("permission denied: run as root or as a member of the %q group", s.group),
+			http.StatusForbidden)
+	}
+}
+
+// authorized reports whether a client may use privileged endpoints: root,
+// the user the agent runs as, and members of the control group.
+func (s *Server) authorized(cred *PeerCred) bool {
+	if cred.UID == 0 || int64(cred.UID) == int64(os.Geteuid()) {
+		return true
+	}
+	if s.groupID < 0 {
+		return false
+	}
+	gid := uint32(s.groupID)
+	if slices.Contains(cred.Groups, gid) {
+		return true
+	}
+
+	// The kernel reports only the primary group on Linux; look up the
+	// supplementary ones in the group database.
+	u, err := user.LookupId(strconv.FormatUint(uint64(cred.UID), 10))
+	if err != nil {
+		return false
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	return slices.Contains(ids, strconv.FormatUint(uint64(gid), 10))
+}
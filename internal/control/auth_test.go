@@ -0,0 +1,73 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServer_Authorized(t *testing.T) {
+	t.Parallel()
+
+	const stranger = 54321 // not root, not us, in no group database
+	if os.Geteuid() == stranger {
+		t.Skip("running as the test's stranger UID")
+	}
+
+	tests := []struct {
+		name    string
+		groupID int64
+		cred    PeerCred
+		want    bool
+	}{
+		{"root", -1, PeerCred{UID: 0}, true},
+		{"agent user", -1, PeerCred{UID: uint32(os.Geteuid())}, true},
+		{"group member", 4242, PeerCred{UID: stranger, Groups: []uint32{100, 4242}}, true},
+		{"not a member", 4242, PeerCred{UID: stranger, Groups: []uint32{100}}, false},
+		{"no group", -1, PeerCred{UID: stranger, Groups: []uint32{4242}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := NewServer("", nil, nil)
+			s.groupID = tt.groupID
+			if got := s.authorized(&tt.cred); got != tt.want {
+				t.Errorf("authorized(%+v) = %v, want %v", tt.cred, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Privileged(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer("", nil, nil)
+	s.SetGroup("wheel-ish")
+	s.groupID = -1
+	h := s.privileged(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name string
+		cred *PeerCred
+		want int
+	}{
+		{"root", &PeerCred{UID: 0}, http.StatusNoContent},
+		{"other user", &PeerCred{UID: 54321, PID: 99}, http.StatusForbidden},
+		{"no credentials", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/auth/token", nil)
+		if tt.cred != nil {
+			req = req.WithContext(context.WithValue(req.Context(), peerCredKey{}, tt.cred))
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
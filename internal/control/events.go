@@ -0,0 +1,128 @@
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what an Event reports. The values are part of the
+// GET /events wire format and must stay stable.
+type EventType string
+
+const (
+	// EventPeerDiscovered: the signaling server listed Peer.
+	EventPeerDiscovered EventType = "peer_discovered"
+
+	// EventPeerRemoved: the connection to Peer was torn down.
+	EventPeerRemoved EventType = "peer_removed"
+
+	// EventICEState: Peer's ICE connection entered State.
+	EventICEState EventType = "ice_state"
+
+	// EventICERestart: an ICE restart with Peer was started (Attempt).
+	EventICERestart EventType = "ice_restart"
+
+	// EventDataChannelOpen: the data channel to Peer opened and WireGuard
+	// traffic flows.
+	EventDataChannelOpen EventType = "datachannel_open"
+
+	// EventPeerIdle: the connection to Peer was closed because it was idle
+	// or failed; Peer stays in WireGuard and is reconnected on demand.
+	EventPeerIdle EventType = "peer_idle"
+
+	// EventPeerRelayed: Peer could not be reached directly and its traffic
+	// now goes through Relay.
+	EventPeerRelayed EventType = "peer_relayed"
+
+	// EventRouteAdded and EventRouteRemoved: Route was installed or
+	// withdrawn through Peer.
+	EventRouteAdded   EventType = "route_added"
+	EventRouteRemoved EventType = "route_removed"
+
+	// EventDNSApplied: DNS and DNSSearch from Peer were installed.
+	EventDNSApplied EventType = "dns_applied"
+
+	// EventJWTRefreshed: the signaling access token was renewed.
+	EventJWTRefreshed EventType = "jwt_refreshed"
+
+	// EventSignalingReconnected: the signaling connection was
+	// re-established after Attempt attempts.
+	EventSignalingReconnected EventType = "signaling_reconnected"
+
+	// EventForwardingRepaired: the watchdog re-enabled forwarding on
+	// Interface or re-applied the NAT rules.
+	EventForwardingRepaired EventType = "forwarding_repaired"
+)
+
+// Event is something that happened in the agent, streamed by GET /events
+// as one JSON object per line. Fields not relevant to Type are omitted.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+
+	Peer      string   `json:"peer,omitempty"`
+	State     string   `json:"state,omitempty"`
+	Route     string   `json:"route,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+	DNSSearch []string `json:"dns_search,omitempty"`
+	Interface string   `json:"interface,omitempty"`
+	Attempt   int      `json:"attempt,omitempty"`
+	Relay     string   `json:"relay,omitempty"`
+}
+
+// EventSubscriber subscribes to the agent's events. Events arrive on the
+// returned channel until cancel is called; the channel is closed if the
+// subscriber falls too far behind.
+type EventSubscriber func() (events <-chan Event, cancel func())
+
+// eventBuffer is how many events a subscriber may fall behind before it is
+// disconnected.
+const eventBuffer = 256
+
+// EventBus fans events out to subscribers. It is safe for concurrent use,
+// and publishing never blocks: a subscriber that falls behind loses its
+// subscription, so its channel is closed and it should re-read the status.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewEventBus creates an EventBus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends ev to every subscriber, stamping its time if unset.
+func (b *EventBus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe implements EventSubscriber.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	t.Parallel()
+
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe()
+
+	stamped := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	bus.Publish(Event{Type: EventPeerDiscovered, Peer: "home"})
+	bus.Publish(Event{Type: EventICEState, Peer: "home", State: "connected", Time: stamped})
+
+	ev := <-events
+	if ev.Type != EventPeerDiscovered || ev.Peer != "home" || ev.Time.IsZero() {
+		t.Errorf("first event = %+v, want a stamped peer_discovered for home", ev)
+	}
+	if ev = <-events; ev.State != "connected" || !ev.Time.Equal(stamped) {
+		t.Errorf("second event = %+v, want ice_state connected at %v", ev, stamped)
+	}
+
+	// Cancelling closes the channel and may be repeated.
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("channel still open after cancel")
+	}
+	bus.Publish(Event{Type: EventJWTRefreshed})
+}
+
+func TestEventBus_SlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	bus := NewEventBus()
+	slow, cancelSlow := bus.Subscribe()
+	defer cancelSlow()
+	fast, cancelFast := bus.Subscribe()
+	defer cancelFast()
+
+	// Publishing never blocks; the subscriber that stops reading is
+	// dropped once its buffer is full, the other keeps receiving.
+	for i := range eventBuffer + 1 {
+		bus.Publish(Event{Type: EventICERestart, Attempt: i + 1})
+		<-fast
+	}
+
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != eventBuffer {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, eventBuffer)
+	}
+
+	bus.Publish(Event{Type: EventJWTRefreshed})
+	if ev := <-fast; ev.Type != EventJWTRefreshed {
+		t.Errorf("fast subscriber got %+v, want jwt_refreshed", ev)
+	}
+}
@@ -0,0 +1,43 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/kuuji/bamgate/internal/metrics"
+)
+
+// MetricsFunc writes the agent's metrics in the Prometheus text format.
+type MetricsFunc func(w io.Writer) error
+
+// MetricsHandler serves the metrics written by fn, for GET /metrics on the
+// control socket and on the agent's optional TCP metrics listener.
+func MetricsHandler(fn MetricsFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Render into a buffer so a failure is reported as an error instead
+		// of a truncated scrape.
+		var buf bytes.Buffer
+		if err := fn(&buf); err != nil {
+			http.Error(w, fmt.Sprintf("collecting metrics: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", metrics.ContentType)
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// SetMetricsFunc sets the function used to serve GET /metrics.
+func (s *Server) SetMetricsFunc(fn MetricsFunc) {
+	s.metricsFn = fn
+}
+
+// handleMetrics serves the agent's metrics.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metricsFn == nil {
+		http.Error(w, "metrics not available", http.StatusNotImplemented)
+		return
+	}
+	MetricsHandler(s.metricsFn).ServeHTTP(w, r)
+}
@@ -0,0 +1,45 @@
+package control
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kuuji/bamgate/internal/metrics"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	h := MetricsHandler(func(w io.Writer) error {
+		m := metrics.NewWriter(w)
+		m.Family("bamgate_peers", metrics.Gauge, "Number of peers known to the agent.")
+		m.Sample(2)
+		return m.Err()
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, metrics.ContentType)
+	}
+	if !strings.Contains(rec.Body.String(), "\nbamgate_peers 2\n") {
+		t.Errorf("body = %q, want the bamgate_peers sample", rec.Body.String())
+	}
+
+	// A failure is an error response, not a truncated scrape.
+	h = MetricsHandler(func(w io.Writer) error {
+		_, _ = io.WriteString(w, "# HELP partial")
+		return errors.New("device closed")
+	})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "partial") {
+		t.Errorf("failed scrape = %d %q, want 500 without partial output", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,36 @@
+//go:build darwin
+
+package control
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCred returns the credentials of the process connected to c, using
+// LOCAL_PEERCRED and LOCAL_PEERPID.
+func peerCred(c *net.UnixConn) (*PeerCred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("accessing socket: %w", err)
+	}
+	var xucred *unix.Xucred
+	var pid int
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		xucred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if credErr == nil {
+			// The PID is informational; ignore failures.
+			pid, _ = unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("accessing socket: %w", err)
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("reading LOCAL_PEERCRED: %w", credErr)
+	}
+	n := min(int(xucred.Ngroups), len(xucred.Groups))
+	return &PeerCred{PID: pid, UID: xucred.Uid, Groups: append([]uint32(nil), xucred.Groups[:n]...)}, nil
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package control
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCred returns the credentials of the process connected to c, using
+// SO_PEERCRED.
+func peerCred(c *net.UnixConn) (*PeerCred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("accessing socket: %w", err)
+	}
+	var ucred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, fmt.Errorf("accessing socket: %w", err)
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("reading SO_PEERCRED: %w", credErr)
+	}
+	return &PeerCred{PID: int(ucred.Pid), UID: ucred.Uid, Groups: []uint32{ucred.Gid}}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package control
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerCred is not implemented on this platform, so only unprivileged
+// endpoints are usable.
+func peerCred(_ *net.UnixConn) (*PeerCred, error) {
+	return nil, fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -7,19 +7,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// ResolveSocketPath returns the socket path for the control server.
+// ResolveSocketPath returns the socket path for the control server of the
+// default network.
 //
 // Since bamgate runs as root, the socket is placed in the system runtime
 // directory. On Linux, systemd's RuntimeDirectory= creates /run/bamgate
@@ -28,37 +32,103 @@ import (
 // Falls back to /tmp/bamgate if the system directory doesn't exist yet
 // (e.g. running outside of a service).
 func ResolveSocketPath() string {
+	return NetworkSocketPath("")
+}
+
+// NetworkSocketPath returns the control socket path for network, "" being
+// the default network. Named networks get "<network>.sock" next to the
+// default network's control.sock.
+func NetworkSocketPath(network string) string {
+	name := "control.sock"
+	if network != "" {
+		name = network + ".sock"
+	}
+
 	if runtime.GOOS == "darwin" {
 		if info, err := os.Stat("/var/run/bamgate"); err == nil && info.IsDir() {
-			return "/var/run/bamgate/control.sock"
+			return filepath.Join("/var/run/bamgate", name)
 		}
-		return "/tmp/bamgate/control.sock"
+		return filepath.Join("/tmp/bamgate", name)
 	}
 
 	// Linux: prefer the systemd-managed directory.
 	if info, err := os.Stat("/run/bamgate"); err == nil && info.IsDir() {
-		return "/run/bamgate/control.sock"
+		return filepath.Join("/run/bamgate", name)
 	}
 
-	return "/tmp/bamgate/control.sock"
+	return filepath.Join("/tmp/bamgate", name)
 }
 
 // Status represents the overall agent status returned by the /status endpoint.
 type Status struct {
+	// Network is the name of the agent's network, empty for the default
+	// network.
+	Network string `json:"network,omitempty"`
+
 	Device        string       `json:"device"`
 	Address       string       `json:"address"`
+	Address6      string       `json:"address6,omitempty"`
 	Routes        []string     `json:"routes,omitempty"`
 	ServerURL     string       `json:"server_url"`
 	UptimeSeconds float64      `json:"uptime_seconds"`
 	Peers         []PeerStatus `json:"peers"`
+	ACL           *ACLStatus   `json:"acl,omitempty"`
+
+	// PolicyVersion is the version of the network policy received from the
+	// signaling server, 0 if none.
+	PolicyVersion int `json:"policy_version,omitempty"`
+
+	// Blocked lists the peers blocked with "bamgate peer block".
+	Blocked []string `json:"blocked,omitempty"`
+
+	// Role is the device's place in a hub-and-spoke topology, "hub" or
+	// "spoke", empty in a full mesh.
+	Role string `json:"role,omitempty"`
+}
+
+// ACLStatus describes the access control policy enforced on traffic from
+// peers. It is omitted when no ACL is configured.
+type ACLStatus struct {
+	// Default is what peers without rules may do: "allow" or "deny".
+	Default string `json:"default"`
+
+	// NetworkDefault is the network policy's default, empty without a
+	// network policy. Both defaults must allow a peer without rules.
+	NetworkDefault string `json:"network_default,omitempty"`
+
+	// Peers lists peers with allow rules or dropped packets.
+	Peers []ACLPeerStatus `json:"peers,omitempty"`
+
+	// DroppedUnknown counts packets dropped from sources no peer owns.
+	DroppedUnknown uint64 `json:"dropped_unknown,omitempty"`
+}
+
+// ACLPeerStatus is the access granted to one peer.
+type ACLPeerStatus struct {
+	Peer string `json:"peer"`
+
+	// Allow lists the destinations the peer may reach. Empty means the
+	// default applies.
+	Allow []string `json:"allow,omitempty"`
+
+	// NetworkAllow lists the destinations the network policy allows. A
+	// packet must be allowed by both lists.
+	NetworkAllow []string `json:"network_allow,omitempty"`
+
+	// Dropped is the number of packets from the peer the policy dropped.
+	Dropped uint64 `json:"dropped,omitempty"`
 }
 
 // PeerStatus represents the status of a single connected peer.
 type PeerStatus struct {
 	ID             string            `json:"id"`
+	Role           string            `json:"role,omitempty"`  // "hub" or "spoke", see Status.Role
+	Relay          string            `json:"relay,omitempty"` // peer the traffic goes through when not direct
 	Address        string            `json:"address"`
+	Address6       string            `json:"address6,omitempty"`
 	State          string            `json:"state"`
 	ICEType        string            `json:"ice_type"`
+	MTU            int               `json:"mtu,omitempty"` // effective tunnel MTU over the current path
 	Routes         []string          `json:"routes,omitempty"`
 	Metadata       map[string]string `json:"metadata,omitempty"`
 	ConnectedSince time.Time         `json:"connected_since,omitempty"`
@@ -85,6 +155,35 @@ type PeerOfferings struct {
 
 	// Accepted is what the local user has chosen to accept (from config).
 	Accepted PeerCapabilities `json:"accepted"`
+
+	// InstalledRoutes are the routes actually installed for this peer after
+	// applying the route conflict policy to the accepted routes.
+	InstalledRoutes []string `json:"installed_routes,omitempty"`
+
+	// PrimaryRoutes are the InstalledRoutes this peer currently carries.
+	// The rest are standby: another peer advertising the same route is
+	// primary and this one takes over if that peer fails.
+	PrimaryRoutes []string `json:"primary_routes,omitempty"`
+
+	// Conflicts lists advertised routes that overlap subnets this host
+	// already reaches locally.
+	Conflicts []RouteConflict `json:"conflicts,omitempty"`
+
+	// ConflictPolicy is the local device's route conflict policy ("warn",
+	// "refuse", or "more-specific"), applied to conflicting accepted routes.
+	ConflictPolicy string `json:"conflict_policy,omitempty"`
+}
+
+// RouteConflict reports an advertised route that overlaps a local subnet.
+type RouteConflict struct {
+	// Route is the CIDR advertised by the peer.
+	Route string `json:"route"`
+
+	// LocalSubnet is the overlapping interface subnet or kernel route.
+	LocalSubnet string `json:"local_subnet"`
+
+	// Interface is the local interface LocalSubnet is reached through.
+	Interface string `json:"interface,omitempty"`
 }
 
 // PeerCapabilities holds the routes, DNS servers, and search domains
@@ -93,6 +192,26 @@ type PeerCapabilities struct {
 	Routes    []string `json:"routes,omitempty"`
 	DNS       []string `json:"dns,omitempty"`
 	DNSSearch []string `json:"dns_search,omitempty"`
+
+	// RouteAliases maps accepted routes to local alias prefixes. Only used
+	// for selections; peers never advertise aliases.
+	RouteAliases map[string]string `json:"route_aliases,omitempty"`
+
+	// RoutePriority ranks the peer among peers advertising the same
+	// routes. Only used for selections.
+	RoutePriority int `json:"route_priority,omitempty"`
+
+	// Services are LAN services the peer publishes on its tunnel address.
+	// Only advertised; there is nothing to accept.
+	Services []Service `json:"services,omitempty"`
+}
+
+// Service is a LAN service a peer publishes on a port of its tunnel
+// address.
+type Service struct {
+	Name     string `json:"name,omitempty"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
 }
 
 // OfferingsProvider is a function that returns peer offerings with current
@@ -114,6 +233,102 @@ type ConfigureFunc func(req ConfigureRequest) error
 // TokenProvider returns the current JWT access token from the running agent.
 type TokenProvider func() string
 
+// ReloadResult reports what a configuration reload changed, by config key
+// (e.g. "device.routes", "peers.home"). It is the JSON response of
+// POST /config/reload.
+type ReloadResult struct {
+	// Applied lists the changed settings the agent applied without
+	// reconnecting peers.
+	Applied []string `json:"applied,omitempty"`
+
+	// RestartRequired lists changed settings that take effect only after
+	// "bamgate restart". The agent keeps running with the old values.
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// ReloadFunc re-reads the config file and applies what changed. It
+// returns an error without applying anything if the file is invalid.
+type ReloadFunc func() (ReloadResult, error)
+
+// Forward describes a port forward. It is the JSON body of POST /forwards
+// and an element of the GET /forwards response.
+type Forward struct {
+	// ID identifies the forward for DELETE /forwards/{id}. Assigned by the
+	// agent.
+	ID string `json:"id,omitempty"`
+
+	// Protocol is "tcp" (default) or "udp".
+	Protocol string `json:"protocol,omitempty"`
+
+	// Reverse forwards from the mesh to this host instead of from this host
+	// to the mesh.
+	Reverse bool `json:"reverse,omitempty"`
+
+	// Listen is the address connections are accepted on.
+	Listen string `json:"listen"`
+
+	// Target is the "host:port" connections are forwarded to. The host may
+	// be a peer name.
+	Target string `json:"target"`
+
+	// Persistent forwards are saved to the config file and restarted with
+	// the agent.
+	Persistent bool `json:"persistent,omitempty"`
+
+	// Active is the number of open TCP connections or UDP sessions.
+	Active int `json:"active,omitempty"`
+}
+
+// ForwardFuncs implement the /forwards endpoints.
+type ForwardFuncs struct {
+	List   func() []Forward
+	Add    func(Forward) (Forward, error)
+	Remove func(id string) error
+}
+
+// Peer actions served by POST /peers/{id}/{action}.
+const (
+	PeerActionRestartICE = "restart-ice"
+	PeerActionReconnect  = "reconnect"
+	PeerActionBlock      = "block"
+	PeerActionUnblock    = "unblock"
+)
+
+// PeerActions implement POST /peers/{id}/{action}, one function per
+// action.
+type PeerActions struct {
+	RestartICE func(peerID string) error
+	Reconnect  func(peerID string) error
+	Block      func(peerID string) error
+	Unblock    func(peerID string) error
+}
+
+// CaptureRequest selects the packets GET /capture streams. It is encoded
+// as query parameters.
+type CaptureRequest struct {
+	// Peer restricts the capture to one peer. Empty captures all traffic.
+	Peer string
+
+	// Filter is a packet filter expression, e.g. "tcp and port 22".
+	Filter string
+
+	// Encrypted adds the WireGuard frames exchanged with peers.
+	Encrypted bool
+
+	// SnapLen truncates packets to this many bytes. 0 keeps them whole.
+	SnapLen int
+
+	// MaxPackets, MaxBytes and Duration end the capture. 0 means no limit.
+	MaxPackets int
+	MaxBytes   int64
+	Duration   time.Duration
+}
+
+// CaptureFunc writes the packets selected by req to w as pcapng until ctx
+// is cancelled or a limit is reached. It returns an error without writing
+// anything if req is invalid.
+type CaptureFunc func(ctx context.Context, req CaptureRequest, w io.Writer) error
+
 // Server is an HTTP server that listens on a Unix domain socket and
 // serves the agent's status as JSON.
 type Server struct {
@@ -122,9 +337,21 @@ type Server struct {
 	offerings   OfferingsProvider
 	configureFn ConfigureFunc
 	tokenFn     TokenProvider
+	forwards    *ForwardFuncs
+	peerActions *PeerActions
+	aclReload   func() error
+	reloadFn    ReloadFunc
+	captureFn   CaptureFunc
+	events      EventSubscriber
+	metricsFn   MetricsFunc
+	group       string
+	groupID     int64 // -1 if the group does not exist
 	log         *slog.Logger
 	listener    net.Listener
 	httpServer  *http.Server
+
+	// cancel ends long-running responses, such as captures, on Stop.
+	cancel context.CancelFunc
 }
 
 // NewServer creates a new control server.
@@ -135,10 +362,22 @@ func NewServer(socketPath string, provider StatusProvider, logger *slog.Logger)
 	return &Server{
 		socketPath: socketPath,
 		provider:   provider,
+		group:      DefaultGroup,
 		log:        logger.With("component", "control"),
 	}
 }
 
+// SetGroup sets the group whose members, besides root and the agent's own
+// user, may use the privileged endpoints: those that change the agent's
+// state or return secrets. Status, offerings, forwards listing, events and
+// metrics are open to every local user. Empty means DefaultGroup.
+func (s *Server) SetGroup(name string) {
+	if name == "" {
+		name = DefaultGroup
+	}
+	s.group = name
+}
+
 // SetOfferingsProvider sets the function used to serve GET /peers/offerings.
 func (s *Server) SetOfferingsProvider(fn OfferingsProvider) {
 	s.offerings = fn
@@ -154,6 +393,36 @@ func (s *Server) SetTokenProvider(fn TokenProvider) {
 	s.tokenFn = fn
 }
 
+// SetForwardFuncs sets the functions used to serve the /forwards endpoints.
+func (s *Server) SetForwardFuncs(fns ForwardFuncs) {
+	s.forwards = &fns
+}
+
+// SetPeerActions sets the functions used to serve POST /peers/{id}/{action}.
+func (s *Server) SetPeerActions(fns PeerActions) {
+	s.peerActions = &fns
+}
+
+// SetACLReloadFunc sets the function used to handle POST /acl/reload.
+func (s *Server) SetACLReloadFunc(fn func() error) {
+	s.aclReload = fn
+}
+
+// SetReloadFunc sets the function used to handle POST /config/reload.
+func (s *Server) SetReloadFunc(fn ReloadFunc) {
+	s.reloadFn = fn
+}
+
+// SetCaptureFunc sets the function used to serve GET /capture.
+func (s *Server) SetCaptureFunc(fn CaptureFunc) {
+	s.captureFn = fn
+}
+
+// SetEventSubscriber sets the function used to serve GET /events.
+func (s *Server) SetEventSubscriber(fn EventSubscriber) {
+	s.events = fn
+}
+
 // Start begins listening on the Unix socket and serving HTTP requests.
 // It returns immediately; the server runs in the background.
 func (s *Server) Start() error {
@@ -174,18 +443,35 @@ func (s *Server) Start() error {
 	}
 	s.listener = ln
 
-	// Make the socket world-readable so non-root users can query status.
+	// Make the socket world-writable so non-root users can query status.
+	// Privileged endpoints check the client's credentials instead.
 	if err := os.Chmod(s.socketPath, 0666); err != nil {
 		s.log.Warn("setting socket permissions", "error", err)
 	}
+	s.resolveGroup()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /status", s.handleStatus)
 	mux.HandleFunc("GET /peers/offerings", s.handlePeerOfferings)
-	mux.HandleFunc("POST /peers/configure", s.handlePeerConfigure)
-	mux.HandleFunc("GET /auth/token", s.handleAuthToken)
-
-	s.httpServer = &http.Server{Handler: mux}
+	mux.HandleFunc("POST /peers/configure", s.privileged(s.handlePeerConfigure))
+	mux.HandleFunc("POST /peers/{id}/{action}", s.privileged(s.handlePeerAction))
+	mux.HandleFunc("GET /auth/token", s.privileged(s.handleAuthToken))
+	mux.HandleFunc("GET /forwards", s.handleListForwards)
+	mux.HandleFunc("POST /forwards", s.privileged(s.handleAddForward))
+	mux.HandleFunc("DELETE /forwards/{id}", s.privileged(s.handleRemoveForward))
+	mux.HandleFunc("POST /acl/reload", s.privileged(s.handleACLReload))
+	mux.HandleFunc("POST /config/reload", s.privileged(s.handleConfigReload))
+	mux.HandleFunc("GET /capture", s.privileged(s.handleCapture))
+	mux.HandleFunc("GET /events", s.handleEvents)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.httpServer = &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+		ConnContext: s.connContext,
+	}
 
 	go func() {
 		if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
@@ -199,6 +485,9 @@ func (s *Server) Start() error {
 
 // Stop gracefully shuts down the control server and removes the socket file.
 func (s *Server) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
 	if s.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
@@ -288,6 +577,283 @@ func (s *Server) handleAuthToken(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": token})
 }
 
+// handleListForwards responds with the running port forwards.
+func (s *Server) handleListForwards(w http.ResponseWriter, r *http.Request) {
+	if s.forwards == nil {
+		http.Error(w, "forwards not available", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.forwards.List()); err != nil {
+		s.log.Error("encoding forwards response", "error", err)
+	}
+}
+
+// handleAddForward starts a port forward and responds with it, including
+// its assigned ID.
+func (s *Server) handleAddForward(w http.ResponseWriter, r *http.Request) {
+	if s.forwards == nil {
+		http.Error(w, "forwards not available", http.StatusNotImplemented)
+		return
+	}
+
+	var req Forward
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.Listen == "" || req.Target == "" {
+		http.Error(w, "listen and target are required", http.StatusBadRequest)
+		return
+	}
+
+	fwd, err := s.forwards.Add(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("starting forward: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(fwd)
+}
+
+// handleRemoveForward stops a port forward.
+func (s *Server) handleRemoveForward(w http.ResponseWriter, r *http.Request) {
+	if s.forwards == nil {
+		http.Error(w, "forwards not available", http.StatusNotImplemented)
+		return
+	}
+
+	if err := s.forwards.Remove(r.PathValue("id")); err != nil {
+		http.Error(w, fmt.Sprintf("removing forward: %s", err), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
+// handlePeerAction restarts ICE with, reconnects, blocks or unblocks a
+// peer.
+func (s *Server) handlePeerAction(w http.ResponseWriter, r *http.Request) {
+	if s.peerActions == nil {
+		http.Error(w, "peer actions not available", http.StatusNotImplemented)
+		return
+	}
+
+	var fn func(string) error
+	action := r.PathValue("action")
+	switch action {
+	case PeerActionRestartICE:
+		fn = s.peerActions.RestartICE
+	case PeerActionReconnect:
+		fn = s.peerActions.Reconnect
+	case PeerActionBlock:
+		fn = s.peerActions.Block
+	case PeerActionUnblock:
+		fn = s.peerActions.Unblock
+	default:
+		http.Error(w, fmt.Sprintf("unknown peer action %q", action), http.StatusNotFound)
+		return
+	}
+	if fn == nil {
+		http.Error(w, fmt.Sprintf("peer action %q not available", action), http.StatusNotImplemented)
+		return
+	}
+
+	if err := fn(r.PathValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
+// handleACLReload re-reads the access control rules from the config file.
+func (s *Server) handleACLReload(w http.ResponseWriter, r *http.Request) {
+	if s.aclReload == nil {
+		http.Error(w, "ACL reload not available", http.StatusNotImplemented)
+		return
+	}
+
+	if err := s.aclReload(); err != nil {
+		http.Error(w, fmt.Sprintf("reloading ACL: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
+// handleConfigReload re-reads the config file and responds with what
+// changed.
+func (s *Server) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if s.reloadFn == nil {
+		http.Error(w, "config reload not available", http.StatusNotImplemented)
+		return
+	}
+
+	result, err := s.reloadFn()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reloading config: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		s.log.Warn("encoding reload response", "error", err)
+	}
+}
+
+// handleCapture streams captured packets as pcapng until the client
+// disconnects or a limit is reached.
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if s.captureFn == nil {
+		http.Error(w, "packet capture not available", http.StatusNotImplemented)
+		return
+	}
+
+	req, err := parseCaptureQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fw := &flushWriter{w: w, contentType: "application/x-pcapng"}
+	if f, ok := w.(http.Flusher); ok {
+		fw.flusher = f
+	}
+	if err := s.captureFn(r.Context(), req, fw); err != nil {
+		if !fw.started {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.log.Debug("capture ended", "error", err)
+	}
+}
+
+// flushWriter sends each write to the client immediately. The first one
+// sets the Content-Type header and commits the response.
+type flushWriter struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	contentType string
+	started     bool
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	if !fw.started {
+		fw.w.Header().Set("Content-Type", fw.contentType)
+		fw.started = true
+	}
+	n, err := fw.w.Write(p)
+	if fw.flusher != nil {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
+
+// captureQuery encodes req as the query of a GET /capture request.
+func captureQuery(req CaptureRequest) url.Values {
+	q := url.Values{}
+	if req.Peer != "" {
+		q.Set("peer", req.Peer)
+	}
+	if req.Filter != "" {
+		q.Set("filter", req.Filter)
+	}
+	if req.Encrypted {
+		q.Set("encrypted", "true")
+	}
+	if req.SnapLen > 0 {
+		q.Set("snaplen", strconv.Itoa(req.SnapLen))
+	}
+	if req.MaxPackets > 0 {
+		q.Set("count", strconv.Itoa(req.MaxPackets))
+	}
+	if req.MaxBytes > 0 {
+		q.Set("max_bytes", strconv.FormatInt(req.MaxBytes, 10))
+	}
+	if req.Duration > 0 {
+		q.Set("duration", req.Duration.String())
+	}
+	return q
+}
+
+// parseCaptureQuery decodes the query of a GET /capture request.
+func parseCaptureQuery(q url.Values) (CaptureRequest, error) {
+	req := CaptureRequest{
+		Peer:   q.Get("peer"),
+		Filter: q.Get("filter"),
+	}
+	var err error
+	if v := q.Get("encrypted"); v != "" {
+		if req.Encrypted, err = strconv.ParseBool(v); err != nil {
+			return CaptureRequest{}, fmt.Errorf("invalid encrypted %q", v)
+		}
+	}
+	if v := q.Get("snaplen"); v != "" {
+		if req.SnapLen, err = strconv.Atoi(v); err != nil || req.SnapLen < 0 {
+			return CaptureRequest{}, fmt.Errorf("invalid snaplen %q", v)
+		}
+	}
+	if v := q.Get("count"); v != "" {
+		if req.MaxPackets, err = strconv.Atoi(v); err != nil || req.MaxPackets < 0 {
+			return CaptureRequest{}, fmt.Errorf("invalid count %q", v)
+		}
+	}
+	if v := q.Get("max_bytes"); v != "" {
+		if req.MaxBytes, err = strconv.ParseInt(v, 10, 64); err != nil || req.MaxBytes < 0 {
+			return CaptureRequest{}, fmt.Errorf("invalid max_bytes %q", v)
+		}
+	}
+	if v := q.Get("duration"); v != "" {
+		if req.Duration, err = time.ParseDuration(v); err != nil || req.Duration < 0 {
+			return CaptureRequest{}, fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	return req, nil
+}
+
+// handleEvents streams agent events as newline-delimited JSON until the
+// client disconnects. The stream ends early if the client falls behind.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		http.Error(w, "events not available", http.StatusNotImplemented)
+		return
+	}
+
+	events, cancel := s.events()
+	defer cancel()
+
+	// Commit the response right away so the client knows it is subscribed.
+	fw := &flushWriter{w: w, contentType: "application/x-ndjson"}
+	if f, ok := w.(http.Flusher); ok {
+		fw.flusher = f
+	}
+	if _, err := fw.Write(nil); err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(fw)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				s.log.Debug("event stream client fell behind, disconnecting")
+				return
+			}
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
 // FetchStatus connects to a running control server and returns the status.
 // This is used by the "bamgate status" CLI command.
 func FetchStatus(socketPath string) (*Status, error) {
@@ -414,3 +980,273 @@ func FetchToken(socketPath string) (string, error) {
 
 	return result.AccessToken, nil
 }
+
+// FetchForwards returns the port forwards running in the agent. This is
+// used by "bamgate forward list".
+func FetchForwards(socketPath string) ([]Forward, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get("http://bamgate/forwards")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("listing forwards (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var forwards []Forward
+	if err := json.NewDecoder(resp.Body).Decode(&forwards); err != nil {
+		return nil, fmt.Errorf("decoding forwards response: %w", err)
+	}
+
+	return forwards, nil
+}
+
+// AddForward asks the agent to start a port forward and returns it as
+// started, with its ID and resolved listen address. This is used by
+// "bamgate forward".
+func AddForward(socketPath string, fwd Forward) (*Forward, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	body, err := json.Marshal(fwd)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+
+	resp, err := client.Post("http://bamgate/forwards", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("adding forward (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var started Forward
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		return nil, fmt.Errorf("decoding forward response: %w", err)
+	}
+
+	return &started, nil
+}
+
+// RemoveForward asks the agent to stop a port forward. This is used by
+// "bamgate forward remove".
+func RemoveForward(socketPath, id string) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, "http://bamgate/forwards/"+url.PathEscape(id), nil)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("removing forward (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
+
+// PeerAction asks the agent to perform action (one of the PeerAction
+// constants) on a peer. This is used by "bamgate peer".
+func PeerAction(socketPath, peerID, action string) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Post("http://bamgate/peers/"+url.PathEscape(peerID)+"/"+action, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s (status %d): %s", action, peerID, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
+
+// ReloadACL asks the agent to re-read its access control rules from the
+// config file. This is used by "bamgate acl reload".
+func ReloadACL(socketPath string) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Post("http://bamgate/acl/reload", "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("reloading ACL (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
+
+// ReloadConfig asks the agent to re-read its config file and apply what
+// changed. This is used by "bamgate config reload" and "bamgate config
+// edit".
+func ReloadConfig(socketPath string) (*ReloadResult, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+		Timeout: 30 * time.Second,
+	}
+
+	resp, err := client.Post("http://bamgate/config/reload", "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("reloading config (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var result ReloadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding reload response: %w", err)
+	}
+	return &result, nil
+}
+
+// StreamCapture asks the agent to capture packets and copies the pcapng
+// stream to w until ctx is cancelled or the agent ends the capture. It
+// returns the number of bytes written. This is used by "bamgate capture".
+func StreamCapture(ctx context.Context, socketPath string, req CaptureRequest, w io.Writer) (int64, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	u := "http://bamgate/capture"
+	if q := captureQuery(req).Encode(); q != "" {
+		u += "?" + q
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, fmt.Errorf("building request: %w", err)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("starting capture (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil && ctx.Err() != nil {
+		return n, nil // stopped by the caller
+	}
+	if err != nil {
+		return n, fmt.Errorf("reading capture: %w", err)
+	}
+	return n, nil
+}
+
+// WatchEvents streams the agent's events, calling fn for each, until ctx
+// is cancelled (returning nil) or the stream ends, e.g. because the agent
+// stopped. This is used by "bamgate status --watch".
+func WatchEvents(ctx context.Context, socketPath string, fn func(Event)) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://bamgate/events", nil)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("connecting to control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("watching events (status %d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("event stream ended")
+			}
+			return fmt.Errorf("reading events: %w", err)
+		}
+		fn(ev)
+	}
+}
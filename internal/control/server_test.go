@@ -1,7 +1,13 @@
 package control
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -77,3 +83,335 @@ func TestFetchStatus_NoServer(t *testing.T) {
 		t.Fatal("expected error when server is not running, got nil")
 	}
 }
+
+func TestNetworkSocketPath(t *testing.T) {
+	t.Parallel()
+
+	def := NetworkSocketPath("")
+	if def != ResolveSocketPath() || filepath.Base(def) != "control.sock" {
+		t.Errorf("NetworkSocketPath(\"\") = %q, want control.sock at ResolveSocketPath()", def)
+	}
+	client := NetworkSocketPath("client")
+	if filepath.Dir(client) != filepath.Dir(def) || filepath.Base(client) != "client.sock" {
+		t.Errorf("NetworkSocketPath(\"client\") = %q, want client.sock next to %q", client, def)
+	}
+}
+
+func TestServer_Forwards(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	srv := NewServer(socketPath, func() Status { return Status{} }, nil)
+
+	var forwards []Forward
+	srv.SetForwardFuncs(ForwardFuncs{
+		List: func() []Forward { return forwards },
+		Add: func(f Forward) (Forward, error) {
+			f.ID = "1"
+			forwards = append(forwards, f)
+			return f, nil
+		},
+		Remove: func(id string) error {
+			for i, f := range forwards {
+				if f.ID == id {
+					forwards = append(forwards[:i], forwards[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("no forward %q", id)
+		},
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer srv.Stop()
+
+	added, err := AddForward(socketPath, Forward{Listen: "127.0.0.1:5432", Target: "home-server:5432"})
+	if err != nil {
+		t.Fatalf("AddForward() error: %v", err)
+	}
+	if added.ID != "1" {
+		t.Errorf("ID = %q, want %q", added.ID, "1")
+	}
+
+	list, err := FetchForwards(socketPath)
+	if err != nil {
+		t.Fatalf("FetchForwards() error: %v", err)
+	}
+	if len(list) != 1 || list[0].Target != "home-server:5432" {
+		t.Errorf("FetchForwards() = %+v, want the added forward", list)
+	}
+
+	if err := RemoveForward(socketPath, "1"); err != nil {
+		t.Fatalf("RemoveForward() error: %v", err)
+	}
+	if err := RemoveForward(socketPath, "1"); err == nil {
+		t.Error("RemoveForward() of a removed forward succeeded")
+	}
+	if _, err := AddForward(socketPath, Forward{Listen: "127.0.0.1:5432"}); err == nil {
+		t.Error("AddForward() without a target succeeded")
+	}
+}
+
+func TestServer_ACLReload(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	srv := NewServer(socketPath, func() Status {
+		return Status{ACL: &ACLStatus{Default: "deny", Peers: []ACLPeerStatus{{Peer: "phone", Allow: []string{"192.168.1.10:22/tcp"}, Dropped: 3}}}}
+	}, nil)
+
+	reloadErr := fmt.Errorf("peers.phone.allow: invalid rule")
+	reloads := 0
+	srv.SetACLReloadFunc(func() error {
+		reloads++
+		if reloads > 1 {
+			return reloadErr
+		}
+		return nil
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer srv.Stop()
+
+	if err := ReloadACL(socketPath); err != nil {
+		t.Fatalf("ReloadACL() error: %v", err)
+	}
+	err := ReloadACL(socketPath)
+	if err == nil || !strings.Contains(err.Error(), reloadErr.Error()) {
+		t.Errorf("ReloadACL() error = %v, want it to contain %q", err, reloadErr)
+	}
+
+	status, err := FetchStatus(socketPath)
+	if err != nil {
+		t.Fatalf("FetchStatus() error: %v", err)
+	}
+	if status.ACL == nil || status.ACL.Default != "deny" || len(status.ACL.Peers) != 1 || status.ACL.Peers[0].Dropped != 3 {
+		t.Errorf("status.ACL = %+v, want default deny with phone's rules", status.ACL)
+	}
+}
+
+func TestServer_ConfigReload(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	srv := NewServer(socketPath, func() Status { return Status{} }, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer srv.Stop()
+
+	if _, err := ReloadConfig(socketPath); err == nil || !strings.Contains(err.Error(), "status 501") {
+		t.Errorf("ReloadConfig() without a reload func error = %v, want status 501", err)
+	}
+
+	want := ReloadResult{Applied: []string{"device.routes"}, RestartRequired: []string{"device.address"}}
+	fail := false
+	srv.SetReloadFunc(func() (ReloadResult, error) {
+		if fail {
+			return ReloadResult{}, fmt.Errorf("device.advertise_routes: unknown mode %q", "sometimes")
+		}
+		return want, nil
+	})
+
+	got, err := ReloadConfig(socketPath)
+	if err != nil {
+		t.Fatalf("ReloadConfig() error: %v", err)
+	}
+	if !slices.Equal(got.Applied, want.Applied) || !slices.Equal(got.RestartRequired, want.RestartRequired) {
+		t.Errorf("ReloadConfig() = %+v, want %+v", got, want)
+	}
+
+	fail = true
+	if _, err := ReloadConfig(socketPath); err == nil || !strings.Contains(err.Error(), "unknown mode") {
+		t.Errorf("ReloadConfig() error = %v, want the reload error", err)
+	}
+}
+
+func TestServer_PeerActions(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	srv := NewServer(socketPath, func() Status { return Status{} }, nil)
+
+	var calls []string
+	record := func(action string) func(string) error {
+		return func(peerID string) error {
+			if peerID == "nobody" {
+				return fmt.Errorf("unknown peer %q", peerID)
+			}
+			calls = append(calls, action+" "+peerID)
+			return nil
+		}
+	}
+	srv.SetPeerActions(PeerActions{
+		RestartICE: record("restart-ice"),
+		Reconnect:  record("reconnect"),
+		Block:      record("block"),
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer srv.Stop()
+
+	for _, action := range []string{PeerActionRestartICE, PeerActionReconnect, PeerActionBlock} {
+		if err := PeerAction(socketPath, "home server", action); err != nil {
+			t.Errorf("PeerAction(%s) error: %v", action, err)
+		}
+	}
+	want := []string{"restart-ice home server", "reconnect home server", "block home server"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	tests := []struct {
+		peer, action, wantErr string
+	}{
+		{"nobody", PeerActionReconnect, `unknown peer "nobody"`},
+		{"home", PeerActionUnblock, "status 501"},
+		{"home", "explode", "status 404"},
+	}
+	for _, tt := range tests {
+		err := PeerAction(socketPath, tt.peer, tt.action)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("PeerAction(%s, %s) error = %v, want it to contain %q", tt.peer, tt.action, err, tt.wantErr)
+		}
+	}
+}
+
+func TestServer_Capture(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	srv := NewServer(socketPath, func() Status { return Status{} }, nil)
+
+	want := CaptureRequest{
+		Peer:       "phone",
+		Filter:     "tcp and port 22",
+		Encrypted:  true,
+		SnapLen:    128,
+		MaxPackets: 10,
+		MaxBytes:   1 << 20,
+		Duration:   30 * time.Second,
+	}
+	srv.SetCaptureFunc(func(ctx context.Context, req CaptureRequest, w io.Writer) error {
+		if req.Peer == "nobody" {
+			return fmt.Errorf("unknown peer %q", req.Peer)
+		}
+		if req != want {
+			return fmt.Errorf("request = %+v, want %+v", req, want)
+		}
+		for _, block := range []string{"header", "packet"} {
+			if _, err := io.WriteString(w, block); err != nil {
+				return err
+			}
+		}
+		<-ctx.Done() // streams until the client goes away
+		return ctx.Err()
+	})
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer srv.Stop()
+
+	// The stream arrives as written; the client stops it by cancelling.
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := StreamCapture(ctx, socketPath, want, pw)
+		pw.Close()
+		done <- err
+	}()
+	got := make([]byte, len("headerpacket"))
+	if _, err := io.ReadFull(pr, got); err != nil {
+		t.Fatalf("reading capture: %v", err)
+	}
+	if string(got) != "headerpacket" {
+		t.Errorf("capture = %q, want %q", got, "headerpacket")
+	}
+	cancel()
+	go io.Copy(io.Discard, pr)
+	if err := <-done; err != nil {
+		t.Errorf("StreamCapture() after cancel error = %v, want nil", err)
+	}
+
+	// Errors before the stream starts are reported to the client.
+	_, err := StreamCapture(context.Background(), socketPath, CaptureRequest{Peer: "nobody"}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), `unknown peer "nobody"`) {
+		t.Errorf("StreamCapture() error = %v, want unknown peer", err)
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	srv := NewServer(socketPath, func() Status { return Status{} }, nil)
+	bus := NewEventBus()
+	srv.SetEventSubscriber(bus.Subscribe)
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	got := make(chan Event)
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchEvents(context.Background(), socketPath, func(ev Event) { got <- ev })
+	}()
+
+	// Events are only delivered once the client has subscribed.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		bus.mu.Lock()
+		n := len(bus.subs)
+		bus.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the event subscription")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	bus.Publish(Event{Type: EventRouteAdded, Peer: "home", Route: "192.168.1.0/24"})
+	bus.Publish(Event{Type: EventDNSApplied, Peer: "home", DNS: []string{"192.168.1.1"}})
+	if ev := <-got; ev.Type != EventRouteAdded || ev.Route != "192.168.1.0/24" || ev.Time.IsZero() {
+		t.Errorf("first event = %+v", ev)
+	}
+	if ev := <-got; ev.Type != EventDNSApplied || len(ev.DNS) != 1 || ev.DNS[0] != "192.168.1.1" {
+		t.Errorf("second event = %+v", ev)
+	}
+
+	// Stopping the server ends the stream and the subscription.
+	srv.Stop()
+	if err := <-done; err == nil {
+		t.Error("WatchEvents() after server stop returned nil, want error")
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if len(bus.subs) != 0 {
+		t.Errorf("%d subscriptions left after the stream ended", len(bus.subs))
+	}
+}
+
+func TestParseCaptureQuery(t *testing.T) {
+	t.Parallel()
+
+	for _, query := range []string{"count=-1", "snaplen=x", "max_bytes=1e3", "duration=5", "encrypted=maybe"} {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseCaptureQuery(q); err == nil {
+			t.Errorf("parseCaptureQuery(%q) succeeded, want error", query)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Package forward implements TCP and UDP port forwards over the mesh, as
+// used by `bamgate forward`. A forward accepts connections on one network
+// and opens a connection to its target on another: a local forward listens
+// on the host and dials through the tunnel, a reverse forward listens on
+// the tunnel address and dials the host. The networks are abstracted so the
+// same code runs on the kernel TUN device and on the userspace netstack.
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// dialTimeout bounds how long a TCP forward waits for its target.
+	dialTimeout = 15 * time.Second
+
+	// udpIdleTimeout is how long a UDP session may go without packets in
+	// either direction before it is closed.
+	udpIdleTimeout = 2 * time.Minute
+
+	// maxDatagram is the largest UDP payload forwarded.
+	maxDatagram = 65535
+)
+
+// Network opens connections and listeners. Host uses the host's own
+// network stack; *netstack.Stack implements it for userspace mode.
+type Network interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	Listen(network, address string) (net.Listener, error)
+	ListenPacket(network, address string) (net.PacketConn, error)
+}
+
+// Host is the host's network stack.
+var Host Network = hostNetwork{}
+
+type hostNetwork struct{}
+
+func (hostNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func (hostNetwork) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (hostNetwork) ListenPacket(network, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+// Forward is a running port forward.
+type Forward struct {
+	log    *slog.Logger
+	target string
+	dialer Network
+
+	ln net.Listener   // TCP
+	pc net.PacketConn // UDP
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}  // open TCP connections, both sides
+	sessions map[string]*udpSession // UDP sessions by client address
+}
+
+// Start listens on listen ("host:port") in listenNet and forwards each TCP
+// connection, or each UDP client, to target in dialNet. protocol is "tcp"
+// or "udp".
+func Start(protocol, listen, target string, listenNet, dialNet Network, logger *slog.Logger) (*Forward, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forward{
+		log:      logger.With("component", "forward", "protocol", protocol, "listen", listen, "target", target),
+		target:   target,
+		dialer:   dialNet,
+		ctx:      ctx,
+		cancel:   cancel,
+		conns:    make(map[net.Conn]struct{}),
+		sessions: make(map[string]*udpSession),
+	}
+
+	switch protocol {
+	case "tcp":
+		ln, err := listenNet.Listen("tcp", listen)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("listening on %s: %w", listen, err)
+		}
+		f.ln = ln
+		f.wg.Add(1)
+		go f.serveTCP()
+	case "udp":
+		pc, err := listenNet.ListenPacket("udp", listen)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("listening on %s: %w", listen, err)
+		}
+		f.pc = pc
+		f.wg.Add(1)
+		go f.serveUDP()
+	default:
+		cancel()
+		return nil, fmt.Errorf("unsupported protocol %q (want tcp or udp)", protocol)
+	}
+	return f, nil
+}
+
+// Addr returns the address the forward is listening on.
+func (f *Forward) Addr() net.Addr {
+	if f.ln != nil {
+		return f.ln.Addr()
+	}
+	return f.pc.LocalAddr()
+}
+
+// Active returns the number of open TCP connections or UDP sessions.
+func (f *Forward) Active() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.conns)/2 + len(f.sessions)
+}
+
+// Close stops the forward and closes every connection it carries.
+func (f *Forward) Close() error {
+	f.cancel()
+	var err error
+	if f.ln != nil {
+		err = f.ln.Close()
+	} else {
+		err = f.pc.Close()
+	}
+
+	f.mu.Lock()
+	for c := range f.conns {
+		_ = c.Close()
+	}
+	for _, s := range f.sessions {
+		_ = s.upstream.Close()
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return err
+}
+
+// track records c as open, or returns false if the forward is closing.
+func (f *Forward) track(c net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ctx.Err() != nil {
+		return false
+	}
+	f.conns[c] = struct{}{}
+	return true
+}
+
+func (f *Forward) untrack(c net.Conn) {
+	f.mu.Lock()
+	delete(f.conns, c)
+	f.mu.Unlock()
+}
+
+// --- TCP ---
+
+func (f *Forward) serveTCP() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			if f.ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				f.log.Warn("accepting connection", "error", err)
+			}
+			return
+		}
+		f.wg.Add(1)
+		go f.handleTCP(conn)
+	}
+}
+
+func (f *Forward) handleTCP(client net.Conn) {
+	defer f.wg.Done()
+	defer client.Close()
+	if !f.track(client) {
+		return
+	}
+	defer f.untrack(client)
+
+	ctx, cancel := context.WithTimeout(f.ctx, dialTimeout)
+	upstream, err := f.dialer.DialContext(ctx, "tcp", f.target)
+	cancel()
+	if err != nil {
+		f.log.Warn("connecting to target", "client", client.RemoteAddr(), "error", err)
+		return
+	}
+	defer upstream.Close()
+	if !f.track(upstream) {
+		return
+	}
+	defer f.untrack(upstream)
+
+	f.log.Debug("forwarding connection", "client", client.RemoteAddr())
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, client)
+		closeWrite(upstream)
+		close(done)
+	}()
+	_, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	<-done
+}
+
+// closeWrite half-closes conn if it supports it, so the other side sees
+// EOF while replies can still flow back.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
+
+// --- UDP ---
+
+// udpSession relays datagrams between one client and the target.
+type udpSession struct {
+	client   net.Addr
+	upstream net.Conn
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+func (s *udpSession) touch() {
+	s.mu.Lock()
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *udpSession) idle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastSeen) >= udpIdleTimeout
+}
+
+func (f *Forward) serveUDP() {
+	defer f.wg.Done()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := f.pc.ReadFrom(buf)
+		if err != nil {
+			if f.ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				f.log.Warn("reading datagram", "error", err)
+			}
+			return
+		}
+
+		s, err := f.session(addr)
+		if err != nil {
+			f.log.Warn("connecting to target", "client", addr, "error", err)
+			continue
+		}
+		s.touch()
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			f.log.Debug("writing datagram to target", "client", addr, "error", err)
+		}
+	}
+}
+
+// session returns the session for a client, opening one if needed.
+func (f *Forward) session(addr net.Addr) (*udpSession, error) {
+	key := addr.String()
+	f.mu.Lock()
+	s, ok := f.sessions[key]
+	f.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	ctx, cancel := context.WithTimeout(f.ctx, dialTimeout)
+	upstream, err := f.dialer.DialContext(ctx, "udp", f.target)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	s = &udpSession{client: addr, upstream: upstream, lastSeen: time.Now()}
+	f.mu.Lock()
+	if f.ctx.Err() != nil {
+		f.mu.Unlock()
+		_ = upstream.Close()
+		return nil, net.ErrClosed
+	}
+	f.sessions[key] = s
+	f.mu.Unlock()
+
+	f.log.Debug("new UDP session", "client", addr)
+	f.wg.Add(1)
+	go f.relayUDP(key, s)
+	return s, nil
+}
+
+// relayUDP copies replies from the target back to the client until the
+// session goes idle or the forward is closed.
+func (f *Forward) relayUDP(key string, s *udpSession) {
+	defer f.wg.Done()
+	defer func() {
+		f.mu.Lock()
+		delete(f.sessions, key)
+		f.mu.Unlock()
+		_ = s.upstream.Close()
+	}()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		_ = s.upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && !s.idle() {
+				continue // the client is still sending
+			}
+			return
+		}
+		s.touch()
+		if _, err := f.pc.WriteTo(buf[:n], s.client); err != nil {
+			return
+		}
+	}
+}
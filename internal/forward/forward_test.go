@@ -0,0 +1,130 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTCPEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func startUDPEcho(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// echo writes msg to conn and checks that it comes back.
+func echo(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("reading echo: %v", err)
+	}
+	if string(buf) != msg {
+		t.Errorf("echo = %q, want %q", buf, msg)
+	}
+}
+
+func TestForward_TCP(t *testing.T) {
+	t.Parallel()
+
+	target := startTCPEcho(t)
+	f, err := Start("tcp", "127.0.0.1:0", target, Host, Host, nil)
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing forward: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello over tcp")
+
+	if got := f.Active(); got != 1 {
+		t.Errorf("Active() = %d, want 1", got)
+	}
+
+	// Closing the forward tears down open connections.
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after Close")
+	}
+}
+
+func TestForward_UDP(t *testing.T) {
+	t.Parallel()
+
+	target := startUDPEcho(t)
+	f, err := Start("udp", "127.0.0.1:0", target, Host, Host, nil)
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	for _, msg := range []string{"first datagram", "second datagram"} {
+		conn, err := net.Dial("udp", f.Addr().String())
+		if err != nil {
+			t.Fatalf("dialing forward: %v", err)
+		}
+		echo(t, conn, msg)
+		conn.Close()
+	}
+	if got := f.Active(); got != 2 {
+		t.Errorf("Active() = %d, want 2 sessions", got)
+	}
+}
+
+func TestStart_invalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Start("sctp", "127.0.0.1:0", "127.0.0.1:1", Host, Host, nil); err == nil {
+		t.Error("Start() accepted an unsupported protocol")
+	}
+	if _, err := Start("tcp", "not-an-address", "127.0.0.1:1", Host, Host, nil); err == nil {
+		t.Error("Start() accepted an invalid listen address")
+	}
+}
@@ -0,0 +1,102 @@
+// Package metrics writes metrics in the Prometheus text exposition format,
+// which Prometheus and OpenMetrics scrapers both accept.
+//
+// Only what the agent needs is implemented: counters and gauges with
+// labels, written in one pass. There is no registry; the caller gathers
+// current values and writes every family on each scrape.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the HTTP Content-Type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Type is a metric family type.
+type Type string
+
+const (
+	Counter Type = "counter"
+	Gauge   Type = "gauge"
+)
+
+// Writer writes metric families to an io.Writer. The first write error is
+// kept and returned by Err; later writes are skipped.
+type Writer struct {
+	w      io.Writer
+	family string
+	err    error
+}
+
+// NewWriter creates a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Family starts a metric family. The samples that follow belong to it. A
+// family must be written only once per scrape.
+func (m *Writer) Family(name string, typ Type, help string) {
+	m.family = name
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+}
+
+// Sample writes a sample of the current family. labels are name, value
+// pairs.
+func (m *Writer) Sample(value float64, labels ...string) {
+	if len(labels)%2 != 0 {
+		panic("metrics: odd number of label arguments")
+	}
+
+	var b strings.Builder
+	b.WriteString(m.family)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	m.printf("%s", b.String())
+}
+
+// Err returns the first error writing to the underlying writer.
+func (m *Writer) Err() error {
+	return m.err
+}
+
+func (m *Writer) printf(format string, args ...any) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, format, args...)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatValue formats a sample value, spelling infinities and NaN the way
+// the format requires.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
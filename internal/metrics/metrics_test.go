@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	m := NewWriter(&b)
+	m.Family("bamgate_uptime_seconds", Gauge, "Seconds since the agent started.")
+	m.Sample(12.5)
+	m.Family("bamgate_peer_receive_bytes_total", Counter, "Bytes received.\nFrom peers, \\ encrypted.")
+	m.Sample(1024, "peer", "home")
+	m.Sample(3e9, "peer", `odd "name"`+"\n\\")
+	m.Family("bamgate_peer_handshake_age_seconds", Gauge, "No samples.")
+	m.Family("bamgate_test", Gauge, "Special values.")
+	m.Sample(math.Inf(1), "v", "inf")
+	m.Sample(math.Inf(-1), "v", "-inf")
+	m.Sample(math.NaN(), "v", "nan", "w", "x")
+	if err := m.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	want := `# HELP bamgate_uptime_seconds Seconds since the agent started.
+# TYPE bamgate_uptime_seconds gauge
+bamgate_uptime_seconds 12.5
+# HELP bamgate_peer_receive_bytes_total Bytes received.\nFrom peers, \\ encrypted.
+# TYPE bamgate_peer_receive_bytes_total counter
+bamgate_peer_receive_bytes_total{peer="home"} 1024
+bamgate_peer_receive_bytes_total{peer="odd \"name\"\n\\"} 3e+09
+# HELP bamgate_peer_handshake_age_seconds No samples.
+# TYPE bamgate_peer_handshake_age_seconds gauge
+# HELP bamgate_test Special values.
+# TYPE bamgate_test gauge
+bamgate_test{v="inf"} +Inf
+bamgate_test{v="-inf"} -Inf
+bamgate_test{v="nan",w="x"} NaN
+`
+	if got := b.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// failWriter fails every write.
+type failWriter struct{ n int }
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("broken pipe")
+}
+
+func TestWriter_Err(t *testing.T) {
+	t.Parallel()
+
+	fw := &failWriter{}
+	m := NewWriter(fw)
+	m.Family("a", Counter, "A.")
+	m.Sample(1)
+	m.Family("b", Counter, "B.")
+	if err := m.Err(); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("Err() = %v, want broken pipe", err)
+	}
+	if fw.n != 1 {
+		t.Errorf("%d writes after the first error, want none", fw.n-1)
+	}
+}
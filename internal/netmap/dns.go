@@ -0,0 +1,55 @@
+package netmap
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RewriteResponse rewrites the A and AAAA records in a DNS response so
+// addresses inside a mapped route point at the alias instead. It returns the
+// original message unchanged (and false) when nothing needed rewriting.
+func RewriteResponse(msg []byte, maps []Mapping) ([]byte, bool, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		return nil, false, fmt.Errorf("parsing DNS response: %w", err)
+	}
+
+	changed := false
+	for _, section := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range section {
+			if rewriteResource(&section[i], maps) {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return msg, false, nil
+	}
+
+	out, err := m.Pack()
+	if err != nil {
+		return nil, false, fmt.Errorf("packing DNS response: %w", err)
+	}
+	return out, true, nil
+}
+
+// rewriteResource translates the address of an A or AAAA record in place.
+func rewriteResource(r *dnsmessage.Resource, maps []Mapping) bool {
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		addr := netip.AddrFrom4(body.A)
+		if out := AliasAddr(addr, maps); out != addr {
+			body.A = out.As4()
+			return true
+		}
+	case *dnsmessage.AAAAResource:
+		addr := netip.AddrFrom16(body.AAAA)
+		if out := AliasAddr(addr, maps); out != addr {
+			body.AAAA = out.As16()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package netmap
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testMappings(t *testing.T) []Mapping {
+	t.Helper()
+	m, err := Parse("192.168.1.0/24", "10.201.1.0/24")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	return []Mapping{m}
+}
+
+// buildResponse packs a response for name with the given A records.
+func buildResponse(t *testing.T, id uint16, name string, addrs ...[4]byte) []byte {
+	t.Helper()
+	out, err := packResponse(id, name, addrs...)
+	if err != nil {
+		t.Fatalf("packing response: %v", err)
+	}
+	return out
+}
+
+func packResponse(id uint16, name string, addrs ...[4]byte) ([]byte, error) {
+	m := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, Response: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	for _, a := range addrs {
+		m.Answers = append(m.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: a},
+		})
+	}
+	return m.Pack()
+}
+
+// answerAddrs unpacks a response and returns its A record addresses.
+func answerAddrs(t *testing.T, msg []byte) []string {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		t.Fatalf("unpacking response: %v", err)
+	}
+	var addrs []string
+	for _, r := range m.Answers {
+		if a, ok := r.Body.(*dnsmessage.AResource); ok {
+			addrs = append(addrs, net.IP(a.A[:]).String())
+		}
+	}
+	return addrs
+}
+
+func TestRewriteResponse(t *testing.T) {
+	t.Parallel()
+
+	resp := buildResponse(t, 7, "nas.home.lan.", [4]byte{192, 168, 1, 20}, [4]byte{8, 8, 8, 8})
+	out, changed, err := RewriteResponse(resp, testMappings(t))
+	if err != nil {
+		t.Fatalf("RewriteResponse() error: %v", err)
+	}
+	if !changed {
+		t.Fatal("RewriteResponse() reported no change")
+	}
+	got := answerAddrs(t, out)
+	if len(got) != 2 || got[0] != "10.201.1.20" || got[1] != "8.8.8.8" {
+		t.Errorf("answers = %v, want [10.201.1.20 8.8.8.8]", got)
+	}
+}
+
+func TestRewriteResponse_unchanged(t *testing.T) {
+	t.Parallel()
+
+	resp := buildResponse(t, 7, "example.com.", [4]byte{93, 184, 216, 34})
+	out, changed, err := RewriteResponse(resp, testMappings(t))
+	if err != nil {
+		t.Fatalf("RewriteResponse() error: %v", err)
+	}
+	if changed || string(out) != string(resp) {
+		t.Error("RewriteResponse() modified a response with no mapped addresses")
+	}
+}
+
+func TestDNSProxy(t *testing.T) {
+	t.Parallel()
+
+	// Fake upstream resolver: answers every query with 192.168.1.20.
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening for upstream: %v", err)
+	}
+	t.Cleanup(func() { upstream.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if q.Unpack(buf[:n]) != nil {
+				continue
+			}
+			if resp, err := packResponse(q.Header.ID, q.Questions[0].Name.String(), [4]byte{192, 168, 1, 20}); err == nil {
+				upstream.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	proxy, err := ListenDNSProxy("127.0.0.1:0", []string{upstream.LocalAddr().String()}, testMappings(t), slog.Default())
+	if err != nil {
+		t.Fatalf("ListenDNSProxy() error: %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName("nas.home.lan."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		t.Fatalf("packing query: %v", err)
+	}
+
+	conn, err := net.Dial("udp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(packed); err != nil {
+		t.Fatalf("sending query: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+
+	got := answerAddrs(t, buf[:n])
+	if len(got) != 1 || got[0] != "10.201.1.20" {
+		t.Errorf("proxied answers = %v, want [10.201.1.20]", got)
+	}
+}
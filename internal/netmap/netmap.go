@@ -0,0 +1,106 @@
+// Package netmap implements 1:1 prefix mapping (NETMAP) for peer routes that
+// overlap other routes — typically several sites that all use
+// 192.168.1.0/24. The accepting device reaches each site through a locally
+// unique alias prefix of the same size; the advertising device translates
+// the alias back to the real subnet with a kernel NAT rule. Because DNS
+// answers from a site's resolver carry real addresses, this package also
+// rewrites DNS responses into the alias space.
+package netmap
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Mapping pairs a route advertised by a peer with the alias prefix the local
+// device uses for it. Host offsets are preserved: with Route 192.168.1.0/24
+// and Alias 10.201.1.0/24, 192.168.1.20 maps to 10.201.1.20.
+type Mapping struct {
+	Route netip.Prefix
+	Alias netip.Prefix
+}
+
+// Parse validates a route/alias pair. Both must be CIDRs of the same address
+// family and prefix length, and the alias must not overlap the route.
+func Parse(route, alias string) (Mapping, error) {
+	r, err := netip.ParsePrefix(route)
+	if err != nil {
+		return Mapping{}, fmt.Errorf("parsing route %q: %w", route, err)
+	}
+	a, err := netip.ParsePrefix(alias)
+	if err != nil {
+		return Mapping{}, fmt.Errorf("parsing alias %q: %w", alias, err)
+	}
+	r, a = r.Masked(), a.Masked()
+
+	if r.Addr().Is4() != a.Addr().Is4() {
+		return Mapping{}, fmt.Errorf("alias %s and route %s are different address families", a, r)
+	}
+	if r.Bits() != a.Bits() {
+		return Mapping{}, fmt.Errorf("alias %s must have the same prefix length as route %s", a, r)
+	}
+	if r.Overlaps(a) {
+		return Mapping{}, fmt.Errorf("alias %s overlaps route %s", a, r)
+	}
+	return Mapping{Route: r, Alias: a}, nil
+}
+
+// ParseAll validates a route → alias map as stored in peer selections.
+func ParseAll(aliases map[string]string) ([]Mapping, error) {
+	maps := make([]Mapping, 0, len(aliases))
+	for route, alias := range aliases {
+		m, err := Parse(route, alias)
+		if err != nil {
+			return nil, err
+		}
+		maps = append(maps, m)
+	}
+	return maps, nil
+}
+
+// ToAlias translates an address inside Route to the same host offset inside
+// Alias. It reports false if addr is not inside Route.
+func (m Mapping) ToAlias(addr netip.Addr) (netip.Addr, bool) {
+	return translate(addr, m.Route, m.Alias)
+}
+
+// ToRoute translates an address inside Alias back to the same host offset
+// inside Route. It reports false if addr is not inside Alias.
+func (m Mapping) ToRoute(addr netip.Addr) (netip.Addr, bool) {
+	return translate(addr, m.Alias, m.Route)
+}
+
+// translate replaces the network bits of addr (which must be inside from)
+// with those of to, keeping the host bits.
+func translate(addr netip.Addr, from, to netip.Prefix) (netip.Addr, bool) {
+	addr = addr.Unmap()
+	if !from.Contains(addr) {
+		return netip.Addr{}, false
+	}
+
+	a := addr.AsSlice()
+	t := to.Addr().AsSlice()
+	bits := from.Bits()
+	for i := range a {
+		switch {
+		case (i+1)*8 <= bits:
+			a[i] = t[i] // whole byte is network
+		case i*8 < bits:
+			mask := byte(0xff << (8 - bits%8))
+			a[i] = t[i]&mask | a[i]&^mask
+		}
+	}
+	out, _ := netip.AddrFromSlice(a)
+	return out, true
+}
+
+// AliasAddr translates addr with the first mapping whose route contains it.
+// Addresses outside every route are returned unchanged.
+func AliasAddr(addr netip.Addr, maps []Mapping) netip.Addr {
+	for _, m := range maps {
+		if out, ok := m.ToAlias(addr); ok {
+			return out
+		}
+	}
+	return addr
+}
@@ -0,0 +1,69 @@
+package netmap
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		route   string
+		alias   string
+		wantErr bool
+	}{
+		{"ipv4", "192.168.1.0/24", "10.201.1.0/24", false},
+		{"unmasked input", "192.168.1.7/24", "10.201.1.9/24", false},
+		{"ipv6", "fd00:1::/64", "fd99:1::/64", false},
+		{"length mismatch", "192.168.1.0/24", "10.201.0.0/16", true},
+		{"family mismatch", "192.168.1.0/24", "fd99::/24", true},
+		{"overlap", "192.168.0.0/16", "192.168.0.0/16", true},
+		{"bad alias", "192.168.1.0/24", "nope", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Parse(tt.route, tt.alias)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q, %q) error = %v, wantErr %v", tt.route, tt.alias, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMapping_ToAlias(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		route, alias string
+		addr         string
+		want         string
+		wantOK       bool
+	}{
+		{"192.168.1.0/24", "10.201.1.0/24", "192.168.1.20", "10.201.1.20", true},
+		{"192.168.1.0/24", "10.201.1.0/24", "192.168.2.20", "", false},
+		// Prefix boundary inside a byte: only the low 4 bits are host bits.
+		{"192.168.1.16/28", "10.0.0.240/28", "192.168.1.21", "10.0.0.245", true},
+		{"fd00:1::/64", "fd99:7::/64", "fd00:1::abcd", "fd99:7::abcd", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			t.Parallel()
+			m, err := Parse(tt.route, tt.alias)
+			if err != nil {
+				t.Fatalf("Parse() error: %v", err)
+			}
+			got, ok := m.ToAlias(netip.MustParseAddr(tt.addr))
+			if ok != tt.wantOK {
+				t.Fatalf("ToAlias(%s) ok = %v, want %v", tt.addr, ok, tt.wantOK)
+			}
+			if ok && got.String() != tt.want {
+				t.Errorf("ToAlias(%s) = %s, want %s", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package netmap
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// upstreamTimeout bounds each attempt to reach an upstream resolver.
+	upstreamTimeout = 2 * time.Second
+
+	// maxDNSMessage is the largest UDP DNS message the proxy handles
+	// (the EDNS0 maximum).
+	maxDNSMessage = 65535
+
+	// maxInflightQueries bounds the queries forwarded at once. Queries
+	// arriving beyond it are dropped; the client's resolver retries.
+	maxInflightQueries = 64
+)
+
+// DNSProxy is a minimal UDP DNS forwarder that relays queries to a peer's
+// resolvers and rewrites the answers into alias space. It lets a device use
+// a remote site's DNS server even when that site is reached through a
+// NETMAP alias.
+//
+// Only UDP is served. Responses too large for UDP are returned truncated as
+// the upstream sent them; clients retrying over TCP will fail.
+type DNSProxy struct {
+	log       *slog.Logger
+	conn      net.PacketConn
+	upstreams []string
+	maps      []Mapping
+	inflight  chan struct{} // semaphore, maxInflightQueries slots
+	wg        sync.WaitGroup
+}
+
+// ListenDNSProxy starts a DNS proxy on listenAddr (e.g. "10.0.0.2:53") that
+// forwards to upstreams in order until one answers. Upstreams without a
+// port use 53.
+func ListenDNSProxy(listenAddr string, upstreams []string, maps []Mapping, logger *slog.Logger) (*DNSProxy, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream DNS servers")
+	}
+	conn, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", listenAddr, err)
+	}
+
+	p := &DNSProxy{
+		log:      logger.With("component", "dns-proxy"),
+		conn:     conn,
+		maps:     maps,
+		inflight: make(chan struct{}, maxInflightQueries),
+	}
+	for _, u := range upstreams {
+		if _, _, err := net.SplitHostPort(u); err != nil {
+			u = net.JoinHostPort(u, "53")
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on.
+func (p *DNSProxy) Addr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+// Close stops the proxy and waits for in-flight queries to finish.
+func (p *DNSProxy) Close() error {
+	err := p.conn.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *DNSProxy) serve() {
+	defer p.wg.Done()
+
+	buf := make([]byte, maxDNSMessage)
+	for {
+		n, client, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.log.Warn("reading DNS query", "error", err)
+			}
+			return
+		}
+
+		select {
+		case p.inflight <- struct{}{}:
+		default:
+			p.log.Debug("too many DNS queries in flight, dropping query", "client", client)
+			continue
+		}
+		query := append([]byte(nil), buf[:n]...)
+		p.wg.Add(1)
+		go func() {
+			defer func() {
+				<-p.inflight
+				p.wg.Done()
+			}()
+			p.handle(query, client)
+		}()
+	}
+}
+
+// handle forwards one query and writes the rewritten response back to the
+// client. Failures are logged and the query is dropped, so the client's
+// resolver retries or falls back to its next server.
+func (p *DNSProxy) handle(query []byte, client net.Addr) {
+	resp, err := p.forward(query)
+	if err != nil {
+		p.log.Debug("forwarding DNS query", "error", err)
+		return
+	}
+
+	if rewritten, changed, err := RewriteResponse(resp, p.maps); err != nil {
+		p.log.Debug("rewriting DNS response, passing through unchanged", "error", err)
+	} else if changed {
+		resp = rewritten
+	}
+
+	if _, err := p.conn.WriteTo(resp, client); err != nil && !errors.Is(err, net.ErrClosed) {
+		p.log.Debug("writing DNS response", "client", client, "error", err)
+	}
+}
+
+// forward sends query to each upstream in turn and returns the first
+// response.
+func (p *DNSProxy) forward(query []byte) ([]byte, error) {
+	var lastErr error
+	for _, upstream := range p.upstreams {
+		resp, err := exchange(upstream, query)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// exchange performs a single UDP round trip with an upstream resolver.
+func exchange(upstream string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", upstream, upstreamTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", upstream, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(upstreamTimeout)); err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("sending query to %s: %w", upstream, err)
+	}
+
+	buf := make([]byte, maxDNSMessage)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading response from %s: %w", upstream, err)
+	}
+	return buf[:n], nil
+}
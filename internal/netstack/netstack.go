@@ -0,0 +1,340 @@
+// Package netstack runs the bamgate tunnel on a userspace TCP/IP stack
+// (gVisor, via wireguard-go's tun/netstack) instead of a kernel TUN device.
+// No root privileges or CAP_NET_ADMIN are needed: addresses, routes and DNS
+// live inside the process, and applications reach the mesh through the
+// local SOCKS5 / HTTP CONNECT proxy in proxy.go.
+package netstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/tun"
+	wgnetstack "golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// Stack is a userspace network stack attached to the WireGuard device. It
+// tracks which destinations belong to the mesh (the tunnel subnets plus
+// routes accepted from peers) and dials those through the tunnel; anything
+// else is dialed directly from the host.
+type Stack struct {
+	log       *slog.Logger
+	addresses []netip.Prefix // tunnel addresses (e.g. 10.0.0.2/24)
+
+	mu     sync.RWMutex
+	tnet   *wgnetstack.Net // nil until Open
+	routes map[netip.Prefix]bool
+	dns    []netip.Addr
+	search []string
+}
+
+// New creates a stack for the given tunnel addresses in CIDR notation
+// (device.address and, on dual-stack networks, device.address6). The stack
+// is not usable until Open is called.
+func New(addresses []string, logger *slog.Logger) (*Stack, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s := &Stack{
+		log:    logger.With("component", "netstack"),
+		routes: make(map[netip.Prefix]bool),
+	}
+	for _, a := range addresses {
+		p, err := netip.ParsePrefix(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tunnel address %q: %w", a, err)
+		}
+		s.addresses = append(s.addresses, p)
+	}
+	if len(s.addresses) == 0 {
+		return nil, errors.New("no tunnel address")
+	}
+	return s, nil
+}
+
+// Open creates the userspace TUN device that WireGuard reads from and
+// writes to. It may only be called once.
+func (s *Stack) Open(mtu int) (tun.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tnet != nil {
+		return nil, errors.New("netstack already open")
+	}
+
+	addrs := make([]netip.Addr, len(s.addresses))
+	for i, p := range s.addresses {
+		addrs[i] = p.Addr()
+	}
+	dev, tnet, err := wgnetstack.CreateNetTUN(addrs, nil, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("creating netstack TUN: %w", err)
+	}
+	s.tnet = tnet
+	s.log.Info("userspace network stack started", "addresses", s.addresses, "mtu", mtu)
+	return dev, nil
+}
+
+// AddRoute marks a subnet accepted from a peer as reachable through the
+// tunnel.
+func (s *Stack) AddRoute(cidr string) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid route %q: %w", cidr, err)
+	}
+	s.mu.Lock()
+	s.routes[p.Masked()] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// RemoveRoute undoes AddRoute.
+func (s *Stack) RemoveRoute(cidr string) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid route %q: %w", cidr, err)
+	}
+	s.mu.Lock()
+	delete(s.routes, p.Masked())
+	s.mu.Unlock()
+	return nil
+}
+
+// SetDNS sets the DNS servers and search domains accepted from peers. Names
+// under a search domain, and single-label names, are resolved through the
+// tunnel with these servers; everything else uses the host resolver. With
+// servers but no search domains, every name goes through the tunnel.
+func (s *Stack) SetDNS(servers, searchDomains []string) error {
+	var dns []netip.Addr
+	for _, server := range servers {
+		addr, err := netip.ParseAddr(server)
+		if err != nil {
+			return fmt.Errorf("invalid DNS server %q: %w", server, err)
+		}
+		dns = append(dns, addr)
+	}
+	search := make([]string, len(searchDomains))
+	for i, d := range searchDomains {
+		search[i] = strings.Trim(strings.ToLower(d), ".")
+	}
+
+	s.mu.Lock()
+	s.dns = dns
+	s.search = search
+	s.mu.Unlock()
+	return nil
+}
+
+// RevertDNS clears the DNS configuration set by SetDNS.
+func (s *Stack) RevertDNS() {
+	s.mu.Lock()
+	s.dns = nil
+	s.search = nil
+	s.mu.Unlock()
+}
+
+// InMesh reports whether addr is reached through the tunnel: it is inside
+// a tunnel subnet or a route accepted from a peer.
+func (s *Stack) InMesh(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range s.addresses {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for p := range s.routes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialContext connects to address ("host:port"), through the tunnel if the
+// destination is in the mesh and directly otherwise. Host names are
+// resolved as described on SetDNS.
+func (s *Stack) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := s.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		target := net.JoinHostPort(addr.String(), port)
+		var conn net.Conn
+		if s.InMesh(addr) {
+			conn, err = s.dialMesh(ctx, network, target)
+		} else {
+			var d net.Dialer
+			conn, err = d.DialContext(ctx, network, target)
+		}
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dialMesh dials target (an IP and port) inside the userspace stack.
+func (s *Stack) dialMesh(ctx context.Context, network, target string) (net.Conn, error) {
+	tnet, err := s.net()
+	if err != nil {
+		return nil, err
+	}
+	return tnet.DialContext(ctx, network, target)
+}
+
+// Listen listens for TCP connections on address ("host:port") inside the
+// userspace stack, so only peers can connect. An empty host means the
+// stack's first tunnel address.
+func (s *Stack) Listen(network, address string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	ap, err := s.listenAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	tnet, err := s.net()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := tnet.ListenTCPAddrPort(ap)
+	if err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// ListenPacket is like Listen for UDP.
+func (s *Stack) ListenPacket(network, address string) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	ap, err := s.listenAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	tnet, err := s.net()
+	if err != nil {
+		return nil, err
+	}
+	pc, err := tnet.ListenUDPAddrPort(ap)
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// listenAddr parses a listen address, defaulting the host to the first
+// tunnel address.
+func (s *Stack) listenAddr(address string) (netip.AddrPort, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if host == "" {
+		host = s.addresses[0].Addr().String()
+	}
+	ap, err := netip.ParseAddrPort(net.JoinHostPort(host, port))
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+	return ap, nil
+}
+
+// net returns the stack's network, or an error before Open.
+func (s *Stack) net() (*wgnetstack.Net, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.tnet == nil {
+		return nil, errors.New("netstack not open")
+	}
+	return s.tnet, nil
+}
+
+// lookup resolves host to addresses. Literal IPs are returned as-is.
+func (s *Stack) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+
+	s.mu.RLock()
+	servers := s.dns
+	search := s.search
+	s.mu.RUnlock()
+
+	var names []string
+	if len(servers) > 0 {
+		names = meshNames(host, search)
+	}
+	if len(names) == 0 {
+		return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	}
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var lastErr error
+			for _, server := range servers {
+				conn, err := s.dialMesh(ctx, network, netip.AddrPortFrom(server, 53).String())
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
+			}
+			return nil, lastErr
+		},
+	}
+	var lastErr error
+	for _, name := range names {
+		addrs, err := r.LookupNetIP(ctx, "ip", name)
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("resolving %s through the tunnel: %w", host, lastErr)
+}
+
+// meshNames returns the fully qualified names to look up with peer DNS
+// servers: host itself if it is under a search domain, or host with each
+// search domain appended if it is a single label. It returns nil for names
+// the host resolver should handle. With no search domains, every name is
+// resolved through the tunnel.
+func meshNames(host string, search []string) []string {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if len(search) == 0 {
+		return []string{name + "."}
+	}
+	if !strings.Contains(name, ".") {
+		names := make([]string, len(search))
+		for i, d := range search {
+			names[i] = name + "." + d + "."
+		}
+		return names
+	}
+	for _, d := range search {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return []string{name + "."}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package netstack
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestStack_InMesh(t *testing.T) {
+	t.Parallel()
+
+	s, err := New([]string{"10.0.0.2/24", "fd12:3456:789a::2/64"}, nil)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if err := s.AddRoute("192.168.1.0/24"); err != nil {
+		t.Fatalf("AddRoute() error: %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.0.0.7", true},
+		{"fd12:3456:789a::9", true},
+		{"192.168.1.20", true},
+		{"192.168.2.20", false},
+		{"8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := s.InMesh(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("InMesh(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if err := s.RemoveRoute("192.168.1.0/24"); err != nil {
+		t.Fatalf("RemoveRoute() error: %v", err)
+	}
+	if s.InMesh(netip.MustParseAddr("192.168.1.20")) {
+		t.Error("InMesh() still true after RemoveRoute")
+	}
+}
+
+func TestMeshNames(t *testing.T) {
+	t.Parallel()
+
+	search := []string{"home.lan", "svc.cluster.local"}
+	tests := []struct {
+		host   string
+		search []string
+		want   []string
+	}{
+		{"nas.home.lan", search, []string{"nas.home.lan."}},
+		{"NAS.Home.Lan.", search, []string{"nas.home.lan."}},
+		{"nas", search, []string{"nas.home.lan.", "nas.svc.cluster.local."}},
+		{"example.com", search, nil},
+		{"example.com", nil, []string{"example.com."}},
+	}
+	for _, tt := range tests {
+		if got := meshNames(tt.host, tt.search); !slices.Equal(got, tt.want) {
+			t.Errorf("meshNames(%q, %v) = %v, want %v", tt.host, tt.search, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package netstack
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultProxyListen is the default address of the local proxy.
+const DefaultProxyListen = "127.0.0.1:1080"
+
+// dialTimeout bounds how long the proxy waits to connect to a destination.
+const dialTimeout = 15 * time.Second
+
+// Dialer opens outbound connections for the proxy. *Stack implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Proxy is a local SOCKS5 and HTTP proxy on a single port. The protocol is
+// detected from the first byte a client sends: 0x05 is SOCKS5, anything
+// else is parsed as HTTP. HTTP clients may use CONNECT for any TCP
+// destination or send plain requests with an absolute URI.
+//
+// No authentication is offered, so the proxy should only listen on
+// loopback.
+type Proxy struct {
+	log    *slog.Logger
+	ln     net.Listener
+	dialer Dialer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// ListenProxy starts a proxy on addr that opens connections with dialer.
+func ListenProxy(addr string, dialer Dialer, logger *slog.Logger) (*Proxy, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Proxy{
+		log:    logger.With("component", "proxy"),
+		ln:     ln,
+		dialer: dialer,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on.
+func (p *Proxy) Addr() net.Addr {
+	return p.ln.Addr()
+}
+
+// Close stops accepting connections, aborts pending dials and waits for
+// the accept loop to exit. Established tunnels are closed by their
+// endpoints.
+func (p *Proxy) Close() error {
+	p.cancel()
+	err := p.ln.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.log.Warn("accepting proxy connection", "error", err)
+			}
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+// handle serves one client connection.
+func (p *Proxy) handle(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+
+	if first[0] == socksVersion {
+		err = p.handleSOCKS(conn, br)
+	} else {
+		err = p.handleHTTP(conn, br)
+	}
+	if err != nil {
+		p.log.Debug("proxy request failed", "client", conn.RemoteAddr(), "error", err)
+	}
+}
+
+func (p *Proxy) dial(address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, dialTimeout)
+	defer cancel()
+	return p.dialer.DialContext(ctx, "tcp", address)
+}
+
+// --- SOCKS5 (RFC 1928), CONNECT only, no authentication ---
+
+const (
+	socksVersion        = 0x05
+	socksNoAuth         = 0x00
+	socksNoAcceptable   = 0xff
+	socksCmdConnect     = 0x01
+	socksAtypIPv4       = 0x01
+	socksAtypDomain     = 0x03
+	socksAtypIPv6       = 0x04
+	socksSucceeded      = 0x00
+	socksHostUnreach    = 0x04
+	socksCmdUnsupported = 0x07
+	socksAtypUnsupport  = 0x08
+)
+
+func (p *Proxy) handleSOCKS(conn net.Conn, br *bufio.Reader) error {
+	// Greeting: VER NMETHODS METHODS...
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return fmt.Errorf("reading SOCKS greeting: %w", err)
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return fmt.Errorf("reading SOCKS methods: %w", err)
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == socksNoAuth {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		_, _ = conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return errors.New("SOCKS client does not support unauthenticated access")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return err
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	var req [4]byte
+	if _, err := io.ReadFull(br, req[:]); err != nil {
+		return fmt.Errorf("reading SOCKS request: %w", err)
+	}
+	if req[1] != socksCmdConnect {
+		_ = socksReply(conn, socksCmdUnsupported)
+		return fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make([]byte, 4)
+		if req[3] == socksAtypIPv6 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return fmt.Errorf("reading SOCKS address: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		n, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("reading SOCKS domain length: %w", err)
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return fmt.Errorf("reading SOCKS domain: %w", err)
+		}
+		host = string(name)
+	default:
+		_ = socksReply(conn, socksAtypUnsupport)
+		return fmt.Errorf("unsupported SOCKS address type %d", req[3])
+	}
+	var portBuf [2]byte
+	if _, err := io.ReadFull(br, portBuf[:]); err != nil {
+		return fmt.Errorf("reading SOCKS port: %w", err)
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBuf[:]))))
+
+	upstream, err := p.dial(target)
+	if err != nil {
+		_ = socksReply(conn, socksHostUnreach)
+		return fmt.Errorf("dialing %s: %w", target, err)
+	}
+	defer upstream.Close()
+
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return err
+	}
+	pipe(conn, br, upstream)
+	return nil
+}
+
+// socksReply sends a reply with an unspecified bound address.
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// --- HTTP proxy ---
+
+func (p *Proxy) handleHTTP(conn net.Conn, br *bufio.Reader) error {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return fmt.Errorf("reading HTTP request: %w", err)
+	}
+
+	if req.Method == http.MethodConnect {
+		upstream, err := p.dial(req.Host)
+		if err != nil {
+			httpError(conn, http.StatusBadGateway)
+			return fmt.Errorf("dialing %s: %w", req.Host, err)
+		}
+		defer upstream.Close()
+
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return err
+		}
+		pipe(conn, br, upstream)
+		return nil
+	}
+
+	// Plain request with an absolute URI (e.g. curl with http_proxy set
+	// for an http:// URL). Forward it with "Connection: close" so the
+	// client opens a new proxy connection for its next request, which may
+	// be for another host.
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		httpError(conn, http.StatusBadRequest)
+		return fmt.Errorf("unsupported proxy request %s %s", req.Method, req.URL)
+	}
+	target := req.URL.Host
+	if req.URL.Port() == "" {
+		target = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	upstream, err := p.dial(target)
+	if err != nil {
+		httpError(conn, http.StatusBadGateway)
+		return fmt.Errorf("dialing %s: %w", target, err)
+	}
+	defer upstream.Close()
+
+	req.Header.Del("Proxy-Connection")
+	req.Close = true
+	if err := req.Write(upstream); err != nil {
+		return fmt.Errorf("forwarding request to %s: %w", target, err)
+	}
+	_, err = io.Copy(conn, upstream)
+	return err
+}
+
+func httpError(conn net.Conn, status int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		status, http.StatusText(status))
+}
+
+// pipe copies data in both directions until both sides are done. Bytes the
+// client sent after its request are already buffered in br.
+func pipe(client net.Conn, br *bufio.Reader, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, br)
+		closeWrite(upstream)
+		close(done)
+	}()
+	_, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	<-done
+}
+
+// closeWrite half-closes conn if it supports it, so the other side sees
+// EOF while replies can still flow back.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,160 @@
+package netstack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// startEcho starts a TCP server that echoes everything it reads.
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func startProxy(t *testing.T) *Proxy {
+	t.Helper()
+	p, err := ListenProxy("127.0.0.1:0", &net.Dialer{}, nil)
+	if err != nil {
+		t.Fatalf("ListenProxy() error: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// roundTrip writes msg to conn and reads the echo back.
+func roundTrip(t *testing.T, conn net.Conn, r io.Reader, msg string) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("reading echo: %v", err)
+	}
+	if string(buf) != msg {
+		t.Errorf("echo = %q, want %q", buf, msg)
+	}
+}
+
+func TestProxy_SOCKS5(t *testing.T) {
+	t.Parallel()
+
+	echo := startEcho(t)
+	p := startProxy(t)
+
+	dialer, err := xproxy.SOCKS5("tcp", p.Addr().String(), nil, xproxy.Direct)
+	if err != nil {
+		t.Fatalf("SOCKS5() error: %v", err)
+	}
+
+	// By IP address and by name (resolved by the proxy).
+	_, port, _ := net.SplitHostPort(echo)
+	for _, target := range []string{echo, net.JoinHostPort("localhost", port)} {
+		conn, err := dialer.Dial("tcp", target)
+		if err != nil {
+			t.Fatalf("dialing %s through SOCKS5: %v", target, err)
+		}
+		roundTrip(t, conn, conn, "hello over socks")
+		conn.Close()
+	}
+}
+
+func TestProxy_HTTPConnect(t *testing.T) {
+	t.Parallel()
+
+	echo := startEcho(t)
+	p := startProxy(t)
+
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing proxy: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echo, echo)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT status = %d, want 200", resp.StatusCode)
+	}
+	roundTrip(t, conn, br, "hello over connect")
+}
+
+func TestProxy_HTTPForward(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "path=%s", r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	p := startProxy(t)
+
+	proxyURL, _ := url.Parse("http://" + p.Addr().String())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get(srv.URL + "/hello")
+	if err != nil {
+		t.Fatalf("GET through proxy: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "path=/hello" {
+		t.Errorf("body = %q, want %q", body, "path=/hello")
+	}
+}
+
+func TestProxy_DialFailure(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+
+	// Grab a free port and close it so nothing is listening there.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	dialer, err := xproxy.SOCKS5("tcp", p.Addr().String(), nil, xproxy.Direct)
+	if err != nil {
+		t.Fatalf("SOCKS5() error: %v", err)
+	}
+	if conn, err := dialer.Dial("tcp", closed); err == nil {
+		conn.Close()
+		t.Error("SOCKS5 dial to a closed port succeeded")
+	}
+}
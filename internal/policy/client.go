@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Stored is the policy as served by GET /policy. Policy is nil when none
+// has been set. Version increases with every update.
+type Stored struct {
+	Version int             `json:"version"`
+	Policy  json.RawMessage `json:"policy"`
+}
+
+// Fetch downloads the network policy from the signaling server. jwt may be
+// empty for a self-hosted hub, which does not authenticate.
+func Fetch(ctx context.Context, serverURL, jwt string) (*Document, int, error) {
+	respBody, err := do(ctx, http.MethodGet, serverURL, jwt, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetching policy failed: %w", err)
+	}
+
+	var stored Stored
+	if err := json.Unmarshal(respBody, &stored); err != nil {
+		return nil, 0, fmt.Errorf("parsing response: %w", err)
+	}
+	if len(stored.Policy) == 0 || string(stored.Policy) == "null" {
+		return nil, stored.Version, nil
+	}
+	doc, err := Parse(stored.Policy)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, stored.Version, nil
+}
+
+// Upload replaces the network policy on the signaling server, which pushes
+// it to every connected device. It returns the new version.
+func Upload(ctx context.Context, serverURL, jwt string, doc *Document) (int, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling policy: %w", err)
+	}
+	respBody, err := do(ctx, http.MethodPut, serverURL, jwt, body)
+	if err != nil {
+		return 0, fmt.Errorf("setting policy failed: %w", err)
+	}
+
+	var result struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return 0, fmt.Errorf("parsing response: %w", err)
+	}
+	return result.Version, nil
+}
+
+// do calls /policy and returns the response body of a 200 response.
+func do(ctx context.Context, method, serverURL, jwt string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, serverURL+"/policy", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+jwt)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling /policy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != "" {
+			return nil, errors.New(errResp.Error)
+		}
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return respBody, nil
+}
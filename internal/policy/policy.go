@@ -0,0 +1,347 @@
+// Package policy defines the network-wide policy document stored by the
+// signaling server (the Cloudflare worker or a self-hosted hub) and pushed
+// to every agent over signaling. It centralizes what would otherwise be
+// repeated in each device's [peers.*] config sections: access control
+// rules, routes to accept automatically, DNS servers to use, and which
+// devices are hubs and spokes.
+//
+// Entries select devices by name, by "group:<name>" or with "*". Local
+// config still applies on top: an agent enforces both the network ACL and
+// its own allow rules, so local rules can only narrow access, and a local
+// [peers.<name>] route or DNS selection replaces the policy's for that peer.
+//
+// A policy file looks like:
+//
+//	acl_default = "deny"
+//
+//	[groups]
+//	laptops = ["work-laptop", "home-laptop"]
+//	mobile = ["phone"]
+//
+//	[[acl]]
+//	from = ["group:mobile"]
+//	to = ["home-gw"]
+//	allow = ["192.168.1.10:22,443/tcp", "10.0.0.1/icmp"]
+//
+//	[[routes]]
+//	devices = ["group:laptops"]
+//	from = "home-gw"
+//	accept = ["192.168.1.0/24"]
+//
+//	[[dns]]
+//	devices = ["*"]
+//	from = "home-gw"
+//	servers = ["192.168.1.1"]
+//	search = ["home.lan"]
+//
+//	[topology]
+//	hubs = ["home-gw", "home-gw2"]
+//	spokes = ["group:mobile"]
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/kuuji/bamgate/internal/acl"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// groupPrefix marks a selector that names a group instead of a device.
+const groupPrefix = "group:"
+
+// Document is a network-wide policy. The same document is sent to every
+// device; each one picks out the entries that apply to it.
+type Document struct {
+	// ACLDefault is what peers without matching acl entries may reach:
+	// "allow" (the default) or "deny".
+	ACLDefault string `toml:"acl_default,omitempty" json:"acl_default,omitempty"`
+
+	// Groups maps group names to device names.
+	Groups map[string][]string `toml:"groups,omitempty" json:"groups,omitempty"`
+
+	ACL    []ACLEntry   `toml:"acl,omitempty" json:"acl,omitempty"`
+	Routes []RouteEntry `toml:"routes,omitempty" json:"routes,omitempty"`
+	DNS    []DNSEntry   `toml:"dns,omitempty" json:"dns,omitempty"`
+
+	Topology Topology `toml:"topology,omitempty" json:"topology,omitzero"`
+}
+
+// Topology assigns devices the hub and spoke roles (see RoleOf). A device
+// setting device.role in its own config keeps that role.
+type Topology struct {
+	Hubs   []string `toml:"hubs,omitempty" json:"hubs,omitempty"`
+	Spokes []string `toml:"spokes,omitempty" json:"spokes,omitempty"`
+}
+
+// ACLEntry allows peers matching From to reach the listed destinations on
+// devices matching To.
+type ACLEntry struct {
+	From []string `toml:"from" json:"from"`
+
+	// To selects the devices enforcing the entry. Empty means every device.
+	To []string `toml:"to,omitempty" json:"to,omitempty"`
+
+	// Allow uses the syntax of peers.<name>.allow, e.g.
+	// "192.168.1.10:22,443/tcp".
+	Allow []string `toml:"allow" json:"allow"`
+}
+
+// RouteEntry makes devices matching Devices accept routes advertised by
+// the peer From without a local route selection.
+type RouteEntry struct {
+	// Devices selects the accepting devices. Empty means every device.
+	Devices []string `toml:"devices,omitempty" json:"devices,omitempty"`
+	From    string   `toml:"from" json:"from"`
+	Accept  []string `toml:"accept" json:"accept"`
+}
+
+// DNSEntry makes devices matching Devices use DNS servers and search
+// domains from the peer From without a local DNS selection.
+type DNSEntry struct {
+	// Devices selects the accepting devices. Empty means every device.
+	Devices []string `toml:"devices,omitempty" json:"devices,omitempty"`
+	From    string   `toml:"from" json:"from"`
+	Servers []string `toml:"servers,omitempty" json:"servers,omitempty"`
+	Search  []string `toml:"search,omitempty" json:"search,omitempty"`
+}
+
+// Parse decodes a policy from JSON, as stored by the server, or from TOML,
+// as written by hand. It does not validate the result.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("decoding policy JSON: %w", err)
+		}
+		return &doc, nil
+	}
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding policy TOML: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown policy key %q", undecoded[0].String())
+	}
+	return &doc, nil
+}
+
+// TOML formats the policy for editing.
+func (d *Document) TOML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(d); err != nil {
+		return nil, fmt.Errorf("encoding policy: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Validate checks that every selector, rule, route and address in the
+// policy is well formed and that referenced groups exist.
+func (d *Document) Validate() error {
+	if _, err := acl.ParseAction(d.ACLDefault); err != nil {
+		return fmt.Errorf("acl_default: %w", err)
+	}
+	for name, members := range d.Groups {
+		if name == "" || strings.ContainsAny(name, ": ") {
+			return fmt.Errorf("groups: invalid group name %q", name)
+		}
+		for _, m := range members {
+			if m == "" || m == "*" || strings.HasPrefix(m, groupPrefix) {
+				return fmt.Errorf("groups.%s: invalid member %q (want a device name)", name, m)
+			}
+		}
+	}
+	for i, e := range d.ACL {
+		if len(e.From) == 0 {
+			return fmt.Errorf("acl[%d]: from is empty", i)
+		}
+		if err := d.checkSelectors(e.From); err != nil {
+			return fmt.Errorf("acl[%d].from: %w", i, err)
+		}
+		if err := d.checkSelectors(e.To); err != nil {
+			return fmt.Errorf("acl[%d].to: %w", i, err)
+		}
+		if _, err := acl.ParseRules(e.Allow); err != nil {
+			return fmt.Errorf("acl[%d].allow: %w", i, err)
+		}
+	}
+	for i, e := range d.Routes {
+		if err := d.checkSource(e.From, e.Devices); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+		if len(e.Accept) == 0 {
+			return fmt.Errorf("routes[%d]: accept is empty", i)
+		}
+		for _, r := range e.Accept {
+			if _, err := netip.ParsePrefix(r); err != nil {
+				return fmt.Errorf("routes[%d].accept: invalid route %q: %w", i, r, err)
+			}
+		}
+	}
+	for i, e := range d.DNS {
+		if err := d.checkSource(e.From, e.Devices); err != nil {
+			return fmt.Errorf("dns[%d]: %w", i, err)
+		}
+		if len(e.Servers) == 0 && len(e.Search) == 0 {
+			return fmt.Errorf("dns[%d]: servers and search are both empty", i)
+		}
+		for _, s := range e.Servers {
+			if _, err := netip.ParseAddr(s); err != nil {
+				return fmt.Errorf("dns[%d].servers: invalid address %q: %w", i, s, err)
+			}
+		}
+	}
+	if err := d.checkSelectors(d.Topology.Hubs); err != nil {
+		return fmt.Errorf("topology.hubs: %w", err)
+	}
+	if err := d.checkSelectors(d.Topology.Spokes); err != nil {
+		return fmt.Errorf("topology.spokes: %w", err)
+	}
+	return nil
+}
+
+// checkSource validates the from and devices fields shared by route and
+// DNS entries.
+func (d *Document) checkSource(from string, devices []string) error {
+	if from == "" || from == "*" || strings.HasPrefix(from, groupPrefix) {
+		return fmt.Errorf("from must name one device, got %q", from)
+	}
+	if err := d.checkSelectors(devices); err != nil {
+		return fmt.Errorf("devices: %w", err)
+	}
+	return nil
+}
+
+func (d *Document) checkSelectors(sel []string) error {
+	for _, s := range sel {
+		if s == "" {
+			return fmt.Errorf("empty selector")
+		}
+		if group, ok := strings.CutPrefix(s, groupPrefix); ok {
+			if _, exists := d.Groups[group]; !exists {
+				return fmt.Errorf("unknown group %q", group)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether device name is selected by sel. An empty
+// selector list matches when emptyAll is set.
+func (d *Document) matches(sel []string, name string, emptyAll bool) bool {
+	if len(sel) == 0 {
+		return emptyAll
+	}
+	for _, s := range sel {
+		if s == "*" || s == name {
+			return true
+		}
+		if group, ok := strings.CutPrefix(s, groupPrefix); ok && slices.Contains(d.Groups[group], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Default returns the ACL default for peers without network rules. A nil
+// policy allows everything.
+func (d *Document) Default() acl.Action {
+	if d == nil {
+		return acl.Allow
+	}
+	a, err := acl.ParseAction(d.ACLDefault)
+	if err != nil {
+		return acl.Allow
+	}
+	return a
+}
+
+// ACLFor returns the rules device self enforces on traffic from peer, or
+// nil if no acl entry covers the pair. Entries that match are combined.
+func (d *Document) ACLFor(self, peer string) []acl.Rule {
+	if d == nil {
+		return nil
+	}
+	var rules []acl.Rule
+	for _, e := range d.ACL {
+		if !d.matches(e.To, self, true) || !d.matches(e.From, peer, false) {
+			continue
+		}
+		r, err := acl.ParseRules(e.Allow)
+		if err != nil {
+			continue // rejected by Validate
+		}
+		rules = append(rules, r...)
+		if rules == nil {
+			rules = []acl.Rule{} // an entry with no rules allows nothing
+		}
+	}
+	return rules
+}
+
+// RoutesFor returns the routes device self accepts from peer.
+func (d *Document) RoutesFor(self, peer string) []string {
+	if d == nil {
+		return nil
+	}
+	var routes []string
+	for _, e := range d.Routes {
+		if e.From != peer || !d.matches(e.Devices, self, true) {
+			continue
+		}
+		for _, r := range e.Accept {
+			if !slices.Contains(routes, r) {
+				routes = append(routes, r)
+			}
+		}
+	}
+	return routes
+}
+
+// DNSFor returns the DNS servers and search domains device self uses from
+// peer.
+func (d *Document) DNSFor(self, peer string) (servers, search []string) {
+	if d == nil {
+		return nil, nil
+	}
+	for _, e := range d.DNS {
+		if e.From != peer || !d.matches(e.Devices, self, true) {
+			continue
+		}
+		for _, s := range e.Servers {
+			if !slices.Contains(servers, s) {
+				servers = append(servers, s)
+			}
+		}
+		for _, s := range e.Search {
+			if !slices.Contains(search, s) {
+				search = append(search, s)
+			}
+		}
+	}
+	return servers, search
+}
+
+// RoleOf returns the topology role of device name: protocol.RoleHub,
+// protocol.RoleSpoke, or "" if the policy assigns none. A device selected
+// as both is a hub.
+func (d *Document) RoleOf(name string) string {
+	if d == nil {
+		return ""
+	}
+	switch {
+	case d.matches(d.Topology.Hubs, name, false):
+		return protocol.RoleHub
+	case d.matches(d.Topology.Spokes, name, false):
+		return protocol.RoleSpoke
+	}
+	return ""
+}
@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kuuji/bamgate/internal/acl"
+)
+
+const example = `
+acl_default = "deny"
+
+[groups]
+laptops = ["work-laptop", "home-laptop"]
+mobile = ["phone"]
+
+[[acl]]
+from = ["group:mobile"]
+to = ["home-gw"]
+allow = ["192.168.1.10:22,443/tcp"]
+
+[[acl]]
+from = ["phone", "work-laptop"]
+allow = ["10.0.0.1/icmp"]
+
+[[routes]]
+devices = ["group:laptops"]
+from = "home-gw"
+accept = ["192.168.1.0/24"]
+
+[[routes]]
+from = "home-gw"
+accept = ["192.168.2.0/24", "192.168.1.0/24"]
+
+[[dns]]
+devices = ["*"]
+from = "home-gw"
+servers = ["192.168.1.1"]
+search = ["home.lan"]
+
+[topology]
+hubs = ["home-gw"]
+spokes = ["group:mobile", "home-gw"]
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	doc, err := Parse([]byte(example))
+	if err != nil {
+		t.Fatalf("Parse(TOML) error: %v", err)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if len(doc.ACL) != 2 || len(doc.Routes) != 2 || len(doc.DNS) != 1 || len(doc.Groups) != 2 {
+		t.Errorf("Parse() = %+v, want 2 acl, 2 routes, 1 dns, 2 groups", doc)
+	}
+
+	fromJSON, err := Parse([]byte(`{"acl_default":"deny","dns":[{"from":"home-gw","servers":["192.168.1.1"]}]}`))
+	if err != nil {
+		t.Fatalf("Parse(JSON) error: %v", err)
+	}
+	if fromJSON.ACLDefault != "deny" || len(fromJSON.DNS) != 1 {
+		t.Errorf("Parse(JSON) = %+v", fromJSON)
+	}
+
+	out, err := doc.TOML()
+	if err != nil {
+		t.Fatalf("TOML() error: %v", err)
+	}
+	again, err := Parse(out)
+	if err != nil {
+		t.Fatalf("Parse(TOML()) error: %v", err)
+	}
+	if len(again.ACL) != 2 || again.ACL[0].Allow[0] != "192.168.1.10:22,443/tcp" || !slices.Equal(again.Topology.Hubs, []string{"home-gw"}) {
+		t.Errorf("round trip = %+v", again)
+	}
+
+	for _, bad := range []string{`acl_defualt = "deny"`, `{"acl": [{"form": ["phone"]}]}`} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want unknown key error", bad)
+		}
+	}
+}
+
+func TestDocument_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		doc     Document
+		wantErr string
+	}{
+		{"empty", Document{}, ""},
+		{"bad default", Document{ACLDefault: "drop"}, "acl_default"},
+		{"nested group", Document{Groups: map[string][]string{"a": {"group:b"}}}, "groups.a"},
+		{"acl without from", Document{ACL: []ACLEntry{{Allow: []string{"10.0.0.1"}}}}, "acl[0]: from is empty"},
+		{"unknown group", Document{ACL: []ACLEntry{{From: []string{"group:nope"}}}}, "unknown group"},
+		{"bad rule", Document{ACL: []ACLEntry{{From: []string{"*"}, Allow: []string{"phone:22"}}}}, "acl[0].allow"},
+		{"route from group", Document{Routes: []RouteEntry{{From: "group:a", Accept: []string{"10.1.0.0/16"}}}}, "routes[0]"},
+		{"bad route", Document{Routes: []RouteEntry{{From: "gw", Accept: []string{"10.1.0.0"}}}}, "routes[0].accept"},
+		{"empty dns", Document{DNS: []DNSEntry{{From: "gw"}}}, "dns[0]"},
+		{"bad dns server", Document{DNS: []DNSEntry{{From: "gw", Servers: []string{"gw.lan"}}}}, "dns[0].servers"},
+		{"unknown hub group", Document{Topology: Topology{Hubs: []string{"group:gws"}}}, "topology.hubs"},
+		{"empty spoke", Document{Topology: Topology{Spokes: []string{""}}}, "topology.spokes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.doc.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDocument_resolve(t *testing.T) {
+	t.Parallel()
+
+	doc, err := Parse([]byte(example))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if got := doc.Default(); got != acl.Deny {
+		t.Errorf("Default() = %q, want deny", got)
+	}
+	var none *Document
+	if got := none.Default(); got != acl.Allow {
+		t.Errorf("nil Default() = %q, want allow", got)
+	}
+
+	ruleStrings := func(rules []acl.Rule) []string {
+		var s []string
+		for _, r := range rules {
+			s = append(s, r.String())
+		}
+		return s
+	}
+	aclTests := []struct {
+		self, peer string
+		want       []string
+	}{
+		{"home-gw", "phone", []string{"192.168.1.10:22,443/tcp", "10.0.0.1/icmp"}},
+		{"nas", "phone", []string{"10.0.0.1/icmp"}},
+		{"home-gw", "work-laptop", []string{"10.0.0.1/icmp"}},
+		{"home-gw", "home-laptop", nil},
+	}
+	for _, tt := range aclTests {
+		if got := ruleStrings(doc.ACLFor(tt.self, tt.peer)); !slices.Equal(got, tt.want) {
+			t.Errorf("ACLFor(%q, %q) = %v, want %v", tt.self, tt.peer, got, tt.want)
+		}
+	}
+	if none.ACLFor("a", "b") != nil {
+		t.Error("nil ACLFor() returned rules")
+	}
+
+	if got := doc.RoutesFor("work-laptop", "home-gw"); !slices.Equal(got, []string{"192.168.1.0/24", "192.168.2.0/24"}) {
+		t.Errorf("RoutesFor(work-laptop) = %v", got)
+	}
+	if got := doc.RoutesFor("phone", "home-gw"); !slices.Equal(got, []string{"192.168.2.0/24", "192.168.1.0/24"}) {
+		t.Errorf("RoutesFor(phone) = %v", got)
+	}
+	if got := doc.RoutesFor("phone", "nas"); got != nil {
+		t.Errorf("RoutesFor(phone, nas) = %v, want none", got)
+	}
+
+	servers, search := doc.DNSFor("phone", "home-gw")
+	if !slices.Equal(servers, []string{"192.168.1.1"}) || !slices.Equal(search, []string{"home.lan"}) {
+		t.Errorf("DNSFor() = %v, %v", servers, search)
+	}
+
+	for name, want := range map[string]string{"home-gw": "hub", "phone": "spoke", "nas": ""} {
+		if got := doc.RoleOf(name); got != want {
+			t.Errorf("RoleOf(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if got := none.RoleOf("phone"); got != "" {
+		t.Errorf("nil RoleOf() = %q, want none", got)
+	}
+}
@@ -51,6 +51,11 @@ type ClientConfig struct {
 	// like any other dial failure.
 	OnAuthFailure func() error
 
+	// OnReconnect is called after the connection was re-established and
+	// the join message re-sent, with the number of attempts it took. If
+	// nil, reconnects are only logged.
+	OnReconnect func(attempts int)
+
 	// Logger is the structured logger to use. If nil, slog.Default() is used.
 	Logger *slog.Logger
 
@@ -265,13 +270,29 @@ func (c *Client) dial(ctx context.Context) error {
 
 // sendJoin sends the initial join message on the current connection.
 func (c *Client) sendJoin(ctx context.Context) error {
-	return c.Send(ctx, &protocol.JoinMessage{
+	c.mu.Lock()
+	join := &protocol.JoinMessage{
 		PeerID:    c.cfg.PeerID,
 		PublicKey: c.cfg.PublicKey,
 		Address:   c.cfg.Address,
 		Routes:    c.cfg.Routes,
 		Metadata:  c.cfg.Metadata,
-	})
+	}
+	c.mu.Unlock()
+	return c.Send(ctx, join)
+}
+
+// Update replaces the routes and metadata announced to other peers. They
+// are sent to the server right away in an update message, and used in the
+// join message on every reconnect. If the client is disconnected the error
+// is returned, but the next reconnect announces the new values anyway.
+func (c *Client) Update(ctx context.Context, routes []string, metadata map[string]string) error {
+	c.mu.Lock()
+	c.cfg.Routes = routes
+	c.cfg.Metadata = metadata
+	c.mu.Unlock()
+
+	return c.Send(ctx, &protocol.UpdateMessage{Routes: routes, Metadata: metadata})
 }
 
 // closeConn closes the current WebSocket connection, if any.
@@ -477,6 +498,9 @@ func (c *Client) reconnect(ctx context.Context) bool {
 		}
 
 		c.log.Info("reconnected to signaling server", "attempt", attempt)
+		if c.cfg.OnReconnect != nil {
+			c.cfg.OnReconnect(attempt)
+		}
 		return true
 	}
 
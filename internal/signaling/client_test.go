@@ -261,6 +261,73 @@ func TestClient_PeerLeft(t *testing.T) {
 	}
 }
 
+func TestClient_Update(t *testing.T) {
+	t.Parallel()
+
+	_, wsURL := startTestHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clientA := NewClient(ClientConfig{
+		ServerURL: wsURL,
+		PeerID:    "peer-a",
+		PublicKey: "key-a",
+		Routes:    []string{"192.168.1.0/24"},
+	})
+	if err := clientA.Connect(ctx); err != nil {
+		t.Fatalf("clientA.Connect() error: %v", err)
+	}
+	defer clientA.Close()
+	receiveTimeout(t, clientA.Messages(), 2*time.Second) // drain peers
+
+	clientB := NewClient(ClientConfig{
+		ServerURL: wsURL,
+		PeerID:    "peer-b",
+		PublicKey: "key-b",
+	})
+	if err := clientB.Connect(ctx); err != nil {
+		t.Fatalf("clientB.Connect() error: %v", err)
+	}
+	defer clientB.Close()
+	receiveTimeout(t, clientB.Messages(), 2*time.Second) // drain peers list for B
+	receiveTimeout(t, clientA.Messages(), 2*time.Second) // drain B's join notification on A
+
+	routes := []string{"192.168.1.0/24", "192.168.20.0/24"}
+	if err := clientA.Update(ctx, routes, map[string]string{"routes": `["192.168.1.0/24","192.168.20.0/24"]`}); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+
+	msg := receiveTimeout(t, clientB.Messages(), 2*time.Second)
+	update, ok := msg.(*protocol.UpdateMessage)
+	if !ok {
+		t.Fatalf("expected *protocol.UpdateMessage, got %T", msg)
+	}
+	if update.PeerID != "peer-a" || len(update.Routes) != 2 || update.Metadata["routes"] == "" {
+		t.Errorf("update = %+v, want peer-a with 2 routes and metadata", update)
+	}
+	expectNoMessage(t, clientA.Messages(), 200*time.Millisecond)
+
+	// Peers joining later see the updated routes.
+	clientC := NewClient(ClientConfig{
+		ServerURL: wsURL,
+		PeerID:    "peer-c",
+		PublicKey: "key-c",
+	})
+	if err := clientC.Connect(ctx); err != nil {
+		t.Fatalf("clientC.Connect() error: %v", err)
+	}
+	defer clientC.Close()
+	peers, ok := receiveTimeout(t, clientC.Messages(), 2*time.Second).(*protocol.PeersMessage)
+	if !ok {
+		t.Fatal("expected *protocol.PeersMessage")
+	}
+	for _, p := range peers.Peers {
+		if p.PeerID == "peer-a" && len(p.Routes) != 2 {
+			t.Errorf("peer-a routes in peers list = %v, want %v", p.Routes, routes)
+		}
+	}
+}
+
 func TestClient_Reconnect(t *testing.T) {
 	t.Parallel()
 
@@ -313,6 +380,40 @@ func TestClient_Reconnect(t *testing.T) {
 	}
 }
 
+func TestClient_ForceReconnect_OnReconnect(t *testing.T) {
+	t.Parallel()
+
+	_, wsURL := startTestHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reconnects := make(chan int, 1)
+	client := NewClient(ClientConfig{
+		ServerURL: wsURL,
+		PeerID:    "peer-a",
+		PublicKey: "key-a",
+		Reconnect: ReconnectConfig{Enabled: true, MaxAttempts: 3},
+		OnReconnect: func(attempts int) {
+			reconnects <- attempts
+		},
+	})
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	defer client.Close()
+	receiveTimeout(t, client.Messages(), 2*time.Second)
+
+	client.ForceReconnect()
+	select {
+	case attempts := <-reconnects:
+		if attempts != 1 {
+			t.Errorf("OnReconnect attempts = %d, want 1", attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReconnect")
+	}
+}
+
 func TestClient_ContextCancellation(t *testing.T) {
 	t.Parallel()
 
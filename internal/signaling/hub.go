@@ -25,6 +25,11 @@ type Hub struct {
 	log    *slog.Logger
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	// policy is the network-wide policy pushed to peers, nil if unset.
+	policy        json.RawMessage
+	policyVersion int
+	policyPath    string // where updates are saved, "" for memory only
 }
 
 type hubPeer struct {
@@ -61,9 +66,15 @@ func (h *Hub) Close() {
 	h.cancel()
 }
 
-// ServeHTTP implements http.Handler. Each request is expected to be a
-// WebSocket upgrade. The first message must be a JoinMessage.
+// ServeHTTP implements http.Handler. Requests for /policy read or replace
+// the network policy; every other request is expected to be a WebSocket
+// upgrade, whose first message must be a JoinMessage.
 func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/policy" {
+		h.servePolicy(w, r)
+		return
+	}
+
 	c, err := websocket.Accept(w, r, nil)
 	if err != nil {
 		h.log.Warn("WebSocket accept failed", "error", err)
@@ -104,6 +115,17 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	h.log.Info("peer joined", "peer_id", peer.id)
 
+	// Send the network policy first so the peer applies it to the
+	// connections it is about to open.
+	h.mu.Lock()
+	policyMsg := h.policyMessage()
+	h.mu.Unlock()
+	if policyMsg != nil {
+		if pData, mErr := protocol.Marshal(policyMsg); mErr == nil {
+			_ = c.Write(ctx, websocket.MessageText, pData)
+		}
+	}
+
 	// Send the current peers list to the new peer.
 	h.mu.Lock()
 	var peerInfos []protocol.PeerInfo
@@ -193,6 +215,38 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			} else {
 				h.log.Debug("target peer not found", "type", env.Type, "to", env.To)
 			}
+		case "update":
+			h.updatePeer(ctx, peer, data)
+		}
+	}
+}
+
+// updatePeer stores the routes and metadata from a peer's update message,
+// so peers joining later see them in the peers list, and relays the update
+// to every other peer.
+func (h *Hub) updatePeer(ctx context.Context, peer *hubPeer, data []byte) {
+	msg, err := protocol.Unmarshal(data)
+	if err != nil {
+		h.log.Warn("malformed update message", "peer_id", peer.id, "error", err)
+		return
+	}
+	update := msg.(*protocol.UpdateMessage)
+	update.PeerID = peer.id
+
+	relay, err := protocol.Marshal(update)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peer.routes = update.Routes
+	peer.metadata = update.Metadata
+	h.log.Info("peer updated", "peer_id", peer.id, "routes", peer.routes)
+	for _, p := range h.peers {
+		if p.id == peer.id {
+			continue
 		}
+		_ = p.conn.Write(ctx, websocket.MessageText, relay)
 	}
 }
@@ -0,0 +1,132 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/coder/websocket"
+
+	"github.com/kuuji/bamgate/internal/policy"
+	"github.com/kuuji/bamgate/pkg/protocol"
+)
+
+// maxPolicySize bounds PUT /policy request bodies.
+const maxPolicySize = 1 << 20
+
+// LoadPolicy reads the network policy from path, if the file exists, and
+// saves later updates back to it. Without a policy file, updates are kept
+// in memory only and lost when the hub exits.
+func (h *Hub) LoadPolicy(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policyPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading policy file: %w", err)
+	}
+	var stored policy.Stored
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("parsing policy file %s: %w", path, err)
+	}
+	if string(stored.Policy) == "null" {
+		stored.Policy = nil
+	}
+	h.policy, h.policyVersion = stored.Policy, stored.Version
+	return nil
+}
+
+// policyMessage returns the message announcing the current policy, or nil
+// if none was ever set. h.mu must be held.
+func (h *Hub) policyMessage() *protocol.PolicyMessage {
+	if h.policyVersion == 0 {
+		return nil
+	}
+	return &protocol.PolicyMessage{Version: h.policyVersion, Policy: h.policy}
+}
+
+// servePolicy handles GET and PUT /policy. The hub does not authenticate
+// peers, so neither does this; it is meant for trusted networks.
+func (h *Hub) servePolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.mu.Lock()
+		stored := policy.Stored{Version: h.policyVersion, Policy: h.policy}
+		h.mu.Unlock()
+		writeJSON(w, http.StatusOK, stored)
+
+	case http.MethodPut:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicySize))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reading body"})
+			return
+		}
+		doc, err := policy.Parse(data)
+		if err == nil {
+			err = doc.Validate()
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		version, err := h.setPolicy(doc)
+		if err != nil {
+			h.log.Error("saving policy", "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "saving policy"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"version": version})
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// setPolicy stores a new policy and pushes it to every connected peer.
+func (h *Hub) setPolicy(doc *policy.Document) (int, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling policy: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	version := h.policyVersion + 1
+	if h.policyPath != "" {
+		data, err := json.Marshal(policy.Stored{Version: version, Policy: raw})
+		if err != nil {
+			return 0, fmt.Errorf("marshaling policy file: %w", err)
+		}
+		if err := os.WriteFile(h.policyPath, data, 0o600); err != nil {
+			return 0, fmt.Errorf("writing policy file: %w", err)
+		}
+	}
+	h.policy, h.policyVersion = raw, version
+	h.log.Info("network policy updated", "version", version)
+
+	data, err := protocol.Marshal(h.policyMessage())
+	if err != nil {
+		return version, nil
+	}
+	for _, p := range h.peers {
+		_ = p.conn.Write(context.Background(), websocket.MessageText, data)
+	}
+	return version, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
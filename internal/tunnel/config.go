@@ -3,7 +3,9 @@ package tunnel
 import (
 	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kuuji/bamgate/internal/config"
 )
@@ -100,3 +102,47 @@ func BuildPeerUAPIConfig(peer PeerConfig) string {
 func BuildRemovePeerUAPIConfig(publicKey config.Key) string {
 	return fmt.Sprintf("public_key=%s\nremove=true\n", hexKey(publicKey))
 }
+
+// ParseLastHandshakes extracts each peer's most recent handshake time from
+// the output of wireguard-go's Device.IpcGet. Peers that have never
+// completed a handshake map to the zero time.
+func ParseLastHandshakes(uapi string) (map[config.Key]time.Time, error) {
+	handshakes := make(map[config.Key]time.Time)
+
+	var (
+		current config.Key
+		inPeer  bool
+		sec     int64
+	)
+	for line := range strings.Lines(uapi) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "public_key":
+			b, err := hex.DecodeString(value)
+			if err != nil || len(b) != config.KeySize {
+				return nil, fmt.Errorf("invalid peer public key %q", value)
+			}
+			current = config.Key(b)
+			inPeer = true
+			handshakes[current] = time.Time{}
+		case "last_handshake_time_sec":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid handshake time %q: %w", value, err)
+			}
+			sec = n
+		case "last_handshake_time_nsec":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid handshake time %q: %w", value, err)
+			}
+			if inPeer && (sec != 0 || n != 0) {
+				handshakes[current] = time.Unix(sec, n)
+			}
+		}
+	}
+	return handshakes, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kuuji/bamgate/internal/config"
 )
@@ -213,3 +214,39 @@ func assertContains(t *testing.T, s, substr string) {
 		t.Errorf("expected string to contain %q, got:\n%s", substr, s)
 	}
 }
+
+func TestParseLastHandshakes(t *testing.T) {
+	t.Parallel()
+
+	var k1, k2 config.Key
+	k1[0] = 0x01
+	k2[0] = 0x02
+
+	uapi := "private_key=" + hexKey(config.Key{}) + "\n" +
+		"public_key=" + hexKey(k1) + "\n" +
+		"allowed_ip=10.0.0.2/32\n" +
+		"last_handshake_time_sec=1700000000\n" +
+		"last_handshake_time_nsec=500\n" +
+		"public_key=" + hexKey(k2) + "\n" +
+		"last_handshake_time_sec=0\n" +
+		"last_handshake_time_nsec=0\n" +
+		"errno=0\n"
+
+	got, err := ParseLastHandshakes(uapi)
+	if err != nil {
+		t.Fatalf("ParseLastHandshakes() error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d peers, want 2", len(got))
+	}
+	if want := time.Unix(1700000000, 500); !got[k1].Equal(want) {
+		t.Errorf("handshake for k1 = %v, want %v", got[k1], want)
+	}
+	if !got[k2].IsZero() {
+		t.Errorf("handshake for k2 = %v, want zero", got[k2])
+	}
+
+	if _, err := ParseLastHandshakes("public_key=nothex\n"); err == nil {
+		t.Error("ParseLastHandshakes() accepted an invalid public key")
+	}
+}
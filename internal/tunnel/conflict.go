@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// RouteConflictPolicy selects what the agent does when a route accepted from
+// a peer overlaps a subnet the host already reaches locally — for example a
+// remote home LAN of 192.168.1.0/24 accepted while on café Wi-Fi that uses
+// the same range.
+type RouteConflictPolicy string
+
+const (
+	// RouteConflictWarn installs the route anyway and logs a warning. This
+	// is the default and matches the behavior before conflicts were
+	// detected.
+	RouteConflictWarn RouteConflictPolicy = "warn"
+
+	// RouteConflictRefuse skips the conflicting route entirely.
+	RouteConflictRefuse RouteConflictPolicy = "refuse"
+
+	// RouteConflictMoreSpecific installs only the parts of the route that
+	// do not overlap local subnets, split into more-specific prefixes. Local
+	// destinations keep working and the rest of the remote network is still
+	// reachable through the tunnel. A route entirely covered by a local
+	// subnet is skipped.
+	RouteConflictMoreSpecific RouteConflictPolicy = "more-specific"
+)
+
+// ParseRouteConflictPolicy validates a route_conflict_policy config value.
+// An empty string is treated as RouteConflictWarn.
+func ParseRouteConflictPolicy(s string) (RouteConflictPolicy, error) {
+	switch p := RouteConflictPolicy(s); p {
+	case "", RouteConflictWarn:
+		return RouteConflictWarn, nil
+	case RouteConflictRefuse, RouteConflictMoreSpecific:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown route conflict policy %q (want warn, refuse, or more-specific)", s)
+	}
+}
+
+// FindRouteConflicts returns the local subnets that overlap route. Two
+// prefixes overlap when either contains the other. Invalid CIDRs never
+// conflict.
+func FindRouteConflicts(route string, local []SubnetInfo) []SubnetInfo {
+	p, err := netip.ParsePrefix(route)
+	if err != nil {
+		return nil
+	}
+	p = p.Masked()
+
+	var conflicts []SubnetInfo
+	for _, l := range local {
+		lp, err := netip.ParsePrefix(l.CIDR)
+		if err != nil {
+			continue
+		}
+		if p.Overlaps(lp.Masked()) {
+			conflicts = append(conflicts, l)
+		}
+	}
+	return conflicts
+}
+
+// ExcludeSubnets returns the smallest set of prefixes that covers route
+// minus every subnet in exclude. For example, excluding 192.168.1.0/24 from
+// 192.168.0.0/22 yields 192.168.0.0/24 and 192.168.2.0/23. The result is
+// empty when an excluded subnet covers the whole route.
+func ExcludeSubnets(route string, exclude []string) ([]string, error) {
+	p, err := netip.ParsePrefix(route)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CIDR %q: %w", route, err)
+	}
+
+	var ex []netip.Prefix
+	for _, c := range exclude {
+		e, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("parsing CIDR %q: %w", c, err)
+		}
+		if e.Addr().Is4() == p.Addr().Is4() {
+			ex = append(ex, e.Masked())
+		}
+	}
+
+	var out []string
+	for _, r := range excludePrefixes(p.Masked(), ex) {
+		out = append(out, r.String())
+	}
+	return out, nil
+}
+
+// excludePrefixes recursively halves p until each half is either disjoint
+// from every excluded prefix (kept) or fully covered by one (dropped).
+func excludePrefixes(p netip.Prefix, exclude []netip.Prefix) []netip.Prefix {
+	overlaps := false
+	for _, e := range exclude {
+		if e.Bits() <= p.Bits() && e.Contains(p.Addr()) {
+			return nil // p lies entirely inside an excluded prefix
+		}
+		if p.Overlaps(e) {
+			overlaps = true
+		}
+	}
+	if !overlaps {
+		return []netip.Prefix{p}
+	}
+
+	// An excluded prefix lies strictly inside p, so p cannot be a host
+	// prefix and both halves are valid.
+	lo := netip.PrefixFrom(p.Addr(), p.Bits()+1)
+	hi := netip.PrefixFrom(lastAddr(lo).Next(), p.Bits()+1)
+	return append(excludePrefixes(lo, exclude), excludePrefixes(hi, exclude)...)
+}
+
+// lastAddr returns the highest address in the masked prefix p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
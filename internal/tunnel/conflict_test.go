@@ -0,0 +1,112 @@
+package tunnel
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRouteConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    RouteConflictPolicy
+		wantErr bool
+	}{
+		{"", RouteConflictWarn, false},
+		{"warn", RouteConflictWarn, false},
+		{"refuse", RouteConflictRefuse, false},
+		{"more-specific", RouteConflictMoreSpecific, false},
+		{"ignore", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseRouteConflictPolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRouteConflictPolicy(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRouteConflictPolicy(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindRouteConflicts(t *testing.T) {
+	t.Parallel()
+
+	local := []SubnetInfo{
+		{CIDR: "192.168.1.0/24", Interface: "wlan0"},
+		{CIDR: "172.17.0.0/16", Interface: "docker0"},
+		{CIDR: "fd00:1::/64", Interface: "wlan0"},
+	}
+
+	tests := []struct {
+		route string
+		want  []string
+	}{
+		{"192.168.1.0/24", []string{"192.168.1.0/24"}},   // identical
+		{"192.168.0.0/16", []string{"192.168.1.0/24"}},   // remote is wider
+		{"192.168.1.128/25", []string{"192.168.1.0/24"}}, // remote is narrower
+		{"192.168.2.0/24", nil},
+		{"172.17.5.0/24", []string{"172.17.0.0/16"}},
+		{"fd00::/16", []string{"fd00:1::/64"}},
+		{"fd00:2::/64", nil},
+		{"not-a-cidr", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, c := range FindRouteConflicts(tt.route, local) {
+				got = append(got, c.CIDR)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindRouteConflicts(%q) = %v, want %v", tt.route, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcludeSubnets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		route   string
+		exclude []string
+		want    []string
+	}{
+		{"no overlap", "10.1.0.0/16", []string{"192.168.1.0/24"}, []string{"10.1.0.0/16"}},
+		{"identical", "192.168.1.0/24", []string{"192.168.1.0/24"}, nil},
+		{"covered by wider local", "192.168.1.128/25", []string{"192.168.1.0/24"}, nil},
+		{
+			"carve one subnet", "192.168.0.0/22", []string{"192.168.1.0/24"},
+			[]string{"192.168.0.0/24", "192.168.2.0/23"},
+		},
+		{
+			"carve two subnets", "10.0.0.0/24", []string{"10.0.0.0/26", "10.0.0.192/26"},
+			[]string{"10.0.0.64/26", "10.0.0.128/26"},
+		},
+		{
+			"ipv6", "fd00::/62", []string{"fd00:0:0:1::/64", "192.168.1.0/24"},
+			[]string{"fd00::/64", "fd00:0:0:2::/63"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ExcludeSubnets(tt.route, tt.exclude)
+			if err != nil {
+				t.Fatalf("ExcludeSubnets() error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ExcludeSubnets(%q, %v) = %v, want %v", tt.route, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package tunnel
 import (
 	"fmt"
 	"log/slog"
+	"time"
 
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/device"
@@ -86,6 +87,16 @@ func (d *Device) RemovePeer(publicKey config.Key) error {
 	return nil
 }
 
+// LastHandshakes returns the time of each peer's most recent completed
+// handshake, keyed by public key. Peers without one map to the zero time.
+func (d *Device) LastHandshakes() (map[config.Key]time.Time, error) {
+	uapi, err := d.wgDev.IpcGet()
+	if err != nil {
+		return nil, fmt.Errorf("reading WireGuard state: %w", err)
+	}
+	return ParseLastHandshakes(uapi)
+}
+
 // Wait returns a channel that is closed when the WireGuard device shuts down.
 func (d *Device) Wait() chan struct{} {
 	return d.wgDev.Wait()
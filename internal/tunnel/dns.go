@@ -0,0 +1,44 @@
+package tunnel
+
+import "fmt"
+
+// DNSBackend selects the mechanism used to apply per-interface DNS servers
+// and search domains accepted from peers. Only Linux has more than one
+// backend; macOS always uses /etc/resolver and Android leaves DNS to
+// VpnService.Builder.
+type DNSBackend string
+
+const (
+	// DNSBackendAuto picks the best available backend at runtime:
+	// systemd-resolved, then NetworkManager, then resolvconf(8), then the
+	// direct-file fallback.
+	DNSBackendAuto DNSBackend = "auto"
+
+	// DNSBackendResolvectl configures systemd-resolved via resolvectl.
+	DNSBackendResolvectl DNSBackend = "resolvectl"
+
+	// DNSBackendResolvconf registers a record for the interface with
+	// resolvconf(8) (openresolv or Debian resolvconf).
+	DNSBackendResolvconf DNSBackend = "resolvconf"
+
+	// DNSBackendNetworkManager applies runtime DNS settings to the
+	// interface via nmcli.
+	DNSBackendNetworkManager DNSBackend = "networkmanager"
+
+	// DNSBackendFile edits /etc/resolv.conf directly. The original file is
+	// backed up and restored on revert, or on the next start after a crash.
+	DNSBackendFile DNSBackend = "file"
+)
+
+// ParseDNSBackend validates a dns_backend config value. An empty string is
+// treated as DNSBackendAuto.
+func ParseDNSBackend(s string) (DNSBackend, error) {
+	switch b := DNSBackend(s); b {
+	case "", DNSBackendAuto:
+		return DNSBackendAuto, nil
+	case DNSBackendResolvectl, DNSBackendResolvconf, DNSBackendNetworkManager, DNSBackendFile:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unknown DNS backend %q (want auto, resolvectl, resolvconf, networkmanager, or file)", s)
+	}
+}
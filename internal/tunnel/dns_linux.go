@@ -0,0 +1,466 @@
+//go:build linux && !android
+
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// resolvConfPath is the system resolver configuration edited by the
+	// direct-file backend.
+	resolvConfPath = "/etc/resolv.conf"
+
+	// resolvConfBackupPath holds the pre-bamgate contents of resolv.conf
+	// while the managed block is in place. It lives next to the original
+	// (rather than under /run) so it survives a crash followed by a reboot.
+	resolvConfBackupPath = "/etc/resolv.conf.bamgate"
+
+	// Markers delimiting the block bamgate owns inside resolv.conf.
+	managedBlockBegin = "# BEGIN bamgate managed block - do not edit"
+	managedBlockEnd   = "# END bamgate managed block"
+
+	// legacyBlockMarker starts the lines releases before the managed
+	// block prepended to resolv.conf, with no end marker: nameserver
+	// lines and an optional search line.
+	legacyBlockMarker = "# Added by bamgate"
+
+	// disabledLinePrefix comments out original search/domain lines while
+	// the managed block is active. The resolver only honours the last
+	// search line, so leaving them in place would shadow ours.
+	disabledLinePrefix = "# bamgate disabled: "
+)
+
+// dnsConfigurator applies and removes DNS settings for one interface.
+type dnsConfigurator interface {
+	set(ifName string, servers []string, searchDomains []string) error
+	revert(ifName string) error
+}
+
+// SetDNS configures DNS servers and search domains for ifName using the
+// given backend. DNSBackendAuto detects the best backend available on this
+// host. Calling SetDNS again replaces the previous settings.
+func SetDNS(ifName string, backend DNSBackend, servers []string, searchDomains []string) error {
+	if len(servers) == 0 && len(searchDomains) == 0 {
+		return nil
+	}
+	return dnsConfiguratorFor(ResolveDNSBackend(backend)).set(ifName, servers, searchDomains)
+}
+
+// RevertDNS removes DNS configuration set by SetDNS.
+func RevertDNS(ifName string, backend DNSBackend) error {
+	return dnsConfiguratorFor(ResolveDNSBackend(backend)).revert(ifName)
+}
+
+// RecoverDNS undoes DNS changes left behind by a previous run that did not
+// shut down cleanly. It restores /etc/resolv.conf if it still carries a
+// bamgate managed block, removes nameserver lines prepended by releases
+// before the managed block, and drops any stale resolvconf(8) record for
+// ifName. It is safe to call when there is nothing to recover.
+func RecoverDNS(ifName string, backend DNSBackend) error {
+	f := defaultFileDNS()
+	if err := f.restore(); err != nil {
+		return err
+	}
+	if err := f.stripLegacy(); err != nil {
+		return err
+	}
+	if ResolveDNSBackend(backend) == DNSBackendResolvconf {
+		// Best-effort: the record may legitimately not exist.
+		_ = exec.Command("resolvconf", "-d", ifName).Run()
+	}
+	return nil
+}
+
+// ResolveDNSBackend maps DNSBackendAuto (or an empty value) to the backend
+// that would be used on this host. Explicit backends are returned as-is.
+func ResolveDNSBackend(backend DNSBackend) DNSBackend {
+	if backend != "" && backend != DNSBackendAuto {
+		return backend
+	}
+	return probeDNSEnvironment().choose()
+}
+
+func dnsConfiguratorFor(backend DNSBackend) dnsConfigurator {
+	switch backend {
+	case DNSBackendResolvectl:
+		return resolvectlDNS{}
+	case DNSBackendResolvconf:
+		return resolvconfDNS{}
+	case DNSBackendNetworkManager:
+		return networkManagerDNS{}
+	default:
+		return defaultFileDNS()
+	}
+}
+
+// dnsEnvironment records which DNS management stacks are active on the host.
+type dnsEnvironment struct {
+	// resolved is true when systemd-resolved is running and resolvectl is installed.
+	resolved bool
+
+	// networkManager is true when NetworkManager is running, nmcli is
+	// installed, and NetworkManager generated /etc/resolv.conf.
+	networkManager bool
+
+	// resolvconf is true when a real resolvconf(8) is installed (not the
+	// compatibility symlink to resolvectl).
+	resolvconf bool
+}
+
+// choose returns the preferred backend for the environment. Backends that
+// keep per-interface state are preferred over editing resolv.conf directly.
+func (e dnsEnvironment) choose() DNSBackend {
+	switch {
+	case e.resolved:
+		return DNSBackendResolvectl
+	case e.networkManager:
+		return DNSBackendNetworkManager
+	case e.resolvconf:
+		return DNSBackendResolvconf
+	default:
+		return DNSBackendFile
+	}
+}
+
+// probeDNSEnvironment inspects the host to fill in a dnsEnvironment.
+func probeDNSEnvironment() dnsEnvironment {
+	var env dnsEnvironment
+
+	if _, err := exec.LookPath("resolvectl"); err == nil {
+		if _, err := os.Stat("/run/systemd/resolve"); err == nil {
+			env.resolved = true
+		}
+	}
+
+	if _, err := exec.LookPath("nmcli"); err == nil {
+		if _, err := os.Stat("/run/NetworkManager"); err == nil {
+			data, _ := os.ReadFile(resolvConfPath)
+			env.networkManager = bytes.Contains(data, []byte("# Generated by NetworkManager"))
+		}
+	}
+
+	if path, err := exec.LookPath("resolvconf"); err == nil {
+		if real, err := filepath.EvalSymlinks(path); err == nil && filepath.Base(real) != "resolvectl" {
+			env.resolvconf = true
+		}
+	}
+
+	return env
+}
+
+// --- systemd-resolved ---
+
+// resolvectlDNS sets per-interface DNS through systemd-resolved, leaving
+// system-wide DNS unaffected.
+type resolvectlDNS struct{}
+
+func (resolvectlDNS) set(ifName string, servers []string, searchDomains []string) error {
+	if len(servers) > 0 {
+		args := append([]string{"dns", ifName}, servers...)
+		if err := runCommand("resolvectl", args...); err != nil {
+			return err
+		}
+	}
+	if len(searchDomains) > 0 {
+		args := append([]string{"domain", ifName}, searchDomains...)
+		if err := runCommand("resolvectl", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (resolvectlDNS) revert(ifName string) error {
+	return runCommand("resolvectl", "revert", ifName)
+}
+
+// --- resolvconf(8) ---
+
+// resolvconfDNS registers an interface record with resolvconf(8), which
+// merges it into /etc/resolv.conf alongside records from other sources.
+type resolvconfDNS struct{}
+
+func (resolvconfDNS) set(ifName string, servers []string, searchDomains []string) error {
+	var buf strings.Builder
+	for _, s := range servers {
+		buf.WriteString("nameserver " + s + "\n")
+	}
+	if len(searchDomains) > 0 {
+		buf.WriteString("search " + strings.Join(searchDomains, " ") + "\n")
+	}
+
+	cmd := exec.Command("resolvconf", "-a", ifName)
+	cmd.Stdin = strings.NewReader(buf.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("resolvconf -a %s: %w (output: %s)",
+			ifName, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (resolvconfDNS) revert(ifName string) error {
+	return runCommand("resolvconf", "-d", ifName)
+}
+
+// --- NetworkManager ---
+
+// networkManagerDNS applies runtime-only DNS settings to the interface with
+// `nmcli device modify`. Nothing is written to NetworkManager's connection
+// profiles, so the settings disappear with the interface.
+type networkManagerDNS struct{}
+
+func (networkManagerDNS) set(ifName string, servers []string, searchDomains []string) error {
+	return runCommand("nmcli", nmcliModifyArgs(ifName, servers, searchDomains)...)
+}
+
+func (networkManagerDNS) revert(ifName string) error {
+	return runCommand("nmcli", nmcliModifyArgs(ifName, nil, nil)...)
+}
+
+// nmcliModifyArgs builds the nmcli arguments that set (or, with empty
+// slices, clear) DNS on ifName. Servers are split by address family since
+// NetworkManager keeps ipv4.dns and ipv6.dns separately.
+func nmcliModifyArgs(ifName string, servers []string, searchDomains []string) []string {
+	var v4, v6 []string
+	for _, s := range servers {
+		if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+			v6 = append(v6, s)
+		} else {
+			v4 = append(v4, s)
+		}
+	}
+	return []string{
+		"device", "modify", ifName,
+		"ipv4.dns", strings.Join(v4, ","),
+		"ipv6.dns", strings.Join(v6, ","),
+		"ipv4.dns-search", strings.Join(searchDomains, ","),
+	}
+}
+
+// --- Direct file ---
+
+// fileDNS edits resolv.conf directly for hosts with no resolver manager.
+// The original file is saved to backupPath before the first change and put
+// back on revert. The managed block is also self-describing, so the file
+// can be repaired even if the backup is lost.
+type fileDNS struct {
+	path       string
+	backupPath string
+}
+
+func defaultFileDNS() fileDNS {
+	return fileDNS{path: resolvConfPath, backupPath: resolvConfBackupPath}
+}
+
+func (f fileDNS) set(_ string, servers []string, searchDomains []string) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading %s: %w", f.path, err)
+	}
+	// Lines left by older releases go now, so the backup never keeps them.
+	current := stripLegacyBlocks(string(data))
+
+	// Only back up once. Later calls (another peer's DNS) must not capture
+	// our own managed block as the "original".
+	if _, err := os.Stat(f.backupPath); errors.Is(err, fs.ErrNotExist) {
+		original := stripManagedBlock(current)
+		if err := os.WriteFile(f.backupPath, []byte(original), 0644); err != nil {
+			return fmt.Errorf("backing up %s: %w", f.path, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("checking %s: %w", f.backupPath, err)
+	}
+
+	content := buildManagedResolvConf(current, servers, searchDomains)
+
+	// Write in place rather than rename so a symlinked resolv.conf stays a symlink.
+	if err := os.WriteFile(f.path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", f.path, err)
+	}
+	return nil
+}
+
+func (f fileDNS) revert(_ string) error {
+	return f.restore()
+}
+
+// restore puts back the original resolv.conf if it still contains our
+// managed block. If something else (a DHCP client, an administrator) has
+// rewritten the file since, that version wins and the backup is discarded.
+func (f fileDNS) restore() error {
+	current, err := os.ReadFile(f.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading %s: %w", f.path, err)
+	}
+
+	if hasManagedBlock(string(current)) {
+		restored, err := os.ReadFile(f.backupPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			restored = []byte(stripManagedBlock(string(current)))
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %w", f.backupPath, err)
+		}
+		if err := os.WriteFile(f.path, restored, 0644); err != nil {
+			return fmt.Errorf("restoring %s: %w", f.path, err)
+		}
+	}
+
+	if err := os.Remove(f.backupPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing %s: %w", f.backupPath, err)
+	}
+	return nil
+}
+
+// stripLegacy removes the lines older releases prepended to resolv.conf
+// (see stripLegacyBlocks).
+func (f fileDNS) stripLegacy() error {
+	current, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", f.path, err)
+	}
+	stripped := stripLegacyBlocks(string(current))
+	if stripped == string(current) {
+		return nil
+	}
+	if err := os.WriteFile(f.path, []byte(stripped), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// buildManagedResolvConf returns resolv.conf contents with a bamgate managed
+// block at the top. Any existing managed block is replaced. When search
+// domains are given, the original search/domain lines are disabled and their
+// domains appended to ours so they keep working.
+func buildManagedResolvConf(current string, servers []string, searchDomains []string) string {
+	original := stripManagedBlock(current)
+
+	var rest []string
+	search := append([]string(nil), searchDomains...)
+	for _, line := range splitLines(original) {
+		fields := strings.Fields(line)
+		if len(searchDomains) > 0 && len(fields) > 0 && (fields[0] == "search" || fields[0] == "domain") {
+			search = appendUnique(search, fields[1:]...)
+			rest = append(rest, disabledLinePrefix+line)
+			continue
+		}
+		rest = append(rest, line)
+	}
+
+	lines := []string{managedBlockBegin}
+	for _, s := range servers {
+		lines = append(lines, "nameserver "+s)
+	}
+	if len(search) > 0 {
+		lines = append(lines, "search "+strings.Join(search, " "))
+	}
+	lines = append(lines, managedBlockEnd)
+	lines = append(lines, rest...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// stripManagedBlock removes the bamgate managed block and re-enables any
+// lines it disabled, yielding the original resolv.conf contents.
+func stripManagedBlock(content string) string {
+	if !hasManagedBlock(content) {
+		return content
+	}
+
+	var out []string
+	inBlock := false
+	for _, line := range splitLines(content) {
+		switch {
+		case line == managedBlockBegin:
+			inBlock = true
+		case line == managedBlockEnd:
+			inBlock = false
+		case inBlock:
+		case strings.HasPrefix(line, disabledLinePrefix):
+			out = append(out, strings.TrimPrefix(line, disabledLinePrefix))
+		default:
+			out = append(out, line)
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// stripLegacyBlocks removes each block older releases prepended to
+// resolv.conf: the legacy marker followed by nameserver lines and, ending
+// it, at most one search line. Without a search line the block cannot be
+// told from nameserver lines at the very top of the original file; those
+// are rare, as generated files start with a comment.
+func stripLegacyBlocks(content string) string {
+	if !strings.Contains(content, legacyBlockMarker) {
+		return content
+	}
+
+	var out []string
+	inBlock := false
+	for _, line := range splitLines(content) {
+		switch {
+		case line == legacyBlockMarker:
+			inBlock = true
+			continue
+		case inBlock && strings.HasPrefix(line, "nameserver "):
+			continue
+		case inBlock && strings.HasPrefix(line, "search "):
+			inBlock = false
+			continue
+		}
+		inBlock = false
+		out = append(out, line)
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// hasManagedBlock reports whether content contains a bamgate managed block.
+func hasManagedBlock(content string) bool {
+	return strings.Contains(content, managedBlockBegin)
+}
+
+// splitLines splits content into lines without a trailing empty element.
+func splitLines(content string) []string {
+	content = strings.TrimSuffix(content, "\n")
+	if content == "" {
+		return nil
+	}
+	return strings.Split(content, "\n")
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// runCommand runs name with args and wraps failures with the command output.
+func runCommand(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w (output: %s)",
+			name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+//go:build linux && !android
+
+package tunnel
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testResolvConf = `# Generated by dhcpcd
+search home.lan
+nameserver 192.168.1.1
+options edns0
+`
+
+func newTestFileDNS(t *testing.T, content string) fileDNS {
+	t.Helper()
+	dir := t.TempDir()
+	f := fileDNS{
+		path:       filepath.Join(dir, "resolv.conf"),
+		backupPath: filepath.Join(dir, "resolv.conf.bamgate"),
+	}
+	if err := os.WriteFile(f.path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing resolv.conf: %v", err)
+	}
+	return f
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestBuildManagedResolvConf(t *testing.T) {
+	t.Parallel()
+
+	got := buildManagedResolvConf(testResolvConf, []string{"10.96.0.10"}, []string{"svc.cluster.local"})
+	want := managedBlockBegin + `
+nameserver 10.96.0.10
+search svc.cluster.local home.lan
+` + managedBlockEnd + `
+# Generated by dhcpcd
+` + disabledLinePrefix + `search home.lan
+nameserver 192.168.1.1
+options edns0
+`
+	if got != want {
+		t.Errorf("buildManagedResolvConf() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBuildManagedResolvConf_serversOnlyKeepsSearch(t *testing.T) {
+	t.Parallel()
+
+	got := buildManagedResolvConf(testResolvConf, []string{"10.96.0.10"}, nil)
+	if strings.Contains(got, disabledLinePrefix) {
+		t.Errorf("original search line disabled without our own search domains:\n%s", got)
+	}
+	if !strings.Contains(got, "\nsearch home.lan\n") {
+		t.Errorf("original search line missing:\n%s", got)
+	}
+}
+
+func TestBuildManagedResolvConf_replacesExistingBlock(t *testing.T) {
+	t.Parallel()
+
+	first := buildManagedResolvConf(testResolvConf, []string{"10.96.0.10"}, []string{"a.local"})
+	second := buildManagedResolvConf(first, []string{"10.0.0.53"}, []string{"b.local"})
+
+	if strings.Count(second, managedBlockBegin) != 1 {
+		t.Errorf("expected exactly one managed block:\n%s", second)
+	}
+	if strings.Contains(second, "10.96.0.10") || strings.Contains(second, "a.local") {
+		t.Errorf("stale managed entries survived:\n%s", second)
+	}
+	if stripManagedBlock(second) != testResolvConf {
+		t.Errorf("stripManagedBlock() did not restore original:\n%s", stripManagedBlock(second))
+	}
+}
+
+func TestStripManagedBlock_noBlock(t *testing.T) {
+	t.Parallel()
+
+	if got := stripManagedBlock(testResolvConf); got != testResolvConf {
+		t.Errorf("stripManagedBlock() modified unmanaged content:\n%s", got)
+	}
+}
+
+func TestStripLegacyBlocks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"none", testResolvConf, testResolvConf},
+		{"with search", "# Added by bamgate\nnameserver 10.96.0.10\nsearch svc.cluster.local\n" + testResolvConf, testResolvConf},
+		{"stacked", "# Added by bamgate\nnameserver 10.0.0.53\n# Added by bamgate\nnameserver 10.96.0.10\n" + testResolvConf, testResolvConf},
+		{"only block", "# Added by bamgate\nnameserver 10.96.0.10\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := stripLegacyBlocks(tt.content); got != tt.want {
+				t.Errorf("stripLegacyBlocks() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileDNS_setAndRevert(t *testing.T) {
+	t.Parallel()
+
+	f := newTestFileDNS(t, testResolvConf)
+
+	if err := f.set("bamgate0", []string{"10.96.0.10"}, []string{"svc.cluster.local"}); err != nil {
+		t.Fatalf("set() error: %v", err)
+	}
+	// A second peer's DNS must not overwrite the backup of the original.
+	if err := f.set("bamgate0", []string{"10.0.0.53"}, nil); err != nil {
+		t.Fatalf("second set() error: %v", err)
+	}
+
+	if got := readFile(t, f.backupPath); got != testResolvConf {
+		t.Errorf("backup =\n%s\nwant original:\n%s", got, testResolvConf)
+	}
+	if got := readFile(t, f.path); !hasManagedBlock(got) || !strings.Contains(got, "nameserver 10.0.0.53") {
+		t.Errorf("resolv.conf missing managed block:\n%s", got)
+	}
+
+	if err := f.revert("bamgate0"); err != nil {
+		t.Fatalf("revert() error: %v", err)
+	}
+	if got := readFile(t, f.path); got != testResolvConf {
+		t.Errorf("resolv.conf after revert =\n%s\nwant:\n%s", got, testResolvConf)
+	}
+	if _, err := os.Stat(f.backupPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("backup not removed after revert: %v", err)
+	}
+}
+
+func TestFileDNS_setStripsLegacyBlock(t *testing.T) {
+	t.Parallel()
+
+	f := newTestFileDNS(t, "# Added by bamgate\nnameserver 10.0.0.53\n"+testResolvConf)
+
+	if err := f.set("bamgate0", []string{"10.96.0.10"}, nil); err != nil {
+		t.Fatalf("set() error: %v", err)
+	}
+	if got := readFile(t, f.path); strings.Contains(got, "10.0.0.53") {
+		t.Errorf("resolv.conf kept legacy block:\n%s", got)
+	}
+	if err := f.revert("bamgate0"); err != nil {
+		t.Fatalf("revert() error: %v", err)
+	}
+	if got := readFile(t, f.path); got != testResolvConf {
+		t.Errorf("resolv.conf after revert =\n%s\nwant:\n%s", got, testResolvConf)
+	}
+}
+
+func TestFileDNS_restoreWithoutBackup(t *testing.T) {
+	t.Parallel()
+
+	// Simulates a crash followed by loss of the backup file: the managed
+	// block alone carries enough information to undo the change.
+	managed := buildManagedResolvConf(testResolvConf, []string{"10.96.0.10"}, []string{"svc.cluster.local"})
+	f := newTestFileDNS(t, managed)
+
+	if err := f.restore(); err != nil {
+		t.Fatalf("restore() error: %v", err)
+	}
+	if got := readFile(t, f.path); got != testResolvConf {
+		t.Errorf("resolv.conf after restore =\n%s\nwant:\n%s", got, testResolvConf)
+	}
+}
+
+func TestFileDNS_restoreKeepsForeignRewrite(t *testing.T) {
+	t.Parallel()
+
+	f := newTestFileDNS(t, testResolvConf)
+	if err := f.set("bamgate0", []string{"10.96.0.10"}, nil); err != nil {
+		t.Fatalf("set() error: %v", err)
+	}
+
+	// A DHCP client rewrites resolv.conf while bamgate is running.
+	const rewritten = "nameserver 192.168.50.1\n"
+	if err := os.WriteFile(f.path, []byte(rewritten), 0644); err != nil {
+		t.Fatalf("rewriting resolv.conf: %v", err)
+	}
+
+	if err := f.restore(); err != nil {
+		t.Fatalf("restore() error: %v", err)
+	}
+	if got := readFile(t, f.path); got != rewritten {
+		t.Errorf("restore() clobbered foreign rewrite:\n%s", got)
+	}
+	if _, err := os.Stat(f.backupPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stale backup not removed: %v", err)
+	}
+}
+
+func TestDNSEnvironment_choose(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		env  dnsEnvironment
+		want DNSBackend
+	}{
+		{"nothing", dnsEnvironment{}, DNSBackendFile},
+		{"resolvconf", dnsEnvironment{resolvconf: true}, DNSBackendResolvconf},
+		{"networkmanager", dnsEnvironment{networkManager: true, resolvconf: true}, DNSBackendNetworkManager},
+		{"resolved wins", dnsEnvironment{resolved: true, networkManager: true, resolvconf: true}, DNSBackendResolvectl},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.env.choose(); got != tt.want {
+				t.Errorf("choose() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNmcliModifyArgs(t *testing.T) {
+	t.Parallel()
+
+	got := nmcliModifyArgs("bamgate0", []string{"10.96.0.10", "fd00::53"}, []string{"a.local", "b.local"})
+	want := []string{
+		"device", "modify", "bamgate0",
+		"ipv4.dns", "10.96.0.10",
+		"ipv6.dns", "fd00::53",
+		"ipv4.dns-search", "a.local,b.local",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("nmcliModifyArgs() = %v, want %v", got, want)
+	}
+}
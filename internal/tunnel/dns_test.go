@@ -0,0 +1,34 @@
+package tunnel
+
+import "testing"
+
+func TestParseDNSBackend(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    DNSBackend
+		wantErr bool
+	}{
+		{"", DNSBackendAuto, false},
+		{"auto", DNSBackendAuto, false},
+		{"resolvectl", DNSBackendResolvectl, false},
+		{"resolvconf", DNSBackendResolvconf, false},
+		{"networkmanager", DNSBackendNetworkManager, false},
+		{"file", DNSBackendFile, false},
+		{"systemd", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseDNSBackend(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDNSBackend(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDNSBackend(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -22,29 +22,31 @@ var virtualPrefixes = []string{
 }
 
 // DiscoverLocalSubnets enumerates all network interfaces and returns the
-// IPv4 subnets that are likely to be real, physical/routable networks.
+// IPv4 and IPv6 subnets that are likely to be real, physical/routable networks.
 //
 // It filters out:
 //   - Loopback interfaces
 //   - Down interfaces
 //   - Link-local addresses (169.254.0.0/16, fe80::/10)
-//   - IPv6 addresses (VPN routes are IPv4-only for now)
+//   - Host routes (/32, /128)
 //   - Virtual/container interfaces (docker, veth, br-, virbr, etc.)
 //
-// The optional excludeCIDR parameter allows filtering out a specific subnet
-// (e.g. the WireGuard tunnel subnet that was just assigned).
-func DiscoverLocalSubnets(excludeCIDR string) ([]SubnetInfo, error) {
+// The optional excludeCIDRs allow filtering out specific subnets (e.g. the
+// WireGuard tunnel subnets that were just assigned). Empty strings are ignored.
+func DiscoverLocalSubnets(excludeCIDRs ...string) ([]SubnetInfo, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, fmt.Errorf("listing interfaces: %w", err)
 	}
 
-	var exclude *net.IPNet
-	if excludeCIDR != "" {
-		_, exclude, _ = net.ParseCIDR(excludeCIDR)
+	seen := make(map[string]bool)
+	for _, c := range excludeCIDRs {
+		if _, exclude, err := net.ParseCIDR(c); err == nil {
+			// Pre-marking excluded subnets as seen skips them below.
+			seen[exclude.String()] = true
+		}
 	}
 
-	seen := make(map[string]bool)
 	var results []SubnetInfo
 
 	for _, iface := range ifaces {
@@ -58,52 +60,103 @@ func DiscoverLocalSubnets(excludeCIDR string) ([]SubnetInfo, error) {
 		}
 
 		for _, addr := range addrs {
-			ip, ipNet, err := net.ParseCIDR(addr.String())
-			if err != nil {
+			cidr, ok := routableSubnet(addr.String())
+			if !ok {
 				continue
 			}
 
-			// IPv4 only for now.
-			if ip.To4() == nil {
+			// Deduplicate by CIDR (multiple IPs on same subnet).
+			if seen[cidr] {
 				continue
 			}
+			seen[cidr] = true
 
-			// Skip link-local (169.254.0.0/16).
-			if ip[0] == 169 && ip[1] == 254 {
-				continue
-			}
+			results = append(results, SubnetInfo{
+				CIDR:      cidr,
+				Interface: iface.Name,
+			})
+		}
+	}
 
-			// Compute the network address (mask the IP).
-			networkIP := ip.Mask(ipNet.Mask)
-			ones, bits := ipNet.Mask.Size()
-			cidr := fmt.Sprintf("%s/%d", networkIP, ones)
+	return results, nil
+}
 
-			// Skip host routes (/32).
-			if ones == bits {
-				continue
-			}
+// LocalRoutes returns the destinations this host already reaches without
+// the tunnel: the subnets of every up, non-loopback interface plus the
+// non-default entries of the kernel routing table. Unlike
+// DiscoverLocalSubnets, virtual interfaces (docker, libvirt, other VPNs)
+// are included because their subnets conflict with accepted routes just the
+// same. The interface named excludeIface (the bamgate TUN) and routes
+// through it are omitted.
+//
+// If the routing table cannot be read, the interface subnets are returned
+// along with the error.
+func LocalRoutes(excludeIface string) ([]SubnetInfo, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("listing interfaces: %w", err)
+	}
 
-			// Skip the excluded subnet (e.g. WireGuard tunnel).
-			if exclude != nil && exclude.String() == cidr {
-				continue
-			}
+	seen := make(map[string]bool)
+	var results []SubnetInfo
 
-			// Deduplicate by CIDR (multiple IPs on same subnet).
-			if seen[cidr] {
+	for _, iface := range ifaces {
+		if iface.Name == excludeIface || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			cidr, ok := routableSubnet(addr.String())
+			if !ok || seen[cidr] {
 				continue
 			}
 			seen[cidr] = true
+			results = append(results, SubnetInfo{CIDR: cidr, Interface: iface.Name})
+		}
+	}
 
-			results = append(results, SubnetInfo{
-				CIDR:      cidr,
-				Interface: iface.Name,
-			})
+	routes, err := kernelRoutes()
+	if err != nil {
+		return results, fmt.Errorf("reading routing table: %w", err)
+	}
+	for _, r := range routes {
+		if r.Interface == excludeIface || seen[r.CIDR] {
+			continue
 		}
+		seen[r.CIDR] = true
+		results = append(results, r)
 	}
 
 	return results, nil
 }
 
+// routableSubnet converts an interface address (e.g. "192.168.1.5/24") to
+// its network CIDR ("192.168.1.0/24"). It reports false for addresses that
+// are not worth advertising: link-local, multicast, and host routes.
+func routableSubnet(addr string) (string, bool) {
+	ip, ipNet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return "", false
+	}
+
+	// Skip link-local (169.254.0.0/16, fe80::/10) and multicast.
+	if ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsLoopback() {
+		return "", false
+	}
+
+	// Skip host routes (/32, /128).
+	ones, bits := ipNet.Mask.Size()
+	if ones == bits {
+		return "", false
+	}
+
+	// ipNet.String() is the masked network address, e.g. "192.168.1.0/24".
+	return ipNet.String(), true
+}
+
 // shouldSkipInterface returns true if the interface should be excluded from
 // subnet discovery (loopback, down, or virtual/container interface).
 func shouldSkipInterface(iface net.Interface) bool {
@@ -124,12 +177,21 @@ func shouldSkipInterface(iface net.Interface) bool {
 	return false
 }
 
+// subnetWithin reports whether subnet lies entirely inside outer.
+func subnetWithin(subnet, outer *net.IPNet) bool {
+	subOnes, subBits := subnet.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+	return subBits == outerBits && outerOnes <= subOnes && outer.Contains(subnet.IP)
+}
+
 // FindInterfaceForSubnet returns the name of the network interface that has an
 // IP address within the given CIDR subnet. This is used to determine which
 // interface to masquerade on when forwarding traffic for an advertised route.
 //
 // For example, if the subnet is "192.168.1.0/24" and the host has
-// 192.168.1.233 on "wlan0", this returns "wlan0".
+// 192.168.1.233 on "wlan0", this returns "wlan0". A subnet inside an
+// interface's network also matches, so "192.168.1.50/32" (a port forward
+// target) finds "wlan0" too.
 func FindInterfaceForSubnet(cidr string) (string, error) {
 	_, subnet, err := net.ParseCIDR(cidr)
 	if err != nil {
@@ -151,11 +213,11 @@ func FindInterfaceForSubnet(cidr string) (string, error) {
 			continue
 		}
 		for _, addr := range addrs {
-			ip, _, err := net.ParseCIDR(addr.String())
+			ip, ifNet, err := net.ParseCIDR(addr.String())
 			if err != nil {
 				continue
 			}
-			if subnet.Contains(ip) {
+			if subnet.Contains(ip) || subnetWithin(subnet, ifNet) {
 				return iface.Name, nil
 			}
 		}
@@ -225,3 +225,59 @@ func TestDiscoverLocalSubnets_NoLoopback(t *testing.T) {
 		}
 	}
 }
+
+func TestRoutableSubnet(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		addr   string
+		want   string
+		wantOK bool
+	}{
+		{"192.168.1.5/24", "192.168.1.0/24", true},
+		{"10.1.2.3/16", "10.1.0.0/16", true},
+		{"fd12:3456:789a:1::5/64", "fd12:3456:789a:1::/64", true},
+		{"2001:db8:1:2::10/64", "2001:db8:1:2::/64", true},
+
+		// Filtered out.
+		{"169.254.10.1/16", "", false},
+		{"fe80::1/64", "", false},
+		{"10.0.0.1/32", "", false},
+		{"2001:db8::1/128", "", false},
+		{"not-an-address", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			t.Parallel()
+			got, ok := routableSubnet(tt.addr)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("routableSubnet(%q) = (%q, %v), want (%q, %v)",
+					tt.addr, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSubnetWithin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		subnet, outer string
+		want          bool
+	}{
+		{"192.168.1.50/32", "192.168.1.0/24", true},
+		{"192.168.1.0/24", "192.168.1.0/24", true},
+		{"192.168.0.0/16", "192.168.1.0/24", false},
+		{"192.168.2.50/32", "192.168.1.0/24", false},
+		{"fd00::5/128", "fd00::/64", true},
+		{"192.168.1.50/32", "fd00::/64", false},
+	}
+	for _, tt := range tests {
+		_, subnet, _ := net.ParseCIDR(tt.subnet)
+		_, outer, _ := net.ParseCIDR(tt.outer)
+		if got := subnetWithin(subnet, outer); got != tt.want {
+			t.Errorf("subnetWithin(%s, %s) = %v, want %v", tt.subnet, tt.outer, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package tunnel
+
+// Encapsulation overhead, in bytes, of the layers a packet from the TUN
+// interface passes through on its way to a peer: WireGuard, an SCTP DATA
+// chunk on the WebRTC data channel, a DTLS record, and UDP/IP or, through a
+// TURN relay, a ChannelData header over UDP or TCP.
+const (
+	wireGuardOverhead   = 32 // data message header (16) + Poly1305 tag (16)
+	sctpOverhead        = 28 // SCTP common header (12) + DATA chunk header (16)
+	dtlsOverhead        = 37 // record header (13) + AES-GCM explicit nonce (8) + tag (16)
+	turnChannelOverhead = 4  // TURN ChannelData header
+	udpOverhead         = 8
+	tcpOverhead         = 20
+	ipv4Overhead        = 20
+	ipv6Overhead        = 40
+
+	// DataChannelMTU is the largest SCTP packet pion sends on a data
+	// channel. It is fixed; larger messages are split across packets.
+	DataChannelMTU = 1200
+
+	// defaultLinkMTU is assumed when the MTU of the local link is unknown.
+	defaultLinkMTU = 1500
+
+	// minPathMTU is the smallest path MTU reported, whatever the link: the
+	// minimum every IPv4 host must accept.
+	minPathMTU = 576
+)
+
+// Path describes how a peer's tunnel traffic leaves this machine: the
+// selected ICE candidate pair and the link it is sent on.
+type Path struct {
+	// Relay is true when the local candidate is a TURN relay.
+	Relay bool
+
+	// RelayProtocol is how the TURN server is reached: "udp", "tcp" or
+	// "tls". Only meaningful for relay paths; empty means UDP.
+	RelayProtocol string
+
+	// IPv6 is true when the path uses IPv6 on the wire.
+	IPv6 bool
+
+	// LinkMTU is the MTU of the local interface the path leaves through,
+	// or 0 if unknown.
+	LinkMTU int
+}
+
+// stream reports whether the path runs over TCP, where the kernel segments
+// the stream and the link MTU never fragments a packet.
+func (p Path) stream() bool {
+	return p.Relay && (p.RelayProtocol == "tcp" || p.RelayProtocol == "tls")
+}
+
+// Overhead returns the bytes the path adds around each SCTP packet on the
+// wire: DTLS, the TURN ChannelData header on relay paths, and the
+// transport and IP headers.
+func (p Path) Overhead() int {
+	overhead := dtlsOverhead + ipv4Overhead
+	if p.IPv6 {
+		overhead = dtlsOverhead + ipv6Overhead
+	}
+	if p.Relay {
+		overhead += turnChannelOverhead
+	}
+	if p.stream() {
+		return overhead + tcpOverhead
+	}
+	return overhead + udpOverhead
+}
+
+// PathMTU returns the effective MTU of the tunnel to a peer on path: the
+// largest packet, at most tunMTU, that travels in a single SCTP packet
+// which the link carries without IP fragmentation. Larger packets still
+// arrive, split across several SCTP packets, but losing any one of them
+// drops the whole packet because the data channel does not retransmit.
+func PathMTU(tunMTU int, p Path) int {
+	budget := DataChannelMTU
+	if !p.stream() {
+		link := p.LinkMTU
+		if link <= 0 {
+			link = defaultLinkMTU
+		}
+		budget = min(budget, link-p.Overhead())
+	}
+	return max(min(tunMTU, budget-sctpOverhead-wireGuardOverhead), minPathMTU)
+}
@@ -0,0 +1,55 @@
+package tunnel
+
+import "testing"
+
+func TestPathMTU(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		tunMTU int
+		path   Path
+		want   int
+	}{
+		{"direct on ethernet", DefaultMTU, Path{LinkMTU: 1500}, 1140},
+		{"unknown link", DefaultMTU, Path{}, 1140},
+		{"direct on small IPv4 link", DefaultMTU, Path{LinkMTU: 1200}, 1075},
+		{"direct on IPv6 minimum link", DefaultMTU, Path{IPv6: true, LinkMTU: 1280}, 1135},
+		{"UDP relay on IPv6 minimum link", DefaultMTU, Path{Relay: true, IPv6: true, LinkMTU: 1280}, 1131},
+		{"TCP relay ignores link", DefaultMTU, Path{Relay: true, RelayProtocol: "tcp", LinkMTU: 1000}, 1140},
+		{"TLS relay ignores link", DefaultMTU, Path{Relay: true, RelayProtocol: "tls", LinkMTU: 1000}, 1140},
+		{"capped by TUN MTU", 1000, Path{LinkMTU: 1500}, 1000},
+		{"tiny link", DefaultMTU, Path{LinkMTU: 600}, minPathMTU},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := PathMTU(tt.tunMTU, tt.path); got != tt.want {
+				t.Errorf("PathMTU(%d, %+v) = %d, want %d", tt.tunMTU, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathOverhead(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		path Path
+		want int
+	}{
+		{"direct IPv4", Path{}, 37 + 8 + 20},
+		{"direct IPv6", Path{IPv6: true}, 37 + 8 + 40},
+		{"UDP relay", Path{Relay: true, RelayProtocol: "udp"}, 37 + 4 + 8 + 20},
+		{"TCP relay", Path{Relay: true, RelayProtocol: "tcp"}, 37 + 4 + 20 + 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.path.Overhead(); got != tt.want {
+				t.Errorf("%+v.Overhead() = %d, want %d", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,63 +3,86 @@
 package tunnel
 
 import (
+	"encoding/binary"
 	"fmt"
 	"log/slog"
 	"net"
 
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	// nftTableName is the nftables table name used by bamgate.
 	// All rules are scoped to this table so they don't interfere with
-	// other firewall rules on the system.
+	// other firewall rules on the system. Named networks use
+	// "bamgate-<network>", so each network's rules can be cleaned up on
+	// their own.
 	nftTableName = "bamgate"
 )
 
+// natTableName returns the nftables table name for network, "" being the
+// default network.
+func natTableName(network string) string {
+	if network == "" {
+		return nftTableName
+	}
+	return nftTableName + "-" + network
+}
+
 // NATManager manages nftables rules for masquerading traffic from the
 // WireGuard tunnel to local network subnets. It creates a dedicated "bamgate"
-// table with a postrouting NAT chain.
+// table with a postrouting NAT chain, one per address family in use (ip and
+// ip6).
 //
 // Requires CAP_NET_ADMIN.
 type NATManager struct {
-	log   *slog.Logger
-	table *nftables.Table
-	conn  *nftables.Conn
+	log    *slog.Logger
+	table  string // nftables table name, see natTableName
+	tables map[nftables.TableFamily]*nftables.Table
+	conn   *nftables.Conn
+
+	// netmapFamilies records which families have netmap rules, so
+	// SetNetmaps can flush chains that no longer have any.
+	netmapFamilies map[nftables.TableFamily]bool
+
+	// portForwardFamilies is the same for SetPortForwards.
+	portForwardFamilies map[nftables.TableFamily]bool
 }
 
-// NewNATManager creates a new NATManager.
-func NewNATManager(logger *slog.Logger) *NATManager {
+// NewNATManager creates a new NATManager for network, "" being the default
+// network.
+func NewNATManager(logger *slog.Logger, network string) *NATManager {
 	return &NATManager{
-		log: logger.With("component", "nat"),
+		log:                 logger.With("component", "nat"),
+		table:               natTableName(network),
+		tables:              make(map[nftables.TableFamily]*nftables.Table),
+		netmapFamilies:      make(map[nftables.TableFamily]bool),
+		portForwardFamilies: make(map[nftables.TableFamily]bool),
 	}
 }
 
 // SetupMasquerade creates nftables rules to masquerade traffic from the
-// WireGuard subnet going out through the specified interface. This is
-// equivalent to:
+// WireGuard subnet going out through the specified interface. For an IPv4
+// subnet this is equivalent to:
 //
 //	nft add table ip bamgate
 //	nft add chain ip bamgate postrouting { type nat hook postrouting priority srcnat; }
 //	nft add rule ip bamgate postrouting ip saddr <wgSubnet> oifname <outIface> masquerade
 //
-// The wgSubnet should be in CIDR notation (e.g., "10.0.0.0/24").
+// IPv6 subnets get the same rule in an "ip6 bamgate" table, matching on
+// ip6 saddr.
+//
+// The wgSubnet should be in CIDR notation (e.g., "10.0.0.0/24" or "fd00::/64").
 // The outIface is the network interface to masquerade on (e.g., "wlan0").
 func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
-	ip, ipNet, err := net.ParseCIDR(wgSubnet)
+	_, ipNet, err := net.ParseCIDR(wgSubnet)
 	if err != nil {
 		return fmt.Errorf("parsing WireGuard subnet %q: %w", wgSubnet, err)
 	}
 
-	// Only support IPv4 for now.
-	ipv4 := ip.To4()
-	if ipv4 == nil {
-		return fmt.Errorf("only IPv4 subnets are supported for masquerade, got %q", wgSubnet)
-	}
-
-	networkAddr := ipNet.IP.To4()
-	mask := ipNet.Mask
+	family, saddrExprs := masqueradeSourceMatch(ipNet)
 
 	c, err := nftables.New()
 	if err != nil {
@@ -69,10 +92,10 @@ func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 
 	// Create table.
 	table := c.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
-		Name:   nftTableName,
+		Family: family,
+		Name:   n.table,
 	})
-	n.table = table
+	n.tables[family] = table
 
 	// Create postrouting NAT chain.
 	chain := c.AddChain(&nftables.Chain{
@@ -84,61 +107,38 @@ func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 	})
 
 	// Build the nftables rule:
-	//   ip saddr & <mask> == <network> oifname <outIface> masquerade
-	//
-	// This is equivalent to: ip saddr <wgSubnet> oifname <outIface> masquerade
+	//   saddr & <mask> == <network> oifname <outIface> masquerade
 	//
 	// The rule uses:
-	// 1. payload: load source IP (4 bytes at offset 12 of network header)
-	// 2. bitwise: AND with subnet mask
-	// 3. cmp: compare with network address
-	// 4. meta: load output interface name
-	// 5. cmp: compare with interface name
-	// 6. masquerade
+	// 1. payload/bitwise/cmp: match the source address against the subnet
+	// 2. meta: load output interface name
+	// 3. cmp: compare with interface name
+	// 4. masquerade
 
 	// Pad interface name to 16 bytes (IFNAMSIZ) with null bytes for nftables comparison.
 	ifaceData := make([]byte, 16)
 	copy(ifaceData, outIface)
 
+	exprs := append(saddrExprs,
+		// Load output interface name into register 1.
+		&expr.Meta{
+			Key:      expr.MetaKeyOIFNAME,
+			Register: 1,
+		},
+		// Compare with target interface name.
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     ifaceData,
+		},
+		// Apply masquerade.
+		&expr.Masq{},
+	)
+
 	c.AddRule(&nftables.Rule{
 		Table: table,
 		Chain: chain,
-		Exprs: []expr.Any{
-			// Load source IP address into register 1.
-			&expr.Payload{
-				DestRegister: 1,
-				Base:         expr.PayloadBaseNetworkHeader,
-				Offset:       12, // IPv4 source address offset
-				Len:          4,  // IPv4 address length
-			},
-			// Bitwise AND with subnet mask.
-			&expr.Bitwise{
-				SourceRegister: 1,
-				DestRegister:   1,
-				Len:            4,
-				Mask:           mask,
-				Xor:            []byte{0, 0, 0, 0},
-			},
-			// Compare with network address.
-			&expr.Cmp{
-				Op:       expr.CmpOpEq,
-				Register: 1,
-				Data:     networkAddr,
-			},
-			// Load output interface name into register 1.
-			&expr.Meta{
-				Key:      expr.MetaKeyOIFNAME,
-				Register: 1,
-			},
-			// Compare with target interface name.
-			&expr.Cmp{
-				Op:       expr.CmpOpEq,
-				Register: 1,
-				Data:     ifaceData,
-			},
-			// Apply masquerade.
-			&expr.Masq{},
-		},
+		Exprs: exprs,
 	})
 
 	// Flush all buffered commands atomically.
@@ -147,7 +147,8 @@ func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 	}
 
 	n.log.Info("nftables masquerade rule added",
-		"table", nftTableName,
+		"table", n.table,
+		"family", familyName(family),
 		"subnet", wgSubnet,
 		"out_iface", outIface,
 	)
@@ -155,7 +156,388 @@ func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 	return nil
 }
 
-// TableExists checks if the bamgate nftables table still exists.
+// masqueradeSourceMatch returns the table family and the expressions that
+// match packets whose source address lies in ipNet. The source address is
+// loaded from the network header (offset 12, 4 bytes for IPv4; offset 8,
+// 16 bytes for IPv6), masked, and compared with the network address.
+func masqueradeSourceMatch(ipNet *net.IPNet) (nftables.TableFamily, []expr.Any) {
+	return addrMatch(ipNet, false)
+}
+
+// addrMatch returns the table family and the expressions that match packets
+// whose source (or, if dst is set, destination) address lies in ipNet.
+// Destination addresses follow the source in the header: offset 16 for
+// IPv4, 24 for IPv6.
+func addrMatch(ipNet *net.IPNet, dst bool) (nftables.TableFamily, []expr.Any) {
+	family := nftables.TableFamilyIPv4
+	offset := uint32(12) // IPv4 source address offset
+	network := ipNet.IP.To4()
+	mask := []byte(ipNet.Mask)
+	if network == nil {
+		family = nftables.TableFamilyIPv6
+		offset = 8 // IPv6 source address offset
+		network = ipNet.IP.To16()
+	}
+	if dst {
+		offset += uint32(len(network))
+	}
+	if len(mask) != len(network) {
+		// An IPv4 mask parsed from a 16-byte form; keep the last 4 bytes.
+		mask = mask[len(mask)-len(network):]
+	}
+	addrLen := uint32(len(network))
+
+	return family, []expr.Any{
+		// Load source IP address into register 1.
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          addrLen,
+		},
+		// Bitwise AND with subnet mask.
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            addrLen,
+			Mask:           mask,
+			Xor:            make([]byte, addrLen),
+		},
+		// Compare with network address.
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     network,
+		},
+	}
+}
+
+// SetNetmaps replaces the 1:1 prefix translation rules for traffic arriving
+// on tunIface. For an IPv4 rule this is equivalent to:
+//
+//	nft add chain ip bamgate prerouting { type nat hook prerouting priority dstnat; }
+//	nft add rule ip bamgate prerouting iifname <tunIface> ip saddr <source> ip daddr <alias> dnat ip prefix to <route>
+//
+// IPv6 rules go into the "ip6 bamgate" table. The prerouting chain of each
+// family is flushed first, so passing no rules removes all translations.
+func (n *NATManager) SetNetmaps(tunIface string, rules []NetmapRule) error {
+	c, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("connecting to nftables: %w", err)
+	}
+	n.conn = c
+
+	byFamily := make(map[nftables.TableFamily][][]expr.Any)
+	for _, r := range rules {
+		family, exprs, err := netmapExprs(tunIface, r)
+		if err != nil {
+			return err
+		}
+		byFamily[family] = append(byFamily[family], exprs)
+	}
+
+	for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+		// Only touch families that have rules now or had them before.
+		if len(byFamily[family]) == 0 && !n.netmapFamilies[family] {
+			continue
+		}
+
+		table := c.AddTable(&nftables.Table{Family: family, Name: n.table})
+		n.tables[family] = table
+		chain := c.AddChain(&nftables.Chain{
+			Name:     "prerouting",
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPrerouting,
+			Priority: nftables.ChainPriorityNATDest,
+		})
+		c.FlushChain(chain)
+		for _, exprs := range byFamily[family] {
+			c.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: exprs})
+		}
+		n.netmapFamilies[family] = len(byFamily[family]) > 0
+	}
+
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("applying nftables netmap rules: %w", err)
+	}
+
+	n.log.Info("nftables netmap rules applied", "table", n.table, "rules", len(rules))
+	return nil
+}
+
+// netmapExprs builds the expressions for one NetmapRule:
+//
+//	iifname <tunIface> saddr == <source> daddr & <mask> == <alias>
+//	dnat prefix to <route>
+//
+// The DNAT range is the first and last address of route, with the NETMAP
+// flag telling the kernel to keep host bits instead of picking an address.
+func netmapExprs(tunIface string, r NetmapRule) (nftables.TableFamily, []expr.Any, error) {
+	_, alias, err := net.ParseCIDR(r.Alias)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parsing netmap alias %q: %w", r.Alias, err)
+	}
+	_, route, err := net.ParseCIDR(r.Route)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parsing netmap route %q: %w", r.Route, err)
+	}
+	src := net.ParseIP(r.Source)
+	if src == nil {
+		return 0, nil, fmt.Errorf("parsing netmap source %q", r.Source)
+	}
+	is4 := route.IP.To4() != nil
+	if (src.To4() != nil) != is4 || (alias.IP.To4() != nil) != is4 {
+		return 0, nil, fmt.Errorf("netmap source %s, alias %s and route %s must share an address family",
+			r.Source, r.Alias, r.Route)
+	}
+
+	srcNet := &net.IPNet{IP: src, Mask: net.CIDRMask(32, 32)}
+	natFamily := uint32(unix.NFPROTO_IPV4)
+	first := route.IP.To4()
+	if !is4 {
+		srcNet.Mask = net.CIDRMask(128, 128)
+		natFamily = unix.NFPROTO_IPV6
+		first = route.IP.To16()
+	}
+
+	family, srcExprs := addrMatch(srcNet, false)
+	_, dstExprs := addrMatch(alias, true)
+
+	mask := []byte(route.Mask)
+	mask = mask[len(mask)-len(first):]
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^mask[i]
+	}
+
+	ifaceData := make([]byte, 16)
+	copy(ifaceData, tunIface)
+
+	exprs := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifaceData},
+	}
+	exprs = append(exprs, srcExprs...)
+	exprs = append(exprs, dstExprs...)
+	exprs = append(exprs,
+		&expr.Immediate{Register: 1, Data: []byte(first)},
+		&expr.Immediate{Register: 2, Data: []byte(last)},
+		&expr.NAT{
+			Type:       expr.NATTypeDestNAT,
+			Family:     natFamily,
+			RegAddrMin: 1,
+			RegAddrMax: 2,
+			Prefix:     true,
+		},
+	)
+	return family, exprs, nil
+}
+
+// SetPortForwards replaces the rules publishing LAN services on the tunnel
+// address. For an IPv4 TCP rule this is equivalent to:
+//
+//	nft add chain ip bamgate portforward { type nat hook prerouting priority dstnat; }
+//	nft add rule ip bamgate portforward iifname <tunIface> ip daddr <address> tcp dport <port> dnat ip to <target>
+//
+// The rules live in their own chain so SetNetmaps and SetPortForwards do
+// not flush each other's rules. Passing no rules removes all port forwards.
+func (n *NATManager) SetPortForwards(tunIface string, rules []PortForwardRule) error {
+	c, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("connecting to nftables: %w", err)
+	}
+	n.conn = c
+
+	byFamily := make(map[nftables.TableFamily][][]expr.Any)
+	for _, r := range rules {
+		family, exprs, err := portForwardExprs(tunIface, r)
+		if err != nil {
+			return err
+		}
+		byFamily[family] = append(byFamily[family], exprs)
+	}
+
+	for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+		if len(byFamily[family]) == 0 && !n.portForwardFamilies[family] {
+			continue
+		}
+
+		table := c.AddTable(&nftables.Table{Family: family, Name: n.table})
+		n.tables[family] = table
+		chain := c.AddChain(&nftables.Chain{
+			Name:     "portforward",
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPrerouting,
+			Priority: nftables.ChainPriorityNATDest,
+		})
+		c.FlushChain(chain)
+		for _, exprs := range byFamily[family] {
+			c.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: exprs})
+		}
+		n.portForwardFamilies[family] = len(byFamily[family]) > 0
+	}
+
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("applying nftables port forward rules: %w", err)
+	}
+
+	n.log.Info("nftables port forward rules applied", "table", n.table, "rules", len(rules))
+	return nil
+}
+
+// portForwardExprs builds the expressions for one PortForwardRule:
+//
+//	iifname <tunIface> daddr == <address> l4proto == <protocol>
+//	th dport == <port> dnat to <target ip>:<target port>
+func portForwardExprs(tunIface string, r PortForwardRule) (nftables.TableFamily, []expr.Any, error) {
+	addr, target, err := r.parse()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	dst := &net.IPNet{IP: addr.AsSlice(), Mask: net.CIDRMask(addr.BitLen(), addr.BitLen())}
+	family, dstExprs := addrMatch(dst, true)
+	natFamily := uint32(unix.NFPROTO_IPV4)
+	if addr.Is6() {
+		natFamily = unix.NFPROTO_IPV6
+	}
+	proto := byte(unix.IPPROTO_TCP)
+	if r.Protocol == "udp" {
+		proto = unix.IPPROTO_UDP
+	}
+
+	ifaceData := make([]byte, 16)
+	copy(ifaceData, tunIface)
+
+	exprs := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifaceData},
+	}
+	exprs = append(exprs, dstExprs...)
+	exprs = append(exprs,
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		// Destination port: bytes 2-3 of the TCP/UDP header.
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       2,
+			Len:          2,
+		},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binary.BigEndian.AppendUint16(nil, r.Port)},
+		&expr.Immediate{Register: 1, Data: target.Addr().AsSlice()},
+		&expr.Immediate{Register: 2, Data: binary.BigEndian.AppendUint16(nil, target.Port())},
+		&expr.NAT{
+			Type:        expr.NATTypeDestNAT,
+			Family:      natFamily,
+			RegAddrMin:  1,
+			RegProtoMin: 2,
+			Specified:   true,
+		},
+	)
+	return family, exprs, nil
+}
+
+// SetMSSClamp clamps the maximum segment size of TCP connections forwarded
+// into the tunnel to the MTU of the route they take, so hosts behind this
+// device size their segments for the path to each peer (see SetRouteMTU)
+// instead of relying on path MTU discovery through the tunnel. For IPv4
+// this is equivalent to:
+//
+//	nft add chain ip bamgate mssclamp { type filter hook forward priority mangle; }
+//	nft add rule ip bamgate mssclamp oifname <tunIface> tcp flags syn tcp option maxseg size set rt mtu
+//
+// and the same in the "ip6 bamgate" table. The kernel only ever lowers the
+// MSS. mtu is unused: the route MTUs are more precise.
+func (n *NATManager) SetMSSClamp(tunIface string, _ int) error {
+	c, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("connecting to nftables: %w", err)
+	}
+	n.conn = c
+
+	for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+		table := c.AddTable(&nftables.Table{Family: family, Name: n.table})
+		n.tables[family] = table
+		chain := c.AddChain(&nftables.Chain{
+			Name:     "mssclamp",
+			Table:    table,
+			Type:     nftables.ChainTypeFilter,
+			Hooknum:  nftables.ChainHookForward,
+			Priority: nftables.ChainPriorityMangle,
+		})
+		c.FlushChain(chain)
+		c.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: mssClampExprs(tunIface)})
+	}
+
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("applying nftables MSS clamping rules: %w", err)
+	}
+
+	n.log.Info("nftables MSS clamping rules applied", "table", n.table, "tun_iface", tunIface)
+	return nil
+}
+
+// mssClampExprs builds the expressions for the MSS clamping rule:
+//
+//	oifname <tunIface> l4proto == tcp th[13] & SYN != 0
+//	tcp option maxseg size set rt mtu
+func mssClampExprs(tunIface string) []expr.Any {
+	ifaceData := make([]byte, 16)
+	copy(ifaceData, tunIface)
+
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifaceData},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		// TCP flags: byte 13 of the TCP header.
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       13,
+			Len:          1,
+		},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            1,
+			Mask:           []byte{0x02}, // SYN
+			Xor:            []byte{0x00},
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x00}},
+		// The MSS the route's MTU allows, in host byte order.
+		&expr.Rt{Register: 1, Key: expr.RtTCPMSS},
+		&expr.Byteorder{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Op:             expr.ByteorderHton,
+			Len:            2,
+			Size:           2,
+		},
+		// Overwrite the MSS option (kind 2): 2 bytes after its header.
+		&expr.Exthdr{
+			SourceRegister: 1,
+			Op:             expr.ExthdrOpTcpopt,
+			Type:           2,
+			Offset:         2,
+			Len:            2,
+		},
+	}
+}
+
+// familyName returns the nft keyword for a table family.
+func familyName(family nftables.TableFamily) string {
+	if family == nftables.TableFamilyIPv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+// TableExists checks if the bamgate nftables tables still exist for every
+// family SetupMasquerade was called with (IPv4 if it was never called).
 // This is used by the forwarding watchdog to detect if external processes
 // (e.g., a firewall manager) have flushed our rules.
 func (n *NATManager) TableExists() bool {
@@ -173,15 +555,30 @@ func (n *NATManager) TableExists() bool {
 		return false
 	}
 
-	for _, t := range tables {
-		if t.Name == nftTableName && t.Family == nftables.TableFamilyIPv4 {
-			return true
+	want := []nftables.TableFamily{nftables.TableFamilyIPv4}
+	if len(n.tables) > 0 {
+		want = want[:0]
+		for family := range n.tables {
+			want = append(want, family)
+		}
+	}
+
+	for _, family := range want {
+		found := false
+		for _, t := range tables {
+			if t.Name == n.table && t.Family == family {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-// Cleanup removes the bamgate nftables table and all its rules.
+// Cleanup removes the bamgate nftables tables and all their rules.
 // This is safe to call even if SetupMasquerade was never called.
 func (n *NATManager) Cleanup() error {
 	c := n.conn
@@ -193,22 +590,26 @@ func (n *NATManager) Cleanup() error {
 		}
 	}
 
-	if n.table != nil {
-		c.DelTable(n.table)
-	} else {
-		// Try to delete by name in case we're cleaning up from a previous run.
-		c.DelTable(&nftables.Table{
-			Family: nftables.TableFamilyIPv4,
-			Name:   nftTableName,
-		})
-	}
-
-	if err := c.Flush(); err != nil {
-		// Table may not exist, which is fine.
-		n.log.Debug("nftables cleanup (table may not have existed)", "error", err)
-		return nil
+	// Try both families by name so tables left by a previous run are
+	// removed too. Each deletion is flushed separately: a batch aborts
+	// entirely if one of its tables does not exist.
+	for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+		table := n.tables[family]
+		if table == nil {
+			table = &nftables.Table{Family: family, Name: n.table}
+		}
+		c.DelTable(table)
+		if err := c.Flush(); err != nil {
+			// Table may not exist, which is fine.
+			n.log.Debug("nftables cleanup (table may not have existed)",
+				"family", familyName(family), "error", err)
+			continue
+		}
+		n.log.Info("nftables bamgate table removed", "table", n.table, "family", familyName(family))
 	}
 
-	n.log.Info("nftables bamgate table removed")
+	n.tables = make(map[nftables.TableFamily]*nftables.Table)
+	n.netmapFamilies = make(map[nftables.TableFamily]bool)
+	n.portForwardFamilies = make(map[nftables.TableFamily]bool)
 	return nil
 }
@@ -9,7 +9,7 @@ import "log/slog"
 type NATManager struct{}
 
 // NewNATManager returns a no-op NATManager for Android.
-func NewNATManager(_ *slog.Logger) *NATManager {
+func NewNATManager(_ *slog.Logger, _ string) *NATManager {
 	return &NATManager{}
 }
 
@@ -18,6 +18,12 @@ func (n *NATManager) SetupMasquerade(wgSubnet, outIface string) error {
 	return nil
 }
 
+// SetNetmaps is a no-op on Android — the device never forwards traffic for
+// advertised routes.
+func (n *NATManager) SetNetmaps(tunIface string, rules []NetmapRule) error {
+	return nil
+}
+
 // TableExists always returns true on Android — no NAT table to monitor.
 func (n *NATManager) TableExists() bool {
 	return true
@@ -27,3 +33,15 @@ func (n *NATManager) TableExists() bool {
 func (n *NATManager) Cleanup() error {
 	return nil
 }
+
+// SetPortForwards is a no-op on Android — the device never forwards
+// traffic to a LAN.
+func (n *NATManager) SetPortForwards(tunIface string, rules []PortForwardRule) error {
+	return nil
+}
+
+// SetMSSClamp is a no-op on Android — the device never forwards traffic
+// into the tunnel.
+func (n *NATManager) SetMSSClamp(tunIface string, mtu int) error {
+	return nil
+}
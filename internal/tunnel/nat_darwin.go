@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net"
 	"os/exec"
+	"slices"
 	"strings"
 )
 
@@ -14,22 +15,38 @@ const (
 	// pfAnchorName is the PF anchor name used by bamgate.
 	// All NAT rules are scoped to this anchor so they don't interfere
 	// with other PF rules on the system.
+	// Named networks use "com.bamgate.<network>".
 	pfAnchorName = "com.bamgate"
 )
 
+// pfAnchorFor returns the PF anchor name for network, "" being the default
+// network.
+func pfAnchorFor(network string) string {
+	if network == "" {
+		return pfAnchorName
+	}
+	return pfAnchorName + "." + network
+}
+
 // NATManager manages PF (Packet Filter) NAT rules for masquerading traffic
 // from the WireGuard tunnel to local network subnets on macOS.
 //
 // Requires root privileges.
 type NATManager struct {
-	log     *slog.Logger
-	anchors []string // anchor rules that have been loaded
+	log      *slog.Logger
+	anchor   string   // PF anchor name, see pfAnchorFor
+	scrub    []string // MSS clamping rules currently loaded into the anchor
+	rules    []string // NAT rules currently loaded into the anchor
+	rdrRules []string // netmap redirect rules currently loaded into the anchor
+	pfwRules []string // port forward redirect rules currently loaded into the anchor
 }
 
-// NewNATManager creates a new NATManager.
-func NewNATManager(logger *slog.Logger) *NATManager {
+// NewNATManager creates a new NATManager for network, "" being the default
+// network.
+func NewNATManager(logger *slog.Logger, network string) *NATManager {
 	return &NATManager{
-		log: logger.With("component", "nat"),
+		log:    logger.With("component", "nat"),
+		anchor: pfAnchorFor(network),
 	}
 }
 
@@ -38,9 +55,12 @@ func NewNATManager(logger *slog.Logger) *NATManager {
 //
 // This is equivalent to loading the following PF rule under an anchor:
 //
-//	nat on <outIface> from <wgSubnet> to any -> (<outIface>)
+//	nat on <outIface> inet[6] from <wgSubnet> to any -> (<outIface>)
+//
+// Loading an anchor replaces its whole ruleset, so every call reloads all
+// rules added so far.
 //
-// The wgSubnet should be in CIDR notation (e.g., "10.0.0.0/24").
+// The wgSubnet should be in CIDR notation (e.g., "10.0.0.0/24" or "fd00::/64").
 // The outIface is the network interface to masquerade on (e.g., "en0").
 func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 	ip, _, err := net.ParseCIDR(wgSubnet)
@@ -48,34 +68,26 @@ func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 		return fmt.Errorf("parsing WireGuard subnet %q: %w", wgSubnet, err)
 	}
 
-	// Only support IPv4 for now.
+	af := "inet"
 	if ip.To4() == nil {
-		return fmt.Errorf("only IPv4 subnets are supported for masquerade, got %q", wgSubnet)
+		af = "inet6"
 	}
 
 	// Build the NAT rule. Using parentheses around the interface means PF
 	// will dynamically resolve the interface address (handles DHCP changes).
-	rule := fmt.Sprintf("nat on %s from %s to any -> (%s)\n", outIface, wgSubnet, outIface)
-
-	// Load the rule into a PF anchor via pfctl.
-	// Using -a <anchor> scopes our rules so they don't interfere with
-	// the system's main PF configuration.
-	cmd := exec.Command("pfctl", "-a", pfAnchorName, "-f", "-")
-	cmd.Stdin = strings.NewReader(rule)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("loading PF NAT rule: %w (output: %s)",
-			err, strings.TrimSpace(string(out)))
+	rule := fmt.Sprintf("nat on %s %s from %s to any -> (%s)", outIface, af, wgSubnet, outIface)
+	rules := n.rules
+	if !slices.Contains(rules, rule) {
+		rules = append(rules, rule)
 	}
 
-	n.anchors = append(n.anchors, pfAnchorName)
-
-	// Ensure PF is enabled. This is idempotent — if PF is already enabled,
-	// pfctl -e returns exit code 1 but that's fine.
-	enableCmd := exec.Command("pfctl", "-e")
-	_ = enableCmd.Run() // Ignore error: already-enabled returns non-zero.
+	if err := n.load(n.scrub, rules, n.rdrRules, n.pfwRules); err != nil {
+		return fmt.Errorf("loading PF NAT rule: %w", err)
+	}
+	n.rules = rules
 
 	n.log.Info("PF NAT masquerade rule added",
-		"anchor", pfAnchorName,
+		"anchor", n.anchor,
 		"subnet", wgSubnet,
 		"out_iface", outIface,
 	)
@@ -83,10 +95,114 @@ func (n *NATManager) SetupMasquerade(wgSubnet string, outIface string) error {
 	return nil
 }
 
+// SetNetmaps replaces the 1:1 prefix translation rules for traffic arriving
+// on tunIface. Each rule is loaded into the anchor as:
+//
+//	rdr on <tunIface> inet[6] from <source> to <alias> -> <route> bitmask
+//
+// The bitmask pool option keeps the host bits of the original destination.
+// Passing no rules removes all translations.
+func (n *NATManager) SetNetmaps(tunIface string, rules []NetmapRule) error {
+	rdrRules := make([]string, 0, len(rules))
+	for _, r := range rules {
+		ip, _, err := net.ParseCIDR(r.Route)
+		if err != nil {
+			return fmt.Errorf("parsing netmap route %q: %w", r.Route, err)
+		}
+		af := "inet"
+		if ip.To4() == nil {
+			af = "inet6"
+		}
+		rdrRules = append(rdrRules, fmt.Sprintf("rdr on %s %s from %s to %s -> %s bitmask",
+			tunIface, af, r.Source, r.Alias, r.Route))
+	}
+
+	if err := n.load(n.scrub, n.rules, rdrRules, n.pfwRules); err != nil {
+		return fmt.Errorf("loading PF netmap rules: %w", err)
+	}
+	n.rdrRules = rdrRules
+
+	n.log.Info("PF netmap rules applied", "anchor", n.anchor, "rules", len(rdrRules))
+	return nil
+}
+
+// SetPortForwards replaces the rules publishing LAN services on the tunnel
+// address. Each rule is loaded into the anchor as:
+//
+//	rdr on <tunIface> inet[6] proto <protocol> from any to <address> port <port> -> <target ip> port <target port>
+//
+// Passing no rules removes all port forwards.
+func (n *NATManager) SetPortForwards(tunIface string, rules []PortForwardRule) error {
+	pfwRules := make([]string, 0, len(rules))
+	for _, r := range rules {
+		addr, target, err := r.parse()
+		if err != nil {
+			return err
+		}
+		af := "inet"
+		if addr.Is6() {
+			af = "inet6"
+		}
+		pfwRules = append(pfwRules, fmt.Sprintf("rdr on %s %s proto %s from any to %s port %d -> %s port %d",
+			tunIface, af, r.Protocol, addr, r.Port, target.Addr(), target.Port()))
+	}
+
+	if err := n.load(n.scrub, n.rules, n.rdrRules, pfwRules); err != nil {
+		return fmt.Errorf("loading PF port forward rules: %w", err)
+	}
+	n.pfwRules = pfwRules
+
+	n.log.Info("PF port forward rules applied", "anchor", n.anchor, "rules", len(pfwRules))
+	return nil
+}
+
+// SetMSSClamp clamps the maximum segment size of TCP connections through
+// tunIface so their packets fit mtu, the TUN MTU. PF cannot look up route
+// MTUs, so unlike on Linux the clamp is the same for every peer. The rules
+// are loaded into the anchor as:
+//
+//	scrub on <tunIface> inet proto tcp all max-mss <mtu - 40>
+//	scrub on <tunIface> inet6 proto tcp all max-mss <mtu - 60>
+func (n *NATManager) SetMSSClamp(tunIface string, mtu int) error {
+	scrub := []string{
+		fmt.Sprintf("scrub on %s inet proto tcp all max-mss %d", tunIface, mtu-40),
+		fmt.Sprintf("scrub on %s inet6 proto tcp all max-mss %d", tunIface, mtu-60),
+	}
+
+	if err := n.load(scrub, n.rules, n.rdrRules, n.pfwRules); err != nil {
+		return fmt.Errorf("loading PF MSS clamping rules: %w", err)
+	}
+	n.scrub = scrub
+
+	n.log.Info("PF MSS clamping rules applied", "anchor", n.anchor, "mtu", mtu)
+	return nil
+}
+
+// load replaces the anchor's ruleset with the given scrub, NAT and redirect
+// rule sets, in the order PF requires, and makes sure PF is enabled. Using -a <anchor> scopes our rules so
+// they don't interfere with the system's main PF configuration.
+func (n *NATManager) load(ruleSets ...[]string) error {
+	var all []string
+	for _, rules := range ruleSets {
+		all = append(all, rules...)
+	}
+	cmd := exec.Command("pfctl", "-a", n.anchor, "-f", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(all, "\n") + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w (output: %s)", err, strings.TrimSpace(string(out)))
+	}
+
+	// Ensure PF is enabled. This is idempotent — if PF is already enabled,
+	// pfctl -e returns exit code 1 but that's fine.
+	enableCmd := exec.Command("pfctl", "-e")
+	_ = enableCmd.Run() // Ignore error: already-enabled returns non-zero.
+	return nil
+}
+
 // TableExists checks if the bamgate PF anchor still has rules loaded.
 // This is used by the forwarding watchdog to detect if rules were flushed.
 func (n *NATManager) TableExists() bool {
-	cmd := exec.Command("pfctl", "-a", pfAnchorName, "-s", "nat")
+	cmd := exec.Command("pfctl", "-a", n.anchor, "-s", "nat")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return false
@@ -99,7 +215,7 @@ func (n *NATManager) TableExists() bool {
 // This is safe to call even if SetupMasquerade was never called.
 func (n *NATManager) Cleanup() error {
 	// Flush all rules in the bamgate anchor.
-	cmd := exec.Command("pfctl", "-a", pfAnchorName, "-F", "all")
+	cmd := exec.Command("pfctl", "-a", n.anchor, "-F", "all")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		// Anchor may not exist, which is fine.
 		n.log.Debug("PF cleanup (anchor may not have existed)",
@@ -107,7 +223,10 @@ func (n *NATManager) Cleanup() error {
 		return nil
 	}
 
-	n.anchors = nil
+	n.scrub = nil
+	n.rules = nil
+	n.rdrRules = nil
+	n.pfwRules = nil
 	n.log.Info("PF bamgate anchor flushed")
 	return nil
 }
@@ -0,0 +1,214 @@
+//go:build linux && !android
+
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestMasqueradeSourceMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cidr       string
+		wantFamily nftables.TableFamily
+		wantOffset uint32
+		wantMask   []byte
+		wantAddr   []byte
+	}{
+		{
+			cidr:       "10.0.0.1/24",
+			wantFamily: nftables.TableFamilyIPv4,
+			wantOffset: 12,
+			wantMask:   []byte{255, 255, 255, 0},
+			wantAddr:   []byte{10, 0, 0, 0},
+		},
+		{
+			cidr:       "fd12:3456:789a::2/64",
+			wantFamily: nftables.TableFamilyIPv6,
+			wantOffset: 8,
+			wantMask:   []byte{255, 255, 255, 255, 255, 255, 255, 255, 0, 0, 0, 0, 0, 0, 0, 0},
+			wantAddr:   net.ParseIP("fd12:3456:789a::").To16(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			t.Parallel()
+
+			_, ipNet, err := net.ParseCIDR(tt.cidr)
+			if err != nil {
+				t.Fatalf("ParseCIDR(%q): %v", tt.cidr, err)
+			}
+			family, exprs := masqueradeSourceMatch(ipNet)
+			if family != tt.wantFamily {
+				t.Errorf("family = %v, want %v", family, tt.wantFamily)
+			}
+			if len(exprs) != 3 {
+				t.Fatalf("got %d expressions, want 3", len(exprs))
+			}
+
+			payload := exprs[0].(*expr.Payload)
+			if payload.Offset != tt.wantOffset || payload.Len != uint32(len(tt.wantAddr)) {
+				t.Errorf("payload offset/len = %d/%d, want %d/%d",
+					payload.Offset, payload.Len, tt.wantOffset, len(tt.wantAddr))
+			}
+			bitwise := exprs[1].(*expr.Bitwise)
+			if !bytes.Equal(bitwise.Mask, tt.wantMask) {
+				t.Errorf("mask = %v, want %v", bitwise.Mask, tt.wantMask)
+			}
+			if len(bitwise.Xor) != len(tt.wantMask) {
+				t.Errorf("xor length = %d, want %d", len(bitwise.Xor), len(tt.wantMask))
+			}
+			cmp := exprs[2].(*expr.Cmp)
+			if !bytes.Equal(cmp.Data, tt.wantAddr) {
+				t.Errorf("network = %v, want %v", cmp.Data, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestNetmapExprs(t *testing.T) {
+	t.Parallel()
+
+	family, exprs, err := netmapExprs("bamgate0", NetmapRule{
+		Source: "10.0.0.2",
+		Alias:  "10.201.1.0/24",
+		Route:  "192.168.1.0/24",
+	})
+	if err != nil {
+		t.Fatalf("netmapExprs() error: %v", err)
+	}
+	if family != nftables.TableFamilyIPv4 {
+		t.Errorf("family = %v, want IPv4", family)
+	}
+
+	// iifname (2) + saddr (3) + daddr (3) + two immediates + nat.
+	if len(exprs) != 11 {
+		t.Fatalf("got %d expressions, want 11", len(exprs))
+	}
+	if p := exprs[2].(*expr.Payload); p.Offset != 12 {
+		t.Errorf("source payload offset = %d, want 12", p.Offset)
+	}
+	if c := exprs[4].(*expr.Cmp); !bytes.Equal(c.Data, []byte{10, 0, 0, 2}) {
+		t.Errorf("source = %v, want 10.0.0.2", c.Data)
+	}
+	if p := exprs[5].(*expr.Payload); p.Offset != 16 {
+		t.Errorf("destination payload offset = %d, want 16", p.Offset)
+	}
+	if c := exprs[7].(*expr.Cmp); !bytes.Equal(c.Data, []byte{10, 201, 1, 0}) {
+		t.Errorf("alias network = %v, want 10.201.1.0", c.Data)
+	}
+	if i := exprs[8].(*expr.Immediate); !bytes.Equal(i.Data, []byte{192, 168, 1, 0}) {
+		t.Errorf("range min = %v, want 192.168.1.0", i.Data)
+	}
+	if i := exprs[9].(*expr.Immediate); !bytes.Equal(i.Data, []byte{192, 168, 1, 255}) {
+		t.Errorf("range max = %v, want 192.168.1.255", i.Data)
+	}
+	if nat := exprs[10].(*expr.NAT); nat.Type != expr.NATTypeDestNAT || !nat.Prefix {
+		t.Errorf("nat = %+v, want prefix DNAT", nat)
+	}
+}
+
+func TestNetmapExprs_familyMismatch(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := netmapExprs("bamgate0", NetmapRule{
+		Source: "fd12::2",
+		Alias:  "10.201.1.0/24",
+		Route:  "192.168.1.0/24",
+	})
+	if err == nil {
+		t.Error("netmapExprs() accepted an IPv6 source with an IPv4 alias")
+	}
+}
+
+func TestPortForwardExprs(t *testing.T) {
+	t.Parallel()
+
+	family, exprs, err := portForwardExprs("bamgate0", PortForwardRule{
+		Protocol: "tcp",
+		Address:  "10.0.0.1",
+		Port:     8443,
+		Target:   "192.168.1.50:443",
+	})
+	if err != nil {
+		t.Fatalf("portForwardExprs() error: %v", err)
+	}
+	if family != nftables.TableFamilyIPv4 {
+		t.Errorf("family = %v, want IPv4", family)
+	}
+
+	// iifname (2) + daddr (3) + l4proto (2) + dport (2) + two immediates + nat.
+	if len(exprs) != 12 {
+		t.Fatalf("got %d expressions, want 12", len(exprs))
+	}
+	if c := exprs[4].(*expr.Cmp); !bytes.Equal(c.Data, []byte{10, 0, 0, 1}) {
+		t.Errorf("destination = %v, want 10.0.0.1", c.Data)
+	}
+	if c := exprs[6].(*expr.Cmp); !bytes.Equal(c.Data, []byte{6}) {
+		t.Errorf("l4proto = %v, want tcp (6)", c.Data)
+	}
+	if c := exprs[8].(*expr.Cmp); !bytes.Equal(c.Data, []byte{0x20, 0xfb}) {
+		t.Errorf("dport = %v, want 8443", c.Data)
+	}
+	if i := exprs[9].(*expr.Immediate); !bytes.Equal(i.Data, []byte{192, 168, 1, 50}) {
+		t.Errorf("target address = %v, want 192.168.1.50", i.Data)
+	}
+	if i := exprs[10].(*expr.Immediate); !bytes.Equal(i.Data, []byte{0x01, 0xbb}) {
+		t.Errorf("target port = %v, want 443", i.Data)
+	}
+	if nat := exprs[11].(*expr.NAT); nat.Type != expr.NATTypeDestNAT || nat.RegProtoMin != 2 {
+		t.Errorf("nat = %+v, want DNAT with a port", nat)
+	}
+}
+
+func TestPortForwardExprs_invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []PortForwardRule{
+		{Protocol: "sctp", Address: "10.0.0.1", Port: 80, Target: "192.168.1.50:80"},
+		{Protocol: "tcp", Address: "fd12::1", Port: 80, Target: "192.168.1.50:80"},
+		{Protocol: "tcp", Address: "10.0.0.1", Port: 0, Target: "192.168.1.50:80"},
+		{Protocol: "udp", Address: "10.0.0.1", Port: 53, Target: "192.168.1.50"},
+	}
+	for _, r := range tests {
+		if _, _, err := portForwardExprs("bamgate0", r); err == nil {
+			t.Errorf("portForwardExprs(%+v) succeeded, want error", r)
+		}
+	}
+}
+
+func TestMSSClampExprs(t *testing.T) {
+	t.Parallel()
+
+	exprs := mssClampExprs("bamgate0")
+
+	// oifname (2) + l4proto (2) + SYN flag (3) + rt + byteorder + exthdr.
+	if len(exprs) != 10 {
+		t.Fatalf("got %d expressions, want 10", len(exprs))
+	}
+	if m := exprs[0].(*expr.Meta); m.Key != expr.MetaKeyOIFNAME {
+		t.Errorf("first match = %+v, want oifname", m)
+	}
+	if c := exprs[1].(*expr.Cmp); !bytes.HasPrefix(c.Data, []byte("bamgate0\x00")) {
+		t.Errorf("oifname = %q, want bamgate0", c.Data)
+	}
+	if c := exprs[3].(*expr.Cmp); !bytes.Equal(c.Data, []byte{6}) {
+		t.Errorf("l4proto = %v, want tcp (6)", c.Data)
+	}
+	if b := exprs[5].(*expr.Bitwise); !bytes.Equal(b.Mask, []byte{0x02}) {
+		t.Errorf("flags mask = %v, want SYN", b.Mask)
+	}
+	if rt := exprs[7].(*expr.Rt); rt.Key != expr.RtTCPMSS {
+		t.Errorf("rt key = %v, want tcpmss", rt.Key)
+	}
+	if e := exprs[9].(*expr.Exthdr); e.Op != expr.ExthdrOpTcpopt || e.Type != 2 || e.SourceRegister != 1 {
+		t.Errorf("exthdr = %+v, want a write to the maxseg option", e)
+	}
+}
@@ -6,9 +6,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
-	"os/exec"
 	"strings"
+	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -151,6 +152,55 @@ func AddRoute(ifName string, cidr string) error {
 	return nil
 }
 
+// SetRouteMTU sets the MTU of an existing route for the given destination
+// subnet via the named interface, so the kernel sizes packets and TCP
+// segments for that destination to fit. This replaces
+// `ip route replace <cidr> dev <ifName> mtu <mtu>`.
+// Requires CAP_NET_ADMIN.
+func SetRouteMTU(ifName string, cidr string, mtu int) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("parsing CIDR %q: %w", cidr, err)
+	}
+
+	ifIndex, err := interfaceIndex(ifName)
+	if err != nil {
+		return err
+	}
+
+	family := uint8(unix.AF_INET)
+	dstBytes := ipNet.IP.To4()
+	if dstBytes == nil {
+		family = unix.AF_INET6
+		dstBytes = ipNet.IP.To16()
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("creating netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("binding netlink socket: %w", err)
+	}
+
+	msg := buildRouteMsg(unix.RTM_NEWROUTE, unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_REPLACE,
+		ifIndex, family, uint8(prefixLen), dstBytes)
+	msg = appendRouteMTU(msg, uint32(mtu))
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("sending RTM_NEWROUTE: %w", err)
+	}
+
+	if err := readNetlinkAck(fd); err != nil {
+		return fmt.Errorf("setting MTU %d on route %s via %s: %w", mtu, cidr, ifName, err)
+	}
+
+	return nil
+}
+
 // RemoveRoute removes a kernel route for the given destination subnet via the
 // named interface. This replaces `ip route del <cidr> dev <ifName>`.
 // Requires CAP_NET_ADMIN.
@@ -228,11 +278,19 @@ func buildNewAddrMsg(ifIndex int32, family uint8, prefixLen uint8, addr []byte)
 	binary.LittleEndian.PutUint32(buf[8:12], 1)                                                                  // nlmsg_seq
 	binary.LittleEndian.PutUint32(buf[12:16], 0)                                                                 // nlmsg_pid
 
+	// IPv6 addresses skip duplicate address detection: the tunnel address
+	// is assigned by the signaling server and unique within the network,
+	// and DAD would leave it "tentative" (unusable) for about a second.
+	var flags uint8
+	if family == unix.AF_INET6 {
+		flags = unix.IFA_F_NODAD
+	}
+
 	// ifaddrmsg
 	off := nlmsgHdrLen
 	buf[off] = family                                                // ifa_family
 	buf[off+1] = prefixLen                                           // ifa_prefixlen
-	buf[off+2] = 0                                                   // ifa_flags
+	buf[off+2] = flags                                               // ifa_flags
 	buf[off+3] = unix.RT_SCOPE_UNIVERSE                              // ifa_scope
 	binary.LittleEndian.PutUint32(buf[off+4:off+8], uint32(ifIndex)) // ifa_index
 
@@ -348,6 +406,24 @@ func buildRouteMsg(msgType uint16, flags uint16, ifIndex int32, family uint8, pr
 	return buf
 }
 
+// appendRouteMTU appends an RTA_METRICS attribute holding RTAX_MTU to a
+// route message built by buildRouteMsg and updates nlmsg_len.
+func appendRouteMTU(msg []byte, mtu uint32) []byte {
+	mtuAttrLen := rtaHdrLen + 4
+	metricsLen := rtaHdrLen + mtuAttrLen
+
+	attr := make([]byte, rtaAlignLen(metricsLen))
+	binary.LittleEndian.PutUint16(attr[0:2], uint16(metricsLen)) // rta_len
+	binary.LittleEndian.PutUint16(attr[2:4], unix.RTA_METRICS)   // rta_type
+	binary.LittleEndian.PutUint16(attr[4:6], uint16(mtuAttrLen)) // nested rta_len
+	binary.LittleEndian.PutUint16(attr[6:8], unix.RTAX_MTU)      // nested rta_type
+	binary.LittleEndian.PutUint32(attr[8:12], mtu)
+
+	msg = append(msg, attr...)
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(len(msg))) // nlmsg_len
+	return msg
+}
+
 // --- IPv4 forwarding via netlink ---
 //
 // Per-interface IPv4 forwarding is controlled via the IFLA_AF_SPEC > AF_INET >
@@ -489,88 +565,111 @@ func rtaAlignLen(l int) int {
 	return (l + 3) &^ 3
 }
 
-// SetDNS configures per-interface DNS servers and search domains using
-// systemd-resolved via the resolvectl command. This sets DNS only for the
-// specified interface, leaving system-wide DNS unaffected.
-// Falls back to writing /etc/resolv.conf if systemd-resolved is not available.
-func SetDNS(ifName string, servers []string, searchDomains []string) error {
-	if len(servers) == 0 && len(searchDomains) == 0 {
-		return nil
-	}
+// ipv6ForwardingPath is the global IPv6 forwarding sysctl. Unlike IPv4,
+// Linux only forwards IPv6 when the "all" switch is on; the per-interface
+// values merely select host or router behaviour.
+const ipv6ForwardingPath = "/proc/sys/net/ipv6/conf/all/forwarding"
 
-	// Try systemd-resolved first (resolvectl).
-	if _, err := exec.LookPath("resolvectl"); err == nil {
-		return setDNSResolvectl(ifName, servers, searchDomains)
+// GetIPv6Forwarding reads the global IPv6 forwarding state.
+func GetIPv6Forwarding() (bool, error) {
+	data, err := os.ReadFile(ipv6ForwardingPath)
+	if err != nil {
+		return false, fmt.Errorf("reading IPv6 forwarding state: %w", err)
 	}
-
-	// Fallback: write to /etc/resolv.conf (less ideal but works everywhere).
-	return setDNSResolvConf(servers, searchDomains)
+	return strings.TrimSpace(string(data)) == "1", nil
 }
 
-// RevertDNS removes per-interface DNS configuration set by SetDNS.
-func RevertDNS(ifName string) error {
-	// With systemd-resolved, DNS config is automatically removed when the
-	// interface goes down. We call revert explicitly for clean teardown.
-	if _, err := exec.LookPath("resolvectl"); err == nil {
-		cmd := exec.Command("resolvectl", "revert", ifName)
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("resolvectl revert %s: %w (output: %s)",
-				ifName, err, strings.TrimSpace(string(out)))
-		}
-		return nil
+// SetIPv6Forwarding enables or disables global IPv6 forwarding.
+//
+// Note that enabling forwarding makes the kernel ignore router
+// advertisements on interfaces with accept_ra=1. Hosts that configure their
+// LAN address via SLAAC should set net.ipv6.conf.<iface>.accept_ra=2.
+// Requires CAP_NET_ADMIN.
+func SetIPv6Forwarding(enabled bool) error {
+	val := "0"
+	if enabled {
+		val = "1"
+	}
+	if err := os.WriteFile(ipv6ForwardingPath, []byte(val), 0644); err != nil {
+		return fmt.Errorf("setting IPv6 forwarding to %s: %w", val, err)
 	}
-
-	// No cleanup needed for /etc/resolv.conf fallback — the file is not
-	// interface-scoped, so we can't safely remove entries. The system will
-	// return to its normal DNS config on reboot or DHCP renewal.
 	return nil
 }
 
-// setDNSResolvectl uses resolvectl to set per-interface DNS.
-func setDNSResolvectl(ifName string, servers []string, searchDomains []string) error {
-	if len(servers) > 0 {
-		args := append([]string{"dns", ifName}, servers...)
-		cmd := exec.Command("resolvectl", args...)
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("resolvectl dns %s: %w (output: %s)",
-				ifName, err, strings.TrimSpace(string(out)))
-		}
-	}
+// --- Routing table dump ---
 
-	if len(searchDomains) > 0 {
-		args := append([]string{"domain", ifName}, searchDomains...)
-		cmd := exec.Command("resolvectl", args...)
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("resolvectl domain %s: %w (output: %s)",
-				ifName, err, strings.TrimSpace(string(out)))
-		}
+// kernelRoutes dumps the main routing table (IPv4 and IPv6) via
+// RTM_GETROUTE. Default routes, host routes, and link-local routes are
+// skipped since they never conflict with an accepted subnet route.
+func kernelRoutes() ([]SubnetInfo, error) {
+	data, err := syscall.NetlinkRIB(unix.RTM_GETROUTE, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("dumping routes: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing route dump: %w", err)
 	}
 
-	return nil
+	return parseRouteDump(msgs, func(index int) string {
+		if iface, err := net.InterfaceByIndex(index); err == nil {
+			return iface.Name
+		}
+		return ""
+	}), nil
 }
 
-// setDNSResolvConf is a basic fallback that prepends nameserver entries to
-// /etc/resolv.conf. This is a best-effort approach for systems without
-// systemd-resolved.
-func setDNSResolvConf(servers []string, searchDomains []string) error {
-	existing, err := os.ReadFile("/etc/resolv.conf")
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("reading /etc/resolv.conf: %w", err)
-	}
+// parseRouteDump extracts unicast main-table routes from an RTM_GETROUTE
+// dump. ifName maps an output interface index to its name.
+func parseRouteDump(msgs []syscall.NetlinkMessage, ifName func(index int) string) []SubnetInfo {
+	var routes []SubnetInfo
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < rtmsgLen {
+			continue
+		}
 
-	var lines []string
-	lines = append(lines, "# Added by bamgate")
-	for _, s := range servers {
-		lines = append(lines, "nameserver "+s)
-	}
-	if len(searchDomains) > 0 {
-		lines = append(lines, "search "+strings.Join(searchDomains, " "))
-	}
+		// rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type.
+		dstLen := int(m.Data[1])
+		table := uint32(m.Data[4])
+		if m.Data[7] != unix.RTN_UNICAST || dstLen == 0 {
+			continue
+		}
 
-	newContent := strings.Join(lines, "\n") + "\n" + string(existing)
-	if err := os.WriteFile("/etc/resolv.conf", []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("writing /etc/resolv.conf: %w", err)
-	}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			continue
+		}
+		var dst []byte
+		var oif int
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case unix.RTA_DST:
+				dst = attr.Value
+			case unix.RTA_OIF:
+				if len(attr.Value) == 4 {
+					oif = int(binary.LittleEndian.Uint32(attr.Value))
+				}
+			case unix.RTA_TABLE:
+				// Tables above 255 only appear in RTA_TABLE.
+				if len(attr.Value) == 4 {
+					table = binary.LittleEndian.Uint32(attr.Value)
+				}
+			}
+		}
+		if table != unix.RT_TABLE_MAIN {
+			continue
+		}
 
-	return nil
+		addr, ok := netip.AddrFromSlice(dst)
+		if !ok || dstLen >= addr.BitLen() || addr.IsLinkLocalUnicast() || addr.IsMulticast() {
+			continue
+		}
+
+		routes = append(routes, SubnetInfo{
+			CIDR:      netip.PrefixFrom(addr, dstLen).Masked().String(),
+			Interface: ifName(oif),
+		})
+	}
+	return routes
 }
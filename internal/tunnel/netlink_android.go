@@ -20,14 +20,35 @@ func AddRoute(ifName string, cidr string) error { return nil }
 // RemoveRoute is a no-op on Android — routes are removed when the VPN is stopped.
 func RemoveRoute(ifName string, cidr string) error { return nil }
 
+// SetRouteMTU is a no-op on Android — VpnService.Builder.setMtu() applies to all routes.
+func SetRouteMTU(ifName string, cidr string, mtu int) error { return nil }
+
 // GetForwarding always returns false on Android — IP forwarding is not applicable.
 func GetForwarding(ifName string) (bool, error) { return false, nil }
 
 // SetForwarding is a no-op on Android — IP forwarding is managed by the OS.
 func SetForwarding(ifName string, enabled bool) error { return nil }
 
+// GetIPv6Forwarding always returns false on Android — IP forwarding is not applicable.
+func GetIPv6Forwarding() (bool, error) { return false, nil }
+
+// SetIPv6Forwarding is a no-op on Android — IP forwarding is managed by the OS.
+func SetIPv6Forwarding(enabled bool) error { return nil }
+
 // SetDNS is a no-op on Android — DNS is configured via VpnService.Builder.addDnsServer().
-func SetDNS(ifName string, servers []string, searchDomains []string) error { return nil }
+func SetDNS(ifName string, backend DNSBackend, servers []string, searchDomains []string) error {
+	return nil
+}
 
 // RevertDNS is a no-op on Android — DNS is removed when the VPN stops.
-func RevertDNS(ifName string) error { return nil }
+func RevertDNS(ifName string, backend DNSBackend) error { return nil }
+
+// RecoverDNS is a no-op on Android — bamgate never modifies system DNS files.
+func RecoverDNS(ifName string, backend DNSBackend) error { return nil }
+
+// ResolveDNSBackend returns backend unchanged — Android has no backend choice.
+func ResolveDNSBackend(backend DNSBackend) DNSBackend { return backend }
+
+// kernelRoutes returns nothing on Android — apps cannot dump the routing
+// table, so LocalRoutes relies on interface subnets alone.
+func kernelRoutes() ([]SubnetInfo, error) { return nil, nil }
@@ -5,13 +5,19 @@ package tunnel
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"syscall"
+
+	"golang.org/x/net/route"
 )
 
 // AddAddress assigns an IP address in CIDR notation to a network interface.
-// On macOS, this calls `ifconfig <ifName> inet <ip> <ip> netmask <mask>`.
+// On macOS, this calls `ifconfig <ifName> inet <ip> <ip> netmask <mask>`
+// (or `ifconfig <ifName> inet6 <ip> prefixlen <len>` for IPv6).
 // Requires root privileges.
 func AddAddress(ifName string, cidr string) error {
 	ip, ipNet, err := net.ParseCIDR(cidr)
@@ -19,9 +25,8 @@ func AddAddress(ifName string, cidr string) error {
 		return fmt.Errorf("parsing CIDR %q: %w", cidr, err)
 	}
 
-	ipv4 := ip.To4()
-	if ipv4 == nil {
-		return fmt.Errorf("only IPv4 addresses are supported on macOS, got %q", cidr)
+	if ip.To4() == nil {
+		return addAddress6(ifName, ip, ipNet)
 	}
 
 	mask := net.IP(ipNet.Mask).String()
@@ -50,6 +55,36 @@ func AddAddress(ifName string, cidr string) error {
 	return nil
 }
 
+// addAddress6 assigns an IPv6 address to a utun interface and adds the
+// connected route for its prefix, mirroring the IPv4 path.
+func addAddress6(ifName string, ip net.IP, ipNet *net.IPNet) error {
+	prefixLen, _ := ipNet.Mask.Size()
+	cmd := exec.Command("ifconfig", ifName, "inet6", ip.String(), "prefixlen", fmt.Sprint(prefixLen))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ifconfig add address %s/%d on %s: %w (output: %s)",
+			ip, prefixLen, ifName, err, strings.TrimSpace(string(out)))
+	}
+
+	// Some macOS versions install the prefix route themselves; an
+	// "exists" error from route(8) is therefore not a failure.
+	routeCmd := exec.Command("route", "-n", "add", "-inet6", "-net", ipNet.String(), "-interface", ifName)
+	if out, err := routeCmd.CombinedOutput(); err != nil && !strings.Contains(string(out), "exists") {
+		return fmt.Errorf("adding subnet route %s on %s: %w (output: %s)",
+			ipNet, ifName, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// routeFamilyArgs returns the route(8) arguments selecting the address
+// family and destination for cidr.
+func routeFamilyArgs(ipNet *net.IPNet) []string {
+	if ipNet.IP.To4() == nil {
+		return []string{"-inet6", "-net", ipNet.String()}
+	}
+	return []string{"-net", ipNet.String()}
+}
+
 // SetLinkUp brings a network interface into the UP state.
 // On macOS, this calls `ifconfig <ifName> up`.
 // Requires root privileges.
@@ -63,15 +98,16 @@ func SetLinkUp(ifName string) error {
 }
 
 // AddRoute adds a kernel route for the given destination subnet via the named
-// interface. On macOS, this calls `route -n add -net <cidr> -interface <ifName>`.
+// interface. On macOS, this calls `route -n add [-inet6] -net <cidr> -interface <ifName>`.
 // Requires root privileges.
 func AddRoute(ifName string, cidr string) error {
-	_, _, err := net.ParseCIDR(cidr)
+	_, ipNet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return fmt.Errorf("parsing CIDR %q: %w", cidr, err)
 	}
 
-	cmd := exec.Command("route", "-n", "add", "-net", cidr, "-interface", ifName)
+	args := append([]string{"-n", "add"}, routeFamilyArgs(ipNet)...)
+	cmd := exec.Command("route", append(args, "-interface", ifName)...)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("route add %s via %s: %w (output: %s)",
 			cidr, ifName, err, strings.TrimSpace(string(out)))
@@ -79,16 +115,36 @@ func AddRoute(ifName string, cidr string) error {
 	return nil
 }
 
+// SetRouteMTU sets the MTU of an existing route for the given destination
+// subnet. On macOS, this calls
+// `route -n change [-inet6] -net <cidr> -interface <ifName> -mtu <mtu>`.
+// Requires root privileges.
+func SetRouteMTU(ifName string, cidr string, mtu int) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("parsing CIDR %q: %w", cidr, err)
+	}
+
+	args := append([]string{"-n", "change"}, routeFamilyArgs(ipNet)...)
+	args = append(args, "-interface", ifName, "-mtu", strconv.Itoa(mtu))
+	cmd := exec.Command("route", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("route change %s mtu %d: %w (output: %s)",
+			cidr, mtu, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // RemoveRoute removes a kernel route for the given destination subnet.
-// On macOS, this calls `route -n delete -net <cidr>`.
+// On macOS, this calls `route -n delete [-inet6] -net <cidr>`.
 // Requires root privileges.
 func RemoveRoute(ifName string, cidr string) error {
-	_, _, err := net.ParseCIDR(cidr)
+	_, ipNet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return fmt.Errorf("parsing CIDR %q: %w", cidr, err)
 	}
 
-	cmd := exec.Command("route", "-n", "delete", "-net", cidr)
+	cmd := exec.Command("route", append([]string{"-n", "delete"}, routeFamilyArgs(ipNet)...)...)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("route delete %s: %w (output: %s)",
 			cidr, err, strings.TrimSpace(string(out)))
@@ -126,11 +182,37 @@ func SetForwarding(_ string, enabled bool) error {
 	return nil
 }
 
+// GetIPv6Forwarding reads the current global IPv6 forwarding state.
+func GetIPv6Forwarding() (bool, error) {
+	cmd := exec.Command("sysctl", "-n", "net.inet6.ip6.forwarding")
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("reading IPv6 forwarding state: %w", err)
+	}
+	return strings.TrimSpace(string(out)) == "1", nil
+}
+
+// SetIPv6Forwarding enables or disables global IPv6 forwarding.
+// Requires root privileges.
+func SetIPv6Forwarding(enabled bool) error {
+	val := "0"
+	if enabled {
+		val = "1"
+	}
+	cmd := exec.Command("sysctl", "-w", "net.inet6.ip6.forwarding="+val)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("setting IPv6 forwarding to %s: %w (output: %s)",
+			val, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // SetDNS configures DNS servers and search domains for the bamgate interface
 // on macOS by creating a resolver configuration in /etc/resolver/.
 // Each search domain gets a resolver file that routes queries through the
-// specified DNS servers.
-func SetDNS(_ string, servers []string, searchDomains []string) error {
+// specified DNS servers. The backend is ignored; /etc/resolver is the only
+// mechanism on macOS.
+func SetDNS(_ string, _ DNSBackend, servers []string, searchDomains []string) error {
 	if len(servers) == 0 {
 		return nil
 	}
@@ -158,7 +240,7 @@ func SetDNS(_ string, servers []string, searchDomains []string) error {
 
 // RevertDNS removes DNS configuration set by SetDNS on macOS by removing
 // the resolver files in /etc/resolver/ that were created by bamgate.
-func RevertDNS(_ string) error {
+func RevertDNS(_ string, _ DNSBackend) error {
 	// Read all files in /etc/resolver/ and remove the ones we created.
 	entries, err := os.ReadDir("/etc/resolver")
 	if err != nil {
@@ -186,3 +268,80 @@ func RevertDNS(_ string) error {
 
 	return nil
 }
+
+// RecoverDNS removes resolver files left behind by a previous run that did
+// not shut down cleanly. It is equivalent to RevertDNS on macOS.
+func RecoverDNS(ifName string, backend DNSBackend) error {
+	return RevertDNS(ifName, backend)
+}
+
+// ResolveDNSBackend returns backend unchanged; macOS has no backend choice.
+func ResolveDNSBackend(backend DNSBackend) DNSBackend {
+	return backend
+}
+
+// kernelRoutes reads the routing table via a sysctl(NET_RT_DUMP) snapshot.
+// Default routes, host routes, and link-local routes are skipped since they
+// never conflict with an accepted subnet route.
+func kernelRoutes() ([]SubnetInfo, error) {
+	rib, err := route.FetchRIB(syscall.AF_UNSPEC, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, fmt.Errorf("fetching routing table: %w", err)
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, fmt.Errorf("parsing routing table: %w", err)
+	}
+
+	var routes []SubnetInfo
+	for _, m := range msgs {
+		rm, ok := m.(*route.RouteMessage)
+		if !ok || rm.Flags&syscall.RTF_UP == 0 || rm.Flags&syscall.RTF_HOST != 0 {
+			continue
+		}
+		if len(rm.Addrs) <= syscall.RTAX_NETMASK {
+			continue
+		}
+		prefix, ok := routePrefix(rm.Addrs[syscall.RTAX_DST], rm.Addrs[syscall.RTAX_NETMASK])
+		if !ok || prefix.Bits() == 0 || prefix.Addr().IsLinkLocalUnicast() || prefix.Addr().IsMulticast() {
+			continue
+		}
+
+		var ifName string
+		if iface, err := net.InterfaceByIndex(rm.Index); err == nil {
+			ifName = iface.Name
+		}
+		routes = append(routes, SubnetInfo{CIDR: prefix.String(), Interface: ifName})
+	}
+	return routes, nil
+}
+
+// routePrefix converts a routing message's destination and netmask
+// addresses to a prefix. It reports false for non-IP destinations and
+// non-contiguous masks.
+func routePrefix(dst, mask route.Addr) (netip.Prefix, bool) {
+	var addr netip.Addr
+	var maskBytes []byte
+	switch d := dst.(type) {
+	case *route.Inet4Addr:
+		m, ok := mask.(*route.Inet4Addr)
+		if !ok {
+			return netip.Prefix{}, false
+		}
+		addr, maskBytes = netip.AddrFrom4(d.IP), m.IP[:]
+	case *route.Inet6Addr:
+		m, ok := mask.(*route.Inet6Addr)
+		if !ok {
+			return netip.Prefix{}, false
+		}
+		addr, maskBytes = netip.AddrFrom16(d.IP), m.IP[:]
+	default:
+		return netip.Prefix{}, false
+	}
+
+	ones, bits := net.IPMask(maskBytes).Size()
+	if bits == 0 || ones == bits {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
@@ -4,6 +4,9 @@ package tunnel
 
 import (
 	"encoding/binary"
+	"fmt"
+	"slices"
+	"syscall"
 	"testing"
 
 	"golang.org/x/sys/unix"
@@ -67,6 +70,9 @@ func TestBuildNewAddrMsg_IPv6(t *testing.T) {
 	if msg[off+1] != 64 {
 		t.Errorf("ifa_prefixlen = %d, want 64", msg[off+1])
 	}
+	if msg[off+2] != unix.IFA_F_NODAD {
+		t.Errorf("ifa_flags = 0x%x, want IFA_F_NODAD (0x%x)", msg[off+2], unix.IFA_F_NODAD)
+	}
 }
 
 func TestBuildSetLinkUpMsg(t *testing.T) {
@@ -201,6 +207,69 @@ func TestBuildRouteMsg_Delete(t *testing.T) {
 	}
 }
 
+func TestAppendRouteMTU(t *testing.T) {
+	t.Parallel()
+
+	dst := []byte{192, 168, 1, 0}
+	base := buildRouteMsg(unix.RTM_NEWROUTE, unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_REPLACE,
+		5, unix.AF_INET, 24, dst)
+	baseLen := len(base)
+	msg := appendRouteMTU(base, 1140)
+
+	if got := binary.LittleEndian.Uint32(msg[0:4]); int(got) != len(msg) {
+		t.Errorf("nlmsg_len = %d, want %d", got, len(msg))
+	}
+	if len(msg) != baseLen+12 {
+		t.Fatalf("message length = %d, want %d", len(msg), baseLen+12)
+	}
+
+	off := baseLen
+	if got := binary.LittleEndian.Uint16(msg[off : off+2]); got != 12 {
+		t.Errorf("RTA_METRICS rta_len = %d, want 12", got)
+	}
+	if got := binary.LittleEndian.Uint16(msg[off+2 : off+4]); got != unix.RTA_METRICS {
+		t.Errorf("rta_type = %d, want RTA_METRICS (%d)", got, unix.RTA_METRICS)
+	}
+	if got := binary.LittleEndian.Uint16(msg[off+6 : off+8]); got != unix.RTAX_MTU {
+		t.Errorf("nested rta_type = %d, want RTAX_MTU (%d)", got, unix.RTAX_MTU)
+	}
+	if got := binary.LittleEndian.Uint32(msg[off+8 : off+12]); got != 1140 {
+		t.Errorf("RTAX_MTU = %d, want 1140", got)
+	}
+}
+
+func TestParseRouteDump(t *testing.T) {
+	t.Parallel()
+
+	// A dump is a concatenation of RTM_NEWROUTE messages in the same format
+	// buildRouteMsg produces for adds.
+	var dump []byte
+	add := func(ifIndex int32, family uint8, prefixLen uint8, dst []byte) {
+		dump = append(dump, buildRouteMsg(unix.RTM_NEWROUTE, 0, ifIndex, family, prefixLen, dst)...)
+	}
+	add(2, unix.AF_INET, 24, []byte{192, 168, 1, 0})
+	add(3, unix.AF_INET, 16, []byte{172, 17, 0, 0})
+	add(2, unix.AF_INET, 32, []byte{192, 168, 1, 1}) // host route: skipped
+	add(2, unix.AF_INET6, 64, []byte{0xfd, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	add(2, unix.AF_INET6, 64, []byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}) // link-local: skipped
+
+	add(2, unix.AF_INET, 0, []byte{0, 0, 0, 0}) // default route: skipped
+
+	msgs, err := syscall.ParseNetlinkMessage(dump)
+	if err != nil {
+		t.Fatalf("ParseNetlinkMessage() error: %v", err)
+	}
+
+	var got []string
+	for _, r := range parseRouteDump(msgs, func(index int) string { return fmt.Sprintf("eth%d", index) }) {
+		got = append(got, r.CIDR+" "+r.Interface)
+	}
+	want := []string{"192.168.1.0/24 eth2", "172.17.0.0/16 eth3", "fd00:1::/64 eth2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseRouteDump() = %v, want %v", got, want)
+	}
+}
+
 func TestBuildSetForwardingMsg_Enabled(t *testing.T) {
 	t.Parallel()
 
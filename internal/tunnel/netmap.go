@@ -0,0 +1,11 @@
+package tunnel
+
+// NetmapRule is a 1:1 prefix translation for traffic arriving from one peer
+// over the tunnel: packets from Source addressed to Alias are redirected to
+// the same host offset within Route. Replies are translated back by the
+// kernel's connection tracking.
+type NetmapRule struct {
+	Source string // peer tunnel address without prefix, e.g. "10.0.0.2"
+	Alias  string // alias prefix chosen by the peer, e.g. "10.201.1.0/24"
+	Route  string // locally advertised route, e.g. "192.168.1.0/24"
+}
@@ -0,0 +1,41 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// PortForwardRule publishes a LAN service on this device's tunnel address:
+// Protocol traffic from the tunnel to Address:Port is redirected to Target.
+// Replies are translated back by the kernel's connection tracking, and the
+// forwarded traffic leaves through the LAN interface masqueraded like
+// traffic for advertised routes.
+type PortForwardRule struct {
+	Protocol string // "tcp" or "udp"
+	Address  string // our tunnel address without prefix, e.g. "10.0.0.1"
+	Port     uint16 // port on Address, e.g. 8443
+	Target   string // LAN destination "ip:port", e.g. "192.168.1.50:443"
+}
+
+// parse validates the rule and returns its addresses.
+func (r PortForwardRule) parse() (addr netip.Addr, target netip.AddrPort, err error) {
+	if r.Protocol != "tcp" && r.Protocol != "udp" {
+		return addr, target, fmt.Errorf("port forward protocol %q: want tcp or udp", r.Protocol)
+	}
+	addr, err = netip.ParseAddr(r.Address)
+	if err != nil {
+		return addr, target, fmt.Errorf("parsing port forward address %q: %w", r.Address, err)
+	}
+	target, err = netip.ParseAddrPort(r.Target)
+	if err != nil {
+		return addr, target, fmt.Errorf("parsing port forward target %q: %w", r.Target, err)
+	}
+	if addr.Is4() != target.Addr().Is4() {
+		return addr, target, fmt.Errorf("port forward address %s and target %s must share an address family",
+			r.Address, r.Target)
+	}
+	if r.Port == 0 || target.Port() == 0 {
+		return addr, target, fmt.Errorf("port forward %s:%d -> %s: ports must be non-zero", r.Address, r.Port, r.Target)
+	}
+	return addr, target, nil
+}
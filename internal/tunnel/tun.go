@@ -16,6 +16,9 @@ const (
 //   - Linux: "bamgate0" (in tun_linux.go)
 //   - macOS: "utun" (in tun_darwin.go) — kernel auto-assigns next available utun
 //   - Android: "tun0" (in tun_android.go) — not used, VpnService names the interface
+//
+// NetworkTUNName, which names the interfaces of named networks, is defined
+// next to it.
 
 // CreateTUN creates a kernel TUN device with the given name and MTU.
 // On Linux, requires CAP_NET_ADMIN. On macOS, requires root privileges.
@@ -12,6 +12,11 @@ import (
 // Defined here to satisfy the per-platform constant requirement.
 const DefaultTUNName = "tun0"
 
+// NetworkTUNName returns DefaultTUNName; Android runs a single network.
+func NetworkTUNName(_ string) string {
+	return DefaultTUNName
+}
+
 // CreateTUNFromFD wraps an existing TUN file descriptor into a wireguard-go
 // tun.Device. This is used on Android where the VpnService creates the TUN
 // interface and passes the file descriptor to the Go layer.
@@ -14,6 +14,12 @@ import (
 // kernel to auto-assign the next available utun interface (e.g., utun3).
 const DefaultTUNName = "utun"
 
+// NetworkTUNName returns the TUN interface name for network. Every network
+// gets the next available utun interface, so this is always DefaultTUNName.
+func NetworkTUNName(_ string) string {
+	return DefaultTUNName
+}
+
 // CreateTUNFromFD wraps an existing TUN file descriptor into a wireguard-go
 // tun.Device. On macOS, this uses CreateTUNFromFile.
 func CreateTUNFromFD(fd int) (tun.Device, error) {
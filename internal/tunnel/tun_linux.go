@@ -13,6 +13,17 @@ import (
 // Linux allows arbitrary interface names.
 const DefaultTUNName = "bamgate0"
 
+// NetworkTUNName returns the TUN interface name for network: DefaultTUNName
+// for the default network (""), "bg-<network>" for named networks. Network
+// names are at most 12 characters, which keeps the result within the
+// 15 characters Linux allows.
+func NetworkTUNName(network string) string {
+	if network == "" {
+		return DefaultTUNName
+	}
+	return "bg-" + network
+}
+
 // CreateTUNFromFD wraps an existing TUN file descriptor into a wireguard-go
 // tun.Device. On Linux (non-Android), this uses the full CreateTUNFromFile
 // which sets up netlink monitoring and MTU configuration.
@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrWatchUnsupported is returned by WatchNetwork on platforms without
+// network change notifications. Callers fall back to polling.
+var ErrWatchUnsupported = errors.New("network change notifications not supported on this platform")
+
+// NetworkEventKind classifies a network change notification.
+type NetworkEventKind int
+
+const (
+	// LinkChanged means an interface was added, removed, or changed state.
+	LinkChanged NetworkEventKind = iota + 1
+
+	// AddressChanged means an address was added to or removed from an
+	// interface.
+	AddressChanged
+
+	// NetconfChanged means per-interface IP configuration changed, e.g.
+	// forwarding was switched on or off.
+	NetconfChanged
+
+	// NATTableDeleted means the bamgate nftables table was deleted.
+	NATTableDeleted
+
+	// EventsLost means notifications were dropped because the kernel's
+	// buffer overflowed. Any state may have changed.
+	EventsLost
+
+	// DefaultRouteChanged means a default route in the main routing table
+	// was added or removed, e.g. after switching Wi-Fi networks.
+	DefaultRouteChanged
+)
+
+// String returns a short name for the event kind, used in logs.
+func (k NetworkEventKind) String() string {
+	switch k {
+	case LinkChanged:
+		return "link"
+	case AddressChanged:
+		return "address"
+	case NetconfChanged:
+		return "netconf"
+	case NATTableDeleted:
+		return "nat-table-deleted"
+	case EventsLost:
+		return "events-lost"
+	case DefaultRouteChanged:
+		return "default-route"
+	default:
+		return "unknown"
+	}
+}
+
+// NetworkEvent is a network change reported by WatchNetwork.
+type NetworkEvent struct {
+	Kind NetworkEventKind
+
+	// IfIndex is the interface the change applies to, or 0 if unknown or
+	// not interface-specific. For DefaultRouteChanged it is the route's
+	// output interface.
+	IfIndex int
+
+	// Addr is the address added or removed, for AddressChanged events.
+	Addr netip.Addr
+}
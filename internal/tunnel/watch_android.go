@@ -0,0 +1,11 @@
+//go:build android
+
+package tunnel
+
+import "context"
+
+// WatchNetwork is not available on Android — the app reports network
+// changes through Agent.NotifyNetworkChange.
+func WatchNetwork(_ context.Context) (<-chan NetworkEvent, error) {
+	return nil, ErrWatchUnsupported
+}
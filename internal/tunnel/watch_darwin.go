@@ -0,0 +1,10 @@
+//go:build darwin
+
+package tunnel
+
+import "context"
+
+// WatchNetwork is not implemented on macOS; callers poll instead.
+func WatchNetwork(_ context.Context) (<-chan NetworkEvent, error) {
+	return nil, ErrWatchUnsupported
+}
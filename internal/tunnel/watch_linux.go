@@ -0,0 +1,227 @@
+//go:build linux && !android
+
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// watchGroups are the rtnetlink multicast groups WatchNetwork joins.
+var watchGroups = []int{
+	unix.RTNLGRP_LINK,
+	unix.RTNLGRP_IPV4_IFADDR,
+	unix.RTNLGRP_IPV6_IFADDR,
+	unix.RTNLGRP_IPV4_NETCONF,
+	unix.RTNLGRP_IPV6_NETCONF,
+	unix.RTNLGRP_IPV4_ROUTE,
+	unix.RTNLGRP_IPV6_ROUTE,
+}
+
+// netconfaIfindex is NETCONFA_IFINDEX from include/uapi/linux/netconf.h.
+const netconfaIfindex = 1
+
+// WatchNetwork subscribes to rtnetlink link, address, netconf and default
+// route notifications and to nftables table deletions, and delivers them on the
+// returned channel until ctx is cancelled or the socket fails, when the
+// channel is closed. Events are dropped rather than queued when the
+// receiver falls behind, since any event means "re-check everything".
+//
+// The nftables subscription needs CAP_NET_ADMIN and is best-effort: if it
+// cannot be opened only rtnetlink events are reported.
+func WatchNetwork(ctx context.Context) (<-chan NetworkEvent, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("creating netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("binding netlink socket: %w", err)
+	}
+	for _, group := range watchGroups {
+		if err := unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_ADD_MEMBERSHIP, group); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("joining rtnetlink group %d: %w", group, err)
+		}
+	}
+	// The fd is non-blocking, so os.NewFile registers it with the runtime
+	// poller and Close unblocks a pending read.
+	f := os.NewFile(uintptr(fd), "rtnetlink")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+
+	events := make(chan NetworkEvent, 64)
+	send := func(ev NetworkEvent) {
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		defer cancel()
+		buf := make([]byte, 1<<16)
+		for {
+			var n int
+			var recvErr error
+			err := rc.Read(func(fd uintptr) bool {
+				n, _, recvErr = unix.Recvfrom(int(fd), buf, 0)
+				return !errors.Is(recvErr, unix.EAGAIN)
+			})
+			if err != nil {
+				return // closed
+			}
+			if errors.Is(recvErr, unix.ENOBUFS) {
+				send(NetworkEvent{Kind: EventsLost})
+				continue
+			}
+			if recvErr != nil {
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if ev, ok := parseNetworkEvent(m); ok {
+					send(ev)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		watchNATTable(ctx, send)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+		<-done
+		<-done
+		close(events)
+	}()
+
+	return events, nil
+}
+
+// parseNetworkEvent converts an rtnetlink notification into a NetworkEvent.
+func parseNetworkEvent(m syscall.NetlinkMessage) (NetworkEvent, bool) {
+	switch m.Header.Type {
+	case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+		// struct ifinfomsg: family, pad, type (u16), index (s32), ...
+		if len(m.Data) < unix.SizeofIfInfomsg {
+			return NetworkEvent{}, false
+		}
+		return NetworkEvent{Kind: LinkChanged, IfIndex: int(int32(binary.NativeEndian.Uint32(m.Data[4:8])))}, true
+
+	case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+		// struct ifaddrmsg: family, prefixlen, flags, scope, index (u32)
+		if len(m.Data) < unix.SizeofIfAddrmsg {
+			return NetworkEvent{}, false
+		}
+		ev := NetworkEvent{Kind: AddressChanged, IfIndex: int(binary.NativeEndian.Uint32(m.Data[4:8]))}
+		attrs, _ := syscall.ParseNetlinkRouteAttr(&m)
+		for _, attr := range attrs {
+			// IFA_LOCAL is the interface's own address on point-to-point
+			// links, where IFA_ADDRESS is the remote end.
+			switch attr.Attr.Type {
+			case unix.IFA_ADDRESS:
+				if !ev.Addr.IsValid() {
+					ev.Addr, _ = netip.AddrFromSlice(attr.Value)
+				}
+			case unix.IFA_LOCAL:
+				ev.Addr, _ = netip.AddrFromSlice(attr.Value)
+			}
+		}
+		return ev, true
+
+	case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+		// rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type.
+		if len(m.Data) < rtmsgLen || m.Data[1] != 0 || m.Data[7] != unix.RTN_UNICAST {
+			return NetworkEvent{}, false
+		}
+		table := uint32(m.Data[4])
+		ev := NetworkEvent{Kind: DefaultRouteChanged}
+		attrs, _ := syscall.ParseNetlinkRouteAttr(&m)
+		for _, attr := range attrs {
+			if len(attr.Value) != 4 {
+				continue
+			}
+			switch attr.Attr.Type {
+			case unix.RTA_OIF:
+				ev.IfIndex = int(binary.NativeEndian.Uint32(attr.Value))
+			case unix.RTA_TABLE:
+				table = binary.NativeEndian.Uint32(attr.Value)
+			}
+		}
+		if table != unix.RT_TABLE_MAIN {
+			return NetworkEvent{}, false
+		}
+		return ev, true
+
+	case unix.RTM_NEWNETCONF, unix.RTM_DELNETCONF:
+		// struct netconfmsg (family, padded to 4 bytes), then attributes.
+		ev := NetworkEvent{Kind: NetconfChanged}
+		for b := m.Data[min(4, len(m.Data)):]; len(b) >= unix.SizeofRtAttr; {
+			l := int(binary.NativeEndian.Uint16(b[0:2]))
+			if l < unix.SizeofRtAttr || l > len(b) {
+				break
+			}
+			if binary.NativeEndian.Uint16(b[2:4]) == netconfaIfindex && l >= unix.SizeofRtAttr+4 {
+				// NETCONFA_IFINDEX_ALL and _DEFAULT are negative; report
+				// them as "not interface-specific".
+				ev.IfIndex = max(0, int(int32(binary.NativeEndian.Uint32(b[4:8]))))
+			}
+			b = b[min(rtaAlignLen(l), len(b)):]
+		}
+		return ev, true
+	}
+	return NetworkEvent{}, false
+}
+
+// watchNATTable reports deletions of the bamgate nftables tables, of every
+// network, until ctx is cancelled.
+func watchNATTable(ctx context.Context, send func(NetworkEvent)) {
+	c, err := nftables.New()
+	if err != nil {
+		return
+	}
+	mon := nftables.NewMonitor(
+		nftables.WithMonitorObject(nftables.MonitorObjectTables),
+		nftables.WithMonitorAction(nftables.MonitorActionDel),
+	)
+	ch, err := c.AddMonitor(mon)
+	if err != nil {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		_ = mon.Close()
+	}()
+	for ev := range ch {
+		if ev.Type != nftables.MonitorEventTypeDelTable {
+			continue
+		}
+		if t, ok := ev.Data.(*nftables.Table); ok && (t.Name == nftTableName || strings.HasPrefix(t.Name, nftTableName+"-")) {
+			send(NetworkEvent{Kind: NATTableDeleted})
+		}
+	}
+}
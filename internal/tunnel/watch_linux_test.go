@@ -0,0 +1,112 @@
+//go:build linux && !android
+
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseNetworkEvent(t *testing.T) {
+	t.Parallel()
+
+	ifinfo := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(ifinfo[4:8], 3)
+
+	ifaddr := make([]byte, unix.SizeofIfAddrmsg)
+	binary.NativeEndian.PutUint32(ifaddr[4:8], 4)
+
+	// IFA_ADDRESS 10.0.0.2 (the remote end), IFA_LOCAL 10.0.0.1.
+	ifaddrLocal := append([]byte{}, ifaddr...)
+	for _, a := range []struct {
+		typ  uint16
+		addr [4]byte
+	}{{unix.IFA_ADDRESS, [4]byte{10, 0, 0, 2}}, {unix.IFA_LOCAL, [4]byte{10, 0, 0, 1}}} {
+		ifaddrLocal = binary.NativeEndian.AppendUint16(ifaddrLocal, 8)
+		ifaddrLocal = binary.NativeEndian.AppendUint16(ifaddrLocal, a.typ)
+		ifaddrLocal = append(ifaddrLocal, a.addr[:]...)
+	}
+
+	// rtmsg for a unicast route in the main table, then RTA_OIF = 2.
+	route := func(dstLen, table uint8) []byte {
+		b := []byte{unix.AF_INET, dstLen, 0, 0, table, unix.RTPROT_DHCP, unix.RT_SCOPE_UNIVERSE, unix.RTN_UNICAST, 0, 0, 0, 0}
+		b = binary.NativeEndian.AppendUint16(b, 8)
+		b = binary.NativeEndian.AppendUint16(b, unix.RTA_OIF)
+		return binary.NativeEndian.AppendUint32(b, 2)
+	}
+
+	// netconfmsg, NETCONFA_IFINDEX = 5, NETCONFA_FORWARDING = 0.
+	netconf := make([]byte, 4, 20)
+	netconf = binary.NativeEndian.AppendUint16(netconf, 8)
+	netconf = binary.NativeEndian.AppendUint16(netconf, netconfaIfindex)
+	netconf = binary.NativeEndian.AppendUint32(netconf, 5)
+	netconf = binary.NativeEndian.AppendUint16(netconf, 8)
+	netconf = binary.NativeEndian.AppendUint16(netconf, 2)
+	netconf = binary.NativeEndian.AppendUint32(netconf, 0)
+
+	// NETCONFA_IFINDEX_ALL is -1.
+	netconfAll := make([]byte, 4, 12)
+	netconfAll = binary.NativeEndian.AppendUint16(netconfAll, 8)
+	netconfAll = binary.NativeEndian.AppendUint16(netconfAll, netconfaIfindex)
+	netconfAll = binary.NativeEndian.AppendUint32(netconfAll, 0xffffffff)
+
+	tests := []struct {
+		name   string
+		typ    uint16
+		data   []byte
+		want   NetworkEvent
+		wantOK bool
+	}{
+		{"new link", unix.RTM_NEWLINK, ifinfo, NetworkEvent{Kind: LinkChanged, IfIndex: 3}, true},
+		{"del address", unix.RTM_DELADDR, ifaddr, NetworkEvent{Kind: AddressChanged, IfIndex: 4}, true},
+		{"netconf", unix.RTM_NEWNETCONF, netconf, NetworkEvent{Kind: NetconfChanged, IfIndex: 5}, true},
+		{"netconf all", unix.RTM_NEWNETCONF, netconfAll, NetworkEvent{Kind: NetconfChanged}, true},
+		{"local address", unix.RTM_NEWADDR, ifaddrLocal, NetworkEvent{Kind: AddressChanged, IfIndex: 4, Addr: netip.MustParseAddr("10.0.0.1")}, true},
+		{"new default route", unix.RTM_NEWROUTE, route(0, unix.RT_TABLE_MAIN), NetworkEvent{Kind: DefaultRouteChanged, IfIndex: 2}, true},
+		{"del default route", unix.RTM_DELROUTE, route(0, unix.RT_TABLE_MAIN), NetworkEvent{Kind: DefaultRouteChanged, IfIndex: 2}, true},
+		{"subnet route", unix.RTM_NEWROUTE, route(24, unix.RT_TABLE_MAIN), NetworkEvent{}, false},
+		{"default route in other table", unix.RTM_NEWROUTE, route(0, 100), NetworkEvent{}, false},
+		{"short link", unix.RTM_NEWLINK, ifinfo[:4], NetworkEvent{}, false},
+		{"short route", unix.RTM_NEWROUTE, ifinfo[:4], NetworkEvent{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			m := syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: tt.typ}, Data: tt.data}
+			got, ok := parseNetworkEvent(m)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseNetworkEvent() = %+v, %v; want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWatchNetwork_close(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := WatchNetwork(ctx)
+	if err != nil {
+		cancel()
+		t.Skipf("rtnetlink unavailable: %v", err)
+	}
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("events channel not closed after cancel")
+		}
+	}
+}
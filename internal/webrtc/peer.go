@@ -3,6 +3,7 @@ package webrtc
 import (
 	"fmt"
 	"log/slog"
+	"net"
 	"sync"
 
 	"github.com/pion/webrtc/v4"
@@ -358,6 +359,57 @@ func (p *Peer) ICECandidateType() string {
 	return pair.Local.Typ.String()
 }
 
+// PathInfo describes the selected ICE candidate pair from the local side:
+// how this peer's traffic leaves the machine.
+type PathInfo struct {
+	// CandidateType is the local candidate type ("host", "srflx",
+	// "prflx" or "relay").
+	CandidateType string
+
+	// RelayProtocol is the protocol used to reach the TURN server ("udp",
+	// "tcp" or "tls") for relay candidates, empty otherwise.
+	RelayProtocol string
+
+	// BaseAddress is the local interface address the path is sent from,
+	// empty for relay candidates.
+	BaseAddress string
+
+	// IPv6 is true when the local candidate is an IPv6 address.
+	IPv6 bool
+}
+
+// SelectedPath returns the path of the selected ICE candidate pair, or
+// false if no pair is selected yet.
+func (p *Peer) SelectedPath() (PathInfo, bool) {
+	pair, err := p.pc.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil || pair.Local == nil {
+		return PathInfo{}, false
+	}
+	local := pair.Local
+
+	info := PathInfo{CandidateType: local.Typ.String()}
+	if ip := net.ParseIP(local.Address); ip != nil {
+		info.IPv6 = ip.To4() == nil
+	}
+	switch local.Typ {
+	case webrtc.ICECandidateTypeHost:
+		info.BaseAddress = local.Address
+	case webrtc.ICECandidateTypeSrflx, webrtc.ICECandidateTypePrflx:
+		info.BaseAddress = local.RelatedAddress
+	case webrtc.ICECandidateTypeRelay:
+		// The relay protocol is only exposed through the candidate stats.
+		for _, s := range p.pc.GetStats() {
+			cs, ok := s.(webrtc.ICECandidateStats)
+			if ok && cs.Type == webrtc.StatsTypeLocalCandidate &&
+				cs.IP == local.Address && cs.Port == int32(local.Port) {
+				info.RelayProtocol = cs.RelayProtocol
+				break
+			}
+		}
+	}
+	return info, true
+}
+
 // ConnectionState returns the current ICE connection state.
 func (p *Peer) ConnectionState() webrtc.ICEConnectionState {
 	return p.pc.ICEConnectionState()
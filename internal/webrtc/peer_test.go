@@ -144,6 +144,15 @@ func TestPeer_OfferAnswer(t *testing.T) {
 		t.Errorf("peer B data channel label = %q, want %q", dcB.Label(), DataChannelLabel)
 	}
 
+	// Local peers connect over host candidates.
+	path, ok := peerA.SelectedPath()
+	if !ok {
+		t.Fatal("SelectedPath() reported no selected pair on an open connection")
+	}
+	if path.CandidateType != "host" || path.BaseAddress == "" || path.RelayProtocol != "" {
+		t.Errorf("SelectedPath() = %+v, want a host path with its base address", path)
+	}
+
 	// Signal done to stop any late ICE candidate sends, then close channels.
 	close(done)
 	close(candidatesForB)
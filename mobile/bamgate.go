@@ -20,14 +20,19 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/kuuji/bamgate/internal/agent"
 	"github.com/kuuji/bamgate/internal/auth"
+	"github.com/kuuji/bamgate/internal/capture"
 	"github.com/kuuji/bamgate/internal/config"
+	"github.com/kuuji/bamgate/internal/control"
+	"github.com/kuuji/bamgate/internal/netmap"
 )
 
 // Logger receives log messages from the Go core. Implement this interface
@@ -67,6 +72,20 @@ type TokenUpdateCallback interface {
 	OnTokenUpdated(configTOML string)
 }
 
+// EventCallback receives the agent's events as they happen: peers
+// discovered and removed, ICE state changes and restarts, data channels
+// opening, routes, DNS, token refreshes, signaling reconnects and
+// forwarding repairs. Apps can update their UI from these instead of
+// polling GetStatus().
+//
+// The eventJSON parameter is one event as a JSON object, in the same format
+// as "bamgate status --watch" reads, e.g.
+// '{"time":"...","type":"ice_state","peer":"home","state":"connected"}'.
+// The callback must not block.
+type EventCallback interface {
+	OnEvent(eventJSON string)
+}
+
 // Tunnel represents a bamgate VPN tunnel instance. Create one with
 // NewTunnel(), configure it, then call Start() to connect.
 type Tunnel struct {
@@ -77,9 +96,15 @@ type Tunnel struct {
 	protector     SocketProtector
 	routeCallback RouteUpdateCallback
 	tokenCallback TokenUpdateCallback
+	eventCallback EventCallback
 
 	mu      sync.Mutex
 	running bool
+
+	// captureStop ends the running packet capture and captureDone
+	// receives its result. Guarded by mu.
+	captureStop context.CancelFunc
+	captureDone chan error
 }
 
 // NewTunnel creates a new Tunnel from a TOML configuration string.
@@ -140,6 +165,24 @@ func (t *Tunnel) SetTokenUpdateCallback(cb TokenUpdateCallback) {
 	t.tokenCallback = cb
 }
 
+// SetEventCallback sets a callback that receives the agent's events while
+// the tunnel runs. Must be called before Start().
+func (t *Tunnel) SetEventCallback(cb EventCallback) {
+	t.eventCallback = cb
+}
+
+// forwardEvents delivers events to cb until the subscription ends. A
+// callback that blocks long enough to fall behind loses its subscription.
+func forwardEvents(events <-chan control.Event, cb EventCallback) {
+	for ev := range events {
+		eventJSON, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		cb.OnEvent(string(eventJSON))
+	}
+}
+
 // Start begins the VPN connection using the given TUN file descriptor.
 // The TUN FD should come from Android's VpnService.Builder.establish().
 //
@@ -197,6 +240,12 @@ func (t *Tunnel) Start(tunFD int) error {
 
 	t.ag = agent.New(t.cfg, logger, opts...)
 
+	if t.eventCallback != nil {
+		events, unsubscribe := t.ag.SubscribeEvents()
+		defer unsubscribe()
+		go forwardEvents(events, t.eventCallback)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	t.cancel = cancel
 
@@ -208,8 +257,12 @@ func (t *Tunnel) Start(tunFD int) error {
 	return err
 }
 
-// Stop gracefully shuts down the tunnel. Safe to call from any thread.
+// Stop gracefully shuts down the tunnel, ending any packet capture. Safe
+// to call from any thread.
 func (t *Tunnel) Stop() {
+	if err := t.StopCapture(); err != nil && t.logger != nil {
+		t.logger.Log(2, fmt.Sprintf("packet capture: %s", err))
+	}
 	if t.cancel != nil {
 		t.cancel()
 	}
@@ -229,6 +282,67 @@ func (t *Tunnel) NotifyNetworkChange() {
 	}
 }
 
+// StartCapture records tunnel traffic to a pcapng file at path, for
+// debugging from the app; the file can be shared and opened in Wireshark.
+// peer restricts the capture to one peer ("" for all), filter selects
+// packets with the same syntax as "bamgate capture" ("" for all),
+// encrypted adds the WireGuard frames, and maxMB stops the capture when
+// the file reaches that size (0 for no limit). Only one capture runs at a
+// time; call StopCapture to end it.
+func (t *Tunnel) StartCapture(path, peer, filter string, encrypted bool, maxMB int) error {
+	if t.ag == nil {
+		return fmt.Errorf("tunnel is not running")
+	}
+	if _, err := capture.ParseFilter(filter); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.captureStop != nil {
+		return fmt.Errorf("a capture is already running")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating capture file: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	t.captureStop, t.captureDone = cancel, done
+
+	req := control.CaptureRequest{
+		Peer:      peer,
+		Filter:    filter,
+		Encrypted: encrypted,
+		MaxBytes:  int64(maxMB) << 20,
+	}
+	go func() {
+		err := t.ag.Capture(ctx, req, f)
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing capture file: %w", cerr)
+		}
+		done <- err
+	}()
+	return nil
+}
+
+// StopCapture ends the capture started by StartCapture and returns its
+// error, if any, such as an unknown peer. It is a no-op if no capture was
+// started. A capture that reached its size limit has already stopped.
+func (t *Tunnel) StopCapture() error {
+	t.mu.Lock()
+	stop, done := t.captureStop, t.captureDone
+	t.captureStop, t.captureDone = nil, nil
+	t.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	stop()
+	return <-done
+}
+
 // IsRunning returns whether the tunnel is currently active.
 func (t *Tunnel) IsRunning() bool {
 	t.mu.Lock()
@@ -256,6 +370,27 @@ func (t *Tunnel) GetTunnelAddress() string {
 	return t.cfg.Device.Address
 }
 
+// GetTunnelAddress6 returns the IPv6 tunnel address from the config (e.g.
+// "fd12:3456:789a::2/64"), or "" on IPv4-only networks. The Android
+// VpnService should pass it to VpnService.Builder.addAddress() as well.
+func (t *Tunnel) GetTunnelAddress6() string {
+	return t.cfg.Device.Address6
+}
+
+// GetTunnelSubnet6 returns the IPv6 network CIDR derived from the IPv6
+// tunnel address (e.g. "fd12:3456:789a::/64"), or "" if there is none.
+// The Android VPN builder should add it as a route alongside GetTunnelSubnet.
+func (t *Tunnel) GetTunnelSubnet6() string {
+	if t.cfg.Device.Address6 == "" {
+		return ""
+	}
+	_, ipNet, err := net.ParseCIDR(t.cfg.Device.Address6)
+	if err != nil {
+		return t.cfg.Device.Address6
+	}
+	return ipNet.String()
+}
+
 // GetDeviceName returns the device name from the config.
 func (t *Tunnel) GetDeviceName() string {
 	return t.cfg.Device.Name
@@ -311,9 +446,12 @@ func (t *Tunnel) GetPeerOfferings() string {
 
 // ConfigurePeer applies per-peer selections for the given peer. The
 // selectionsJSON parameter is a JSON object with optional "routes", "dns",
-// and "dns_search" arrays. Example:
+// and "dns_search" arrays, an optional "route_aliases" object mapping
+// accepted routes to alias prefixes, and an optional "route_priority" number
+// ranking the peer among peers advertising the same routes. Example:
 //
 //	{"routes": ["10.96.0.0/12"], "dns": ["10.96.0.10"], "dns_search": ["svc.cluster.local"]}
+//	{"routes": ["192.168.1.0/24"], "route_aliases": {"192.168.1.0/24": "10.201.1.0/24"}}
 //
 // Selections are persisted to the in-memory config. The caller should persist
 // the config via UpdateConfig after making changes.
@@ -323,19 +461,36 @@ func (t *Tunnel) ConfigurePeer(peerID string, selectionsJSON string) error {
 	}
 
 	var caps struct {
-		Routes    []string `json:"routes"`
-		DNS       []string `json:"dns"`
-		DNSSearch []string `json:"dns_search"`
+		Routes        []string          `json:"routes"`
+		DNS           []string          `json:"dns"`
+		DNSSearch     []string          `json:"dns_search"`
+		RouteAliases  map[string]string `json:"route_aliases"`
+		RoutePriority int               `json:"route_priority"`
 	}
 	if err := json.Unmarshal([]byte(selectionsJSON), &caps); err != nil {
 		return fmt.Errorf("parsing selections: %w", err)
 	}
+	for route, alias := range caps.RouteAliases {
+		if _, err := netmap.Parse(route, alias); err != nil {
+			return fmt.Errorf("invalid route alias: %w", err)
+		}
+	}
 
-	t.cfg.SetPeerSelection(peerID, config.PeerSelections{
-		Routes:    caps.Routes,
-		DNS:       caps.DNS,
-		DNSSearch: caps.DNSSearch,
-	})
+	// Allow rules are not part of the selections and are kept. The map is
+	// replaced rather than written to, since the agent reads it.
+	peers := make(map[string]config.PeerSelections, len(t.cfg.Peers)+1)
+	for name, sel := range t.cfg.Peers {
+		peers[name] = sel
+	}
+	peers[peerID] = config.PeerSelections{
+		Routes:        caps.Routes,
+		DNS:           caps.DNS,
+		DNSSearch:     caps.DNSSearch,
+		RouteAliases:  caps.RouteAliases,
+		RoutePriority: caps.RoutePriority,
+		Allow:         t.cfg.Peers[peerID].Allow,
+	}
+	t.cfg.Peers = peers
 
 	return nil
 }
@@ -344,13 +499,23 @@ func (t *Tunnel) ConfigurePeer(peerID string, selectionsJSON string) error {
 // interface, based on the user's per-peer selections. Returns a JSON array
 // of IP strings. If no per-peer DNS is configured, returns the device's
 // configured DNS servers. Falls back to Google DNS if nothing is configured.
+//
+// Servers inside a route reached through an alias are returned at their
+// alias address. Android has no rewriting DNS proxy, so answers from such a
+// server still carry the real subnet's addresses.
 func (t *Tunnel) GetDNSServers() string {
 	var servers []string
 
 	// Collect DNS servers from all per-peer selections.
 	if t.cfg.Peers != nil {
 		for _, sel := range t.cfg.Peers {
-			servers = append(servers, sel.DNS...)
+			maps, _ := netmap.ParseAll(sel.RouteAliases) // validated by ConfigurePeer
+			for _, server := range sel.DNS {
+				if addr, err := netip.ParseAddr(server); err == nil {
+					server = netmap.AliasAddr(addr, maps).String()
+				}
+				servers = append(servers, server)
+			}
 		}
 	}
 
@@ -565,6 +730,7 @@ func RegisterDevice(serverHost, githubToken, deviceName string) (*RegisterResult
 	cfg.Device.Name = deviceName
 	cfg.Device.PrivateKey = privateKey
 	cfg.Device.Address = resp.Address
+	cfg.Device.Address6 = resp.Address6
 	cfg.Device.AcceptRoutes = true //nolint:staticcheck // legacy default for backward compat
 
 	// Serialize to TOML.
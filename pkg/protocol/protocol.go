@@ -40,6 +40,34 @@ const (
 	// MetaKeyDNSSearch advertises DNS search domains available through this peer.
 	// Value is a JSON array of domain strings, e.g. `["svc.cluster.local"]`.
 	MetaKeyDNSSearch = "dns_search"
+
+	// MetaKeyAddress6 carries the peer's IPv6 tunnel address on dual-stack
+	// networks. Value is a plain CIDR string, e.g. `fd12:3456:789a::2/64`.
+	MetaKeyAddress6 = "address6"
+
+	// MetaKeyServices advertises LAN services the peer publishes on its
+	// tunnel address (device.port_forwards). Value is a JSON array of
+	// objects, e.g. `[{"name":"nas-https","protocol":"tcp","port":8443}]`;
+	// a missing protocol means tcp.
+	MetaKeyServices = "services"
+
+	// MetaKeyRole carries the peer's configured place in the topology:
+	// RoleMesh, RoleHub or RoleSpoke. Without it, the network policy
+	// decides, and otherwise the peer is part of the full mesh.
+	MetaKeyRole = "role"
+
+	// MetaKeyRelay is "true" when the peer forwards traffic between peers
+	// that cannot connect to each other directly (device.relay).
+	MetaKeyRelay = "relay"
+)
+
+// Topology roles, the values of MetaKeyRole. Spokes connect only to hubs,
+// which forward traffic between them; mesh devices connect to every
+// device except spokes.
+const (
+	RoleMesh  = "mesh"
+	RoleHub   = "hub"
+	RoleSpoke = "spoke"
 )
 
 // JoinMessage is sent by a client to announce itself to the signaling hub.
@@ -59,6 +87,18 @@ type OfferMessage struct {
 	To        string `json:"to"`
 	SDP       string `json:"sdp"`
 	PublicKey string `json:"publicKey,omitempty"`
+
+	// RouteAliases maps routes the sender accepted from the recipient to
+	// the alias prefixes the sender reaches them through, e.g.
+	// {"192.168.1.0/24": "10.201.1.0/24"}. The recipient installs 1:1
+	// prefix translation for them.
+	RouteAliases map[string]string `json:"routeAliases,omitempty"`
+
+	// Reset asks the recipient to discard any connection it has with the
+	// sender and answer on a new one. It is set when the sender tore its
+	// side down to reconnect, so an existing connection on the recipient
+	// is stale.
+	Reset bool `json:"reset,omitempty"`
 }
 
 func (OfferMessage) MessageType() string { return "offer" }
@@ -69,6 +109,12 @@ type AnswerMessage struct {
 	To        string `json:"to"`
 	SDP       string `json:"sdp"`
 	PublicKey string `json:"publicKey,omitempty"`
+
+	// RouteAliases maps routes the sender accepted from the recipient to
+	// the alias prefixes the sender reaches them through, e.g.
+	// {"192.168.1.0/24": "10.201.1.0/24"}. The recipient installs 1:1
+	// prefix translation for them.
+	RouteAliases map[string]string `json:"routeAliases,omitempty"`
 }
 
 func (AnswerMessage) MessageType() string { return "answer" }
@@ -97,6 +143,29 @@ type PeerLeftMessage struct {
 
 func (PeerLeftMessage) MessageType() string { return "peer-left" }
 
+// PolicyMessage carries the network-wide policy document. The server sends
+// it to a joining peer before the peer list, and to every peer when the
+// policy changes. Policy is the document as JSON, or null once cleared.
+type PolicyMessage struct {
+	Version int             `json:"version"`
+	Policy  json.RawMessage `json:"policy"`
+}
+
+func (PolicyMessage) MessageType() string { return "policy" }
+
+// UpdateMessage changes the routes and metadata a peer announced in its
+// join without reconnecting, e.g. when a gateway's local subnets change.
+// The client sends it without PeerID; the server stores the new values
+// and relays the message to every other peer with PeerID set to the
+// sender.
+type UpdateMessage struct {
+	PeerID   string            `json:"peerId,omitempty"`
+	Routes   []string          `json:"routes,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (UpdateMessage) MessageType() string { return "update" }
+
 // messageTypes maps wire-format type strings to factory functions
 // that produce zero-value pointers of the corresponding message type.
 var messageTypes = map[string]func() Message{
@@ -106,6 +175,8 @@ var messageTypes = map[string]func() Message{
 	"ice-candidate": func() Message { return &ICECandidateMessage{} },
 	"peers":         func() Message { return &PeersMessage{} },
 	"peer-left":     func() Message { return &PeerLeftMessage{} },
+	"policy":        func() Message { return &PolicyMessage{} },
+	"update":        func() Message { return &UpdateMessage{} },
 }
 
 // Marshal serializes a Message to JSON, injecting the "type" discriminator field.
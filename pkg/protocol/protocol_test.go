@@ -29,6 +29,11 @@ func TestMarshalUnmarshal_RoundTrip(t *testing.T) {
 			msg:     &OfferMessage{From: "laptop", To: "home-server", SDP: "v=0\r\noffer"},
 			wantTyp: "offer",
 		},
+		{
+			name:    "offer/reset",
+			msg:     &OfferMessage{From: "laptop", To: "home-server", SDP: "v=0\r\noffer", Reset: true},
+			wantTyp: "offer",
+		},
 		{
 			name:    "answer",
 			msg:     &AnswerMessage{From: "home-server", To: "laptop", SDP: "v=0\r\nanswer"},
@@ -65,6 +70,20 @@ func TestMarshalUnmarshal_RoundTrip(t *testing.T) {
 			msg:     &PeerLeftMessage{PeerID: "home-server"},
 			wantTyp: "peer-left",
 		},
+		{
+			name:    "policy",
+			msg:     &PolicyMessage{Version: 3, Policy: []byte(`{"acl_default":"deny"}`)},
+			wantTyp: "policy",
+		},
+		{
+			name: "update",
+			msg: &UpdateMessage{
+				PeerID:   "home-server",
+				Routes:   []string{"192.168.1.0/24", "192.168.20.0/24"},
+				Metadata: map[string]string{"routes": `["192.168.1.0/24","192.168.20.0/24"]`},
+			},
+			wantTyp: "update",
+		},
 	}
 
 	for _, tt := range tests {
@@ -174,6 +193,8 @@ func TestMessageType_Values(t *testing.T) {
 		{&ICECandidateMessage{}, "ice-candidate"},
 		{&PeersMessage{}, "peers"},
 		{&PeerLeftMessage{}, "peer-left"},
+		{&PolicyMessage{}, "policy"},
+		{&UpdateMessage{}, "update"},
 	}
 
 	for _, tt := range tests {
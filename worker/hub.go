@@ -150,11 +150,40 @@ func jsOnMessage(_ js.Value, args []js.Value) any {
 		if ok {
 			send(targetWsId, []byte(rawJSON))
 		}
+	case "update":
+		onUpdate(args[0].Int(), rawJSON)
 	}
 
 	return nil
 }
 
+// onUpdate stores the routes and metadata from a peer's update message, so
+// peers joining later see them in the peers list, and relays the update to
+// every other peer with the sender's ID.
+func onUpdate(wsId int, rawJSON string) {
+	p, ok := peers[wsId]
+	if !ok {
+		return
+	}
+	var update struct {
+		Routes   []string          `json:"routes,omitempty"`
+		Metadata map[string]string `json:"metadata,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(rawJSON), &update); err != nil {
+		return
+	}
+	p.routes = update.Routes
+	p.metadata = update.Metadata
+
+	msg, _ := json.Marshal(map[string]any{
+		"type":     "update",
+		"peerId":   p.peerID,
+		"routes":   p.routes,
+		"metadata": p.metadata,
+	})
+	broadcast(wsId, msg)
+}
+
 // parseRoutesJSON decodes a JSON array of route strings. Returns nil if the
 // input is empty or invalid.
 func parseRoutesJSON(s string) []string {
@@ -130,6 +130,20 @@ export default {
       return stub.fetch(doReq);
     }
 
+    // Network policy.
+    if (url.pathname === "/policy" && (request.method === "GET" || request.method === "PUT")) {
+      const doReq = new Request(request.url, {
+        method: request.method,
+        headers: {
+          "X-Bamgate-Action": request.method === "GET" ? "get-policy" : "set-policy",
+          "X-Bamgate-JWT": token,
+          "Content-Type": "application/json",
+        },
+        body: request.method === "PUT" ? request.body : null,
+      });
+      return stub.fetch(doReq);
+    }
+
     // Revoke device.
     const revokeMatch = url.pathname.match(/^\/auth\/devices\/([a-f0-9-]+)$/);
     if (revokeMatch && request.method === "DELETE") {
@@ -204,6 +218,12 @@ export class SignalingRoom {
       )
     `);
 
+    // Migration: dual-stack networks store each device's IPv6 ULA address.
+    const deviceCols = [...this.ctx.storage.sql.exec("PRAGMA table_info(devices)")];
+    if (!deviceCols.some(c => c.name === "address6")) {
+      this.ctx.storage.sql.exec("ALTER TABLE devices ADD COLUMN address6 TEXT");
+    }
+
     this._tablesReady = true;
   }
 
@@ -218,6 +238,20 @@ export class SignalingRoom {
     return subnet;
   }
 
+  // IPv6 ULA prefix (RFC 4193): fd00::/8 plus a random 40-bit global ID,
+  // generated once per network, with a /64 subnet.
+  _getSubnet6() {
+    this._ensureTables();
+    const rows = [...this.ctx.storage.sql.exec("SELECT value FROM network WHERE key = 'subnet6'")];
+    if (rows.length > 0) return rows[0].value;
+    const id = new Uint8Array(5);
+    crypto.getRandomValues(id);
+    const hex = "fd" + hexEncode(id);
+    const subnet6 = `${hex.slice(0, 4)}:${hex.slice(4, 8)}:${hex.slice(8, 12)}::/64`;
+    this.ctx.storage.sql.exec("INSERT INTO network (key, value) VALUES ('subnet6', ?)", subnet6);
+    return subnet6;
+  }
+
   _getOrCreateTURNSecret() {
     this._ensureTables();
     const rows = [...this.ctx.storage.sql.exec("SELECT value FROM network WHERE key = 'turn_secret'")];
@@ -267,6 +301,27 @@ export class SignalingRoom {
     return null;
   }
 
+  // Derive a device's IPv6 address from its IPv4 address: the host number
+  // within the IPv4 subnet becomes the interface ID within the IPv6 /64.
+  // IPv4 addresses are unique per network, so the IPv6 ones are too.
+  _address6For(address) {
+    const [baseIP, prefixStr] = this._getSubnet().split("/");
+    const hostBits = 32 - parseInt(prefixStr, 10);
+    const toNum = ip => ip.split(".").map(Number).reduce((n, p) => (n * 256) + p, 0);
+    const host = (toNum(address.split("/")[0]) - toNum(baseIP)) % (2 ** hostBits);
+    const prefix6 = this._getSubnet6().split("::/")[0];
+    return `${prefix6}::${host.toString(16)}/64`;
+  }
+
+  // Return the device's IPv6 address, assigning and storing one if the
+  // device was registered before the network became dual-stack.
+  _ensureAddress6(deviceId, address, address6) {
+    if (address6) return address6;
+    const assigned = this._address6For(address);
+    this.ctx.storage.sql.exec("UPDATE devices SET address6 = ? WHERE device_id = ?", assigned, deviceId);
+    return assigned;
+  }
+
   // ==================== JWT Signing Keys ====================
 
   async _getOrCreateSigningKey() {
@@ -430,7 +485,7 @@ export class SignalingRoom {
 
     // Check if a non-revoked device with the same name already exists for this owner.
     const existing = [...this.ctx.storage.sql.exec(
-      "SELECT device_id, address FROM devices WHERE device_name = ? AND owner_github_id = ? AND revoked = 0",
+      "SELECT device_id, address, address6 FROM devices WHERE device_name = ? AND owner_github_id = ? AND revoked = 0",
       device_name, githubId
     )];
 
@@ -439,12 +494,13 @@ export class SignalingRoom {
     const refreshTokenHash = await this._hashToken(refreshToken);
     const refreshExpiresAt = now + 30 * 24 * 60 * 60; // 30 days.
 
-    let deviceId, address;
+    let deviceId, address, address6;
 
     if (existing.length > 0) {
       // Reclaim existing device — reuse its ID and address, reset credentials.
       deviceId = existing[0].device_id;
       address = existing[0].address;
+      address6 = existing[0].address6;
       this.ctx.storage.sql.exec(
         `UPDATE devices SET refresh_token_hash = ?, refresh_token_expires_at = ?, last_seen_at = ?
          WHERE device_id = ?`,
@@ -464,6 +520,8 @@ export class SignalingRoom {
       );
     }
 
+    address6 = this._ensureAddress6(deviceId, address, address6);
+
     // Get or create TURN secret.
     const turnSecret = this._getOrCreateTURNSecret();
 
@@ -489,6 +547,8 @@ export class SignalingRoom {
       refresh_token: refreshToken,
       address,
       subnet,
+      address6,
+      subnet6: this._getSubnet6(),
       turn_secret: turnSecret,
       server_url: serverURL,
     });
@@ -554,6 +614,7 @@ export class SignalingRoom {
       access_token: accessToken,
       refresh_token: newRefreshToken,
       expires_in: 3600,
+      address6: this._ensureAddress6(device_id, device.address, device.address6),
     });
   }
 
@@ -562,7 +623,7 @@ export class SignalingRoom {
   async _handleListDevices(claims) {
     this._ensureTables();
     const rows = [...this.ctx.storage.sql.exec(
-      `SELECT device_id, device_name, address, created_at, last_seen_at, revoked
+      `SELECT device_id, device_name, address, address6, created_at, last_seen_at, revoked
        FROM devices WHERE owner_github_id = ? ORDER BY created_at`,
       claims.owner
     )];
@@ -572,6 +633,7 @@ export class SignalingRoom {
         device_id: r.device_id,
         device_name: r.device_name,
         address: r.address,
+        address6: r.address6 || "",
         created_at: r.created_at,
         last_seen_at: r.last_seen_at,
         revoked: r.revoked === 1,
@@ -602,6 +664,62 @@ export class SignalingRoom {
     return this._jsonResponse({ ok: true });
   }
 
+  // ==================== Network Policy ====================
+
+  // The policy document is validated by "bamgate policy set" before upload;
+  // the worker only checks that it is a JSON object and versions it.
+  _getPolicy() {
+    this._ensureTables();
+    const rows = [...this.ctx.storage.sql.exec(
+      "SELECT key, value FROM network WHERE key IN ('policy', 'policy_version')"
+    )];
+    const stored = { version: 0, policy: null };
+    for (const r of rows) {
+      if (r.key === "policy") stored.policy = JSON.parse(r.value);
+      if (r.key === "policy_version") stored.version = parseInt(r.value, 10);
+    }
+    return stored;
+  }
+
+  _handleGetPolicy() {
+    return this._jsonResponse(this._getPolicy());
+  }
+
+  async _handleSetPolicy(request) {
+    let doc;
+    try {
+      doc = await request.json();
+    } catch {
+      return this._jsonError("invalid JSON", 400);
+    }
+    if (doc === null || typeof doc !== "object" || Array.isArray(doc)) {
+      return this._jsonError("policy must be a JSON object", 400);
+    }
+
+    const version = this._getPolicy().version + 1;
+    this.ctx.storage.sql.exec(
+      "INSERT OR REPLACE INTO network (key, value) VALUES ('policy', ?)", JSON.stringify(doc)
+    );
+    this.ctx.storage.sql.exec(
+      "INSERT OR REPLACE INTO network (key, value) VALUES ('policy_version', ?)", String(version)
+    );
+
+    // Push the new policy to every joined signaling connection.
+    const msg = JSON.stringify({ type: "policy", version, policy: doc });
+    for (const ws of this.ctx.getWebSockets()) {
+      const attachment = ws.deserializeAttachment();
+      if (attachment && attachment.joined && !attachment.isTurn) {
+        try {
+          ws.send(msg);
+        } catch {
+          // WebSocket may be closing; it gets the policy on rejoin.
+        }
+      }
+    }
+
+    return this._jsonResponse({ version });
+  }
+
   // ==================== Response Helpers ====================
 
   _jsonResponse(data, status = 200) {
@@ -762,6 +880,12 @@ export class SignalingRoom {
     if (action === "list-devices") {
       return this._handleListDevices(claims);
     }
+    if (action === "get-policy") {
+      return this._handleGetPolicy();
+    }
+    if (action === "set-policy") {
+      return this._handleSetPolicy(request);
+    }
     if (action === "revoke-device") {
       const targetId = request.headers.get("X-Bamgate-Device-ID");
       return this._handleRevokeDevice(claims, targetId);
@@ -844,12 +968,36 @@ export class SignalingRoom {
         );
       }
 
+      // Send the network policy before the peer list so the agent applies
+      // it to the connections it is about to open.
+      const stored = this._getPolicy();
+      if (stored.version > 0) {
+        ws.send(JSON.stringify({ type: "policy", version: stored.version, policy: stored.policy }));
+      }
+
       globalThis.goOnJoin(wsId, msg.peerId, msg.publicKey || "", msg.address || "", JSON.stringify(msg.routes || []), JSON.stringify(msg.metadata || {}));
       return;
     }
 
+    // Route and metadata updates replace what the join announced. Keep the
+    // attachment in step so rehydration after hibernation restores them.
+    const text = typeof message === "string" ? message : new TextDecoder().decode(message);
+    let msg;
+    try {
+      msg = JSON.parse(text);
+    } catch {
+      return;
+    }
+    if (msg.type === "update") {
+      ws.serializeAttachment({
+        ...attachment,
+        routes: msg.routes || [],
+        metadata: msg.metadata || {},
+      });
+    }
+
     // Forward subsequent signaling messages to Go hub for routing.
-    globalThis.goOnMessage(wsId, typeof message === "string" ? message : new TextDecoder().decode(message));
+    globalThis.goOnMessage(wsId, text);
   }
 
   async webSocketClose(ws, code, reason, wasClean) {